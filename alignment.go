@@ -0,0 +1,39 @@
+package rados
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+PoolAlignment reports whether the named pool requires writes to be aligned,
+as is the case for some erasure-coded pools, and if so, the alignment in
+bytes. Callers can use this to size their buffers so that writes cover whole
+stripes; appends to such pools must be multiples of the alignment.
+*/
+func (r *RadosFileSystem) PoolAlignment(ctx context.Context, pool string) (
+	bool, uint64, error) {
+	var entry *contextEntry
+	var required bool
+	var alignment uint64
+	var err error
+
+	if entry, err = r.getContext(ctx, pool); err != nil {
+		return false, 0, err
+	}
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		if required, err = entry.ioctx.RequiresAlignment(); err != nil ||
+			!required {
+			return err
+		}
+		alignment, err = entry.ioctx.Alignment()
+		return err
+	}); err != nil {
+		return false, 0, fmt.Errorf("Cannot determine alignment of Rados "+
+			"pool %q: %w", pool, err)
+	}
+
+	return required, alignment, nil
+}
@@ -0,0 +1,37 @@
+package rados_test
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPoolAlignment(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var required bool
+	var alignment uint64
+	var err error
+
+	conn.CreatePool("erasure")
+	conn.SetPoolAlignment("erasure", 4096)
+
+	for _, c := range []struct {
+		pool      string
+		required  bool
+		alignment uint64
+	}{
+		{testPool, false, 0},
+		{"erasure", true, 4096},
+	} {
+		if required, alignment, err = fs.PoolAlignment(ctx,
+			c.pool); err != nil || required != c.required ||
+			alignment != c.alignment {
+			t.Errorf("PoolAlignment(%s) -> %v, %d, %v, want %v, %d", c.pool,
+				required, alignment, err, c.required, c.alignment)
+		}
+	}
+
+	if _, _, err = fs.PoolAlignment(ctx, "missing"); err == nil {
+		t.Error("PoolAlignment() of a missing pool succeeded")
+	}
+}
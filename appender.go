@@ -2,10 +2,13 @@ package rados
 
 import (
 	"context"
+	"fmt"
 	"github.com/ceph/go-ceph/rados"
 	"github.com/childoftheuniverse/filesystem"
 	"github.com/prometheus/client_golang/prometheus"
+	"net/url"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -14,21 +17,28 @@ var radosAppenderLatencies = prometheus.NewHistogramVec(prometheus.HistogramOpts
 	Name:      "append_latency",
 	Help:      "Latency of Rados Append requests",
 	Buckets:   prometheus.ExponentialBuckets(0.001, 5, 20),
-}, []string{"pool"})
+}, []string{"cluster", "pool", "class"})
 var radosAppenderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Subsystem: "rados",
 	Name:      "append_errors",
 	Help:      "Number of errors received when appending to Rados files",
-}, []string{"pool"})
+}, []string{"cluster", "pool", "class"})
 var radosAppenderBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Subsystem: "rados",
 	Name:      "append_bytes",
 	Help:      "Number of bytes sent when appending to Rados files",
-}, []string{"pool"})
+}, []string{"cluster", "pool", "class"})
+
+/*
+DefaultAppendClass is the class label of append metrics for appenders which
+have not been assigned a class.
+*/
+const DefaultAppendClass = "default"
 
 func init() {
 	prometheus.MustRegister(radosAppenderLatencies)
 	prometheus.MustRegister(radosAppenderErrors)
+	prometheus.MustRegister(radosAppenderBytes)
 }
 
 /*
@@ -38,10 +48,35 @@ by its oid.
 Seeks are supported, but only as a means to determine the current position.
 */
 type Appender struct {
-	rctx *rados.IOContext
-	pool string
-	oid  string
-	pos  int64
+	rctx    IOContext
+	cluster string
+	pool    string
+	oid     string
+	pos     int64
+	limit   int64
+	retry   RetryPolicy
+	slots   *opLimiter
+	space   *spaceGuard
+
+	/*
+		class is the logical type of the data appended, used to break down
+		the append metrics.
+	*/
+	class string
+
+	/*
+		closed is set once Close() has been called; further appends are
+		rejected.
+	*/
+	closed bool
+
+	/*
+		inflight tracks appends which have been issued but not yet completed,
+		and err holds the first error any of them returned.
+	*/
+	inflight sync.WaitGroup
+	errMtx   sync.Mutex
+	err      error
 }
 
 /*
@@ -49,56 +84,130 @@ NewAppender creates a new Appender for the Rados object described with
 the specified oid.
 */
 func NewAppender(rctx *rados.IOContext, oid string) (*Appender, error) {
-	var stat rados.ObjectStat
 	var pool string
-	var pos int64
 	var err error
 
 	/*
 	   Determine the name of the pool the object resides in, for prometheus.
 	*/
 	if pool, err = rctx.GetPoolName(); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Cannot determine pool of %s: %w", oid, err)
 	}
 
+	return newAppender(&contextEntry{
+		ioctx:    radosIOContext{IOContext: rctx},
+		poolName: pool,
+	}, oid)
+}
+
+/*
+newAppender is like NewAppender, but takes the pool name from the cached I/O
+context entry rather than looking it up.
+*/
+func newAppender(entry *contextEntry, oid string) (*Appender, error) {
+	var stat rados.ObjectStat
+	var pos int64
+	var err error
+
 	/*
 	   Determine the size of the object. If this fails, assume the object doesn't
 	   exist and we start from offset 0.
 	*/
-	if stat, err = rctx.Stat(oid); err == nil {
-		pos = int64(stat.Size)
+	if stat, err = entry.ioctx.Stat(oid); err == nil {
+		if pos, err = objectSize(stat.Size); err != nil {
+			return nil, objectError("append", entry.poolName, oid, err)
+		}
 	}
 
 	return &Appender{
-		rctx: rctx,
-		pool: pool,
-		oid:  oid,
-		pos:  pos,
+		rctx:    entry.ioctx,
+		cluster: entry.cluster,
+		pool:    entry.poolName,
+		oid:     oid,
+		pos:     pos,
 	}, nil
 }
 
+/*
+labels returns the metric labels for operations on this object.
+*/
+func (w *Appender) labels() prometheus.Labels {
+	var class = w.class
+
+	if class == "" {
+		class = DefaultAppendClass
+	}
+	return prometheus.Labels{"cluster": w.cluster, "pool": w.pool,
+		"class": class}
+}
+
+/*
+SetSizeLimit sets the maximum size, in bytes, the Rados object may reach
+through this appender. Appends which would grow the object past the limit are
+cut off at the limit and return ErrSizeLimitExceeded. A limit of 0 or less
+disables the check, which is the default.
+*/
+func (w *Appender) SetSizeLimit(limit int64) {
+	w.limit = limit
+}
+
 /*
 Write appends the specified input bytes to the end of the Rados object.
 Parallel Write() calls from different callers will cause data to be interleaved
 as complete Write() calls.
-TODO: does not respect contexts yet.
+If a size limit is set and the append would exceed it, only the bytes up to
+the limit are appended and ErrSizeLimitExceeded is returned.
+
+If ctx expires before Rados has confirmed the append, the context error is
+returned and also reported by Flush() and Close(). The append may or may not
+have been committed by Rados in that case, so the contents of the object are
+uncertain and Tell() no longer accounts for it. Flush() still waits for such
+abandoned appends to complete.
 */
 func (w *Appender) Write(ctx context.Context, p []byte) (int, error) {
 	var start = time.Now()
+	var limitErr error
 	var err error
 
-	if err = w.rctx.Append(w.oid, p); err != nil {
-		radosAppenderErrors.With(prometheus.Labels{"pool": w.pool}).Inc()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	p, limitErr = capToSizeLimit(p, w.pos, w.limit)
+	if len(p) == 0 {
+		return 0, limitErr
+	}
+	if err = w.space.check(ctx); err != nil {
+		countNoSpace(w.cluster, w.pool, err)
+		return 0, err
+	}
+
+	if err = w.slots.acquire(ctx); err != nil {
 		return 0, err
 	}
+	defer w.slots.release()
 
-	radosAppenderLatencies.With(prometheus.Labels{"pool": w.pool}).Observe(
+	if err = w.retry.do(ctx, w.cluster, w.pool, "append", func() error {
+		w.inflight.Add(1)
+		return runWithContext(ctx, func() error {
+			defer w.inflight.Done()
+			return w.rctx.Append(w.oid, p)
+		})
+	}); err != nil {
+		radosAppenderErrors.With(w.labels()).Inc()
+		err = objectError("append", w.pool, w.oid, err)
+		countNoSpace(w.cluster, w.pool, err)
+		w.setError(err)
+		return 0, err
+	}
+
+	radosAppenderLatencies.With(w.labels()).Observe(
 		time.Now().Sub(start).Seconds())
-	radosAppenderBytes.With(prometheus.Labels{"pool": w.pool}).Add(
+	radosAppenderBytes.With(w.labels()).Add(
 		float64(len(p)))
 
 	w.pos += int64(len(p))
-	return len(p), nil
+	return len(p), limitErr
 }
 
 /*
@@ -122,8 +231,81 @@ func (w *Appender) Tell(ctx context.Context) (int64, error) {
 }
 
 /*
-Close is a no-op since Rados operations are quasi-synchronous and stateless.
+setError records err as the error to be reported by Flush(), unless an earlier
+error has been recorded already.
+*/
+func (w *Appender) setError(err error) {
+	w.errMtx.Lock()
+	defer w.errMtx.Unlock()
+
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+/*
+Flush waits for all appends which are still in flight to complete and returns
+the first error encountered by any append on this Appender. If ctx expires
+before all appends have completed, the context error is returned instead; the
+appends are not aborted by this. Contexts without a deadline are bounded by
+the default timeout.
 */
-func (*Appender) Close(ctx context.Context) error {
-	return nil
+func (w *Appender) Flush(ctx context.Context) error {
+	var done = make(chan struct{})
+	var cancel context.CancelFunc
+
+	ctx, cancel = withDefaultTimeout(ctx)
+	defer cancel()
+
+	go func() {
+		w.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	w.errMtx.Lock()
+	defer w.errMtx.Unlock()
+	return w.err
+}
+
+/*
+Close waits for all outstanding appends to complete, as with Flush(), and
+reports whether all of them succeeded. Further appends fail with
+os.ErrClosed. Closing more than once is harmless and reports the same error
+again.
+*/
+func (w *Appender) Close(ctx context.Context) error {
+	w.closed = true
+	return w.Flush(ctx)
+}
+
+/*
+OpenAppenderWithClass works like OpenAppender(), but labels the append metrics
+of the returned Appender with class. The class must have been declared using
+WithAppendClasses(); otherwise an error wrapping os.ErrInvalid is returned.
+*/
+func (r *RadosFileSystem) OpenAppenderWithClass(
+	ctx context.Context, u *url.URL, class string) (*Appender, error) {
+	var entry *contextEntry
+	var ret *Appender
+	var err error
+
+	if !r.appendClasses[class] {
+		return nil, fmt.Errorf("Append class %q has not been declared: %w",
+			class, os.ErrInvalid)
+	}
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if ret, err = r.openAppender(ctx, entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	ret.class = class
+	return ret, nil
 }
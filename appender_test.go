@@ -0,0 +1,183 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAppenderSizeLimit(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var w filesystem.WriteCloser
+	var n int
+	var err error
+
+	for _, c := range sizeLimitCases {
+		if w, err = fs.OpenAppender(ctx, testURL("/"+c.name)); err != nil {
+			t.Fatalf("OpenAppender() -> %v", err)
+		}
+		w.(*rados.Appender).SetSizeLimit(c.limit)
+		if _, err = w.Write(ctx, []byte("abc")); err != nil {
+			t.Errorf("%s: first Write() -> %v", c.name, err)
+		}
+		n, err = w.Write(ctx, []byte("def"))
+		if c.written < 6 && (!errors.Is(err, rados.ErrSizeLimitExceeded) ||
+			n != c.written-3) {
+			t.Errorf("%s: Write() past the limit -> %d, %v, want %d, "+
+				"ErrSizeLimitExceeded", c.name, n, err, c.written-3)
+		} else if c.written == 6 && (err != nil || n != 3) {
+			t.Errorf("%s: Write() -> %d, %v, want 3, nil", c.name, n, err)
+		}
+		if err = w.Close(ctx); err != nil {
+			t.Fatalf("Close() -> %v", err)
+		}
+		expectContents(t, fs, "/"+c.name, []byte("abcdef")[:c.written])
+	}
+}
+
+func TestAppenderFlushWaitsForAbandonedAppends(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var release = make(chan struct{})
+	var flushed = make(chan error, 1)
+	var shortCtx context.Context
+	var cancel context.CancelFunc
+	var w filesystem.WriteCloser
+	var err error
+
+	if w, err = fs.OpenAppender(ctx, testURL("/log")); err != nil {
+		t.Fatalf("OpenAppender() -> %v", err)
+	}
+	conn.SetHook(blockOperation("Append", release))
+
+	shortCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err = w.Write(shortCtx, []byte("data")); !errors.Is(err,
+		context.DeadlineExceeded) {
+		t.Fatalf("Write() of a blocked append -> %v, want DeadlineExceeded",
+			err)
+	}
+
+	go func() {
+		flushed <- w.(*rados.Appender).Flush(ctx)
+	}()
+	select {
+	case err = <-flushed:
+		t.Fatalf("Flush() returned %v while an append was in flight", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err = <-flushed; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Flush() -> %v, want the error of the abandoned append", err)
+	}
+	expectContents(t, fs, "/log", []byte("data"))
+	if err = w.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() -> %v, want the error of the abandoned append", err)
+	}
+}
+
+func TestAppenderWriteCancelled(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var fs *rados.RadosFileSystem
+	var labels = prometheus.Labels{"cluster": "append-cancel",
+		"pool": testPool, "class": rados.DefaultAppendClass}
+	var entered = make(chan struct{})
+	var release = make(chan struct{})
+	var cancelCtx context.Context
+	var cancel context.CancelFunc
+	var w filesystem.WriteCloser
+	var err error
+
+	conn.CreatePool(testPool)
+	fs = rados.NewRadosFileSystemWithConn(conn,
+		rados.WithCluster("append-cancel"))
+	if w, err = fs.OpenAppender(ctx, testURL("/log")); err != nil {
+		t.Fatalf("OpenAppender() -> %v", err)
+	}
+	defer close(release)
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Append" {
+			close(entered)
+			<-release
+		}
+		return nil
+	})
+
+	cancelCtx, cancel = context.WithCancel(ctx)
+	go func() {
+		<-entered
+		cancel()
+	}()
+	if _, err = w.Write(cancelCtx, []byte("data")); !errors.Is(err,
+		context.Canceled) {
+		t.Errorf("Write() cancelled during the append -> %v, want Canceled",
+			err)
+	}
+	if got := metricValue(t, "rados_append_errors", labels); got != 1 {
+		t.Errorf("rados_append_errors%v = %v, want 1", labels, got)
+	}
+}
+
+func TestAppendBytesPerClass(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var fs *rados.RadosFileSystem
+	var w *rados.Appender
+	var err error
+
+	conn.CreatePool(testPool)
+	fs = rados.NewRadosFileSystemWithConn(conn,
+		rados.WithCluster("append-classes"),
+		rados.WithAppendClasses("audit", "metrics"))
+
+	for _, c := range []struct {
+		class string
+		data  string
+	}{
+		{"audit", "login\n"},
+		{"metrics", "cpu=0.5\nmem=0.25\n"},
+	} {
+		if w, err = fs.OpenAppenderWithClass(ctx, testURL("/"+c.class),
+			c.class); err != nil {
+			t.Fatalf("OpenAppenderWithClass(%s) -> %v", c.class, err)
+		}
+		if _, err = w.Write(ctx, []byte(c.data)); err != nil {
+			t.Errorf("Write() with class %s -> %v", c.class, err)
+		}
+		if err = w.Close(ctx); err != nil {
+			t.Errorf("Close() with class %s -> %v", c.class, err)
+		}
+	}
+
+	for _, c := range []struct {
+		class string
+		want  float64
+	}{
+		{"audit", 6},
+		{"metrics", 17},
+		{rados.DefaultAppendClass, 0},
+	} {
+		var labels = prometheus.Labels{"cluster": "append-classes",
+			"pool": testPool, "class": c.class}
+
+		if got := metricValue(t, "rados_append_bytes", labels); got != c.want {
+			t.Errorf("rados_append_bytes%v = %v, want %v", labels, got, c.want)
+		}
+	}
+
+	if _, err = fs.OpenAppenderWithClass(ctx, testURL("/other"),
+		"undeclared"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("OpenAppenderWithClass() with an undeclared class -> %v, "+
+			"want %v", err, os.ErrInvalid)
+	}
+}
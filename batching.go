@@ -0,0 +1,205 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+BatchingAppender collects small writes in a buffer and appends them to the
+Rados object in a single Rados append once the buffer reaches a size
+threshold, or once a time interval has elapsed since the first buffered write.
+The data of a single Write() is never split across appends, but it is up to
+the caller to encode record boundaries within the data if they are needed.
+*/
+type BatchingAppender struct {
+	a         *Appender
+	threshold int
+	interval  time.Duration
+
+	/*
+		mtx guards all fields below, which are also accessed by the timer
+		flushing the buffer in the background.
+	*/
+	mtx    sync.Mutex
+	buf    []byte
+	timer  *time.Timer
+	err    error
+	closed bool
+}
+
+/*
+NewBatchingAppender creates a BatchingAppender writing through a. The buffer
+is appended as soon as it holds at least threshold bytes. If interval is
+positive, buffered data is also appended at the latest interval after it was
+written.
+*/
+func NewBatchingAppender(a *Appender, threshold int,
+	interval time.Duration) *BatchingAppender {
+	return &BatchingAppender{
+		a:         a,
+		threshold: threshold,
+		interval:  interval,
+	}
+}
+
+/*
+OpenBatchingAppender opens the specified Rados object (u.Path) in the
+specified pool (u.Host) for batched appends, creating it if necessary.
+*/
+func (r *RadosFileSystem) OpenBatchingAppender(ctx context.Context,
+	u *url.URL, threshold int, interval time.Duration) (
+	*BatchingAppender, error) {
+	var entry *contextEntry
+	var a *Appender
+	var err error
+
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if a, err = r.openAppender(ctx, entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	return NewBatchingAppender(a, threshold, interval), nil
+}
+
+/*
+Write adds p to the buffer, appending the buffer to the Rados object if it
+has reached the size threshold. Errors encountered by earlier background
+flushes are reported here as well; the data which could not be appended then
+remains buffered and is retried with the next flush.
+*/
+func (b *BatchingAppender) Write(ctx context.Context, p []byte) (int, error) {
+	var err error
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.closed {
+		return 0, os.ErrClosed
+	}
+	if err = b.takeError(); err != nil {
+		return 0, err
+	}
+
+	b.buf = append(b.buf, p...)
+	if len(b.buf) >= b.threshold {
+		if err = b.flushLocked(ctx); err != nil {
+			return len(p), err
+		}
+	} else if b.timer == nil && b.interval > 0 {
+		b.timer = time.AfterFunc(b.interval, b.flushInBackground)
+	}
+	return len(p), nil
+}
+
+/*
+takeError returns and clears the error of the last background flush.
+*/
+func (b *BatchingAppender) takeError() error {
+	var err = b.err
+
+	b.err = nil
+	return err
+}
+
+/*
+flushInBackground is run by the timer to append buffered data which has not
+reached the size threshold in time.
+*/
+func (b *BatchingAppender) flushInBackground() {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var err error
+
+	ctx, cancel = withDefaultTimeout(context.Background())
+	defer cancel()
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.timer = nil
+	if err = b.flushLocked(ctx); err != nil {
+		b.err = err
+	}
+}
+
+/*
+flushLocked appends the buffer to the Rados object in a single append. The
+caller must hold mtx.
+*/
+func (b *BatchingAppender) flushLocked(ctx context.Context) error {
+	var n int
+	var err error
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.buf) == 0 {
+		return nil
+	}
+
+	n, err = b.a.Write(ctx, b.buf)
+	b.buf = b.buf[n:]
+	if len(b.buf) == 0 {
+		b.buf = nil
+	}
+	return err
+}
+
+/*
+Flush appends all buffered data to the Rados object and waits for the append
+to complete, using ctx for both.
+*/
+func (b *BatchingAppender) Flush(ctx context.Context) error {
+	var err error
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if err = b.takeError(); err != nil {
+		return err
+	}
+	if err = b.flushLocked(ctx); err != nil {
+		return err
+	}
+	return b.a.Flush(ctx)
+}
+
+/*
+Tell returns the position in the Rados object including data which has been
+buffered but not appended yet.
+*/
+func (b *BatchingAppender) Tell(ctx context.Context) (int64, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.a.pos + int64(len(b.buf)), nil
+}
+
+/*
+Close appends all buffered data and closes the underlying Appender. Further
+writes fail with os.ErrClosed.
+*/
+func (b *BatchingAppender) Close(ctx context.Context) error {
+	var err error
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.closed = true
+	if err = b.takeError(); err != nil {
+		b.a.Close(ctx)
+		return err
+	}
+	if err = b.flushLocked(ctx); err != nil {
+		b.a.Close(ctx)
+		return err
+	}
+	return b.a.Close(ctx)
+}
@@ -0,0 +1,85 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestBatchingAppenderKeepsAllRecords(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var b *rados.BatchingAppender
+	var want bytes.Buffer
+	var record []byte
+	var appends int64
+	var pos int64
+	var i int
+	var err error
+
+	conn.SetHook(failOperation("Append", 0, 0, &appends))
+	if b, err = fs.OpenBatchingAppender(ctx, testURL("/log"), 64,
+		0); err != nil {
+		t.Fatalf("OpenBatchingAppender() -> %v", err)
+	}
+	for i = 0; i < 100; i++ {
+		record = []byte(fmt.Sprintf("record %03d\n", i))
+		want.Write(record)
+		if _, err = b.Write(ctx, record); err != nil {
+			t.Fatalf("Write(%q) -> %v", record, err)
+		}
+	}
+	if pos, err = b.Tell(ctx); err != nil || pos != int64(want.Len()) {
+		t.Errorf("Tell() -> %d, %v, want %d", pos, err, want.Len())
+	}
+	if err = b.Close(ctx); err != nil {
+		t.Fatalf("Close() -> %v", err)
+	}
+	expectContents(t, fs, "/log", want.Bytes())
+
+	if appends == 0 || appends > 20 {
+		t.Errorf("100 records took %d appends, want between 1 and 20", appends)
+	}
+	if _, err = b.Write(ctx, record); err != os.ErrClosed {
+		t.Errorf("Write() after Close() -> %v, want %v", err, os.ErrClosed)
+	}
+}
+
+func TestBatchingAppenderFlushes(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var b *rados.BatchingAppender
+	var deadline = time.Now().Add(5 * time.Second)
+	var data []byte
+	var err error
+
+	if b, err = fs.OpenBatchingAppender(ctx, testURL("/log"), 1<<20,
+		10*time.Millisecond); err != nil {
+		t.Fatalf("OpenBatchingAppender() -> %v", err)
+	}
+	defer b.Close(ctx)
+
+	if _, err = b.Write(ctx, []byte("timed")); err != nil {
+		t.Fatalf("Write() -> %v", err)
+	}
+	for string(data) != "timed" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		data, _ = fs.ReadFile(ctx, testURL("/log"))
+	}
+	if string(data) != "timed" {
+		t.Errorf("Contents after the interval = %q, want \"timed\"", data)
+	}
+
+	if _, err = b.Write(ctx, []byte(" flushed")); err != nil {
+		t.Fatalf("Write() -> %v", err)
+	}
+	if err = b.Flush(ctx); err != nil {
+		t.Fatalf("Flush() -> %v", err)
+	}
+	expectContents(t, fs, "/log", []byte("timed flushed"))
+}
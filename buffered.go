@@ -0,0 +1,186 @@
+package rados
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/childoftheuniverse/filesystem"
+)
+
+/*
+BufferedWriter collects small writes to a ReadWriteCloser in memory and
+writes them to the Rados object in blocks aligned to a block size, similar to
+what bufio.Writer does for io.Writer. This saves a round trip to the OSDs for
+every small Write(). Data at the end which does not fill a whole block is
+written by Flush() or Close().
+*/
+type BufferedWriter struct {
+	w         *ReadWriteCloser
+	blockSize int64
+
+	/*
+		buf holds the data following the position of w which has not been
+		written yet.
+	*/
+	buf    []byte
+	closed bool
+}
+
+/*
+NewBufferedWriter creates a BufferedWriter writing through w in blocks of
+blockSize bytes. Blocks are aligned to multiples of blockSize within the
+object, not to the position of w when the BufferedWriter was created.
+*/
+func NewBufferedWriter(w *ReadWriteCloser, blockSize int64) (
+	*BufferedWriter, error) {
+	if blockSize <= 0 {
+		return nil, os.ErrInvalid
+	}
+	return &BufferedWriter{
+		w:         w,
+		blockSize: blockSize,
+	}, nil
+}
+
+/*
+OpenBufferedWriter opens the specified Rados object (u.Path) in the specified
+pool (u.Host) for buffered writing, truncating it as OpenWriter() does.
+Compressed objects cannot be written in aligned blocks and are rejected with
+an error wrapping os.ErrInvalid.
+*/
+func (r *RadosFileSystem) OpenBufferedWriter(ctx context.Context, u *url.URL,
+	blockSize int64) (*BufferedWriter, error) {
+	var w filesystem.WriteCloser
+	var rwc *ReadWriteCloser
+	var codec string
+	var ok bool
+	var err error
+
+	if blockSize <= 0 {
+		return nil, os.ErrInvalid
+	}
+	if codec, err = urlCompression(u); err != nil {
+		return nil, err
+	}
+	if codec != "" {
+		return nil, fmt.Errorf("%w: buffered writing of compressed object %s",
+			os.ErrInvalid, u.Path)
+	}
+	if w, err = r.OpenWriter(ctx, u); err != nil {
+		return nil, err
+	}
+	if rwc, ok = w.(*ReadWriteCloser); !ok {
+		w.Close(ctx)
+		return nil, fmt.Errorf("%w: cannot buffer writes to %s",
+			os.ErrInvalid, u.Path)
+	}
+	return NewBufferedWriter(rwc, blockSize)
+}
+
+/*
+Write adds p to the buffer and writes all blocks which have been filled
+completely. If writing fails, the data which could not be written remains
+buffered and is retried with the next write or flush.
+*/
+func (b *BufferedWriter) Write(ctx context.Context, p []byte) (int, error) {
+	var end, flushTo int64
+	var err error
+
+	if b.closed {
+		return 0, os.ErrClosed
+	}
+
+	b.buf = append(b.buf, p...)
+
+	end = b.w.pos + int64(len(b.buf))
+	flushTo = end - end%b.blockSize
+	if flushTo > b.w.pos {
+		if err = b.flushUpTo(ctx, flushTo-b.w.pos); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+/*
+flushUpTo writes the first n bytes of the buffer to the current position of
+the underlying ReadWriteCloser, dropping whatever was written from the
+buffer.
+*/
+func (b *BufferedWriter) flushUpTo(ctx context.Context, n int64) error {
+	var written int
+	var err error
+
+	written, err = b.w.Write(ctx, b.buf[:n])
+	b.buf = b.buf[written:]
+	if len(b.buf) == 0 {
+		b.buf = nil
+	}
+	return err
+}
+
+/*
+Buffered returns the number of bytes which have been written to the
+BufferedWriter but not to the Rados object yet.
+*/
+func (b *BufferedWriter) Buffered() int {
+	return len(b.buf)
+}
+
+/*
+Flush writes all buffered data to the Rados object, regardless of whether it
+fills a whole block.
+*/
+func (b *BufferedWriter) Flush(ctx context.Context) error {
+	if b.closed {
+		return os.ErrClosed
+	}
+	if len(b.buf) == 0 {
+		return nil
+	}
+	return b.flushUpTo(ctx, int64(len(b.buf)))
+}
+
+/*
+Seek flushes all buffered data and then moves the position of the underlying
+ReadWriteCloser as outlined in the io.Seeker API.
+*/
+func (b *BufferedWriter) Seek(
+	ctx context.Context, offset int64, whence int) (int64, error) {
+	var err error
+
+	if err = b.Flush(ctx); err != nil {
+		return b.w.pos + int64(len(b.buf)), err
+	}
+	return b.w.Seek(ctx, offset, whence)
+}
+
+/*
+Tell returns the position in the Rados object including data which has been
+buffered but not written yet.
+*/
+func (b *BufferedWriter) Tell(ctx context.Context) (int64, error) {
+	return b.w.pos + int64(len(b.buf)), nil
+}
+
+/*
+Close writes all buffered data and closes the underlying ReadWriteCloser.
+Further writes fail with os.ErrClosed. Closing more than once is harmless.
+*/
+func (b *BufferedWriter) Close(ctx context.Context) error {
+	var err error
+
+	if b.closed {
+		return nil
+	}
+
+	if err = b.Flush(ctx); err != nil {
+		b.closed = true
+		b.w.Close(ctx)
+		return err
+	}
+	b.closed = true
+	return b.w.Close(ctx)
+}
@@ -0,0 +1,104 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestBufferedWriterWritesAlignedBlocks(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var w *rados.BufferedWriter
+	var want bytes.Buffer
+	var record []byte
+	var writes int64
+	var pos int64
+	var i int
+	var err error
+
+	if w, err = fs.OpenBufferedWriter(ctx, testURL("/object"),
+		64); err != nil {
+		t.Fatalf("OpenBufferedWriter() -> %v", err)
+	}
+	conn.SetHook(failOperation("Write", 0, 0, &writes))
+
+	for i = 0; i < 50; i++ {
+		record = []byte(fmt.Sprintf("record %02d\n", i))
+		want.Write(record)
+		if _, err = w.Write(ctx, record); err != nil {
+			t.Fatalf("Write(%q) -> %v", record, err)
+		}
+	}
+	if pos, err = w.Tell(ctx); err != nil || pos != int64(want.Len()) {
+		t.Errorf("Tell() -> %d, %v, want %d", pos, err, want.Len())
+	}
+	if got := w.Buffered(); got != want.Len()%64 {
+		t.Errorf("Buffered() = %d, want %d", got, want.Len()%64)
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Fatalf("Close() -> %v", err)
+	}
+	expectContents(t, fs, "/object", want.Bytes())
+
+	if writes > int64(want.Len()/64+1) {
+		t.Errorf("50 records took %d writes, want at most %d", writes,
+			want.Len()/64+1)
+	}
+}
+
+/*
+BenchmarkSmallWrites compares the number of Rados writes issued for many small
+writes with and without a BufferedWriter.
+*/
+func BenchmarkSmallWrites(b *testing.B) {
+	var record = []byte("a small record\n")
+
+	for _, c := range []struct {
+		name string
+		open func(ctx context.Context, fs *rados.RadosFileSystem) (
+			filesystem.WriteCloser, error)
+	}{
+		{"unbuffered", func(ctx context.Context, fs *rados.RadosFileSystem) (
+			filesystem.WriteCloser, error) {
+			return fs.OpenWriter(ctx, testURL("/object"))
+		}},
+		{"buffered", func(ctx context.Context, fs *rados.RadosFileSystem) (
+			filesystem.WriteCloser, error) {
+			return fs.OpenBufferedWriter(ctx, testURL("/object"), 64<<10)
+		}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			var ctx = context.Background()
+			var fs, conn = newTestFS(b)
+			var w filesystem.WriteCloser
+			var writes int64
+			var i, j int
+			var err error
+
+			conn.SetHook(failOperation("Write", 0, 0, &writes))
+			b.SetBytes(int64(1000 * len(record)))
+			b.ResetTimer()
+			for i = 0; i < b.N; i++ {
+				if w, err = c.open(ctx, fs); err != nil {
+					b.Fatalf("Opening the writer -> %v", err)
+				}
+				for j = 0; j < 1000; j++ {
+					if _, err = w.Write(ctx, record); err != nil {
+						b.Fatalf("Write() -> %v", err)
+					}
+				}
+				if err = w.Close(ctx); err != nil {
+					b.Fatalf("Close() -> %v", err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&writes))/float64(b.N),
+				"writes/op")
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package rados
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+/*
+chunkBuffers holds buffers of copyChunkSize bytes for streaming objects, so
+that they can be reused across transfers rather than allocated every time.
+*/
+var chunkBuffers = sync.Pool{
+	New: func() interface{} {
+		var buf = make([]byte, copyChunkSize)
+		return &buf
+	},
+}
+
+/*
+getChunkBuffer takes a buffer of copyChunkSize bytes from the pool. It must be
+returned using putChunkBuffer() once it is no longer used.
+*/
+func getChunkBuffer() *[]byte {
+	return chunkBuffers.Get().(*[]byte)
+}
+
+/*
+putChunkBuffer returns buf to the pool.
+*/
+func putChunkBuffer(buf *[]byte) {
+	chunkBuffers.Put(buf)
+}
+
+/*
+lineReaders holds buffered readers with lineReadAhead bytes of buffer space
+for LineReader.
+*/
+var lineReaders = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReaderSize(nil, lineReadAhead)
+	},
+}
+
+/*
+getLineReader takes a buffered reader from the pool and points it at r. It
+must be returned using putLineReader() once it is no longer used.
+*/
+func getLineReader(r io.Reader) *bufio.Reader {
+	var ret = lineReaders.Get().(*bufio.Reader)
+
+	ret.Reset(r)
+	return ret
+}
+
+/*
+putLineReader returns br to the pool, dropping its reference to the
+underlying reader.
+*/
+func putLineReader(br *bufio.Reader) {
+	br.Reset(nil)
+	lineReaders.Put(br)
+}
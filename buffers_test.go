@@ -0,0 +1,58 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func BenchmarkCopyTo(b *testing.B) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(b)
+	var data = bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	var i int
+	var err error
+
+	mustWrite(b, fs, "/large", data)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i = 0; i < b.N; i++ {
+		if _, err = fs.CopyTo(ctx, testURL("/large"), io.Discard); err != nil {
+			b.Fatalf("CopyTo() -> %v", err)
+		}
+	}
+}
+
+func BenchmarkLineReader(b *testing.B) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(b)
+	var data = bytes.Repeat([]byte("a line of text\n"), 1000)
+	var reader *rados.LineReader
+	var i int
+	var err error
+
+	mustWrite(b, fs, "/lines", data)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i = 0; i < b.N; i++ {
+		if reader, err = fs.NewLineReader(ctx, testURL("/lines")); err != nil {
+			b.Fatalf("NewLineReader() -> %v", err)
+		}
+		for err == nil {
+			_, err = reader.ReadLine()
+		}
+		if err != io.EOF {
+			b.Fatalf("ReadLine() -> %v", err)
+		}
+		if err = reader.Close(ctx); err != nil {
+			b.Fatalf("Close() -> %v", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package rados
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+/*
+ChecksumAlgorithm identifies a hash function used to checksum the contents of
+Rados objects.
+*/
+type ChecksumAlgorithm string
+
+const (
+	// ChecksumCRC32C uses the Castagnoli variant of CRC32.
+	ChecksumCRC32C ChecksumAlgorithm = "crc32c"
+	// ChecksumXXHash64 uses the 64 bit variant of xxHash.
+	ChecksumXXHash64 ChecksumAlgorithm = "xxhash64"
+	// ChecksumSHA256 uses SHA-256.
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+	// ChecksumMD5 uses MD5, for compatibility with tools expecting it.
+	ChecksumMD5 ChecksumAlgorithm = "md5"
+)
+
+/*
+DefaultChecksumXattr is the extended attribute checksums are kept in if no
+other attribute name has been configured.
+*/
+const DefaultChecksumXattr = "user.checksum"
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+/*
+newHash creates a new hash function for the algorithm.
+*/
+func (a ChecksumAlgorithm) newHash() (hash.Hash, error) {
+	switch a {
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), nil
+	case ChecksumXXHash64:
+		return xxhash.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("Unsupported checksum algorithm %q", string(a))
+	}
+}
+
+/*
+ChecksumConfig describes where checksums of Rados objects are stored and how
+they are computed.
+*/
+type ChecksumConfig struct {
+	/*
+		Xattr is the name of the extended attribute holding the checksum.
+		Defaults to DefaultChecksumXattr.
+	*/
+	Xattr string
+
+	/*
+		Algorithm is the hash function used for checksums which do not name
+		their own algorithm. Defaults to ChecksumCRC32C.
+	*/
+	Algorithm ChecksumAlgorithm
+}
+
+/*
+xattr returns the name of the extended attribute to use, applying defaults.
+*/
+func (c *ChecksumConfig) xattr() string {
+	if c.Xattr == "" {
+		return DefaultChecksumXattr
+	}
+	return c.Xattr
+}
+
+/*
+algorithm returns the checksum algorithm to use, applying defaults.
+*/
+func (c *ChecksumConfig) algorithm() ChecksumAlgorithm {
+	if c.Algorithm == "" {
+		return ChecksumCRC32C
+	}
+	return c.Algorithm
+}
+
+/*
+formatChecksum encodes a checksum for storage in an extended attribute, in the
+format understood by parseChecksum.
+*/
+func formatChecksum(alg ChecksumAlgorithm, digest []byte) string {
+	return string(alg) + ":" + hex.EncodeToString(digest)
+}
+
+/*
+parseChecksum decodes a checksum as stored in an extended attribute. Checksums
+are stored as "<algorithm>:<hex digest>"; a bare hex digest is interpreted
+using the default algorithm passed in.
+*/
+func parseChecksum(value string, def ChecksumAlgorithm) (
+	ChecksumAlgorithm, []byte, error) {
+	var alg = def
+	var digest []byte
+	var err error
+
+	value = strings.TrimSpace(value)
+	if idx := strings.IndexByte(value, ':'); idx >= 0 {
+		alg = ChecksumAlgorithm(value[:idx])
+		value = value[idx+1:]
+	}
+
+	if digest, err = hex.DecodeString(value); err != nil {
+		return alg, nil, fmt.Errorf("Malformed checksum %q: %s", value, err)
+	}
+	return alg, digest, nil
+}
@@ -0,0 +1,68 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+)
+
+func TestRewritesDropStaleChecksums(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var rewrites = map[string]func(u string) error{
+		"OpenWriter": func(u string) error {
+			return writeAndClose(fs.OpenWriter(ctx, testURL(u)))
+		},
+		"WriteFull": func(u string) error {
+			return fs.WriteFull(ctx, testURL(u), []byte("x"))
+		},
+		"CopyFrom": func(u string) error {
+			_, err := fs.CopyFrom(ctx, testURL(u), bytes.NewReader([]byte("x")))
+			return err
+		},
+	}
+	var name string
+	var fn func(u string) error
+	var err error
+
+	fs.SetChecksumVerification(&rados.ChecksumConfig{})
+	for name, fn = range rewrites {
+		fs.SetChecksumStorage(&rados.ChecksumConfig{})
+		mustWrite(t, fs, "/"+name, []byte("old"))
+		fs.SetChecksumStorage(nil)
+
+		if err = fn("/" + name); err != nil {
+			t.Fatalf("%s() -> %v", name, err)
+		}
+		expectContents(t, fs, "/"+name, []byte("x"))
+	}
+}
+
+func TestCopyFromChecksumFailureNamesObject(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var objErr *rados.ObjectError
+	var writes int64
+	var err error
+
+	fs.SetChecksumStorage(&rados.ChecksumConfig{})
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "OperateWrite" && atomic.AddInt64(&writes, 1) == 2 {
+			return radostest.Error(syscall.EIO)
+		}
+		return nil
+	})
+
+	if _, err = fs.CopyFrom(ctx, testURL("/object"),
+		bytes.NewReader([]byte("data"))); !errors.As(err, &objErr) ||
+		objErr.OID != "/object" {
+		t.Errorf("CopyFrom() failing to store the checksum -> %v, want an "+
+			"ObjectError for /object", err)
+	}
+}
@@ -0,0 +1,543 @@
+package rados
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ChunkNamePattern names the chunk objects of a chunked file below the name of
+the file, e.g. name/chunk.00000, name/chunk.00001, etc.
+*/
+const ChunkNamePattern = "%s/chunk.%05d"
+
+/*
+ChunkManifestName is the name of the manifest object of a chunked file,
+relative to the name of the file.
+*/
+const ChunkManifestName = "manifest"
+
+/*
+ChunkManifestVersion is the version of the chunk manifest format written by
+this package. Readers reject manifests with a newer version.
+*/
+const ChunkManifestVersion = 1
+
+/*
+ChunkInfo describes a single chunk object of a chunked file.
+*/
+type ChunkInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+
+	/*
+		Checksum is the CRC32C of the contents of the chunk.
+	*/
+	Checksum uint32 `json:"crc32c"`
+}
+
+/*
+ChunkManifest lists the chunk objects making up a chunked file, in order. It
+is stored as JSON in the object ChunkManifestName below the name of the file
+once the file has been written completely; a chunked file without a manifest
+is considered not to exist.
+*/
+type ChunkManifest struct {
+	/*
+		Version identifies the format of the manifest, see
+		ChunkManifestVersion.
+	*/
+	Version   int         `json:"version"`
+	ChunkSize int64       `json:"chunk_size"`
+	TotalSize int64       `json:"total_size"`
+	Chunks    []ChunkInfo `json:"chunks"`
+}
+
+/*
+ChunkedFile is a large logical file which is split into fixed-size chunk
+objects named after ChunkNamePattern, plus a manifest object listing them.
+This is similar to multipart uploads in S3: every chunk is an ordinary Rados
+object of manageable size, and readers only consider the file to exist once
+the manifest has been written.
+*/
+type ChunkedFile struct {
+	fs        *RadosFileSystem
+	entry     *contextEntry
+	oid       string
+	chunkSize int64
+}
+
+/*
+OpenChunkedFile refers to the chunked file u.Path in the specified pool
+(u.Host). chunkSize is the size of the chunks created by Writer(); it is not
+used for reading, where the chunk layout is taken from the manifest.
+*/
+func (r *RadosFileSystem) OpenChunkedFile(
+	ctx context.Context, u *url.URL, chunkSize int64) (*ChunkedFile, error) {
+	var entry *contextEntry
+	var err error
+
+	if chunkSize <= 0 {
+		return nil, os.ErrInvalid
+	}
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+
+	return &ChunkedFile{
+		fs:        r,
+		entry:     entry,
+		oid:       u.Path,
+		chunkSize: chunkSize,
+	}, nil
+}
+
+/*
+layout returns the StripeLayout of the chunks created by Writer().
+*/
+func (c *ChunkedFile) layout() StripeLayout {
+	return StripeLayout{ChunkSize: c.chunkSize, NamePattern: ChunkNamePattern}
+}
+
+/*
+manifestName returns the name of the manifest object of the file.
+*/
+func (c *ChunkedFile) manifestName() string {
+	return c.oid + "/" + ChunkManifestName
+}
+
+/*
+Manifest fetches and decodes the manifest of the chunked file. If the file
+has not been written completely, an error wrapping ErrObjectNotFound is
+returned.
+*/
+func (c *ChunkedFile) Manifest(ctx context.Context) (*ChunkManifest, error) {
+	var name = c.manifestName()
+	var manifest ChunkManifest
+	var stat rados.ObjectStat
+	var data []byte
+	var total int64
+	var idx int
+	var err error
+
+	if err = runWithContext(ctx, func() error {
+		var n int
+		var err error
+
+		if stat, err = c.entry.ioctx.Stat(name); err != nil {
+			return err
+		}
+		data = make([]byte, stat.Size)
+		n, err = c.entry.ioctx.Read(name, data, 0)
+		data = data[:n]
+		return err
+	}); err != nil {
+		return nil, objectError("read", c.entry.poolName, name, err)
+	}
+
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("Cannot parse chunk manifest %s: %w", name, err)
+	}
+	if manifest.Version < 1 || manifest.Version > ChunkManifestVersion {
+		return nil, fmt.Errorf("Unsupported chunk manifest version %d in %s",
+			manifest.Version, name)
+	}
+	for idx = range manifest.Chunks {
+		if manifest.Chunks[idx].Size < 0 {
+			return nil, fmt.Errorf("Inconsistent chunk manifest %s", name)
+		}
+		total += manifest.Chunks[idx].Size
+	}
+	if total != manifest.TotalSize {
+		return nil, fmt.Errorf("Inconsistent chunk manifest %s", name)
+	}
+	return &manifest, nil
+}
+
+/*
+writeManifest stores manifest as the manifest of the chunked file.
+*/
+func (c *ChunkedFile) writeManifest(
+	ctx context.Context, manifest *ChunkManifest) error {
+	var data []byte
+	var err error
+
+	if data, err = json.Marshal(manifest); err != nil {
+		return err
+	}
+	if err = runWithContext(ctx, func() error {
+		return c.entry.ioctx.WriteFull(c.manifestName(), data)
+	}); err != nil {
+		return objectError("write", c.entry.poolName, c.manifestName(), err)
+	}
+	return c.fs.indexAdd(ctx, c.entry, c.manifestName())
+}
+
+/*
+Writer opens the chunked file for writing from the beginning. The manifest is
+only replaced once the writer is closed, so readers keep seeing the previous
+version of the file until then. However, chunks are overwritten in place, so
+reading while a new version is being written may fail with
+ErrIncompleteStripe or ErrChecksumMismatch.
+*/
+func (c *ChunkedFile) Writer(ctx context.Context) (*ChunkedWriter, error) {
+	var err error
+
+	if err = c.fs.checkModifiable(ctx, c.entry, c.manifestName()); err != nil {
+		return nil, err
+	}
+
+	return &ChunkedWriter{
+		file: c,
+		stripe: &StripedWriter{
+			fs:      c.fs,
+			entry:   c.entry,
+			rctx:    c.entry.ioctx,
+			cluster: c.entry.cluster,
+			pool:    c.entry.poolName,
+			oid:     c.oid,
+			layout:  c.layout(),
+			retry:   c.fs.opRetry,
+			slots:   c.fs.slots,
+		},
+	}, nil
+}
+
+/*
+Reader opens the chunked file for reading. All chunks listed in the manifest
+are checked to be present with the expected sizes; if any are not, an error
+wrapping ErrIncompleteStripe is returned.
+*/
+func (c *ChunkedFile) Reader(ctx context.Context) (*ChunkedReader, error) {
+	var manifest *ChunkManifest
+	var stat rados.ObjectStat
+	var offsets []int64
+	var pos int64
+	var info ChunkInfo
+	var idx int
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if manifest, err = c.Manifest(ctx); err != nil {
+		return nil, err
+	}
+
+	offsets = make([]int64, len(manifest.Chunks))
+	for idx = range manifest.Chunks {
+		info = manifest.Chunks[idx]
+		if err = runWithContext(ctx, func() error {
+			var err error
+			stat, err = c.entry.ioctx.Stat(info.Name)
+			return err
+		}); err != nil {
+			if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+				return nil, fmt.Errorf("%w: chunk %s is missing",
+					ErrIncompleteStripe, info.Name)
+			}
+			return nil, objectError("stat", c.entry.poolName, info.Name, err)
+		}
+		if int64(stat.Size) != info.Size {
+			return nil, fmt.Errorf("%w: chunk %s has %d bytes, expected %d",
+				ErrIncompleteStripe, info.Name, stat.Size, info.Size)
+		}
+		offsets[idx] = pos
+		pos += info.Size
+	}
+
+	return &ChunkedReader{
+		file:     c,
+		manifest: manifest,
+		offsets:  offsets,
+	}, nil
+}
+
+/*
+Remove deletes the manifest and all chunks of the chunked file. The manifest
+is removed first, so that the file ceases to exist even if removing the
+chunks is interrupted.
+*/
+func (c *ChunkedFile) Remove(ctx context.Context) error {
+	var manifest *ChunkManifest
+	var info ChunkInfo
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if err = c.fs.checkModifiable(ctx, c.entry, c.manifestName()); err != nil {
+		return err
+	}
+	if manifest, err = c.Manifest(ctx); err != nil {
+		return err
+	}
+
+	if err = runWithContext(ctx, func() error {
+		return c.entry.ioctx.Delete(c.manifestName())
+	}); err != nil {
+		return objectError("remove", c.entry.poolName, c.manifestName(), err)
+	}
+	if err = c.fs.indexRemove(ctx, c.entry, c.manifestName()); err != nil {
+		return err
+	}
+
+	for _, info = range manifest.Chunks {
+		if err = runWithContext(ctx, func() error {
+			return c.entry.ioctx.Delete(info.Name)
+		}); err != nil {
+			if errno, ok = radosErrno(err); !ok || errno != syscall.ENOENT {
+				return objectError("remove", c.entry.poolName, info.Name, err)
+			}
+		}
+		if err = c.fs.indexRemove(ctx, c.entry, info.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ChunkedWriter writes a chunked file sequentially, rolling over to the next
+chunk object whenever one has been filled. The manifest is written by
+Close(); if the writer is not closed, the file is not updated.
+*/
+type ChunkedWriter struct {
+	file   *ChunkedFile
+	stripe *StripedWriter
+}
+
+/*
+Write appends p to the chunked file, splitting it across as many chunks as
+necessary. Returns the number of bytes written before an error occurred, if
+any.
+*/
+func (w *ChunkedWriter) Write(ctx context.Context, p []byte) (int, error) {
+	return w.stripe.Write(ctx, p)
+}
+
+/*
+Tell returns the number of bytes written so far.
+*/
+func (w *ChunkedWriter) Tell(ctx context.Context) (int64, error) {
+	return w.stripe.Tell(ctx)
+}
+
+/*
+Close finalizes the chunked file by writing its manifest. Closing more than
+once is harmless.
+*/
+func (w *ChunkedWriter) Close(ctx context.Context) error {
+	var manifest = ChunkManifest{
+		Version:   ChunkManifestVersion,
+		ChunkSize: w.file.chunkSize,
+	}
+	var count, idx int64
+	var err error
+
+	if w.stripe.closed {
+		return nil
+	}
+
+	count = w.stripe.chunkCount()
+	if err = w.stripe.Close(ctx); err != nil {
+		return err
+	}
+
+	for idx = 0; idx < count; idx++ {
+		manifest.Chunks = append(manifest.Chunks, ChunkInfo{
+			Name:     w.stripe.layout.chunkName(w.file.oid, idx),
+			Size:     w.file.chunkSize,
+			Checksum: w.stripe.checksums[idx],
+		})
+	}
+	if count > 0 {
+		manifest.Chunks[count-1].Size =
+			w.stripe.pos - (count-1)*w.file.chunkSize
+	}
+	manifest.TotalSize = w.stripe.pos
+
+	return w.file.writeManifest(ctx, &manifest)
+}
+
+/*
+ChunkedReader presents the chunks listed in the manifest of a chunked file as
+one continuous stream.
+*/
+type ChunkedReader struct {
+	file     *ChunkedFile
+	manifest *ChunkManifest
+
+	/*
+		offsets holds the position of the first byte of every chunk within
+		the file.
+	*/
+	offsets []int64
+	pos     int64
+	closed  bool
+}
+
+/*
+Manifest returns the manifest the reader was opened with.
+*/
+func (c *ChunkedReader) Manifest() *ChunkManifest {
+	return c.manifest
+}
+
+/*
+labels returns the metric labels for operations on this file.
+*/
+func (c *ChunkedReader) labels() prometheus.Labels {
+	return prometheus.Labels{
+		"cluster": c.file.entry.cluster, "pool": c.file.entry.poolName}
+}
+
+/*
+Read fetches up to len(p) bytes from the current position. A single call never
+reads across a chunk boundary, so fewer bytes than requested may be returned
+even if the end of the file has not been reached yet. io.EOF is returned once
+the end of the file has been reached.
+*/
+func (c *ChunkedReader) Read(ctx context.Context, p []byte) (int, error) {
+	var start = time.Now()
+	var info ChunkInfo
+	var chunk int
+	var off int64
+	var n int
+	var err error
+
+	if c.closed {
+		return 0, os.ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if c.pos >= c.manifest.TotalSize {
+		return 0, io.EOF
+	}
+
+	/* Find the last chunk starting at or before the current position. */
+	chunk = sort.Search(len(c.offsets), func(i int) bool {
+		return c.offsets[i] > c.pos
+	}) - 1
+	info = c.manifest.Chunks[chunk]
+	off = c.pos - c.offsets[chunk]
+	if int64(len(p)) > info.Size-off {
+		p = p[:info.Size-off]
+	}
+
+	if err = c.file.fs.slots.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer c.file.fs.slots.release()
+
+	if err = c.file.fs.opRetry.do(ctx, c.file.entry.cluster,
+		c.file.entry.poolName, "read", func() error {
+			var err error
+			n, err = c.file.entry.ioctx.Read(info.Name, p, uint64(off))
+			return err
+		}); err != nil {
+		radosReadErrors.With(c.labels()).Inc()
+		return 0, objectError("read", c.file.entry.poolName, info.Name, err)
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("%w: chunk %s is shorter than %d bytes",
+			ErrIncompleteStripe, info.Name, info.Size)
+	}
+
+	c.pos += int64(n)
+	radosReadLatencies.With(c.labels()).Observe(
+		time.Now().Sub(start).Seconds())
+	radosReadBytes.With(c.labels()).Add(float64(n))
+	return n, nil
+}
+
+/*
+VerifyChecksums reads all chunks listed in the manifest and compares them to
+the checksums recorded there. ErrChecksumMismatch is returned for the first
+chunk which does not match.
+*/
+func (c *ChunkedReader) VerifyChecksums(ctx context.Context) error {
+	var info ChunkInfo
+	var sum uint32
+	var err error
+
+	if c.closed {
+		return os.ErrClosed
+	}
+
+	for _, info = range c.manifest.Chunks {
+		if sum, err = chunkChecksum(
+			ctx, c.file.entry.ioctx, info.Name, info.Size); err != nil {
+			return err
+		}
+		if sum != info.Checksum {
+			return fmt.Errorf("%w: chunk %s", ErrChecksumMismatch, info.Name)
+		}
+	}
+	return nil
+}
+
+/*
+Size returns the total size of the chunked file according to its manifest.
+*/
+func (c *ChunkedReader) Size(ctx context.Context) (int64, error) {
+	if c.closed {
+		return 0, os.ErrClosed
+	}
+	return c.manifest.TotalSize, nil
+}
+
+/*
+Seek modifies the position in the chunked file as outlined in the io.Seeker
+API. Positions past the end of the file are rejected.
+*/
+func (c *ChunkedReader) Seek(
+	ctx context.Context, offset int64, whence int) (int64, error) {
+	var newpos int64
+
+	if c.closed {
+		return c.pos, os.ErrClosed
+	}
+
+	if whence == os.SEEK_SET {
+		newpos = offset
+	} else if whence == os.SEEK_CUR {
+		newpos = c.pos + offset
+	} else if whence == os.SEEK_END {
+		newpos = c.manifest.TotalSize + offset
+	} else {
+		return c.pos, os.ErrInvalid
+	}
+
+	if newpos < 0 || newpos > c.manifest.TotalSize {
+		return c.pos, os.ErrInvalid
+	}
+
+	c.pos = newpos
+	return newpos, nil
+}
+
+/*
+Tell returns the current position in the chunked file.
+*/
+func (c *ChunkedReader) Tell(ctx context.Context) (int64, error) {
+	return c.pos, nil
+}
+
+/*
+Close marks the ChunkedReader as closed. Further reads and seeks fail with
+os.ErrClosed.
+*/
+func (c *ChunkedReader) Close(ctx context.Context) error {
+	c.closed = true
+	return nil
+}
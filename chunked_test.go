@@ -0,0 +1,61 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestUploadRejectsShortData(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var file *rados.ChunkedFile
+	var err error
+
+	if file, err = fs.OpenChunkedFile(ctx, testURL("/short"), 4); err != nil {
+		t.Fatalf("OpenChunkedFile() -> %v", err)
+	}
+	if err = file.Upload(ctx, bytes.NewReader([]byte("0123456789")), 12, 2,
+		nil); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Upload() of 12 bytes from 10 -> %v, want "+
+			"io.ErrUnexpectedEOF", err)
+	}
+	if _, err = file.Manifest(ctx); err == nil {
+		t.Error("Upload() of short data wrote a manifest")
+	}
+}
+
+func TestUploadAbandonsSlowWrites(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var release = make(chan struct{})
+	var shortCtx context.Context
+	var cancel context.CancelFunc
+	var file *rados.ChunkedFile
+	var err error
+
+	defer close(release)
+
+	if file, err = fs.OpenChunkedFile(ctx, testURL("/slow"), 4); err != nil {
+		t.Fatalf("OpenChunkedFile() -> %v", err)
+	}
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "WriteFull" {
+			<-release
+		}
+		return nil
+	})
+
+	shortCtx, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err = file.Upload(shortCtx, bytes.NewReader([]byte("0123456789")),
+		10, 2, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Upload() with blocked writes -> %v, want "+
+			"DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,261 @@
+package rados
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+ChunkProgressFunc is called by Upload() and Download() of a ChunkedFile once
+the chunk number idx, described by info, has been transferred completely.
+Calls are serialized, but chunks complete in no particular order.
+*/
+type ChunkProgressFunc func(idx int, info ChunkInfo)
+
+/*
+forEachChunk runs fn for the chunks 0 to count-1, running up to workers of
+them concurrently. Once any of them fails or ctx expires, the context passed
+to the outstanding ones is cancelled, no further chunks are started and the
+first error is returned.
+*/
+func forEachChunk(ctx context.Context, count, workers int,
+	fn func(ctx context.Context, idx int) error) error {
+	var sem chan struct{}
+	var cancel context.CancelFunc
+	var wg sync.WaitGroup
+	var errMtx sync.Mutex
+	var firstErr error
+	var idx int
+
+	if workers < 1 {
+		workers = 1
+	}
+	sem = make(chan struct{}, workers)
+
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	for idx = 0; idx < count; idx++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(idx int) {
+			var err error
+
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err = fn(ctx, idx); err != nil {
+				errMtx.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMtx.Unlock()
+				cancel()
+			}
+		}(idx)
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+/*
+Upload replaces the contents of the chunked file with size bytes read from
+data, writing up to workers chunks concurrently. If data holds fewer than
+size bytes, io.ErrUnexpectedEOF is returned. progress, if not nil, is
+called for every chunk once it has been written. The manifest is only written
+once all chunks have been stored; if any chunk fails or ctx expires, the
+outstanding chunk writes are abandoned and the first error is returned.
+*/
+func (c *ChunkedFile) Upload(ctx context.Context, data io.ReaderAt,
+	size int64, workers int, progress ChunkProgressFunc) error {
+	var layout = c.layout()
+	var manifest = ChunkManifest{
+		Version:   ChunkManifestVersion,
+		ChunkSize: c.chunkSize,
+		TotalSize: size,
+	}
+	var start = time.Now()
+	var progressMtx sync.Mutex
+	var count int64
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if err = c.fs.checkModifiable(ctx, c.entry, c.manifestName()); err != nil {
+		return err
+	}
+	if size < 0 {
+		return os.ErrInvalid
+	}
+
+	count = (size + c.chunkSize - 1) / c.chunkSize
+	manifest.Chunks = make([]ChunkInfo, count)
+
+	if err = forEachChunk(ctx, int(count), workers,
+		func(ctx context.Context, idx int) error {
+			var info = ChunkInfo{
+				Name: layout.chunkName(c.oid, int64(idx)),
+				Size: c.chunkSize,
+			}
+			var buf []byte
+			var n int
+			var err error
+
+			if int64(idx) == count-1 {
+				info.Size = size - int64(idx)*c.chunkSize
+			}
+			buf = make([]byte, info.Size)
+
+			/*
+			   data may end in io.EOF along with the last bytes of the
+			   chunk, but must not end before size bytes.
+			*/
+			if n, err = data.ReadAt(
+				buf, int64(idx)*c.chunkSize); n < len(buf) {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			info.Checksum = crc32.Checksum(buf, crc32cTable)
+
+			if err = c.fs.slots.acquire(ctx); err != nil {
+				return err
+			}
+			defer c.fs.slots.release()
+
+			if err = c.fs.opRetry.do(ctx, c.entry.cluster, c.entry.poolName,
+				"write", func() error {
+					return runWithContext(ctx, func() error {
+						return c.entry.ioctx.WriteFull(info.Name, buf)
+					})
+				}); err != nil {
+				return objectError("write", c.entry.poolName, info.Name, err)
+			}
+			if err = c.fs.indexAdd(ctx, c.entry, info.Name); err != nil {
+				return err
+			}
+
+			manifest.Chunks[idx] = info
+			if progress != nil {
+				progressMtx.Lock()
+				progress(idx, info)
+				progressMtx.Unlock()
+			}
+			return nil
+		}); err != nil {
+		radosWriteErrors.With(c.entry.labels()).Inc()
+		countNoSpace(c.entry.cluster, c.entry.poolName, err)
+		return err
+	}
+
+	/*
+	   Remove the chunk following the last one in case it is left over from
+	   an earlier, longer version of the file, as StripedWriter does.
+	*/
+	if err = runWithContext(ctx, func() error {
+		return c.entry.ioctx.Delete(layout.chunkName(c.oid, count))
+	}); err != nil {
+		if errno, ok = radosErrno(err); !ok || errno != syscall.ENOENT {
+			return objectError("remove", c.entry.poolName,
+				layout.chunkName(c.oid, count), err)
+		}
+	} else if err = c.fs.indexRemove(
+		ctx, c.entry, layout.chunkName(c.oid, count)); err != nil {
+		return err
+	}
+
+	if err = c.writeManifest(ctx, &manifest); err != nil {
+		return err
+	}
+
+	radosWriteLatencies.With(c.entry.labels()).Observe(
+		time.Now().Sub(start).Seconds())
+	radosWriteBytes.With(c.entry.labels()).Add(float64(size))
+	return nil
+}
+
+/*
+Download reads the entire chunked file into w, fetching up to workers chunks
+concurrently, and returns the size of the file. Every chunk is checked against
+the checksum in the manifest; ErrChecksumMismatch is returned for chunks
+which do not match. progress, if not nil, is called for every chunk once it
+has been written to w. If any chunk fails or ctx expires, the outstanding
+chunk reads are abandoned and the first error is returned; w may have
+received some of the chunks at that point.
+*/
+func (c *ChunkedFile) Download(ctx context.Context, w io.WriterAt,
+	workers int, progress ChunkProgressFunc) (int64, error) {
+	var manifest *ChunkManifest
+	var offsets []int64
+	var start = time.Now()
+	var progressMtx sync.Mutex
+	var pos int64
+	var idx int
+	var err error
+
+	if manifest, err = c.Manifest(ctx); err != nil {
+		return 0, err
+	}
+
+	offsets = make([]int64, len(manifest.Chunks))
+	for idx = range manifest.Chunks {
+		offsets[idx] = pos
+		pos += manifest.Chunks[idx].Size
+	}
+
+	if err = forEachChunk(ctx, len(manifest.Chunks), workers,
+		func(ctx context.Context, idx int) error {
+			var info = manifest.Chunks[idx]
+			var data []byte
+			var err error
+
+			if data, err = c.fs.readRange(ctx, c.entry, info.Name,
+				Range{Offset: 0, Length: info.Size}); err != nil {
+				return err
+			}
+			if int64(len(data)) != info.Size {
+				return fmt.Errorf("%w: chunk %s has %d bytes, expected %d",
+					ErrIncompleteStripe, info.Name, len(data), info.Size)
+			}
+			if crc32.Checksum(data, crc32cTable) != info.Checksum {
+				return fmt.Errorf("%w: chunk %s", ErrChecksumMismatch,
+					info.Name)
+			}
+			if _, err = w.WriteAt(data, offsets[idx]); err != nil {
+				return err
+			}
+
+			if progress != nil {
+				progressMtx.Lock()
+				progress(idx, info)
+				progressMtx.Unlock()
+			}
+			return nil
+		}); err != nil {
+		radosReadErrors.With(c.entry.labels()).Inc()
+		return 0, err
+	}
+
+	radosReadLatencies.With(c.entry.labels()).Observe(
+		time.Now().Sub(start).Seconds())
+	radosReadBytes.With(c.entry.labels()).Add(float64(manifest.TotalSize))
+	return manifest.TotalSize, nil
+}
@@ -0,0 +1,207 @@
+package rados
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"syscall"
+)
+
+/*
+CompressParameter is the URL query parameter selecting the codec objects are
+compressed with by OpenWriter(), e.g. rados://pool/log?compress=gzip.
+*/
+const CompressParameter = "compress"
+
+/*
+CompressionXattr is the extended attribute recording the codec an object has
+been compressed with, so that OpenReader() knows how to decompress it.
+*/
+const CompressionXattr = "user.compression"
+
+/*
+CompressionGzip compresses objects using gzip.
+*/
+const CompressionGzip = "gzip"
+
+/*
+urlCompression returns the codec requested by the CompressParameter of u, or
+an empty string if none was requested. Unknown codecs are rejected with an
+error wrapping os.ErrInvalid.
+*/
+func urlCompression(u *url.URL) (string, error) {
+	var codec = u.Query().Get(CompressParameter)
+
+	if codec != "" && codec != CompressionGzip {
+		return "", fmt.Errorf("%w: unsupported compression %q",
+			os.ErrInvalid, codec)
+	}
+	return codec, nil
+}
+
+/*
+objectCompression returns the codec oid has been compressed with, or an empty
+string if it is not compressed. Objects which do not exist are reported as
+not compressed; reading them fails later on.
+*/
+func objectCompression(rctx IOContext, oid string) (string, error) {
+	var buf = make([]byte, 16)
+	var n int
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if n, err = rctx.GetXattr(oid, CompressionXattr, buf); err != nil {
+		if errno, ok = radosErrno(err); ok &&
+			(errno == syscall.ENODATA || errno == syscall.ENOENT) {
+			return "", nil
+		}
+		return "", err
+	}
+	if string(buf[:n]) != CompressionGzip {
+		return "", fmt.Errorf("Unsupported compression %q of Rados object %s",
+			string(buf[:n]), oid)
+	}
+	return string(buf[:n]), nil
+}
+
+/*
+checkUncompressed refuses the operation op on oid with an error wrapping
+ErrUnsupported if the object has been compressed, since offsets into it would
+refer to the compressed data rather than the contents.
+*/
+func checkUncompressed(op string, entry *contextEntry, oid string) error {
+	var codec string
+	var err error
+
+	if codec, err = objectCompression(entry.ioctx, oid); err != nil {
+		return objectError(op, entry.poolName, oid, err)
+	}
+	if codec != "" {
+		return objectError(op, entry.poolName, oid, fmt.Errorf(
+			"%w: object is compressed with %s", ErrUnsupported, codec))
+	}
+	return nil
+}
+
+/*
+contextIO adapts a ReadWriteCloser to io.Reader and io.Writer for the gzip
+package, using the context of the call currently in progress.
+*/
+type contextIO struct {
+	rwc *ReadWriteCloser
+	ctx context.Context
+}
+
+/*
+Read reads from the underlying ReadWriteCloser.
+*/
+func (c *contextIO) Read(p []byte) (int, error) {
+	return c.rwc.Read(c.ctx, p)
+}
+
+/*
+Write writes to the underlying ReadWriteCloser.
+*/
+func (c *contextIO) Write(p []byte) (int, error) {
+	return c.rwc.Write(c.ctx, p)
+}
+
+/*
+gzipWriter compresses data before handing it to the underlying Rados writer,
+so the write metrics of the ReadWriteCloser count compressed bytes.
+*/
+type gzipWriter struct {
+	io     contextIO
+	gz     *gzip.Writer
+	closed bool
+}
+
+/*
+newGzipWriter creates a gzipWriter writing through w.
+*/
+func newGzipWriter(w *ReadWriteCloser) *gzipWriter {
+	var ret = &gzipWriter{io: contextIO{rwc: w}}
+
+	ret.gz = gzip.NewWriter(&ret.io)
+	return ret
+}
+
+/*
+Write compresses p. Compressed data is written out whenever the compressor
+has accumulated enough of it.
+*/
+func (g *gzipWriter) Write(ctx context.Context, p []byte) (int, error) {
+	if g.closed {
+		return 0, os.ErrClosed
+	}
+	g.io.ctx = ctx
+	return g.gz.Write(p)
+}
+
+/*
+Close writes out the remaining compressed data and closes the underlying
+Rados writer. Closing more than once is harmless.
+*/
+func (g *gzipWriter) Close(ctx context.Context) error {
+	var err error
+
+	if g.closed {
+		return nil
+	}
+	g.closed = true
+
+	g.io.ctx = ctx
+	if err = g.gz.Close(); err != nil {
+		g.io.rwc.Close(ctx)
+		return err
+	}
+	return g.io.rwc.Close(ctx)
+}
+
+/*
+gzipReader decompresses data read from the underlying Rados reader, so the
+read metrics of the ReadWriteCloser count compressed bytes.
+*/
+type gzipReader struct {
+	io     contextIO
+	gz     *gzip.Reader
+	closed bool
+}
+
+/*
+newGzipReader creates a gzipReader reading through r. The gzip header is only
+read by the first Read().
+*/
+func newGzipReader(r *ReadWriteCloser) *gzipReader {
+	return &gzipReader{io: contextIO{rwc: r}}
+}
+
+/*
+Read returns decompressed data.
+*/
+func (g *gzipReader) Read(ctx context.Context, p []byte) (int, error) {
+	var err error
+
+	if g.closed {
+		return 0, os.ErrClosed
+	}
+
+	g.io.ctx = ctx
+	if g.gz == nil {
+		if g.gz, err = gzip.NewReader(&g.io); err != nil {
+			return 0, err
+		}
+	}
+	return g.gz.Read(p)
+}
+
+/*
+Close closes the underlying Rados reader. Closing more than once is harmless.
+*/
+func (g *gzipReader) Close(ctx context.Context) error {
+	g.closed = true
+	return g.io.rwc.Close(ctx)
+}
@@ -0,0 +1,131 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestReadFileDecompresses(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var u = testURL("/compressed")
+	var buf bytes.Buffer
+	var err error
+
+	u.RawQuery = rados.CompressParameter + "=" + rados.CompressionGzip
+	if err = writeAndClose(fs.OpenWriter(ctx, u)); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+
+	expectContents(t, fs, "/compressed", []byte("x"))
+	if _, err = fs.CopyTo(ctx, testURL("/compressed"), &buf); err != nil {
+		t.Fatalf("CopyTo() -> %v", err)
+	}
+	if buf.String() != "x" {
+		t.Errorf("CopyTo() copied %q, want %q", buf.String(), "x")
+	}
+}
+
+func TestRewritesDropCompression(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var rewrites = map[string]func(u string) error{
+		"OpenWriter": func(u string) error {
+			return writeAndClose(fs.OpenWriter(ctx, testURL(u)))
+		},
+		"WriteFull": func(u string) error {
+			return fs.WriteFull(ctx, testURL(u), []byte("x"))
+		},
+		"CopyFrom": func(u string) error {
+			_, err := fs.CopyFrom(ctx, testURL(u), bytes.NewReader([]byte("x")))
+			return err
+		},
+		"WriteAtomic": func(u string) error {
+			return fs.WriteAtomic(ctx, testURL(u), bytes.NewReader([]byte("x")))
+		},
+		"Update": func(u string) error {
+			return fs.Update(ctx, testURL(u), func(old []byte) ([]byte, error) {
+				return old, nil
+			})
+		},
+	}
+	var compressed = testURL("")
+	var name string
+	var fn func(u string) error
+	var err error
+
+	compressed.RawQuery = rados.CompressParameter + "=" + rados.CompressionGzip
+	for name, fn = range rewrites {
+		compressed.Path = "/" + name
+		if err = writeAndClose(fs.OpenWriter(ctx, compressed)); err != nil {
+			t.Fatalf("OpenWriter(%s) -> %v", compressed, err)
+		}
+		if err = fn("/" + name); err != nil {
+			t.Fatalf("%s() -> %v", name, err)
+		}
+		expectContents(t, fs, "/"+name, []byte("x"))
+	}
+}
+
+func TestCompressedObjectsRefuseRawAccess(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var u = testURL("/compressed")
+	var ops = map[string]func() error{
+		"OpenAppender": func() error {
+			_, err := fs.OpenAppender(ctx, u)
+			return err
+		},
+		"OpenRecordAppender": func() error {
+			_, err := fs.OpenRecordAppender(ctx, u, false)
+			return err
+		},
+		"OpenBatchingAppender": func() error {
+			_, err := fs.OpenBatchingAppender(ctx, u, 10, 0)
+			return err
+		},
+		"OpenReaderAt": func() error {
+			_, err := fs.OpenReaderAt(ctx, u)
+			return err
+		},
+		"OpenRecordReader": func() error {
+			_, err := fs.OpenRecordReader(ctx, u, false)
+			return err
+		},
+		"OpenSectionReader": func() error {
+			_, err := fs.OpenSectionReader(ctx, u, 0, 1)
+			return err
+		},
+		"SparseRead": func() error {
+			_, _, err := fs.SparseRead(ctx, u, 0, 1)
+			return err
+		},
+		"ReadRanges": func() error {
+			_, err := fs.ReadRanges(ctx, u, []rados.Range{{Length: 1}})
+			return err
+		},
+		"Readv": func() error {
+			_, err := fs.Readv(ctx, u, []rados.Range{{Length: 1}})
+			return err
+		},
+	}
+	var compressed = *u
+	var name string
+	var fn func() error
+	var err error
+
+	compressed.RawQuery = rados.CompressParameter + "=" + rados.CompressionGzip
+	if err = writeAndClose(fs.OpenWriter(ctx, &compressed)); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+
+	for name, fn = range ops {
+		if err = fn(); !errors.Is(err, rados.ErrUnsupported) {
+			t.Errorf("%s() -> %v, want ErrUnsupported", name, err)
+		}
+	}
+}
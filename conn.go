@@ -0,0 +1,569 @@
+package rados
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+/*
+maxReadOpAttempts is the number of times a ReadOp is attempted against a real
+cluster before giving up because the object keeps changing underneath it.
+*/
+const maxReadOpAttempts = 5
+
+/*
+maxXattrSize is the largest extended attribute value a ReadOp will fetch.
+*/
+const maxXattrSize = 64 << 10
+
+/*
+maxIdlePrivateContexts is the number of private I/O contexts kept open per
+shared context for reuse, see sharedIOContext.
+*/
+const maxIdlePrivateContexts = 4
+
+/*
+Conn is the subset of a Rados cluster connection used by RadosFileSystem. It is
+implemented on top of go-ceph for real clusters, and by the radostest package
+for tests.
+*/
+type Conn interface {
+	OpenIOContext(pool string) (IOContext, error)
+	ListPools() ([]string, error)
+
+	/*
+		PoolPGCount returns the number of placement groups of the named pool,
+		which determines the positions an Iter can be seeked to.
+	*/
+	PoolPGCount(pool string) (uint32, error)
+
+	/*
+		GetClusterStats reports the capacity and usage of the cluster.
+	*/
+	GetClusterStats() (rados.ClusterStat, error)
+
+	/*
+		GetFSID returns the unique ID of the cluster.
+	*/
+	GetFSID() (string, error)
+
+	/*
+		Shutdown disconnects from the cluster. The connection cannot be used
+		afterwards.
+	*/
+	Shutdown()
+}
+
+/*
+IOContext is the subset of a Rados I/O context used by this package.
+
+I/O contexts are cached and shared by all operations on the same pool and
+namespace, see sharedIOContext. Operations on objects name the object in
+every call and are safe to use concurrently. GetLastVersion() reports the
+version seen by the most recent operation of any caller, so shared contexts
+refuse it; withVersion() runs an operation on a private context instead.
+SetNamespace() changes the context for all callers and is therefore only
+allowed before the context is shared; changing it afterwards panics.
+Destroy() is only called by RadosFileSystem.Shutdown().
+*/
+type IOContext interface {
+	GetPoolName() (string, error)
+	Read(oid string, data []byte, offset uint64) (int, error)
+	Write(oid string, data []byte, offset uint64) error
+	WriteFull(oid string, data []byte) error
+	Append(oid string, data []byte) error
+	Truncate(oid string, size uint64) error
+	Stat(oid string) (rados.ObjectStat, error)
+	Delete(oid string) error
+	Iter() (Iter, error)
+	GetXattr(oid, name string, data []byte) (int, error)
+	SetXattr(oid, name string, data []byte) error
+	RmXattr(oid, name string) error
+	ListXattrs(oid string) (map[string][]byte, error)
+	GetLastVersion() (uint64, error)
+	SetNamespace(namespace string)
+	RequiresAlignment() (bool, error)
+
+	/*
+		LockExclusive and Unlock behave like their go-ceph counterparts: a
+		lock held by another client is reported by returning -EBUSY rather
+		than an error, and releasing a lock which is not held returns
+		-ENOENT.
+	*/
+	LockExclusive(oid, name, cookie, desc string, duration time.Duration,
+		flags *byte) (int, error)
+	Unlock(oid, name, cookie string) (int, error)
+	Alignment() (uint64, error)
+	Destroy()
+	Exec(oid, class, method string, in []byte) ([]byte, error)
+	CreateSnap(name string) error
+	RemoveSnap(name string) error
+	ListSnaps() ([]rados.SnapID, error)
+	LookupSnap(name string) (rados.SnapID, error)
+	GetSnapName(id rados.SnapID) (string, error)
+	GetSnapStamp(id rados.SnapID) (time.Time, error)
+	GetAllOmapValues(oid, startAfter, filterPrefix string,
+		iteratorSize int64) (map[string][]byte, error)
+
+	/*
+		OperateWrite applies all steps of op to the object atomically.
+	*/
+	OperateWrite(oid string, op *WriteOp) error
+
+	/*
+		OperateRead executes all steps of op against the object and fills in
+		their results.
+	*/
+	OperateRead(oid string, op *ReadOp) error
+}
+
+/*
+Iter iterates over the object IDs of a pool. Objects are returned ordered by
+the placement group they are stored in; Token() returns the placement group
+of the current position, and Seek() moves to the first object of the given
+placement group.
+*/
+type Iter interface {
+	Next() bool
+	Value() string
+	Err() error
+	Close()
+	Seek(token rados.IterToken)
+	Token() rados.IterToken
+}
+
+var _ Conn = radosConn{}
+var _ IOContext = radosIOContext{}
+
+/*
+radosConn implements Conn on top of a go-ceph connection.
+*/
+type radosConn struct {
+	*rados.Conn
+
+	/*
+		poolFlags holds the operation flags to use for the I/O contexts of
+		specific pools.
+	*/
+	poolFlags map[string]rados.OperationFlags
+}
+
+/*
+OpenIOContext opens a go-ceph I/O context for the named pool, applying the
+operation flags configured for the pool.
+*/
+func (c radosConn) OpenIOContext(pool string) (IOContext, error) {
+	var ioctx *rados.IOContext
+	var err error
+
+	if ioctx, err = c.Conn.OpenIOContext(pool); err != nil {
+		return nil, err
+	}
+	return c.wrapIOContext(pool, ioctx), nil
+}
+
+/*
+wrapIOContext wraps the go-ceph I/O context ioctx of pool, applying the
+operation flags configured for the pool.
+*/
+func (c radosConn) wrapIOContext(pool string,
+	ioctx *rados.IOContext) radosIOContext {
+	return radosIOContext{IOContext: ioctx, flags: c.poolFlags[pool]}
+}
+
+/*
+PoolPGCount asks the monitors for the pg_num setting of the named pool.
+*/
+func (c radosConn) PoolPGCount(pool string) (uint32, error) {
+	var cmd []byte
+	var res []byte
+	var status string
+	var reply struct {
+		PGNum uint32 `json:"pg_num"`
+	}
+	var err error
+
+	if cmd, err = json.Marshal(map[string]string{
+		"prefix": "osd pool get",
+		"pool":   pool,
+		"var":    "pg_num",
+		"format": "json",
+	}); err != nil {
+		return 0, err
+	}
+
+	if res, status, err = c.Conn.MonCommand(cmd); err != nil {
+		return 0, fmt.Errorf("osd pool get %s pg_num -> %w (%s)", pool,
+			err, status)
+	}
+	if err = json.Unmarshal(res, &reply); err != nil {
+		return 0, fmt.Errorf("Cannot parse pg_num of pool %s: %w", pool, err)
+	}
+	return reply.PGNum, nil
+}
+
+/*
+radosIOContext implements IOContext on top of a go-ceph I/O context.
+*/
+type radosIOContext struct {
+	*rados.IOContext
+
+	/*
+		flags are passed to every compound read and write operation.
+	*/
+	flags rados.OperationFlags
+}
+
+/*
+Iter creates an iterator over all objects in the pool.
+*/
+func (i radosIOContext) Iter() (Iter, error) {
+	return i.IOContext.Iter()
+}
+
+/*
+OperateWrite translates op into a go-ceph write operation and executes it.
+*/
+func (i radosIOContext) OperateWrite(oid string, op *WriteOp) error {
+	var wop = rados.CreateWriteOp()
+	var step WriteStep
+
+	defer wop.Release()
+
+	for _, step = range op.Steps() {
+		switch step.Kind {
+		case WriteStepAssertVersion:
+			wop.AssertVersion(step.Version)
+		case WriteStepWriteFull:
+			wop.WriteFull(step.Data)
+		case WriteStepSetXattr:
+			wop.SetXattr(step.Name, step.Data)
+		case WriteStepAssertExists:
+			wop.AssertExists()
+		case WriteStepCreate:
+			if step.Exclusive {
+				wop.Create(rados.CreateExclusive)
+			} else {
+				wop.Create(rados.CreateIdempotent)
+			}
+		case WriteStepWrite:
+			wop.Write(step.Data, step.Offset)
+		case WriteStepAppend:
+			wop.Append(step.Data)
+		case WriteStepTruncate:
+			wop.Truncate(step.Offset)
+		case WriteStepZero:
+			wop.Zero(step.Offset, step.Length)
+		case WriteStepRemove:
+			wop.Remove()
+		case WriteStepRmXattr:
+			wop.RmXattr(step.Name)
+		case WriteStepSetOmap:
+			wop.SetOmap(step.Pairs)
+		case WriteStepRmOmapKeys:
+			wop.RmOmapKeys(step.Keys)
+		default:
+			return fmt.Errorf("Unsupported write step %d", step.Kind)
+		}
+	}
+
+	return wop.Operate(i.IOContext, oid, i.flags)
+}
+
+/*
+OperateRead executes op as a go-ceph ReadOp. go-ceph read operations can only
+read data, so extended attributes and object status are fetched beforehand and
+the reads are then made conditional on the object version observed at that
+point. If the object changes in between, the whole operation is retried,
+unless op asserts a version itself; then the version mismatch is returned.
+*/
+func (i radosIOContext) OperateRead(oid string, op *ReadOp) error {
+	var stat rados.ObjectStat
+	var version uint64
+	var asserted bool
+	var step *ReadStep
+	var attempt int
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	for _, step = range op.Steps() {
+		if step.Kind == ReadStepAssertVersion {
+			asserted = true
+			version = step.Version
+		}
+	}
+
+	for attempt = 0; attempt < maxReadOpAttempts; attempt++ {
+		/*
+		   The version is only needed if op does not assert one, and the
+		   status only if op asks for it or reads a lot.
+		*/
+		if !asserted || needsStat(op) {
+			if stat, err = i.IOContext.Stat(oid); err != nil {
+				return err
+			}
+		}
+		if !asserted {
+			if version, err = i.IOContext.GetLastVersion(); err != nil {
+				return err
+			}
+		}
+
+		if err = i.operateRead(oid, op, stat, version); err == nil {
+			return nil
+		}
+		if errno, ok = radosErrno(err); asserted || !ok ||
+			(errno != syscall.ECANCELED && errno != syscall.ERANGE &&
+				errno != syscall.EOVERFLOW) {
+			return err
+		}
+	}
+
+	return err
+}
+
+/*
+needsStat reports whether op contains a ReadStepStat step, or reads of more
+than copyChunkSize bytes, whose buffers are sized by the object size instead.
+*/
+func needsStat(op *ReadOp) bool {
+	var step *ReadStep
+
+	for _, step = range op.Steps() {
+		if step.Kind == ReadStepStat ||
+			(step.Kind == ReadStepRead && step.Length > copyChunkSize) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+readLength returns the size of the buffer for the read step, which is cut
+short at the end of the object if the read is large enough for needsStat()
+to have fetched the object size into stat.
+*/
+func readLength(step *ReadStep, stat rados.ObjectStat) uint64 {
+	if step.Length <= copyChunkSize {
+		return step.Length
+	}
+	if step.Offset >= stat.Size {
+		return 0
+	}
+	if step.Length > stat.Size-step.Offset {
+		return stat.Size - step.Offset
+	}
+	return step.Length
+}
+
+/*
+operateRead runs a single attempt of OperateRead against the object version
+observed alongside stat.
+*/
+func (i radosIOContext) operateRead(
+	oid string, op *ReadOp, stat rados.ObjectStat, version uint64) error {
+	var rop = rados.CreateReadOp()
+	var reads = make([]*rados.ReadOpReadStep, len(op.Steps()))
+	var buf []byte
+	var step *ReadStep
+	var idx int
+	var n int
+	var err error
+
+	defer rop.Release()
+
+	rop.AssertVersion(version)
+
+	for idx, step = range op.Steps() {
+		switch step.Kind {
+		case ReadStepRead:
+			reads[idx] = rop.Read(step.Offset,
+				make([]byte, readLength(step, stat)))
+		case ReadStepGetXattr:
+			buf = make([]byte, maxXattrSize)
+			if n, err = i.IOContext.GetXattr(oid, step.Name, buf); err != nil {
+				return err
+			}
+			step.Data = buf[:n]
+		case ReadStepStat:
+			step.Size = stat.Size
+			step.ModTime = stat.ModTime
+		case ReadStepAssertVersion:
+			/* Covered by the version assertion of rop. */
+		default:
+			return fmt.Errorf("Unsupported read step %d", step.Kind)
+		}
+	}
+
+	if err = rop.Operate(i.IOContext, oid, i.flags); err != nil {
+		return err
+	}
+
+	for idx, step = range op.Steps() {
+		if reads[idx] != nil {
+			step.Data = reads[idx].Buffer[:reads[idx].BytesRead]
+		}
+	}
+	return nil
+}
+
+/*
+sharedIOContext wraps I/O contexts held in the context cache. Since they are
+shared by all operations on the pool, changing their settings would affect
+unrelated callers; operations needing different settings, such as another
+namespace, must open a separate context instead.
+
+For the same reason, the version reported by GetLastVersion() may stem from
+the operation of another caller. Operations which need to know the version
+of the object they operated on borrow a private context of the same pool and
+namespace through withVersion(). Idle private contexts are kept for reuse.
+
+Operations on a shared context hold off Destroy() until they are done, and
+fail with ErrClosed once it has been destroyed, see contextGuard.
+*/
+type sharedIOContext struct {
+	IOContext
+
+	/*
+		openPrivate opens a new private context for the pool and namespace of
+		the shared one.
+	*/
+	openPrivate func() (IOContext, error)
+
+	private *privateContexts
+	guard   *contextGuard
+}
+
+/*
+privateContexts holds the idle private contexts of a sharedIOContext.
+*/
+type privateContexts struct {
+	mtx       sync.Mutex
+	idle      []IOContext
+	destroyed bool
+}
+
+/*
+newSharedIOContext wraps ioctx for the context cache. openPrivate is used to
+open private contexts for withVersion().
+*/
+func newSharedIOContext(ioctx IOContext,
+	openPrivate func() (IOContext, error)) sharedIOContext {
+	return sharedIOContext{
+		IOContext:   ioctx,
+		openPrivate: openPrivate,
+		private:     &privateContexts{},
+		guard:       &contextGuard{},
+	}
+}
+
+/*
+SetNamespace panics, as changing the namespace of a shared context would
+redirect the operations of all other users of the context.
+*/
+func (s sharedIOContext) SetNamespace(namespace string) {
+	panic("rados: SetNamespace called on a shared I/O context; use a " +
+		"namespace specific context from getNamespaceContext() instead")
+}
+
+/*
+GetLastVersion fails, since the version of a shared context may have been
+set by the operation of another caller. Use withVersion() instead.
+*/
+func (s sharedIOContext) GetLastVersion() (uint64, error) {
+	return 0, fmt.Errorf("%w: version of a shared I/O context", ErrUnsupported)
+}
+
+/*
+OperateRead executes op on a private context, since OperateRead() relies on
+GetLastVersion() to make all steps observe the same version of the object.
+*/
+func (s sharedIOContext) OperateRead(oid string, op *ReadOp) error {
+	var _, err = withVersion(s, func(rctx IOContext) error {
+		return rctx.OperateRead(oid, op)
+	})
+	return err
+}
+
+/*
+Destroy waits for the operations in progress on the context to finish, then
+destroys the idle private contexts along with the shared context. Private
+contexts still in use are destroyed once they are returned.
+*/
+func (s sharedIOContext) Destroy() {
+	var rctx IOContext
+
+	s.guard.close()
+
+	s.private.mtx.Lock()
+	s.private.destroyed = true
+	for _, rctx = range s.private.idle {
+		rctx.Destroy()
+	}
+	s.private.idle = nil
+	s.private.mtx.Unlock()
+
+	s.IOContext.Destroy()
+}
+
+/*
+withVersion runs fn against an I/O context of the same pool and namespace as
+rctx which no other operation uses meanwhile, and returns the object version
+observed by the last operation of fn. Shared contexts lend a private context
+for this; other contexts are used directly.
+*/
+func withVersion(rctx IOContext, fn func(rctx IOContext) error) (
+	uint64, error) {
+	var shared sharedIOContext
+	var private IOContext
+	var version uint64
+	var n int
+	var ok bool
+	var err error
+
+	if shared, ok = rctx.(sharedIOContext); !ok {
+		if err = fn(rctx); err != nil {
+			return 0, err
+		}
+		return rctx.GetLastVersion()
+	}
+
+	if err = shared.guard.enter(); err != nil {
+		return 0, err
+	}
+	defer shared.guard.exit()
+
+	shared.private.mtx.Lock()
+	if n = len(shared.private.idle); n > 0 {
+		private = shared.private.idle[n-1]
+		shared.private.idle = shared.private.idle[:n-1]
+	}
+	shared.private.mtx.Unlock()
+
+	if private == nil {
+		if private, err = shared.openPrivate(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = fn(private); err == nil {
+		version, err = private.GetLastVersion()
+	}
+
+	shared.private.mtx.Lock()
+	if shared.private.destroyed ||
+		len(shared.private.idle) >= maxIdlePrivateContexts {
+		private.Destroy()
+	} else {
+		shared.private.idle = append(shared.private.idle, private)
+	}
+	shared.private.mtx.Unlock()
+
+	return version, err
+}
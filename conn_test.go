@@ -0,0 +1,48 @@
+package rados_test
+
+import (
+	"errors"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+)
+
+func TestSharedIOContextRefusesMutations(t *testing.T) {
+	var conn = radostest.NewConn()
+	var ioctx rados.IOContext
+	var op rados.ReadOp
+	var step *rados.ReadStep
+	var err error
+
+	conn.CreatePool(testPool)
+	if ioctx, err = rados.OpenSharedIOContext(conn, testPool); err != nil {
+		t.Fatalf("OpenSharedIOContext() -> %v", err)
+	}
+	defer ioctx.Destroy()
+
+	if err = ioctx.WriteFull("/object", []byte("data")); err != nil {
+		t.Fatalf("WriteFull() -> %v", err)
+	}
+	if _, err = ioctx.GetLastVersion(); !errors.Is(err,
+		rados.ErrUnsupported) {
+		t.Errorf("GetLastVersion() on a shared context -> %v, want %v", err,
+			rados.ErrUnsupported)
+	}
+
+	step = op.Read(0, 4)
+	if err = ioctx.OperateRead("/object", &op); err != nil ||
+		string(step.Data) != "data" {
+		t.Errorf("OperateRead() on a shared context -> %q, %v, want \"data\"",
+			step.Data, err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("SetNamespace() on a shared context did not panic")
+			}
+		}()
+		ioctx.SetNamespace("other")
+	}()
+}
@@ -0,0 +1,49 @@
+package rados
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+/*
+Increment atomically adds delta to the counter stored in the Rados object
+named u.Path in the pool pointed at by u.Host, and returns the new value. The
+counter is stored as a decimal number; objects which are empty or do not
+exist yet count as 0. Concurrent increments are serialized using Update(), so
+none of them are lost; under heavy contention, Increment may give up with an
+error wrapping ErrLocked.
+*/
+func (r *RadosFileSystem) Increment(
+	ctx context.Context, u *url.URL, delta int64) (int64, error) {
+	var value int64
+	var err error
+
+	if err = r.Update(ctx, u, func(old []byte) ([]byte, error) {
+		var err error
+
+		value = 0
+		if old = bytes.TrimSpace(old); len(old) > 0 {
+			if value, err = strconv.ParseInt(string(old), 10, 64); err != nil {
+				return nil, fmt.Errorf("Rados object %s/%s is not a "+
+					"counter: %w", u.Host, u.Path, err)
+			}
+		}
+
+		if (delta > 0 && value > math.MaxInt64-delta) ||
+			(delta < 0 && value < math.MinInt64-delta) {
+			return nil, fmt.Errorf("Counter %s/%s would overflow: %w",
+				u.Host, u.Path, strconv.ErrRange)
+		}
+
+		value += delta
+		return []byte(strconv.FormatInt(value, 10)), nil
+	}); err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
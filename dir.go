@@ -0,0 +1,42 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/childoftheuniverse/filesystem"
+)
+
+/*
+DirPlaceholder is the name of the zero-byte object MkDir() creates inside a
+directory to make it show up in listings while it has no other entries.
+*/
+const DirPlaceholder = ".keep"
+
+/*
+SetDirectoryPlaceholders enables or disables MkDir() and the hiding of
+directory placeholder objects in listings, as with WithDirectoryPlaceholders().
+*/
+func (r *RadosFileSystem) SetDirectoryPlaceholders(enabled bool) {
+	r.dirPlaceholders.Store(enabled)
+}
+
+/*
+MkDir makes the directory u.Path in the pool pointed at by u.Host show up in
+listings even if no objects exist below it, by creating a zero-byte
+placeholder object named DirPlaceholder inside it. Creating a directory which
+exists already is not an error.
+
+Returns filesystem.EUNSUPP unless directory placeholders have been enabled.
+*/
+func (r *RadosFileSystem) MkDir(ctx context.Context, u *url.URL) error {
+	var placeholder = *u
+
+	if !r.dirPlaceholders.Load() {
+		return filesystem.EUNSUPP
+	}
+
+	placeholder.Path = strings.TrimSuffix(u.Path, "/") + "/" + DirPlaceholder
+	return r.Touch(ctx, &placeholder)
+}
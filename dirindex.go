@@ -0,0 +1,261 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+/*
+DirIndexObject is the name of the object holding the index of a directory
+when directory indexing is enabled. Its OMAP maps the names of the entries of
+the directory to DirIndexFile or DirIndexDir.
+*/
+const DirIndexObject = ".index"
+
+/*
+Values stored in the OMAP of directory index objects.
+*/
+const (
+	DirIndexFile = "f"
+	DirIndexDir  = "d"
+)
+
+/*
+dirIndexBatch is the number of index entries fetched per round trip.
+*/
+const dirIndexBatch = 1000
+
+/*
+SetDirectoryIndex enables or disables directory indexing, as with
+WithDirectoryIndex().
+*/
+func (r *RadosFileSystem) SetDirectoryIndex(enabled bool) {
+	r.dirIndex.Store(enabled)
+}
+
+/*
+indexObjectName returns the name of the index object of the directory dir.
+*/
+func indexObjectName(dir string) string {
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		return DirIndexObject
+	}
+	return dir + "/" + DirIndexObject
+}
+
+/*
+splitParent splits oid into the directory containing it and its name within
+that directory.
+*/
+func splitParent(oid string) (string, string) {
+	var idx = strings.LastIndex(oid, "/")
+
+	if idx < 0 {
+		return "", oid
+	}
+	return oid[:idx], oid[idx+1:]
+}
+
+/*
+indexAdd records oid in the index of its directory, and every directory above
+it in the index of its respective parent. This is a no-op unless directory
+indexing is enabled.
+*/
+func (r *RadosFileSystem) indexAdd(
+	ctx context.Context, entry *contextEntry, oid string) error {
+	var dir, name string
+	var kind = DirIndexFile
+	var err error
+
+	if !r.dirIndex.Load() {
+		return nil
+	}
+
+	dir, name = splitParent(strings.Trim(oid, "/"))
+	for name != "" {
+		if err = indexSet(ctx, entry, dir, map[string][]byte{
+			name: []byte(kind),
+		}); err != nil {
+			return err
+		}
+		kind = DirIndexDir
+		dir, name = splitParent(dir)
+	}
+	return nil
+}
+
+/*
+indexSet adds pairs to the index of dir.
+*/
+func indexSet(ctx context.Context, entry *contextEntry, dir string,
+	pairs map[string][]byte) error {
+	var op WriteOp
+
+	op.Create(false)
+	op.SetOmap(pairs)
+	return objectError("index", entry.poolName, indexObjectName(dir),
+		runWithContext(ctx, func() error {
+			return entry.ioctx.OperateWrite(indexObjectName(dir), &op)
+		}))
+}
+
+/*
+indexRemove removes oid from the index of its directory. Parent directories
+keep listing the directory even if it has become empty; Reindex() cleans
+these up. This is a no-op unless directory indexing is enabled.
+*/
+func (r *RadosFileSystem) indexRemove(
+	ctx context.Context, entry *contextEntry, oid string) error {
+	var dir, name string
+	var op WriteOp
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if !r.dirIndex.Load() {
+		return nil
+	}
+
+	dir, name = splitParent(strings.Trim(oid, "/"))
+	op.AssertExists()
+	op.RmOmapKeys([]string{name})
+	if err = runWithContext(ctx, func() error {
+		return entry.ioctx.OperateWrite(indexObjectName(dir), &op)
+	}); err != nil {
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+			return nil
+		}
+		return objectError("index", entry.poolName, indexObjectName(dir), err)
+	}
+	return nil
+}
+
+/*
+readIndex returns the entries recorded in the index of dir, mapped to whether
+they are directories. If dir has no index, nil is returned without an error.
+*/
+func readIndex(ctx context.Context, entry *contextEntry, dir string) (
+	map[string]bool, error) {
+	var values map[string][]byte
+	var set = make(map[string]bool)
+	var name string
+	var value []byte
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		values, err = entry.ioctx.GetAllOmapValues(
+			indexObjectName(dir), "", "", dirIndexBatch)
+		return err
+	}); err != nil {
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+			return nil, nil
+		}
+		return nil, objectError("index", entry.poolName, indexObjectName(dir),
+			err)
+	}
+
+	for name, value = range values {
+		set[name] = string(value) == DirIndexDir
+	}
+	return set, nil
+}
+
+/*
+Reindex rebuilds the directory indexes of u.Path and all directories below it
+in the pool pointed at by u.Host from a full scan of the pool. This is needed
+after enabling indexing on an existing pool, after objects have been written
+by other means than this package, and to drop directories which have become
+empty from the indexes.
+*/
+func (r *RadosFileSystem) Reindex(ctx context.Context, u *url.URL) error {
+	var entry *contextEntry
+	var iter Iter
+	var indexes = make(map[string]map[string][]byte)
+	var prefix = strings.Trim(u.Path, "/")
+	var dir, name string
+	var pairs map[string][]byte
+	var existing map[string]bool
+	var stale []string
+	var kind string
+	var ok bool
+	var oid string
+	var op *WriteOp
+	var err error
+
+	if err = r.checkWritable(); err != nil {
+		return err
+	}
+	if entry, err = r.getURLContext(ctx, u); err != nil {
+		return err
+	}
+
+	indexes[prefix] = make(map[string][]byte)
+
+	if iter, err = entry.ioctx.Iter(); err != nil {
+		return objectError("list", entry.poolName, u.Path, err)
+	}
+	for iter.Next() {
+		oid = strings.Trim(iter.Value(), "/")
+		if prefix != "" && !strings.HasPrefix(oid, prefix+"/") {
+			continue
+		}
+		if _, name = splitParent(oid); name == DirIndexObject {
+			continue
+		}
+
+		/*
+		   Record the object in its directory, and each directory in its
+		   parent, up to the prefix being reindexed.
+		*/
+		kind = DirIndexFile
+		for len(oid) > len(prefix) {
+			dir, name = splitParent(oid)
+			if indexes[dir] == nil {
+				indexes[dir] = make(map[string][]byte)
+			}
+			indexes[dir][name] = []byte(kind)
+			kind = DirIndexDir
+			oid = dir
+		}
+	}
+	err = iter.Err()
+	iter.Close()
+	if err != nil {
+		return objectError("list", entry.poolName, u.Path, err)
+	}
+
+	for dir, pairs = range indexes {
+		if existing, err = readIndex(ctx, entry, dir); err != nil {
+			return err
+		}
+		stale = nil
+		for name = range existing {
+			if _, ok = pairs[name]; !ok {
+				stale = append(stale, name)
+			}
+		}
+
+		op = new(WriteOp)
+		op.Create(false)
+		if len(stale) > 0 {
+			op.RmOmapKeys(stale)
+		}
+		if len(pairs) > 0 {
+			op.SetOmap(pairs)
+		}
+		if err = runWithContext(ctx, func() error {
+			return entry.ioctx.OperateWrite(indexObjectName(dir), op)
+		}); err != nil {
+			return objectError("index", entry.poolName, indexObjectName(dir),
+				err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+/*
+listNames returns the sorted entries listed by fs below oid, leaving out the
+index objects themselves.
+*/
+func listNames(t *testing.T, fs *rados.RadosFileSystem, oid string) []string {
+	var entries []rados.Entry
+	var names []string
+	var entry rados.Entry
+	var err error
+
+	t.Helper()
+	if entries, err = fs.ListEntriesTyped(context.Background(),
+		testURL(oid)); err != nil {
+		t.Fatalf("ListEntriesTyped(%s) -> %v", oid, err)
+	}
+	for _, entry = range entries {
+		if entry.Name == rados.DirIndexObject {
+			continue
+		}
+		if entry.IsDir {
+			names = append(names, entry.Name+"/")
+		} else {
+			names = append(names, entry.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDirectoryIndexCoversAllWriters(t *testing.T) {
+	var ctx = context.Background()
+	var indexed, conn = newTestFS(t)
+	var scanned = rados.NewRadosFileSystemWithConn(conn)
+	var writers = map[string]func(u string) error{
+		"OpenWriter": func(u string) error {
+			return writeAndClose(indexed.OpenWriter(ctx, testURL(u)))
+		},
+		"OpenAppender": func(u string) error {
+			return writeAndClose(indexed.OpenAppender(ctx, testURL(u)))
+		},
+		"OpenEncryptedWriter": func(u string) error {
+			return writeAndClose(indexed.OpenEncryptedWriter(ctx, testURL(u),
+				staticKeys{}))
+		},
+		"OpenIntegrityWriter": func(u string) error {
+			return writeAndClose(indexed.OpenIntegrityWriter(ctx, testURL(u),
+				nil))
+		},
+		"OpenRecordAppender": func(u string) error {
+			return writeAndClose(indexed.OpenRecordAppender(ctx, testURL(u),
+				false))
+		},
+		"WriteIfVersion": func(u string) error {
+			var version uint64
+			var err error
+
+			if err = scanned.WriteFull(ctx, testURL(u), nil); err != nil {
+				return err
+			}
+			if version, err = scanned.Version(ctx, testURL(u)); err != nil {
+				return err
+			}
+			return indexed.WriteIfVersion(ctx, testURL(u), []byte("x"),
+				version)
+		},
+		"OperateWrite": func(u string) error {
+			var op rados.WriteOp
+			op.WriteFull([]byte("x"))
+			return indexed.OperateWrite(ctx, testURL(u), &op)
+		},
+		"OpenStripedWriter": func(u string) error {
+			var w, err = indexed.OpenStripedWriter(ctx, testURL(u),
+				rados.StripeLayout{ChunkSize: 4})
+			if err != nil {
+				return err
+			}
+			w.SetWriteManifest(true)
+			if _, err = w.Write(ctx, []byte("0123456789")); err != nil {
+				return err
+			}
+			return w.Close(ctx)
+		},
+		"Upload": func(u string) error {
+			var file, err = indexed.OpenChunkedFile(ctx, testURL(u), 4)
+			if err != nil {
+				return err
+			}
+			return file.Upload(ctx, bytes.NewReader([]byte("0123456789")),
+				10, 2, nil)
+		},
+	}
+	var name string
+	var fn func(u string) error
+	var err error
+
+	indexed.SetDirectoryIndex(true)
+
+	for name, fn = range writers {
+		if err = fn("/" + name + "/object"); err != nil {
+			t.Fatalf("%s() -> %v", name, err)
+		}
+		if got, want := listNames(t, indexed, "/"+name),
+			listNames(t, scanned, "/"+name); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: index lists %v, want %v", name, got, want)
+		}
+	}
+	if got, want := listNames(t, indexed, "/"),
+		listNames(t, scanned, "/"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Index of / lists %v, want %v", got, want)
+	}
+}
+
+func TestDirectoryIndexForgetsRemovedObjects(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var file *rados.ChunkedFile
+	var op rados.WriteOp
+	var err error
+
+	fs.SetDirectoryIndex(true)
+
+	mustWrite(t, fs, "/dir/a", []byte("a"))
+	mustWrite(t, fs, "/dir/b", []byte("b"))
+	op.Remove()
+	if err = fs.OperateWrite(ctx, testURL("/dir/a"), &op); err != nil {
+		t.Fatalf("OperateWrite() -> %v", err)
+	}
+	if got := listNames(t, fs, "/dir"); !reflect.DeepEqual(
+		got, []string{"b"}) {
+		t.Errorf("Index lists %v after removal, want [b]", got)
+	}
+
+	if file, err = fs.OpenChunkedFile(ctx, testURL("/chunked"), 4); err != nil {
+		t.Fatalf("OpenChunkedFile() -> %v", err)
+	}
+	if err = file.Upload(ctx, bytes.NewReader([]byte("0123456789")), 10, 2,
+		nil); err != nil {
+		t.Fatalf("Upload() -> %v", err)
+	}
+	if err = file.Remove(ctx); err != nil {
+		t.Fatalf("Remove() -> %v", err)
+	}
+	if got := listNames(t, fs, "/chunked"); len(got) != 0 {
+		t.Errorf("Index lists %v after removing the chunked file", got)
+	}
+}
+
+/*
+writeAndClose writes a few bytes to the writer returned by an Open function
+and closes it.
+*/
+func writeAndClose(w filesystem.WriteCloser, err error) error {
+	var ctx = context.Background()
+
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(ctx, []byte("x")); err != nil {
+		return err
+	}
+	return w.Close(ctx)
+}
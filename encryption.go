@@ -0,0 +1,374 @@
+package rados
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/childoftheuniverse/filesystem"
+)
+
+/*
+ErrDecryptionFailed is returned when reading an encrypted Rados object fails
+to authenticate, i.e. the wrong key was used or the stored data has been
+tampered with or truncated.
+*/
+var ErrDecryptionFailed = errors.New("Rados object decryption failed")
+
+const (
+	/*
+		encryptionNonceXattr and encryptionKeyIDXattr name the extended
+		attributes holding the base nonce and the ID of the key used to encrypt
+		an object.
+	*/
+	encryptionNonceXattr = "user.crypt.nonce"
+	encryptionKeyIDXattr = "user.crypt.keyid"
+
+	/*
+		encryptionChunkSize is the amount of plaintext encrypted and
+		authenticated as one unit. Each chunk is stored as ciphertext followed
+		by the GCM tag.
+	*/
+	encryptionChunkSize = 64 << 10
+
+	/*
+		maxKeyIDSize is the largest key ID which will be read from an object.
+	*/
+	maxKeyIDSize = 1024
+)
+
+/*
+KeyProvider supplies the keys for client-side encryption of Rados objects.
+Keys must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+*/
+type KeyProvider interface {
+	/*
+		CurrentKey returns the key new objects should be encrypted with, along
+		with an identifier which will be stored with the object.
+	*/
+	CurrentKey() (keyID string, key []byte, err error)
+
+	/*
+		Key returns the key with the given identifier for decrypting objects.
+	*/
+	Key(keyID string) ([]byte, error)
+}
+
+/*
+newChunkAEAD creates the AES-GCM cipher for the given key.
+*/
+func newChunkAEAD(key []byte) (cipher.AEAD, error) {
+	var block cipher.Block
+	var err error
+
+	if block, err = aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+chunkNonce derives the nonce for the chunk with the given index from the base
+nonce of the object, and the additional data binding the chunk to its place in
+the object so chunks cannot be reordered or the object truncated unnoticed.
+*/
+func chunkNonce(base []byte, index uint64, final bool) ([]byte, []byte) {
+	var nonce = make([]byte, len(base))
+	var ad = make([]byte, 9)
+	var i int
+
+	copy(nonce, base)
+	binary.BigEndian.PutUint64(ad, index)
+	for i = 0; i < 8; i++ {
+		nonce[len(nonce)-8+i] ^= ad[i]
+	}
+	if final {
+		ad[8] = 1
+	}
+	return nonce, ad
+}
+
+/*
+OpenEncryptedWriter opens the specified Rados object (u.Path) in the specified
+pool (u.Host), truncates it and returns a writer which encrypts all data with
+the current key of keys before it is written. The nonce and key ID are stored
+in extended attributes of the object, in the same atomic operation which
+truncates it, so that the object never carries the nonce of other contents.
+Compression records and checksums of the previous contents are removed.
+
+Data is encrypted in chunks so that large objects do not need to be buffered.
+The writer must be closed for the object to be readable.
+*/
+func (r *RadosFileSystem) OpenEncryptedWriter(
+	ctx context.Context, u *url.URL, keys KeyProvider) (
+	filesystem.WriteCloser, error) {
+	var entry *contextEntry
+	var op WriteOp
+	var aead cipher.AEAD
+	var nonce []byte
+	var keyID string
+	var key []byte
+	var err error
+
+	if keyID, key, err = keys.CurrentKey(); err != nil {
+		return nil, err
+	}
+	if aead, err = newChunkAEAD(key); err != nil {
+		return nil, err
+	}
+
+	nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if err = clearXattrs(
+		entry.ioctx, u.Path, &op, r.contentXattrs()); err != nil {
+		return nil, objectError("truncate", entry.poolName, u.Path, err)
+	}
+	op.WriteFull([]byte{})
+	op.SetXattr(encryptionNonceXattr, nonce)
+	op.SetXattr(encryptionKeyIDXattr, []byte(keyID))
+	if err = entry.ioctx.OperateWrite(u.Path, &op); err != nil {
+		return nil, objectError("truncate", entry.poolName, u.Path, err)
+	}
+	if err = r.indexAdd(ctx, entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		w:     r.openReadWriteCloser(entry, u.Path),
+		aead:  aead,
+		nonce: nonce,
+		buf:   make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+/*
+encryptingWriter encrypts data chunk by chunk before handing it to the
+underlying Rados writer.
+*/
+type encryptingWriter struct {
+	w     *ReadWriteCloser
+	aead  cipher.AEAD
+	nonce []byte
+	buf   []byte
+	index uint64
+
+	/*
+		closed is set once Close() has been called, and closeErr holds its
+		result.
+	*/
+	closed   bool
+	closeErr error
+}
+
+/*
+Write buffers p and encrypts and writes out every chunk which has been filled.
+*/
+func (e *encryptingWriter) Write(ctx context.Context, p []byte) (int, error) {
+	var written int
+	var n int
+	var err error
+
+	if e.closed {
+		return 0, os.ErrClosed
+	}
+
+	for len(p) > 0 {
+		n = copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(e.buf) == cap(e.buf) {
+			if err = e.writeChunk(ctx, false); err != nil {
+				return written - len(e.buf), err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+/*
+writeChunk encrypts the buffered data as the next chunk and writes it out.
+*/
+func (e *encryptingWriter) writeChunk(ctx context.Context, final bool) error {
+	var nonce, ad = chunkNonce(e.nonce, e.index, final)
+	var err error
+
+	if _, err = e.w.Write(ctx, e.aead.Seal(nil, nonce, e.buf, ad)); err != nil {
+		return err
+	}
+
+	e.buf = e.buf[:0]
+	e.index++
+	return nil
+}
+
+/*
+Close encrypts and writes the final, possibly empty, chunk. The final chunk is
+always shorter than a full chunk, which marks the end of the object. Closing
+more than once does not write another chunk, but reports the result of the
+first Close again.
+*/
+func (e *encryptingWriter) Close(ctx context.Context) error {
+	if e.closed {
+		return e.closeErr
+	}
+	e.closed = true
+
+	if e.closeErr = e.writeChunk(ctx, true); e.closeErr != nil {
+		return e.closeErr
+	}
+	e.buf = nil
+	e.closeErr = e.w.Close(ctx)
+	return e.closeErr
+}
+
+/*
+OpenEncryptedReader opens the specified Rados object (u.Path) in the specified
+pool (u.Host), which must have been written using OpenEncryptedWriter(), and
+returns a reader which decrypts the data using the matching key from keys.
+
+If the key is wrong or the data has been tampered with, reads will fail with
+ErrDecryptionFailed. No data from a chunk is returned before the chunk has
+been authenticated.
+*/
+func (r *RadosFileSystem) OpenEncryptedReader(
+	ctx context.Context, u *url.URL, keys KeyProvider) (
+	filesystem.ReadCloser, error) {
+	var entry *contextEntry
+	var aead cipher.AEAD
+	var nonce = make([]byte, 64)
+	var keyID = make([]byte, maxKeyIDSize)
+	var key []byte
+	var n int
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if n, err = entry.ioctx.GetXattr(
+		u.Path, encryptionNonceXattr, nonce); err != nil {
+		return nil, err
+	}
+	nonce = nonce[:n]
+	if n, err = entry.ioctx.GetXattr(
+		u.Path, encryptionKeyIDXattr, keyID); err != nil {
+		return nil, err
+	}
+	keyID = keyID[:n]
+
+	if key, err = keys.Key(string(keyID)); err != nil {
+		return nil, err
+	}
+	if aead, err = newChunkAEAD(key); err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+
+	return &decryptingReader{
+		r:     r.openReadWriteCloser(entry, u.Path),
+		aead:  aead,
+		nonce: nonce,
+		chunk: make([]byte, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+/*
+decryptingReader reads and authenticates encrypted data chunk by chunk from
+the underlying Rados reader.
+*/
+type decryptingReader struct {
+	r      *ReadWriteCloser
+	aead   cipher.AEAD
+	nonce  []byte
+	chunk  []byte
+	plain  []byte
+	index  uint64
+	done   bool
+	closed bool
+}
+
+/*
+Read returns decrypted data from the current chunk, fetching and decrypting the
+next chunk from Rados when the current one is exhausted.
+*/
+func (d *decryptingReader) Read(ctx context.Context, p []byte) (int, error) {
+	var n int
+	var err error
+
+	if d.closed {
+		return 0, os.ErrClosed
+	}
+
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err = d.readChunk(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+/*
+readChunk fetches the next encrypted chunk and decrypts it. A chunk shorter
+than the full chunk size is the final chunk of the object.
+*/
+func (d *decryptingReader) readChunk(ctx context.Context) error {
+	var nonce, ad []byte
+	var final bool
+	var total int
+	var n int
+	var err error
+
+	for total < len(d.chunk) {
+		n, err = d.r.Read(ctx, d.chunk[total:])
+		total += n
+		if err == io.EOF {
+			final = true
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	nonce, ad = chunkNonce(d.nonce, d.index, final)
+	if d.plain, err = d.aead.Open(
+		d.chunk[:0], nonce, d.chunk[:total], ad); err != nil {
+		return ErrDecryptionFailed
+	}
+
+	d.index++
+	d.done = final
+	return nil
+}
+
+/*
+Close releases the chunk buffer and the underlying Rados reader. Closing more
+than once is harmless.
+*/
+func (d *decryptingReader) Close(ctx context.Context) error {
+	d.closed = true
+	d.chunk = nil
+	d.plain = nil
+	return d.r.Close(ctx)
+}
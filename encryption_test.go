@@ -0,0 +1,66 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestEncryptedWriterTruncatesInOneOperation(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var ops []string
+	var reader filesystem.ReadCloser
+	var data []byte
+	var n int
+	var objErr *rados.ObjectError
+	var err error
+
+	if err = writeAndClose(fs.OpenEncryptedWriter(ctx, testURL("/secret"),
+		staticKeys{})); err != nil {
+		t.Fatalf("OpenEncryptedWriter() -> %v", err)
+	}
+
+	conn.SetHook(func(op, pool, oid string) error {
+		if oid == "/secret" {
+			ops = append(ops, op)
+		}
+		return nil
+	})
+	if err = writeAndClose(fs.OpenEncryptedWriter(ctx, testURL("/secret"),
+		staticKeys{})); err != nil {
+		t.Fatalf("OpenEncryptedWriter() on an existing object -> %v", err)
+	}
+	for _, op := range ops {
+		if op == "WriteFull" || op == "SetXattr" {
+			t.Errorf("OpenEncryptedWriter() used a separate %s", op)
+		}
+	}
+
+	if reader, err = fs.OpenEncryptedReader(ctx, testURL("/secret"),
+		staticKeys{}); err != nil {
+		t.Fatalf("OpenEncryptedReader() -> %v", err)
+	}
+	data = make([]byte, 16)
+	if n, err = reader.Read(ctx, data); (err != nil && err != io.EOF) ||
+		string(data[:n]) != "x" {
+		t.Errorf("Reading the encrypted object -> %q, %v, want \"x\"",
+			data[:n], err)
+	}
+
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "OperateWrite" {
+			return errors.New("injected failure")
+		}
+		return nil
+	})
+	if _, err = fs.OpenEncryptedWriter(ctx, testURL("/secret"),
+		staticKeys{}); !errors.As(err, &objErr) || objErr.OID != "/secret" {
+		t.Errorf("OpenEncryptedWriter() with a failing write -> %v, "+
+			"want an ObjectError for /secret", err)
+	}
+}
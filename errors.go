@@ -0,0 +1,306 @@
+package rados
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/childoftheuniverse/filesystem"
+)
+
+/*
+ErrSizeLimitExceeded is returned by writers when a Write would grow the Rados
+object beyond the size limit configured on the writer. Data up to the limit
+will still have been written.
+*/
+var ErrSizeLimitExceeded = errors.New("Rados object size limit exceeded")
+
+/*
+ErrVersionMismatch is returned by conditional writes when the version of the
+Rados object no longer matches the version the caller expected, i.e. the
+object has been modified in the meantime.
+*/
+var ErrVersionMismatch = errors.New("Rados object version mismatch")
+
+/*
+ErrChecksumMismatch is returned when the contents of a Rados object do not
+match the checksum stored alongside it.
+*/
+var ErrChecksumMismatch = errors.New("Rados object checksum mismatch")
+
+/*
+ErrInvalidURL is returned for URLs which cannot refer to a Rados object, e.g.
+because they lack the pool (rados:///object) or the object name
+(rados://pool) where one is required, or contain ".." segments.
+*/
+var ErrInvalidURL = errors.New("Invalid Rados URL")
+
+/*
+ErrPoolNotFound is returned when the Rados pool named in a URL does not exist,
+as opposed to the object named in it.
+*/
+var ErrPoolNotFound = errors.New("Rados pool not found")
+
+/*
+ErrConnectionClosed is returned when the connection to the Rados cluster has
+been shut down, so the operation cannot succeed no matter how often it is
+retried.
+*/
+var ErrConnectionClosed = errors.New("Rados connection closed")
+
+/*
+ErrClosed is returned by operations on a RadosFileSystem after Shutdown() has
+been called on it.
+*/
+var ErrClosed = errors.New("Rados filesystem has been shut down")
+
+/*
+ErrIncompleteStripe is returned when the chunks of a striped file do not match
+its manifest, e.g. because an upload was interrupted.
+*/
+var ErrIncompleteStripe = errors.New("Striped Rados file is incomplete")
+
+/*
+ErrImmutable is returned when attempting to modify or delete a Rados object
+which has been marked immutable, see MarkImmutable().
+*/
+var ErrImmutable = errors.New("Rados object is immutable")
+
+/*
+ErrReadOnly is returned by all operations which would modify the cluster when
+the RadosFileSystem has been set up read-only, see WithReadOnly().
+*/
+var ErrReadOnly = errors.New("Rados filesystem is read-only")
+
+/*
+ErrObjectNotFound is returned when the Rados object an operation refers to
+does not exist.
+*/
+var ErrObjectNotFound = errors.New("Rados object not found")
+
+/*
+ErrObjectExists is returned when creating a Rados object exclusively which
+exists already.
+*/
+var ErrObjectExists = errors.New("Rados object exists")
+
+/*
+ErrNoSpace is returned when the cluster or the pool is full, or a quota has
+been reached, and when writes are refused early because of
+WithSpaceHighWater(). Such writes are counted in the
+rados_write_nospace_total metric. Callers should back off and retry later
+rather than retrying right away, which only adds load to a full cluster.
+*/
+var ErrNoSpace = errors.New("No space left in Rados pool")
+
+/*
+ErrLocked is returned when a Rados object is locked by another client.
+*/
+var ErrLocked = errors.New("Rados object is locked")
+
+/*
+ErrUnsupported is returned for operations Rados cannot perform. It is the same
+error as filesystem.EUNSUPP.
+*/
+var ErrUnsupported = filesystem.EUNSUPP
+
+/*
+ErrSnapshotNotFound is returned when a pool snapshot referred to by name does
+not exist.
+*/
+var ErrSnapshotNotFound = errors.New("Rados pool snapshot not found")
+
+/*
+ErrSnapshotExists is returned when creating a pool snapshot under a name which
+is taken already.
+*/
+var ErrSnapshotExists = errors.New("Rados pool snapshot exists")
+
+/*
+ErrOffsetOverflow is returned when an object size or position cannot be
+represented as a (signed) 64 bit offset.
+*/
+var ErrOffsetOverflow = errors.New("Rados object offset out of range")
+
+/*
+errnoSentinels maps the errno values returned by Rados to the sentinel errors
+of this package:
+
+  - ENOENT: ErrObjectNotFound (ErrPoolNotFound when opening a pool)
+  - EEXIST: ErrObjectExists
+  - ENOSPC, EDQUOT: ErrNoSpace
+  - EBUSY: ErrLocked
+  - EROFS: ErrReadOnly
+  - EOPNOTSUPP: ErrUnsupported
+
+Errors returned by operations on objects can be matched against these using
+errors.Is(); the original Rados error remains accessible through errors.As().
+*/
+var errnoSentinels = map[syscall.Errno]error{
+	syscall.ENOENT:     ErrObjectNotFound,
+	syscall.EEXIST:     ErrObjectExists,
+	syscall.ENOSPC:     ErrNoSpace,
+	syscall.EDQUOT:     ErrNoSpace,
+	syscall.EBUSY:      ErrLocked,
+	syscall.EROFS:      ErrReadOnly,
+	syscall.EOPNOTSUPP: ErrUnsupported,
+}
+
+/*
+sentinelError attaches a sentinel error to an error returned by Rados, so
+that both can be found by errors.Is() and errors.As().
+*/
+type sentinelError struct {
+	err      error
+	sentinel error
+}
+
+/*
+Error returns the message of the original error.
+*/
+func (e *sentinelError) Error() string {
+	return e.err.Error()
+}
+
+/*
+Unwrap returns the original error.
+*/
+func (e *sentinelError) Unwrap() error {
+	return e.err
+}
+
+/*
+Is reports whether target is the sentinel attached to the error.
+*/
+func (e *sentinelError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+/*
+withSentinel attaches the sentinel error corresponding to the errno of err,
+if there is one, as documented for errnoSentinels.
+*/
+func withSentinel(err error) error {
+	var errno syscall.Errno
+	var sentinel error
+	var ok bool
+
+	if errno, ok = radosErrno(err); !ok {
+		return err
+	}
+	if sentinel, ok = errnoSentinels[errno]; !ok {
+		return err
+	}
+	return &sentinelError{err: err, sentinel: sentinel}
+}
+
+/*
+ObjectError describes an error which occurred while operating on a Rados
+object, or on a prefix of objects when listing. It records the operation
+which failed and the pool and object it was attempted on, so that logged
+errors can be traced back to the object. The original error remains
+accessible through errors.Is() and errors.As().
+*/
+type ObjectError struct {
+	/*
+		Op is the name of the operation which failed, e.g. "read" or
+		"remove".
+	*/
+	Op   string
+	Pool string
+	OID  string
+	Err  error
+}
+
+/*
+Error returns the message of the original error, prefixed with the operation,
+pool and object.
+*/
+func (e *ObjectError) Error() string {
+	return fmt.Sprintf("%s %s/%s: %s", e.Op, e.Pool, e.OID, e.Err.Error())
+}
+
+/*
+Unwrap returns the original error.
+*/
+func (e *ObjectError) Unwrap() error {
+	return e.Err
+}
+
+/*
+objectError annotates err with the operation which failed and the pool and
+object it was attempted on by wrapping it in an ObjectError. Errors caused by
+the conditions listed for errnoSentinels also match the corresponding
+sentinel error. Errors which have been annotated already are returned
+unchanged, as is nil.
+*/
+func objectError(op, pool, oid string, err error) error {
+	var objErr *ObjectError
+
+	if err == nil {
+		return nil
+	}
+	if errors.As(err, &objErr) {
+		return err
+	}
+	return &ObjectError{Op: op, Pool: pool, OID: oid, Err: withSentinel(err)}
+}
+
+/*
+snapshotError annotates err with the snapshot operation which failed and the
+pool and snapshot it was attempted on. Snapshots which do not exist are
+reported as ErrSnapshotNotFound, and names which are taken already as
+ErrSnapshotExists.
+*/
+func snapshotError(op, pool, name string, err error) error {
+	var errno syscall.Errno
+	var ok bool
+
+	if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+		err = &sentinelError{err: err, sentinel: ErrSnapshotNotFound}
+	} else if ok && errno == syscall.EEXIST {
+		err = &sentinelError{err: err, sentinel: ErrSnapshotExists}
+	}
+	return fmt.Errorf("%s snapshot %s@%s: %w", op, pool, name, err)
+}
+
+/*
+translateShutdown attaches ErrConnectionClosed to errors caused by the
+connection having been shut down. The original Rados error remains accessible
+through errors.As(). Other errors are returned unchanged.
+*/
+func translateShutdown(err error) error {
+	var errno syscall.Errno
+	var ok bool
+
+	if errno, ok = radosErrno(err); ok &&
+		(errno == syscall.ESHUTDOWN || errno == syscall.ENOTCONN) {
+		return &sentinelError{err: err, sentinel: ErrConnectionClosed}
+	}
+	return err
+}
+
+/*
+radosErrno extracts the (positive) errno from an error returned by the Rados
+library, if there is one. Errors from compound operations are unpacked to the
+error of the operation itself.
+*/
+func radosErrno(err error) (syscall.Errno, bool) {
+	var opErr rados.OperationError
+	var coded interface{ ErrorCode() int }
+	var code int
+
+	if errors.As(err, &opErr) && opErr.OpError != nil {
+		err = opErr.OpError
+	}
+	if !errors.As(err, &coded) {
+		return 0, false
+	}
+
+	code = coded.ErrorCode()
+	if code < 0 {
+		code = -code
+	}
+	return syscall.Errno(code), true
+}
@@ -0,0 +1,68 @@
+package rados
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+/*
+testErrno mimics the errors returned by go-ceph: a negative errno.
+*/
+type testErrno int
+
+func (e testErrno) Error() string {
+	return syscall.Errno(-e).Error()
+}
+
+func (e testErrno) ErrorCode() int {
+	return int(e)
+}
+
+func TestTranslateShutdownKeepsErrorChain(t *testing.T) {
+	var orig = testErrno(-int(syscall.ESHUTDOWN))
+	var coded testErrno
+	var errno syscall.Errno
+	var ok bool
+	var err = translateShutdown(orig)
+
+	if !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("translateShutdown(%v) = %v, want ErrConnectionClosed", orig,
+			err)
+	}
+	if !errors.As(err, &coded) || coded != orig {
+		t.Errorf("Original error lost from chain of %v", err)
+	}
+	if errno, ok = radosErrno(err); !ok || errno != syscall.ESHUTDOWN {
+		t.Errorf("radosErrno(%v) = %v, %v, want ESHUTDOWN", err, errno, ok)
+	}
+}
+
+func TestTranslateShutdownLeavesOtherErrors(t *testing.T) {
+	var orig = testErrno(-int(syscall.EIO))
+
+	if err := translateShutdown(orig); err != orig {
+		t.Errorf("translateShutdown(%v) = %v, want it unchanged", orig, err)
+	}
+	if err := translateShutdown(nil); err != nil {
+		t.Errorf("translateShutdown(nil) = %v, want nil", err)
+	}
+}
+
+func TestObjectErrorMatchesSentinel(t *testing.T) {
+	var err = objectError("read", "pool", "obj",
+		testErrno(-int(syscall.ENOENT)))
+	var objErr *ObjectError
+
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("objectError(ENOENT) = %v, want ErrObjectNotFound", err)
+	}
+	if !errors.As(err, &objErr) || objErr.Op != "read" ||
+		objErr.Pool != "pool" || objErr.OID != "obj" {
+		t.Errorf("objectError() = %#v, want ObjectError for read pool/obj",
+			err)
+	}
+	if objectError("write", "pool", "obj", err) != err {
+		t.Error("objectError() annotated an ObjectError twice")
+	}
+}
@@ -0,0 +1,62 @@
+package rados
+
+import (
+	"github.com/ceph/go-ceph/rados"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ConfigureConn applies the configuration described by opts to conn in the same
+way as NewRadosFileSystem() does before connecting.
+*/
+func ConfigureConn(conn configurableConn, opts ...Option) error {
+	return configureConn(conn, newConfig(opts))
+}
+
+/*
+RegisterAs registers r for handling URLs with scheme, as RegisterRadosAs()
+does for the instances it creates.
+*/
+func RegisterAs(scheme string, r *RadosFileSystem) {
+	register(scheme, r)
+}
+
+/*
+PoolOperationFlags returns the operation flags the I/O contexts of pool are
+opened with when configured by opts.
+*/
+func PoolOperationFlags(pool string, opts ...Option) rados.OperationFlags {
+	var conn = radosConn{poolFlags: newConfig(opts).poolFlags}
+
+	return conn.wrapIOContext(pool, nil).flags
+}
+
+/*
+MaxWriteChunk is the largest number of bytes sent to Rados in a single write.
+*/
+const MaxWriteChunk = maxWriteChunk
+
+/*
+OpenSharedIOContext opens an I/O context for pool on conn and wraps it the
+way the context cache does.
+*/
+func OpenSharedIOContext(conn Conn, pool string) (IOContext, error) {
+	var ioctx IOContext
+	var err error
+
+	if ioctx, err = conn.OpenIOContext(pool); err != nil {
+		return nil, err
+	}
+	return newSharedIOContext(ioctx, func() (IOContext, error) {
+		return conn.OpenIOContext(pool)
+	}), nil
+}
+
+/*
+RegisterClusterMetrics registers the metrics of the cluster conn is connected
+to with reg, as NewRadosFileSystem() does when configured by opts.
+*/
+func RegisterClusterMetrics(conn Conn, reg prometheus.Registerer,
+	opts ...Option) error {
+	return registerClusterMetrics(conn, newConfig(opts), reg)
+}
@@ -0,0 +1,107 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+)
+
+/*
+seeker is implemented by the readers and writers of the fake filesystem.
+*/
+type seeker interface {
+	Seek(ctx context.Context, offset int64, whence int) (int64, error)
+	Tell(ctx context.Context) (int64, error)
+}
+
+/*
+fakeURL returns the URL of the object oid in testPool of the fake filesystem.
+*/
+func fakeURL(oid string) *url.URL {
+	return &url.URL{Scheme: radostest.Scheme, Host: testPool, Path: oid}
+}
+
+func TestFakeReadWriteSeek(t *testing.T) {
+	var ctx = context.Background()
+	var fake, conn = radostest.NewFileSystem()
+	var w filesystem.WriteCloser
+	var r filesystem.ReadCloser
+	var buf = make([]byte, 4)
+	var objErr *rados.ObjectError
+	var pos int64
+	var n int
+	var err error
+
+	if _, err = fake.OpenReader(ctx, fakeURL("/object")); err == nil {
+		t.Error("OpenReader() in a missing pool succeeded")
+	}
+	conn.CreatePool(testPool)
+
+	if w, err = fake.OpenWriter(ctx, fakeURL("/object")); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+	if _, err = w.Write(ctx, []byte("0123456789")); err != nil {
+		t.Fatalf("Write() -> %v", err)
+	}
+	if pos, err = w.(seeker).Seek(ctx, 2, io.SeekStart); err != nil ||
+		pos != 2 {
+		t.Fatalf("Seek(2, SeekStart) -> %d, %v", pos, err)
+	}
+	if _, err = w.Write(ctx, []byte("ab")); err != nil {
+		t.Fatalf("Write() -> %v", err)
+	}
+	if pos, err = w.(seeker).Tell(ctx); err != nil || pos != 4 {
+		t.Errorf("Tell() -> %d, %v, want 4", pos, err)
+	}
+	if _, err = w.(seeker).Seek(ctx, 1, io.SeekEnd); err == nil {
+		t.Error("Seek() past the end succeeded")
+	}
+	w.Close(ctx)
+
+	if w, err = fake.OpenAppender(ctx, fakeURL("/object")); err != nil {
+		t.Fatalf("OpenAppender() -> %v", err)
+	}
+	if _, err = w.Write(ctx, []byte("!")); err != nil {
+		t.Fatalf("Append() -> %v", err)
+	}
+	w.Close(ctx)
+
+	if r, err = fake.OpenReader(ctx, fakeURL("/object")); err != nil {
+		t.Fatalf("OpenReader() -> %v", err)
+	}
+	if _, err = r.(seeker).Seek(ctx, -4, io.SeekEnd); err != nil {
+		t.Fatalf("Seek(-4, SeekEnd) -> %v", err)
+	}
+	if n, err = r.Read(ctx, buf); err != nil || string(buf[:n]) != "789!" {
+		t.Errorf("Read() -> %q, %v, want \"789!\"", buf[:n], err)
+	}
+	if _, err = r.Read(ctx, buf); err != io.EOF {
+		t.Errorf("Read() at the end -> %v, want io.EOF", err)
+	}
+	if _, err = r.(seeker).Seek(ctx, 0, io.SeekStart); err != nil {
+		t.Fatalf("Seek(0, SeekStart) -> %v", err)
+	}
+	if n, err = r.Read(ctx, buf); err != nil || string(buf[:n]) != "01ab" {
+		t.Errorf("Read() -> %q, %v, want \"01ab\"", buf[:n], err)
+	}
+
+	if err = fake.Remove(ctx, fakeURL("/object")); err != nil {
+		t.Errorf("Remove() -> %v", err)
+	}
+	if err = fake.Remove(ctx, fakeURL("/object")); !errors.Is(err,
+		rados.ErrObjectNotFound) || !errors.As(err, &objErr) {
+		t.Errorf("Remove() of a removed object -> %v, want an ObjectError "+
+			"wrapping ErrObjectNotFound", err)
+	}
+	if _, err = r.Read(ctx, buf); !errors.Is(err, rados.ErrObjectNotFound) ||
+		!errors.As(err, &objErr) {
+		t.Errorf("Read() of a removed object -> %v, want an ObjectError "+
+			"wrapping ErrObjectNotFound", err)
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
 	"net/url"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/ceph/go-ceph/rados"
 	"github.com/childoftheuniverse/filesystem"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var configPath = flag.String("rados-config", "",
@@ -20,306 +23,1687 @@ var user = flag.String("rados-user", "",
 	"cephx user to use for talking to ceph/rados")
 var cluster = flag.String("rados-cluster", "",
 	"Ceph cluster name to connect to for rados. Defaults to ceph")
+var keyring = flag.String("rados-keyring", "",
+	"Path to the cephx keyring to authenticate with, overriding the config file")
+var configOptions configOptionFlag
+var namespace = flag.String("rados-namespace", "",
+	"Object namespace to use unless a URL specifies one. Defaults to none")
+var pathSeparator = flag.String("rados-path-separator", DefaultPathSeparator,
+	"Separator splitting object IDs into directory-like entries for listings")
+var defaultTimeout = flag.Duration("rados-default-timeout", 30*time.Second,
+	"Deadline applied to rados operations whose context has none. 0 disables")
+
+var radosConnectionUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Subsystem: "rados",
+	Name:      "connection_up",
+	Help:      "Whether the connection to the Rados cluster is established",
+}, []string{"cluster"})
+
+var radosOpenContexts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Subsystem: "rados",
+	Name:      "open_contexts",
+	Help:      "Number of Rados I/O contexts held open in the context cache",
+}, []string{"cluster"})
+
+var radosContextCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Subsystem: "rados",
+	Name:      "context_cache",
+	Help:      "Number of I/O context lookups served from the cache (hit) or opened anew (miss)",
+}, []string{"cluster", "result"})
+
+var radosOpenContextLatencies = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Subsystem: "rados",
+	Name:      "open_context_latency",
+	Help:      "Latency of opening Rados I/O contexts on context cache misses",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 5, 20),
+}, []string{"cluster", "pool"})
+
+func init() {
+	flag.Var(&configOptions, "rados-option",
+		"Ceph client configuration option as key=value. May be repeated")
+	prometheus.MustRegister(radosConnectionUp)
+	prometheus.MustRegister(radosOpenContexts)
+	prometheus.MustRegister(radosContextCache)
+	prometheus.MustRegister(radosOpenContextLatencies)
+}
 
 /*
-radosFileSystem provides a filesystem-like interface for Rados object stores.
+RadosFileSystem provides a filesystem-like interface for Rados object stores.
 All operations except WatchFile are supported.
+
+Instances are created using NewRadosFileSystem() and can be registered with
+the filesystem API using filesystem.AddImplementation(), or used directly.
+The settings changed by the Set*() methods are held in atomic variables, so
+they can be changed while operations are running; each operation observes
+either the old or the new value.
+*/
+type RadosFileSystem struct {
+	rfs Conn
+
+	/*
+		cluster is the name of the cluster connected to, used to tell the
+		metrics of several instances apart.
+	*/
+	cluster string
+
+	/*
+		namespace is the object namespace used for URLs which do not specify
+		one. It holds a string.
+	*/
+	namespace atomic.Value
+
+	/*
+		separator splits object IDs into directory-like entries in listings.
+		It holds a string.
+	*/
+	separator atomic.Value
+
+	/*
+		listConcurrency is the number of placement group ranges listed in
+		parallel when a listing has to scan the pool.
+	*/
+	listConcurrency atomic.Int64
+
+	/*
+		openContexts holds a mapping of rados pool names and namespaces to the
+		corresponding currently open I/O contexts to avoid recreating them
+		every time a file is accessed.
+	*/
+	openContexts    map[contextKey]*contextEntry
+	openContextsMtx sync.Mutex
+
+	/*
+		pendingContexts holds the I/O contexts which are currently being
+		opened, so that concurrent callers wait for the same open rather than
+		starting their own. It is guarded by openContextsMtx.
+	*/
+	pendingContexts map[contextKey]*pendingContext
+
+	/*
+		closed is set by Shutdown(), after which no more I/O contexts are
+		handed out. It is guarded by openContextsMtx.
+	*/
+	closed bool
+
+	/*
+		verifyChecksum describes how object checksums are verified when reading
+		whole objects. Verification is disabled if nil.
+	*/
+	verifyChecksum atomic.Pointer[ChecksumConfig]
+
+	/*
+		storeChecksum describes how object checksums are computed and stored
+		when writing whole objects. No checksums are stored if nil.
+	*/
+	storeChecksum atomic.Pointer[ChecksumConfig]
+
+	/*
+		opRetry determines how reads, writes and appends are retried on
+		transient errors.
+	*/
+	opRetry RetryPolicy
+
+	/*
+		dirPlaceholders enables MkDir() and hides the placeholder objects it
+		creates from listings.
+	*/
+	dirPlaceholders atomic.Bool
+
+	/*
+		sealOnClose marks objects written through OpenWriter() as immutable
+		once the writer is closed.
+	*/
+	sealOnClose atomic.Bool
+
+	/*
+		readOnly rejects all operations which modify the cluster.
+	*/
+	readOnly atomic.Bool
+
+	/*
+		dirIndex maintains OMAP directory indexes on writes and serves
+		listings from them.
+	*/
+	dirIndex atomic.Bool
+
+	/*
+		appendClasses holds the classes OpenAppenderWithClass() accepts.
+	*/
+	appendClasses map[string]bool
+
+	/*
+		logger receives log messages about this filesystem.
+	*/
+	logger atomic.Pointer[Logger]
+
+	/*
+		slots bounds the number of data operations in flight at a time. No
+		limit is imposed if nil.
+	*/
+	slots *opLimiter
+
+	/*
+		space refuses writes while the cluster is nearly full. No check is
+		performed if it holds nil.
+	*/
+	space atomic.Pointer[spaceGuard]
+}
+
+/*
+InitRados attempts to create a new Rados connection using the parameters passed
+in via flags and, if successful, registers a rados:// URL handler with the
+filesystem API.
+*/
+func InitRados() error {
+	return RegisterRados(
+		WithConfigPath(*configPath),
+		WithUser(*user),
+		WithCluster(*cluster),
+		WithKeyringPath(*keyring),
+		WithNamespace(*namespace),
+		WithPathSeparator(*pathSeparator),
+		WithListConcurrency(*listConcurrency),
+		withConfigOptionList(configOptions))
+}
+
+/*
+RegisterRados creates a new Rados client configured by the specified options
+and, if it can connect successfully, registers it for handling rados:// URLs.
+*/
+func RegisterRados(opts ...Option) error {
+	return RegisterRadosAs("rados", opts...)
+}
+
+/*
+RegisterRadosAs creates a new Rados client configured by the specified options
+and, if it can connect successfully, registers it for handling URLs with the
+given scheme. This allows talking to several clusters, or as several users,
+at the same time, e.g. using rados:// and rados-backup:// URLs. Each instance
+has its own I/O context cache, and its metrics are labelled with the name of
+its cluster.
+
+Registering a scheme again replaces the previous instance, which is shut down
+once the new one is in place; readers and writers still open on it fail with
+ErrClosed afterwards. If the new instance cannot connect, the previous one
+remains registered.
+*/
+func RegisterRadosAs(scheme string, opts ...Option) error {
+	var r *RadosFileSystem
+	var err error
+
+	if r, err = NewRadosFileSystem(opts...); err != nil {
+		return err
+	}
+
+	register(scheme, r)
+	return nil
+}
+
+/*
+register makes r handle URLs with scheme, shutting down the instance which
+has been registered for it before, unless that is r itself.
+*/
+func register(scheme string, r *RadosFileSystem) {
+	var prev *RadosFileSystem
+
+	filesystem.AddImplementation(scheme, r)
+	if prev = addRegistered(scheme, r); prev != nil {
+		prev.Shutdown()
+	}
+}
+
+/*
+Deregister removes the Rados filesystem registered for scheme from the
+registry and shuts it down, releasing its connection. The filesystem API
+offers no way to remove an implementation, so URLs with the scheme are still
+routed to the shut down instance, where they fail with ErrClosed, until the
+scheme is registered again. Returns an error if no Rados filesystem has been
+registered for scheme.
+*/
+func Deregister(scheme string) error {
+	var r *RadosFileSystem
+	var ok bool
+
+	registeredMtx.Lock()
+	if r, ok = registered[scheme]; ok {
+		delete(registered, scheme)
+	}
+	registeredMtx.Unlock()
+
+	if !ok {
+		return fmt.Errorf("No Rados filesystem registered for %s://", scheme)
+	}
+
+	r.Shutdown()
+	return nil
+}
+
+/*
+registered maps the URL schemes Rados filesystems have been registered for
+to the respective instance, so that operations spanning several clusters can
+reach the Rados specific functionality of each of them.
+*/
+var registered = make(map[string]*RadosFileSystem)
+var registeredMtx sync.Mutex
+
+/*
+addRegistered records r as the filesystem handling URLs with scheme, and
+returns the filesystem previously registered for it, if any.
+*/
+func addRegistered(scheme string, r *RadosFileSystem) *RadosFileSystem {
+	var prev *RadosFileSystem
+
+	registeredMtx.Lock()
+	defer registeredMtx.Unlock()
+
+	prev = registered[scheme]
+	registered[scheme] = r
+	if prev == r {
+		return nil
+	}
+	return prev
+}
+
+/*
+lookupRegistered returns the Rados filesystem registered for scheme, if any.
+*/
+func lookupRegistered(scheme string) (*RadosFileSystem, bool) {
+	var r *RadosFileSystem
+	var ok bool
+
+	registeredMtx.Lock()
+	defer registeredMtx.Unlock()
+
+	r, ok = registered[scheme]
+	return r, ok
+}
+
+/*
+NewRadosFileSystem creates a new Rados client configured by the specified
+options and connects it to the cluster. Unlike RegisterRados(), the resulting
+instance is not registered with the filesystem API, so multiple independent
+instances can be created and used side by side.
+*/
+func NewRadosFileSystem(opts ...Option) (*RadosFileSystem, error) {
+	var cfg = newConfig(opts)
+	var rfs *rados.Conn
+	var err error
+
+	if rfs, err = initRadosConnection(cfg); err != nil {
+		return nil, err
+	}
+
+	return newRadosFileSystem(radosConn{
+		Conn:      rfs,
+		poolFlags: cfg.poolFlags,
+	}, cfg), nil
+}
+
+/*
+NewRadosFileSystemWithConn creates a RadosFileSystem on top of an already
+established connection. This is mostly useful for supplying a fake connection,
+e.g. from the radostest package, in tests. opts configure the filesystem as
+with NewRadosFileSystem(); options concerning the connection itself, such as
+WithConfigPath() or WithPoolOperationFlags(), have no effect.
+*/
+func NewRadosFileSystemWithConn(conn Conn, opts ...Option) *RadosFileSystem {
+	return newRadosFileSystem(conn, newConfig(opts))
+}
+
+/*
+newRadosFileSystem creates a RadosFileSystem on top of conn, configured by
+cfg.
+*/
+func newRadosFileSystem(conn Conn, cfg *config) *RadosFileSystem {
+	var r = &RadosFileSystem{
+		openContexts:    make(map[contextKey]*contextEntry),
+		pendingContexts: make(map[contextKey]*pendingContext),
+		rfs:             conn,
+		cluster:         cfg.clusterName(),
+		opRetry:         cfg.opRetry,
+		appendClasses:   cfg.appendClasses,
+	}
+
+	r.dirPlaceholders.Store(cfg.dirPlaceholders)
+	r.sealOnClose.Store(cfg.sealOnClose)
+	r.readOnly.Store(cfg.readOnly)
+	r.dirIndex.Store(cfg.dirIndex)
+	r.listConcurrency.Store(int64(cfg.listConcurrency))
+	r.namespace.Store(cfg.namespace)
+	r.SetPathSeparator(cfg.separator)
+	r.SetLogger(cfg.logger)
+	r.slots = newOpLimiter(cfg.maxOps, r.cluster)
+	r.space.Store(newSpaceGuard(conn, r.cluster, cfg.spaceHighWater))
+	return r
+}
+
+/*
+RegisterRadosConfig creates a new Rados client based on the configuration file
+specified as configPath, and registers it for handling rados:// URLs.
+
+If configPath is left empty, the default configuration path will be used, so
+this will have the same effect as the init() initializer.
+*/
+func RegisterRadosConfig(configPath string) error {
+	return RegisterRados(WithConfigPath(configPath))
+}
+
+/*
+RegisterRadosConfigWithUser creates a new Rados client based on the
+configuration file specified as configPath and using the specified user, and
+registers it for handling rados:// URLs.
+
+If configPath is left empty, the default configuration path will be used.
+*/
+func RegisterRadosConfigWithUser(configPath, user string) error {
+	return RegisterRados(WithConfigPath(configPath), WithUser(user))
+}
+
+/*
+RegisterRadosConfigWithClusterAndUser creates a new Rados client based on the
+configuration file specified as configPath and using the specified cluster name
+and user, and registers it for handling rados:// URLs.
+
+If configPath is left empty, the default configuration path will be used.
+*/
+func RegisterRadosConfigWithClusterAndUser(configPath, cluster, user string) error {
+	return RegisterRados(
+		WithConfigPath(configPath),
+		WithCluster(cluster),
+		WithUser(user))
+}
+
+/*
+RegisterRadosConfigWithKeyring creates a new Rados client based on the
+configuration file specified as configPath, using the specified cluster name
+and user and authenticating with the cephx keyring stored at keyringPath, and
+registers it for handling rados:// URLs. This allows pointing at a specific
+keyring without modifying the configuration file.
+
+If configPath, cluster, user or keyringPath are left empty, the defaults will
+be used.
+*/
+func RegisterRadosConfigWithKeyring(
+	configPath, cluster, user, keyringPath string) error {
+	return RegisterRados(
+		WithConfigPath(configPath),
+		WithCluster(cluster),
+		WithUser(user),
+		WithKeyringPath(keyringPath))
+}
+
+/*
+RegisterRadosWithConfigOptions creates a new Rados client based on the
+configuration file specified as configPath, overriding the Ceph client
+configuration options contained in options, and registers it for handling
+rados:// URLs. This allows tuning settings such as rados_osd_op_timeout
+without a configuration file.
+
+If configPath is left empty, the default configuration path will be used.
+*/
+func RegisterRadosWithConfigOptions(
+	configPath string, options map[string]string) error {
+	return RegisterRados(
+		WithConfigPath(configPath),
+		WithConfigOptions(options))
+}
+
+/*
+newConn creates a new, unconnected Rados client for the user and cluster set
+in cfg.
+*/
+func newConn(cfg *config) (*rados.Conn, error) {
+	var rfs *rados.Conn
+	var err error
+
+	if cfg.user != "" {
+		if cfg.cluster != "" {
+			if rfs, err = rados.NewConnWithClusterAndUser(
+				cfg.cluster, cfg.user); err != nil {
+				return nil, fmt.Errorf("NewConnWithClusterAndUser(%s, %s) -> %w",
+					cfg.cluster, cfg.user, err)
+			}
+		} else {
+			if rfs, err = rados.NewConnWithUser(cfg.user); err != nil {
+				return nil, fmt.Errorf("NewConnWithUser(%s) -> %w", cfg.user, err)
+			}
+		}
+	} else {
+		if rfs, err = rados.NewConn(); err != nil {
+			return nil, fmt.Errorf("NewConn() -> %w", err)
+		}
+	}
+	return rfs, nil
+}
+
+/*
+initRadosConnection does the "lower part" of the Rados Initialization: it
+creates a Rados client configured by cfg using configureConn() and attempts to
+connect it to Rados, retrying as set up in cfg. Every attempt uses a fresh
+client, since a client whose attempt has timed out may still be connecting
+in the background.
+*/
+func initRadosConnection(cfg *config) (*rados.Conn, error) {
+	var rfs *rados.Conn
+	var errno syscall.Errno
+	var attempt int
+	var ok bool
+	var err error
+
+	for attempt = 0; attempt < cfg.retryPolicy.attempts(); attempt++ {
+		if attempt > 0 {
+			time.Sleep(cfg.retryPolicy.backoff(attempt - 1))
+		}
+		if rfs, err = newConn(cfg); err != nil {
+			return nil, err
+		}
+		if err = configureConn(rfs, cfg); err != nil {
+			rfs.Shutdown()
+			return nil, err
+		}
+		if err = connectWithTimeout(rfs, cfg.connectTimeout); err == nil {
+			break
+		}
+		cfg.logger.Warn("Cannot connect to rados", "cluster",
+			cfg.clusterName(), "operation", "connect", "attempt", attempt+1,
+			"attempts", cfg.retryPolicy.attempts(), "error", err)
+	}
+	if err != nil {
+		radosConnectionUp.With(prometheus.Labels{
+			"cluster": cfg.clusterName(),
+		}).Set(0)
+		if errno, ok = radosErrno(err); ok &&
+			(errno == syscall.EACCES || errno == syscall.EPERM) {
+			return nil, fmt.Errorf("Authentication with rados failed, check "+
+				"the user and its credentials: %w", err)
+		}
+		return nil, err
+	}
+	radosConnectionUp.With(prometheus.Labels{
+		"cluster": cfg.clusterName(),
+	}).Set(1)
+
+	if cfg.registerer != nil {
+		if err = registerClusterMetrics(rfs, cfg,
+			cfg.registerer); err != nil {
+			rfs.Shutdown()
+			return nil, err
+		}
+	}
+
+	return rfs, nil
+}
+
+/*
+configurableConn is the part of a Rados connection handle used to configure
+it before connecting.
+*/
+type configurableConn interface {
+	ReadConfigFile(path string) error
+	ReadDefaultConfigFile() error
+	ParseDefaultConfigEnv() error
+	ParseCmdLineArgs(args []string) error
+	SetConfigOption(option, value string) error
+}
+
+/*
+configureConn parses the specified configuration file (or the default
+configuration in case the path is left empty) into rfs, reads environment
+variables, reads command line flags and applies the remaining settings from
+cfg.
+*/
+func configureConn(rfs configurableConn, cfg *config) error {
+	var option ConfigOption
+	var err error
+
+	if cfg.keyringPath != "" && cfg.key != "" {
+		return fmt.Errorf(
+			"Only one of a keyring path and an inline key may be specified")
+	}
+	if cfg.keyringPath != "" {
+		if _, err = os.Stat(cfg.keyringPath); err != nil {
+			return fmt.Errorf("Cannot access rados keyring %s: %w",
+				cfg.keyringPath, err)
+		}
+	}
+
+	if len(cfg.configPath) > 0 {
+		if err = rfs.ReadConfigFile(cfg.configPath); err != nil {
+			return fmt.Errorf("ReadConfigFile(%s) -> %w", cfg.configPath, err)
+		}
+	} else {
+		if err = rfs.ReadDefaultConfigFile(); err != nil {
+			cfg.logger.Warn("Cannot read default rados configuration file",
+				"cluster", cfg.clusterName(), "error", err)
+		}
+	}
+	if err = rfs.ParseDefaultConfigEnv(); err != nil {
+		cfg.logger.Warn("Cannot parse rados configuration environment",
+			"cluster", cfg.clusterName(), "error", err)
+	}
+	if err = rfs.ParseCmdLineArgs(os.Args[1:]); err != nil {
+		cfg.logger.Warn("Cannot parse rados command line arguments",
+			"cluster", cfg.clusterName(), "error", err)
+	}
+	if len(cfg.monHosts) > 0 {
+		if err = rfs.SetConfigOption(
+			"mon_host", monHostValue(cfg.monHosts)); err != nil {
+			return fmt.Errorf("SetConfigOption(mon_host) -> %w", err)
+		}
+	}
+	if cfg.keyringPath != "" {
+		if err = rfs.SetConfigOption("keyring", cfg.keyringPath); err != nil {
+			return fmt.Errorf("SetConfigOption(keyring) -> %w", err)
+		}
+	}
+	if cfg.key != "" {
+		if err = rfs.SetConfigOption("key", cfg.key); err != nil {
+			return fmt.Errorf("SetConfigOption(key) -> %w", err)
+		}
+	}
+	for _, option = range cfg.options {
+		if err = rfs.SetConfigOption(option.Key, option.Value); err != nil {
+			return fmt.Errorf("Invalid rados option %s=%s: %w", option.Key,
+				option.Value, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+connectWithTimeout connects rfs to the cluster, giving up after timeout. The
+connection attempt itself cannot be aborted and is left running in the
+background in that case; rfs is then shut down once the attempt finishes, so
+it must not be used anymore. Failed attempts shut rfs down as well. A timeout
+of 0 or less waits indefinitely.
+*/
+func connectWithTimeout(rfs *rados.Conn, timeout time.Duration) error {
+	var done = make(chan error)
+	var abandoned = make(chan struct{})
+	var timer *time.Timer
+	var err error
+
+	if timeout <= 0 {
+		if err = rfs.Connect(); err != nil {
+			rfs.Shutdown()
+		}
+		return err
+	}
+
+	go func() {
+		var err = rfs.Connect()
+
+		select {
+		case done <- err:
+		case <-abandoned:
+			rfs.Shutdown()
+		}
+	}()
+
+	timer = time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			rfs.Shutdown()
+		}
+		return err
+	case <-timer.C:
+		close(abandoned)
+		return fmt.Errorf("Timed out connecting to rados after %s, check "+
+			"the monitor addresses and network connectivity", timeout)
+	}
+}
+
+/*
+withDefaultTimeout bounds ctx by the default timeout configured through the
+-rados-default-timeout flag, unless ctx already carries a deadline. This
+ensures internal and background operations cannot hang forever when called
+with context.Background(). The returned cancel function must be called once
+the operation is done.
+*/
+func withDefaultTimeout(ctx context.Context) (
+	context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || *defaultTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, *defaultTimeout)
+}
+
+/*
+contextEntry holds an open Rados I/O context along with information about it
+which would otherwise need to be looked up for every object opened.
+*/
+type contextEntry struct {
+	ioctx    IOContext
+	poolName string
+
+	/*
+		cluster is the name of the cluster the pool belongs to, for metrics.
+	*/
+	cluster string
+
+	/*
+		namespace is the object namespace the I/O context has been set to. The
+		empty string denotes the default namespace.
+	*/
+	namespace string
+}
+
+/*
+pendingContext is an I/O context which is being opened. done is closed once
+the open has finished, at which point entry or err hold its result.
+*/
+type pendingContext struct {
+	done  chan struct{}
+	entry *contextEntry
+	err   error
+}
+
+/*
+runWithContext runs fn in the background and waits for it to finish, or for
+ctx to expire, whichever happens first. In the latter case, the context error
+is returned while fn keeps running to completion in the background; fn must
+therefore not touch any state the caller relies on after returning. Errors
+due to a closed connection are reported as ErrConnectionClosed.
+*/
+func runWithContext(ctx context.Context, fn func() error) error {
+	var result = make(chan error, 1)
+
+	go func() {
+		result <- fn()
+	}()
+
+	select {
+	case err := <-result:
+		return translateShutdown(err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+labels returns the metric labels for operations on the pool of the entry.
 */
-type radosFileSystem struct {
-	rfs *rados.Conn
+func (e *contextEntry) labels() prometheus.Labels {
+	return prometheus.Labels{"cluster": e.cluster, "pool": e.poolName}
+}
+
+/*
+DefaultPathSeparator separates the fragments of object IDs which are listed as
+directory-like entries, unless configured otherwise using WithPathSeparator().
+*/
+const DefaultPathSeparator = "/"
+
+/*
+SetPathSeparator sets the separator splitting object IDs into directory-like
+entries in listings, as with WithPathSeparator(). An empty separator restores
+DefaultPathSeparator.
+*/
+func (r *RadosFileSystem) SetPathSeparator(sep string) {
+	if sep == "" {
+		sep = DefaultPathSeparator
+	}
+	r.separator.Store(sep)
+}
+
+/*
+pathSeparator returns the separator splitting object IDs into directory-like
+entries in listings.
+*/
+func (r *RadosFileSystem) pathSeparator() string {
+	return r.separator.Load().(string)
+}
+
+/*
+contextKey identifies a cached I/O context by its pool and namespace.
+*/
+type contextKey struct {
+	pool      string
+	namespace string
+}
+
+/*
+NamespaceParameter is the URL query parameter selecting the object namespace
+for a single URL, e.g. rados://pool/object?namespace=tenant. It overrides the
+default namespace; an empty value selects the default Rados namespace.
+*/
+const NamespaceParameter = "namespace"
+
+/*
+SetNamespace sets the object namespace used for URLs which do not specify
+one, as with WithNamespace().
+*/
+func (r *RadosFileSystem) SetNamespace(namespace string) {
+	r.namespace.Store(namespace)
+}
+
+/*
+defaultNamespace returns the object namespace used for URLs which do not
+specify one.
+*/
+func (r *RadosFileSystem) defaultNamespace() string {
+	return r.namespace.Load().(string)
+}
+
+/*
+urlNamespace returns the object namespace to use for u: the one given in its
+query, if any, or the default namespace.
+*/
+func (r *RadosFileSystem) urlNamespace(u *url.URL) string {
+	var values []string
+	var ok bool
+
+	if values, ok = u.Query()[NamespaceParameter]; ok && len(values) > 0 {
+		return values[0]
+	}
+	return r.defaultNamespace()
+}
+
+/*
+getURLContext finds or opens the I/O context for the pool (u.Host) and object
+namespace u refers to, as with getNamespaceContext().
+*/
+func (r *RadosFileSystem) getURLContext(ctx context.Context, u *url.URL) (
+	*contextEntry, error) {
+	return r.getNamespaceContext(ctx, u.Host, r.urlNamespace(u))
+}
+
+/*
+getObjectContext works like getURLContext(), but also requires u to name an
+object rather than just a pool. Otherwise, an error wrapping ErrInvalidURL is
+returned. The path of u is brought into canonical form by normalizeURL(), and
+the resulting URL is returned along with the context; callers must address
+the object through it, so that all operations agree on the object ID.
+*/
+func (r *RadosFileSystem) getObjectContext(ctx context.Context, u *url.URL) (
+	*contextEntry, *url.URL, error) {
+	var entry *contextEntry
+	var err error
+
+	if u, err = normalizeURL(u); err != nil {
+		return nil, nil, err
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		return nil, nil, fmt.Errorf("%w: %s does not name a Rados object",
+			ErrInvalidURL, u.String())
+	}
+	if entry, err = r.getURLContext(ctx, u); err != nil {
+		return nil, nil, err
+	}
+	return entry, u, nil
+}
+
+/*
+getContext finds or opens the I/O context for the specified pool name in the
+default namespace, as with getNamespaceContext().
+*/
+func (r *RadosFileSystem) getContext(ctx context.Context, pool string) (
+	*contextEntry, error) {
+	return r.getNamespaceContext(ctx, pool, r.defaultNamespace())
+}
+
+/*
+getNamespaceContext finds an open Rados I/O context for the specified pool
+name and namespace and returns it. If no context can be found, a new one will
+be opened and cached. An empty pool name is rejected with ErrInvalidURL.
+
+Opening a context can take a while on a busy cluster, so this will return
+early with the context error if ctx expires first, or the default timeout
+elapses if ctx has no deadline. The context is still opened and cached in
+the background so it is neither leaked nor opened twice. Concurrent callers
+for the same pool and namespace wait for the same open; callers for other
+pools are not held up by it.
+*/
+func (r *RadosFileSystem) getNamespaceContext(ctx context.Context, pool,
+	namespace string) (*contextEntry, error) {
+	var key = contextKey{pool: pool, namespace: namespace}
+	var ret *contextEntry
+	var pending *pendingContext
+	var cancel context.CancelFunc
+	var ok bool
+
+	if pool == "" {
+		return nil, fmt.Errorf("%w: no Rados pool specified", ErrInvalidURL)
+	}
+
+	r.openContextsMtx.Lock()
+	if r.closed {
+		r.openContextsMtx.Unlock()
+		return nil, ErrClosed
+	}
+	if ret, ok = r.openContexts[key]; ok && ret != nil {
+		radosContextCache.With(prometheus.Labels{
+			"cluster": r.cluster, "result": "hit"}).Inc()
+		r.openContextsMtx.Unlock()
+		return ret, nil
+	}
+	if pending, ok = r.pendingContexts[key]; !ok {
+		radosContextCache.With(prometheus.Labels{
+			"cluster": r.cluster, "result": "miss"}).Inc()
+		pending = &pendingContext{done: make(chan struct{})}
+		r.pendingContexts[key] = pending
+		go r.openContext(key, pending)
+	}
+	r.openContextsMtx.Unlock()
+
+	ctx, cancel = withDefaultTimeout(ctx)
+	defer cancel()
+
+	select {
+	case <-pending.done:
+		return pending.entry, pending.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+/*
+openContext opens a Rados I/O context for the pool and namespace of key and
+stores it in the context cache, then reports the result through pending. The
+cache is not locked while the context is being opened. If the pool does not
+exist, an error wrapping ErrPoolNotFound is returned. If the filesystem has
+been shut down in the meantime, the new context is destroyed again and
+ErrClosed is returned.
+*/
+func (r *RadosFileSystem) openContext(key contextKey, pending *pendingContext) {
+	var pool = key.pool
+	var start time.Time
+	var ioctx IOContext
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	defer close(pending.done)
+
+	start = time.Now()
+	ioctx, err = r.rfs.OpenIOContext(pool)
+	radosOpenContextLatencies.With(prometheus.Labels{
+		"cluster": r.cluster, "pool": pool}).Observe(
+		time.Now().Sub(start).Seconds())
+	if err != nil {
+		r.log().Warn("Cannot open rados I/O context", "cluster", r.cluster,
+			"pool", pool, "operation", "open", "error", err)
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+			err = fmt.Errorf("Rados pool %q does not exist: %w", pool,
+				ErrPoolNotFound)
+		} else {
+			err = fmt.Errorf("Cannot open Rados pool %q: %w", pool,
+				translateShutdown(err))
+		}
+	} else if key.namespace != "" {
+		ioctx.SetNamespace(key.namespace)
+	}
+
+	r.openContextsMtx.Lock()
+	defer r.openContextsMtx.Unlock()
+
+	delete(r.pendingContexts, key)
+	if err != nil {
+		pending.err = err
+		return
+	}
+	if r.closed {
+		ioctx.Destroy()
+		pending.err = ErrClosed
+		return
+	}
+
+	pending.entry = &contextEntry{
+		ioctx: newSharedIOContext(ioctx, func() (IOContext, error) {
+			var private IOContext
+			var err error
+
+			if private, err = r.rfs.OpenIOContext(pool); err != nil {
+				return nil, err
+			}
+			if key.namespace != "" {
+				private.SetNamespace(key.namespace)
+			}
+			return private, nil
+		}),
+		poolName:  pool,
+		cluster:   r.cluster,
+		namespace: key.namespace,
+	}
+	r.openContexts[key] = pending.entry
+	radosOpenContexts.With(prometheus.Labels{"cluster": r.cluster}).Inc()
+}
+
+/*
+SetReadOnly enables or disables read-only mode, as with WithReadOnly().
+*/
+func (r *RadosFileSystem) SetReadOnly(readOnly bool) {
+	r.readOnly.Store(readOnly)
+}
+
+/*
+checkWritable returns ErrReadOnly if the filesystem is in read-only mode.
+*/
+func (r *RadosFileSystem) checkWritable() error {
+	if r.readOnly.Load() {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+/*
+getWritableContext works like getObjectContext(), but for operations which
+modify or remove the object. In read-only mode, ErrReadOnly is returned
+without opening an I/O context, and objects which have been marked immutable
+are refused with ErrImmutable. All such operations go through here, or
+through checkModifiable() for objects not addressed by a URL, so that none of
+them can bypass these checks.
+*/
+func (r *RadosFileSystem) getWritableContext(ctx context.Context, u *url.URL) (
+	*contextEntry, *url.URL, error) {
+	var entry *contextEntry
+	var err error
+
+	if err = r.checkWritable(); err != nil {
+		return nil, nil, err
+	}
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, nil, err
+	}
+	if err = checkMutable(ctx, entry, u.Path); err != nil {
+		return nil, nil, err
+	}
+	return entry, u, nil
+}
+
+/*
+checkModifiable performs the checks of getWritableContext() for the object oid
+in the pool of entry.
+*/
+func (r *RadosFileSystem) checkModifiable(
+	ctx context.Context, entry *contextEntry, oid string) error {
+	var err error
+
+	if err = r.checkWritable(); err != nil {
+		return err
+	}
+	return checkMutable(ctx, entry, oid)
+}
+
+/*
+openReadWriteCloser creates a ReadWriteCloser for the object oid using the
+settings of this filesystem.
+*/
+func (r *RadosFileSystem) openReadWriteCloser(
+	entry *contextEntry, oid string) *ReadWriteCloser {
+	var ret = newReadWriteCloser(entry, oid)
+	ret.retry = r.opRetry
+	ret.slots = r.slots
+	ret.space = r.space.Load()
+	ret.readOnly = r.readOnly.Load()
+	return ret
+}
+
+/*
+openAppender creates an Appender for the object oid using the settings of this
+filesystem, and records the object in the directory index. Objects which have
+been compressed by OpenWriter() are refused with an error wrapping
+ErrUnsupported, since appended data would not be compressed.
+*/
+func (r *RadosFileSystem) openAppender(
+	ctx context.Context, entry *contextEntry, oid string) (*Appender, error) {
+	var ret *Appender
+	var err error
+
+	if err = checkUncompressed("append", entry, oid); err != nil {
+		return nil, err
+	}
+	if err = r.indexAdd(ctx, entry, oid); err != nil {
+		return nil, err
+	}
+	if ret, err = newAppender(entry, oid); err != nil {
+		return nil, err
+	}
+	ret.retry = r.opRetry
+	ret.slots = r.slots
+	ret.space = r.space.Load()
+	return ret, nil
+}
+
+/*
+OpenReader opens the specified Rados object (u.Path) in the specified pool
+(u.Host) for reading starting from offset 0. Objects which have been
+compressed by OpenWriter() are decompressed transparently.
+*/
+func (r *RadosFileSystem) OpenReader(ctx context.Context, u *url.URL) (
+	filesystem.ReadCloser, error) {
+	var entry *contextEntry
+	var codec string
+	var err error
 
-	/*
-		openContexts holds a mapping of rados pool names to the corresponding
-		currently open I/O contexts to avoid recreating them every time a file is
-		accessed.
-	*/
-	openContexts    map[string]*rados.IOContext
-	openContextsMtx sync.Mutex
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+
+	if codec, err = objectCompression(entry.ioctx, u.Path); err != nil {
+		return nil, err
+	}
+	if codec == CompressionGzip {
+		return newGzipReader(r.openReadWriteCloser(entry, u.Path)), nil
+	}
+	return r.openReadWriteCloser(entry, u.Path), nil
 }
 
 /*
-InitRados attempts to create a new Rados connection using the parameters passed
-in via flags and, if successful, registers a rados:// URL handler with the
-filesystem API.
+OpenWriter opens the specified Rados object (u.Path) in the specified pool
+(u.Host), truncates it to 0 bytes and creates a writer object to write data
+to the resulting object. Objects which do not exist yet are created by the
+first write. The data is compressed with the codec given in the
+CompressParameter of u, if any; compression records and checksums of the
+previous contents are removed. Objects marked immutable are refused with
+ErrImmutable.
 */
-func InitRados() error {
-	var rfs *rados.Conn
+func (r *RadosFileSystem) OpenWriter(ctx context.Context, u *url.URL) (
+	filesystem.WriteCloser, error) {
+	var entry *contextEntry
+	var writer *ReadWriteCloser
+	var op WriteOp
+	var codec string
+	var errno syscall.Errno
+	var ok bool
 	var err error
 
-	if user != nil && *user != "" {
-		if cluster != nil && *cluster != "" {
-			if rfs, err = rados.NewConnWithClusterAndUser(*cluster, *user); err != nil {
-				return fmt.Errorf("NewConnWithClusterAndUser(%s, %s) -> %s",
-					*cluster, *user, err.Error())
-			}
-		} else {
-			if rfs, err = rados.NewConnWithUser(*user); err != nil {
-				return fmt.Errorf("NewConnWithUser(%s) -> %s", *user, err.Error())
-			}
-		}
-	} else {
-		if rfs, err = rados.NewConn(); err != nil {
-			return fmt.Errorf("NewConn() -> %s", err.Error())
+	if codec, err = urlCompression(u); err != nil {
+		return nil, err
+	}
+
+	entry, u, err = r.getWritableContext(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	/*
+	   Depending on the Rados version, truncating a nonexistent object may
+	   fail. The object is empty either way, and the first write creates it.
+	*/
+	err = runWithContext(ctx, func() error {
+		return entry.ioctx.Truncate(u.Path, 0)
+	})
+	if errno, ok = radosErrno(err); err != nil &&
+		!(ok && errno == syscall.ENOENT) {
+		return nil, objectError("truncate", entry.poolName, u.Path, err)
+	}
+
+	/*
+	   Drop the compression record and checksums of the previous contents,
+	   and record the compression of the new ones.
+	*/
+	if err = clearXattrs(
+		entry.ioctx, u.Path, &op, r.contentXattrs()); err != nil {
+		return nil, objectError("truncate", entry.poolName, u.Path, err)
+	}
+	if codec != "" {
+		op.SetXattr(CompressionXattr, []byte(codec))
+	}
+	if len(op.Steps()) > 0 {
+		if err = runWithContext(ctx, func() error {
+			return entry.ioctx.OperateWrite(u.Path, &op)
+		}); err != nil {
+			return nil, objectError("truncate", entry.poolName, u.Path, err)
 		}
 	}
-	return initRadosConnection(rfs, *configPath)
+
+	if err = r.indexAdd(ctx, entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	writer = r.openReadWriteCloser(entry, u.Path)
+	writer.seal = r.sealOnClose.Load()
+	if codec == CompressionGzip {
+		return newGzipWriter(writer), nil
+	}
+	return writer, nil
 }
 
 /*
-RegisterRadosConfig creates a new Rados client based on the configuration file
-specified as configPath, and registers it for handling rados:// URLs.
-
-If configPath is left empty, the default configuration path will be used, so
-this will have the same effect as the init() initializer.
+OpenAppender opens the specified Rados object (u.Path) in the specified pool
+(u.Host) for appending. If the object does not exist yet, it will be created.
+Objects marked immutable are refused with ErrImmutable, and compressed objects
+with an error wrapping ErrUnsupported.
 */
-func RegisterRadosConfig(configPath string) error {
-	var rfs *rados.Conn
+func (r *RadosFileSystem) OpenAppender(ctx context.Context, u *url.URL) (
+	filesystem.WriteCloser, error) {
+	var entry *contextEntry
 	var err error
 
-	if rfs, err = rados.NewConn(); err != nil {
-		return err
+	entry, u, err = r.getWritableContext(ctx, u)
+	if err != nil {
+		return nil, err
 	}
 
-	return initRadosConnection(rfs, configPath)
+	return r.openAppender(ctx, entry, u.Path)
 }
 
 /*
-RegisterRadosConfigWithUser creates a new Rados client based on the
-configuration file specified as configPath and using the specified user, and
-registers it for handling rados:// URLs.
-
-If configPath is left empty, the default configuration path will be used.
+ListEntries will find all entries in the Rados pool designated by u.Host which
+have the prefix of u.Path. The object ID will be broken up into parts separated
+by slashes, or the separator set using WithPathSeparator(). Only the part
+before the next separator is returned.
 */
-func RegisterRadosConfigWithUser(configPath, user string) error {
-	var rfs *rados.Conn
+func (r *RadosFileSystem) ListEntries(ctx context.Context, u *url.URL) (
+	[]string, error) {
+	var set map[string]bool
 	var err error
 
-	if rfs, err = rados.NewConnWithUser(user); err != nil {
-		return err
+	if set, err = r.collectEntries(ctx, u); err != nil {
+		return nil, err
 	}
 
-	return initRadosConnection(rfs, configPath)
+	return listEntriesFromSet(set), nil
 }
 
 /*
-RegisterRadosConfigWithClusterAndUser creates a new Rados client based on the
-configuration file specified as configPath and using the specified cluster name
-and user, and registers it for handling rados:// URLs.
+Entry describes a single entry of a directory listing.
+*/
+type Entry struct {
+	/*
+		Name is the part of the object ID following the listed path, up to the
+		next slash.
+	*/
+	Name string
 
-If configPath is left empty, the default configuration path will be used.
+	/*
+		IsDir is true if at least one object ID continues past Name with
+		another slash, i.e. the entry has children.
+	*/
+	IsDir bool
+}
+
+/*
+ListEntriesTyped works like ListEntries, but also reports for each entry
+whether it is a directory-like prefix of further objects or a leaf object. An
+entry which is both an object in its own right and a prefix of other objects
+is reported as a directory.
 */
-func RegisterRadosConfigWithClusterAndUser(configPath, cluster, user string) error {
-	var rfs *rados.Conn
+func (r *RadosFileSystem) ListEntriesTyped(ctx context.Context, u *url.URL) (
+	[]Entry, error) {
+	var set map[string]bool
 	var err error
 
-	if rfs, err = rados.NewConnWithClusterAndUser(cluster, user); err != nil {
-		return err
+	if set, err = r.collectEntries(ctx, u); err != nil {
+		return nil, err
 	}
 
-	return initRadosConnection(rfs, configPath)
+	return typedEntriesFromSet(set), nil
 }
 
 /*
-initRadosConnection does the "lower part" of the Rados Initialization: it parses
-the specified configuration file (or the default configuration in case the path
-is left empty), reads environment variables, reads command line flags and
-attempts to connect to Rados. Upon success, the Rados handler will be
-registered.
+collectEntries iterates over all objects of the pool u.Host and collects the
+entries below u.Path using addListEntry. If the list concurrency is set to
+more than 1, see WithListConcurrency(), the pool is listed in parallel
+shards. With directory indexing enabled, the index of u.Path is read instead,
+and the pool is only scanned if u.Path has not been indexed.
 */
-func initRadosConnection(rfs *rados.Conn, configPath string) error {
+func (r *RadosFileSystem) collectEntries(ctx context.Context, u *url.URL) (
+	map[string]bool, error) {
+	var entry *contextEntry
+	var set map[string]bool
+	var shards int
 	var err error
 
-	if len(configPath) > 0 {
-		if err = rfs.ReadConfigFile(configPath); err != nil {
-			return fmt.Errorf("ReadConfigFile(%s) -> %s", configPath, err.Error())
+	entry, err = r.getURLContext(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.dirIndex.Load() && r.pathSeparator() == DefaultPathSeparator {
+		if set, err = readIndex(ctx, entry, u.Path); err != nil {
+			return nil, err
 		}
-	} else {
-		if err = rfs.ReadDefaultConfigFile(); err != nil {
-			log.Print("Error reading default rados configuration file: ", err)
+	}
+
+	if shards = int(r.listConcurrency.Load()); set == nil && shards > 1 {
+		if set, err = r.collectEntriesParallel(
+			ctx, u, entry, shards); err != nil {
+			return nil, objectError("list", entry.poolName, u.Path, err)
+		}
+	} else if set == nil {
+		if set, err = r.collectEntriesSequential(ctx, u, entry); err != nil {
+			return nil, err
 		}
 	}
-	if err = rfs.ParseDefaultConfigEnv(); err != nil {
-		log.Print("Error parsing default rados configuration environment: ", err)
+
+	if r.dirPlaceholders.Load() {
+		delete(set, DirPlaceholder)
 	}
-	if err = rfs.ParseCmdLineArgs(os.Args[1:]); err != nil {
-		log.Print("Error parsing rados command line arguments: ", err)
+	if r.dirIndex.Load() {
+		delete(set, DirIndexObject)
 	}
-	if err = rfs.Connect(); err != nil {
-		log.Print("Error connecting to rados: ", err)
-		return err
+
+	return set, nil
+}
+
+/*
+collectEntriesSequential iterates over all objects of the pool of entry in a
+single pass and collects the entries below u.Path using addListEntry.
+*/
+func (r *RadosFileSystem) collectEntriesSequential(ctx context.Context,
+	u *url.URL, entry *contextEntry) (map[string]bool, error) {
+	var iter Iter
+	var set = make(map[string]bool)
+	var separator = r.pathSeparator()
+	var err error
+
+	iter, err = entry.ioctx.Iter()
+	if err != nil {
+		return nil, objectError("list", entry.poolName, u.Path, err)
 	}
 
-	filesystem.AddImplementation("rados", &radosFileSystem{
-		openContexts: make(map[string]*rados.IOContext),
-		rfs:          rfs,
-	})
-	return nil
+	defer iter.Close()
+
+	for iter.Next() {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		addListEntry(set, iter.Value(), u.Path, separator)
+	}
+	if err = iter.Err(); err != nil {
+		return nil, objectError("list", entry.poolName, u.Path, err)
+	}
+
+	return set, nil
 }
 
 /*
-getContext finds an open Rados I/O context for the specified pool name and
-returns it. If no context can be found, it will
+addListEntry records the part of the object ID oid which should be listed as an
+entry of the directory-like path in set, if any. The value recorded in set
+tells whether the entry has children.
 */
-func (r *radosFileSystem) getContext(pool string) (*rados.IOContext, error) {
-	var ret *rados.IOContext
+func addListEntry(set map[string]bool, oid, path, sep string) {
+	var name string
+	var isDir bool
 	var ok bool
-	var err error
 
-	r.openContextsMtx.Lock()
-	defer r.openContextsMtx.Unlock()
+	if name, isDir, ok = listEntryName(oid, path, sep); ok {
+		set[name] = set[name] || isDir
+	}
+}
 
-	if ret, ok = r.openContexts[pool]; ok && ret != nil {
-		return ret, nil
+/*
+listEntryName returns the name under which oid is listed as an entry of the
+directory-like path, whether that entry has children, and whether oid is
+listed at all. Object IDs are split into fragments at sep.
+
+An object whose ID is exactly path is listed as a leaf under its basename.
+Objects below path, i.e. whose ID starts with path followed by sep, are
+listed by the fragment following path up to the next sep, if any.
+*/
+func listEntryName(oid, path, sep string) (string, bool, bool) {
+	var prefix = path
+	var fragments []string
+
+	if oid == path {
+		var basename = oid[strings.LastIndex(oid, sep)+len(sep):]
+		return basename, false, len(basename) > 0
 	}
 
-	if ret, err = r.rfs.OpenIOContext(pool); err != nil {
-		return nil, err
+	if !strings.HasSuffix(prefix, sep) {
+		prefix += sep
+	}
+	if !strings.HasPrefix(oid, prefix) {
+		return "", false, false
 	}
 
-	r.openContexts[pool] = ret
-	return ret, err
+	fragments = strings.SplitN(oid[len(prefix):], sep, 2)
+	return fragments[0], len(fragments) > 1, len(fragments[0]) > 0
 }
 
 /*
-OpenReader opens the specified Rados object (u.Path) in the specified pool
-(u.Host) for reading starting from offset 0.
-TODO: does not respect contexts yet.
+listEntriesFromSet converts a set of entries collected with addListEntry into
+the list of entries returned by ListEntries.
 */
-func (r *radosFileSystem) OpenReader(ctx context.Context, u *url.URL) (
-	filesystem.ReadCloser, error) {
-	var rctx *rados.IOContext
+func listEntriesFromSet(set map[string]bool) []string {
+	var objs = make([]string, 0, len(set))
+	var path string
+
+	for path = range set {
+		objs = append(objs, path)
+	}
+
+	return objs
+}
+
+/*
+typedEntriesFromSet converts a set of entries collected with addListEntry into
+the list of entries returned by ListEntriesTyped.
+*/
+func typedEntriesFromSet(set map[string]bool) []Entry {
+	var entries = make([]Entry, 0, len(set))
+	var path string
+	var isDir bool
+
+	for path, isDir = range set {
+		entries = append(entries, Entry{Name: path, IsDir: isDir})
+	}
+
+	return entries
+}
+
+/*
+WriteIfVersion replaces the contents of the Rados object named u.Path in the
+pool pointed at by u.Host with data, but only if the object is still at
+expectedVersion. If the object has been modified since, ErrVersionMismatch is
+returned and the object is left untouched. The version of an object can be
+determined using Version(), or ReadWriteCloser.Version() after reading from
+it with version tracking enabled.
+*/
+func (r *RadosFileSystem) WriteIfVersion(
+	ctx context.Context, u *url.URL, data []byte, expectedVersion uint64) error {
+	var entry *contextEntry
+	var op WriteOp
+	var errno syscall.Errno
+	var ok bool
 	var err error
 
-	if rctx, err = r.getContext(u.Host); err != nil {
-		return nil, err
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return err
+	}
+
+	op.AssertVersion(expectedVersion)
+
+	if err = r.writeFull(ctx, entry, u.Path, &op, data); err != nil {
+		/*
+		   A failed version assertion is reported as ERANGE or EOVERFLOW
+		   depending on whether the object is older or newer than expected.
+		*/
+		if errno, ok = radosErrno(err); ok && (errno == syscall.ECANCELED ||
+			errno == syscall.ERANGE || errno == syscall.EOVERFLOW) {
+			return objectError("write", entry.poolName, u.Path,
+				ErrVersionMismatch)
+		}
+		return err
 	}
 
-	return NewReadWriteCloser(rctx, u.Path), nil
+	return r.indexAdd(ctx, entry, u.Path)
 }
 
 /*
-OpenWriter opens the specified Rados object (u.Path) in the specified pool
-(u.Host), truncates it to 0 bytes and creates a writer object to write data
-to the resulting object.
-TODO: does not respect contexts yet.
+OperateWrite applies all steps of op atomically to the Rados object named
+u.Path in the pool pointed at by u.Host in a single round trip. Either all
+steps take effect or, if any of them fails, none do.
 */
-func (r *radosFileSystem) OpenWriter(ctx context.Context, u *url.URL) (
-	filesystem.WriteCloser, error) {
-	var rctx *rados.IOContext
+func (r *RadosFileSystem) OperateWrite(
+	ctx context.Context, u *url.URL, op *WriteOp) error {
+	var entry *contextEntry
 	var err error
 
-	rctx, err = r.getContext(u.Host)
-	if err != nil {
-		return nil, err
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return err
 	}
+	if err = r.space.Load().check(ctx); err != nil {
+		countNoSpace(entry.cluster, entry.poolName, err)
+		return err
+	}
+	if err = r.slots.acquire(ctx); err != nil {
+		return err
+	}
+	defer r.slots.release()
 
-	err = rctx.Truncate(u.Path, 0)
-	if err != nil {
-		return nil, err
+	if err = runWithContext(ctx, func() error {
+		return entry.ioctx.OperateWrite(u.Path, op)
+	}); err != nil {
+		err = objectError("operate write", entry.poolName, u.Path, err)
+		countNoSpace(entry.cluster, entry.poolName, err)
+		return err
 	}
 
-	return NewReadWriteCloser(rctx, u.Path), nil
+	if removesObject(op) {
+		return r.indexRemove(ctx, entry, u.Path)
+	}
+	return r.indexAdd(ctx, entry, u.Path)
 }
 
 /*
-OpenAppender opens the specified Rados object (u.Path) in the specified pool
-(u.Host) for appending. If the object does not exist yet, it will be created.
-TODO: does not respect contexts yet.
+removesObject reports whether op deletes the object it is applied to.
 */
-func (r *radosFileSystem) OpenAppender(ctx context.Context, u *url.URL) (
-	filesystem.WriteCloser, error) {
-	var rctx *rados.IOContext
+func removesObject(op *WriteOp) bool {
+	var step WriteStep
+
+	for _, step = range op.Steps() {
+		if step.Kind == WriteStepRemove {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+OperateRead executes all steps of op against the Rados object named u.Path in
+the pool pointed at by u.Host. All steps observe the same version of the
+object, so e.g. data and the checksum stored alongside it are consistent.
+*/
+func (r *RadosFileSystem) OperateRead(
+	ctx context.Context, u *url.URL, op *ReadOp) error {
+	var entry *contextEntry
 	var err error
 
-	rctx, err = r.getContext(u.Host)
-	if err != nil {
-		return nil, err
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return err
+	}
+	if err = r.slots.acquire(ctx); err != nil {
+		return err
 	}
+	defer r.slots.release()
 
-	return NewAppender(rctx, u.Path)
+	return objectError("operate read", entry.poolName, u.Path,
+		runWithContext(ctx, func() error {
+			return entry.ioctx.OperateRead(u.Path, op)
+		}))
 }
 
 /*
-ListEntries will find all entries in the Rados pool designated by u.Host which
-have the prefix of u.Path. The object ID will be broken up into parts separated
-by slashes. Only the part before the next slash is returned.
-TODO: does not respect contexts yet.
+Exec invokes method of the Ceph object class named class on the Rados object
+named u.Path in the pool pointed at by u.Host, passing in as input, and returns
+the output of the method. The object class must be loaded on the OSDs. This
+allows computation to be offloaded to the OSDs rather than transferring the
+object data. Since object class methods may modify the object, Exec() is
+refused with ErrReadOnly in read-only mode and with ErrImmutable on immutable
+objects.
 */
-func (r *radosFileSystem) ListEntries(ctx context.Context, u *url.URL) (
-	[]string, error) {
-	var rctx *rados.IOContext
-	var iter *rados.Iter
-	var set = make(map[string]bool)
-	var objs = make([]string, 0)
-	var prefix = u.Path
-	var path string
-	var isset bool
+func (r *RadosFileSystem) Exec(ctx context.Context, u *url.URL,
+	class, method string, in []byte) ([]byte, error) {
+	var entry *contextEntry
+	var out []byte
 	var err error
 
-	rctx, err = r.getContext(u.Host)
-	if err != nil {
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
 		return nil, err
 	}
 
-	iter, err = rctx.Iter()
+	err = runWithContext(ctx, func() error {
+		var err error
+		out, err = entry.ioctx.Exec(u.Path, class, method, in)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, objectError("exec "+class+"."+method, entry.poolName,
+			u.Path, err)
 	}
 
-	if !strings.HasSuffix(prefix, "/") {
-		prefix += "/"
-	}
+	return out, nil
+}
 
-	for iter.Next() {
-		path = iter.Value()
-		if path == u.Path {
-			var basename = path[strings.LastIndex(path, "/")+1:]
-			if len(basename) > 0 {
-				set[basename] = true
-			}
-		}
-		if strings.HasPrefix(path, prefix) {
-			var fragments []string
-			path = path[len(prefix)+1:]
-			fragments = strings.SplitN(path, "/", 2)
-			if len(fragments) > 0 && len(fragments[0]) > 0 {
-				set[fragments[0]] = true
-			}
-		}
+/*
+FSID returns the unique ID of the cluster connected to. Unlike the cluster
+name, it tells clusters apart even if they are all called "ceph".
+*/
+func (r *RadosFileSystem) FSID(ctx context.Context) (string, error) {
+	var fsid string
+	var err error
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		fsid, err = r.rfs.GetFSID()
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("Cannot determine Rados cluster FSID: %w", err)
 	}
 
-	iter.Close()
+	return fsid, nil
+}
 
-	for path, isset = range set {
-		if isset {
-			objs = append(objs, path)
-		}
+/*
+ListPools returns the names of all pools in the cluster, which can be used as
+the host part of rados:// URLs.
+*/
+func (r *RadosFileSystem) ListPools(ctx context.Context) ([]string, error) {
+	var pools []string
+	var err error
+
+	err = runWithContext(ctx, func() error {
+		var err error
+		pools, err = r.rfs.ListPools()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list Rados pools: %w", err)
 	}
 
-	return objs, nil
+	return pools, nil
 }
 
 /*
 WatchFile returns an error because Rados does not provide any functionality for
 watching files and cannot do so by design.
 */
-func (*radosFileSystem) WatchFile(
+func (*RadosFileSystem) WatchFile(
 	context.Context, *url.URL, filesystem.FileWatchFunc) (
 	filesystem.CancelWatchFunc, chan error, error) {
 	return nil, nil, filesystem.EUNSUPP
 }
 
+/*
+IfExistsParameter is the URL query parameter which makes Remove() treat an
+object which does not exist as successfully removed, e.g.
+rados://pool/object?ifexists=true.
+*/
+const IfExistsParameter = "ifexists"
+
 /*
 Remove deletes the Rados object named u.Path in the pool pointed at by u.Host.
+Objects marked immutable are refused with ErrImmutable. If the object does not
+exist, an error wrapping ErrObjectNotFound is returned, unless the URL sets
+IfExistsParameter; see also RemoveIfExists().
 */
-func (r *radosFileSystem) Remove(ctx context.Context, u *url.URL) error {
-	var rctx *rados.IOContext
+func (r *RadosFileSystem) Remove(ctx context.Context, u *url.URL) error {
+	var entry *contextEntry
+	var ifExists bool
+	var errno syscall.Errno
+	var ok bool
 	var err error
 
-	rctx, err = r.getContext(u.Host)
+	if ifExists, err = queryBool(u, IfExistsParameter); err != nil {
+		return err
+	}
+	entry, u, err = r.getWritableContext(ctx, u)
 	if err != nil {
 		return err
 	}
 
-	return rctx.Delete(u.Path)
+	if err = runWithContext(ctx, func() error {
+		return entry.ioctx.Delete(u.Path)
+	}); err != nil {
+		if errno, ok = radosErrno(err); !ifExists || !ok ||
+			errno != syscall.ENOENT {
+			return objectError("remove", entry.poolName, u.Path, err)
+		}
+	}
+
+	return r.indexRemove(ctx, entry, u.Path)
+}
+
+/*
+RemoveIfExists deletes the Rados object named u.Path in the pool pointed at by
+u.Host like Remove, but treats an object which does not exist as successfully
+removed. Other errors, such as a missing pool, are returned.
+*/
+func (r *RadosFileSystem) RemoveIfExists(ctx context.Context, u *url.URL) error {
+	var ifExists = *u
+	var query = u.Query()
+
+	query.Set(IfExistsParameter, "true")
+	ifExists.RawQuery = query.Encode()
+	return r.Remove(ctx, &ifExists)
+}
+
+/*
+Touch creates the Rados object named u.Path in the pool pointed at by u.Host
+with a length of zero bytes if it does not exist yet. Existing objects are
+left untouched, which makes Touch useful for marker objects.
+*/
+func (r *RadosFileSystem) Touch(ctx context.Context, u *url.URL) error {
+	var entry *contextEntry
+	var op WriteOp
+	var err error
+
+	if err = r.checkWritable(); err != nil {
+		return err
+	}
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return err
+	}
+
+	op.Create(false)
+
+	if err = runWithContext(ctx, func() error {
+		return entry.ioctx.OperateWrite(u.Path, &op)
+	}); err != nil {
+		return objectError("touch", entry.poolName, u.Path, err)
+	}
+
+	return r.indexAdd(ctx, entry, u.Path)
+}
+
+/*
+Shutdown releases all cached I/O contexts and disconnects from the cluster.
+Operations in progress are waited for; afterwards, all operations requiring
+an I/O context fail with ErrClosed, including those of readers and writers
+which are still open. Calling Shutdown more than once is harmless.
+
+The context cache is only locked while the filesystem is marked as closed, so
+callers looking up contexts meanwhile fail with ErrClosed right away rather
+than waiting for the operations in progress. Contexts which are still being
+opened are waited for before the connection is shut down.
+*/
+func (r *RadosFileSystem) Shutdown() {
+	var entries []*contextEntry
+	var pendings []*pendingContext
+	var entry *contextEntry
+	var pending *pendingContext
+
+	r.openContextsMtx.Lock()
+	if r.closed {
+		r.openContextsMtx.Unlock()
+		return
+	}
+	r.closed = true
+
+	for _, entry = range r.openContexts {
+		entries = append(entries, entry)
+	}
+	r.openContexts = make(map[contextKey]*contextEntry)
+	for _, pending = range r.pendingContexts {
+		pendings = append(pendings, pending)
+	}
+	r.openContextsMtx.Unlock()
+
+	/* Contexts opened after closing are destroyed by openContext(). */
+	for _, pending = range pendings {
+		<-pending.done
+	}
+	for _, entry = range entries {
+		entry.ioctx.Destroy()
+		radosOpenContexts.With(prometheus.Labels{"cluster": r.cluster}).Dec()
+	}
+
+	r.rfs.Shutdown()
+	radosConnectionUp.With(prometheus.Labels{"cluster": r.cluster}).Set(0)
 }
@@ -0,0 +1,873 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+testPool is the pool created for every test filesystem.
+*/
+const testPool = "test"
+
+/*
+newTestFS creates a RadosFileSystem on top of a fresh fake connection with
+testPool already created.
+*/
+func newTestFS(t testing.TB) (*rados.RadosFileSystem, *radostest.Conn) {
+	var conn = radostest.NewConn()
+
+	t.Helper()
+	conn.CreatePool(testPool)
+	return rados.NewRadosFileSystemWithConn(conn), conn
+}
+
+/*
+testURL returns the URL of the object oid in testPool.
+*/
+func testURL(oid string) *url.URL {
+	return &url.URL{Scheme: "rados", Host: testPool, Path: oid}
+}
+
+/*
+mustWrite replaces the contents of oid with data, failing the test on errors.
+*/
+func mustWrite(t testing.TB, fs *rados.RadosFileSystem, oid string,
+	data []byte) {
+	t.Helper()
+	if err := fs.WriteFull(context.Background(), testURL(oid),
+		data); err != nil {
+		t.Fatalf("WriteFull(%s) -> %v", oid, err)
+	}
+}
+
+/*
+mustRead returns the contents of oid, failing the test on errors.
+*/
+func mustRead(t testing.TB, fs *rados.RadosFileSystem, oid string) []byte {
+	var data []byte
+	var err error
+
+	t.Helper()
+	if data, err = fs.ReadFile(context.Background(), testURL(oid)); err != nil {
+		t.Fatalf("ReadFile(%s) -> %v", oid, err)
+	}
+	return data
+}
+
+/*
+expectContents fails the test unless oid holds exactly want.
+*/
+func expectContents(t testing.TB, fs *rados.RadosFileSystem, oid string,
+	want []byte) {
+	var got []byte
+
+	t.Helper()
+	if got = mustRead(t, fs, oid); !bytes.Equal(got, want) {
+		t.Errorf("Contents of %s = %q, want %q", oid, got, want)
+	}
+}
+
+func TestObjectURLsAreNormalized(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var ok bool
+	var err error
+
+	mustWrite(t, fs, "/a//b/./c/", []byte("data"))
+	expectContents(t, fs, "/a/b/c", []byte("data"))
+
+	if ok, err = fs.Exists(ctx, testURL("/a/b//c")); err != nil || !ok {
+		t.Errorf("Exists(/a/b//c) = %v, %v, want true", ok, err)
+	}
+	if err = fs.Touch(ctx, testURL("/a/b//c")); err != nil {
+		t.Errorf("Touch(/a/b//c) -> %v", err)
+	}
+	expectContents(t, fs, "/a/b/c", []byte("data"))
+
+	if err = fs.WriteFull(ctx, testURL("/a/../b"), nil); err == nil {
+		t.Error("WriteFull(/a/../b) succeeded, want ErrInvalidURL")
+	}
+}
+
+func TestSlowContextOpenBlocksOnlyItsPool(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var release = make(chan struct{})
+	var slow = &url.URL{Scheme: "rados", Host: "slow", Path: "/object"}
+	var shortCtx, waitCtx context.Context
+	var cancel context.CancelFunc
+	var done = make(chan error, 1)
+	var err error
+
+	conn.CreatePool("slow")
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "OpenIOContext" && pool == "slow" {
+			<-release
+		}
+		return nil
+	})
+
+	shortCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err = fs.ReadFile(shortCtx, slow); !errors.Is(
+		err, context.DeadlineExceeded) {
+		t.Errorf("ReadFile() while opening the pool -> %v, "+
+			"want DeadlineExceeded", err)
+	}
+
+	waitCtx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	go func() {
+		done <- fs.WriteFull(waitCtx, slow, []byte("data"))
+	}()
+
+	if err = fs.WriteFull(waitCtx, testURL("/object"),
+		[]byte("data")); err != nil {
+		t.Errorf("WriteFull() on another pool -> %v", err)
+	}
+
+	close(release)
+	if err = <-done; err != nil {
+		t.Errorf("WriteFull() waiting for the pool -> %v", err)
+	}
+}
+
+func TestShutdownWaitsForOperationsAndFailsLaterOnes(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var entered = make(chan struct{})
+	var release = make(chan struct{})
+	var readDone = make(chan error, 1)
+	var shutdownDone = make(chan struct{})
+	var reader filesystem.ReadCloser
+	var blocked = true
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("data"))
+	if reader, err = fs.OpenReader(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenReader() -> %v", err)
+	}
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Read" && blocked {
+			blocked = false
+			close(entered)
+			<-release
+		}
+		return nil
+	})
+
+	go func() {
+		var _, err = reader.Read(ctx, make([]byte, 4))
+		readDone <- err
+	}()
+	<-entered
+	go func() {
+		fs.Shutdown()
+		close(shutdownDone)
+	}()
+
+	select {
+	case <-shutdownDone:
+		t.Error("Shutdown() returned while a read was in progress")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if _, err = fs.Exists(ctx, testURL("/object")); !errors.Is(err,
+		rados.ErrClosed) {
+		t.Errorf("Exists() during Shutdown() -> %v, want ErrClosed", err)
+	}
+	close(release)
+	if err = <-readDone; err != nil {
+		t.Errorf("Read() in progress during Shutdown() -> %v", err)
+	}
+	<-shutdownDone
+
+	if _, err = reader.Read(ctx, make([]byte, 4)); !errors.Is(err,
+		rados.ErrClosed) {
+		t.Errorf("Read() after Shutdown() -> %v, want ErrClosed", err)
+	}
+}
+
+func TestShutdownWaitsForContextsBeingOpened(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var release = make(chan struct{})
+	var shutdownDone = make(chan struct{})
+	var shortCtx context.Context
+	var cancel context.CancelFunc
+	var err error
+
+	conn.SetHook(blockOperation("OpenIOContext", release))
+	shortCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err = fs.ReadFile(shortCtx, testURL("/object")); !errors.Is(err,
+		context.DeadlineExceeded) {
+		t.Errorf("ReadFile() while opening the pool -> %v, "+
+			"want DeadlineExceeded", err)
+	}
+
+	go func() {
+		fs.Shutdown()
+		close(shutdownDone)
+	}()
+	select {
+	case <-shutdownDone:
+		t.Error("Shutdown() returned while a context was being opened")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-shutdownDone
+}
+
+func TestWritesGiveUpOnExpiredContexts(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var release = make(chan struct{})
+	var writes = map[string]func(ctx context.Context) error{
+		"Truncate": func(ctx context.Context) error {
+			_, err := fs.OpenWriter(ctx, testURL("/object"))
+			return err
+		},
+		"Delete": func(ctx context.Context) error {
+			return fs.Remove(ctx, testURL("/object"))
+		},
+		"OperateWrite": func(ctx context.Context) error {
+			if err := fs.WriteIfVersion(ctx, testURL("/object"),
+				[]byte("new"), 1); !errors.Is(err,
+				context.DeadlineExceeded) {
+				return err
+			}
+			if _, err := fs.CopyFrom(ctx, testURL("/object"),
+				bytes.NewReader([]byte("new"))); !errors.Is(err,
+				context.DeadlineExceeded) {
+				return err
+			}
+			return fs.WriteFull(ctx, testURL("/object"), []byte("new"))
+		},
+	}
+	var shortCtx context.Context
+	var cancel context.CancelFunc
+	var blocked string
+	var write func(ctx context.Context) error
+	var err error
+
+	defer close(release)
+
+	mustWrite(t, fs, "/object", []byte("data"))
+
+	for blocked, write = range writes {
+		conn.SetHook(blockOperation(blocked, release))
+		shortCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+		if err = write(shortCtx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Write blocked in %s -> %v, want DeadlineExceeded",
+				blocked, err)
+		}
+		cancel()
+	}
+}
+
+/*
+blockOperation returns a hook which blocks all operations named op until
+release is closed.
+*/
+func blockOperation(op string, release chan struct{}) radostest.Hook {
+	return func(name, pool, oid string) error {
+		if name == op {
+			<-release
+		}
+		return nil
+	}
+}
+
+func TestDefaultTimeoutBoundsContextsWithoutDeadline(t *testing.T) {
+	var fs, conn = newTestFS(t)
+	var release = make(chan struct{})
+	var slow = &url.URL{Scheme: "rados", Host: "slow", Path: "/object"}
+	var start time.Time
+	var err error
+
+	defer close(release)
+	if err = flag.Set("rados-default-timeout", "20ms"); err != nil {
+		t.Fatalf("Setting -rados-default-timeout -> %v", err)
+	}
+	defer flag.Set("rados-default-timeout", "30s")
+
+	conn.CreatePool("slow")
+	conn.SetHook(blockOperation("OpenIOContext", release))
+
+	start = time.Now()
+	if _, err = fs.ReadFile(context.Background(), slow); !errors.Is(err,
+		context.DeadlineExceeded) {
+		t.Errorf("ReadFile() while the pool cannot be opened -> %v, want "+
+			"DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ReadFile() gave up only after %s", elapsed)
+	}
+}
+
+func TestContextsAreCachedPerPoolAndNamespace(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var other = &url.URL{Scheme: "rados", Host: "other", Path: "/object"}
+	var tenant = &url.URL{Scheme: "rados", Host: testPool, Path: "/object",
+		RawQuery: rados.NamespaceParameter + "=tenant"}
+	var unused = &url.URL{Scheme: "rados", Host: testPool, Path: "/object",
+		RawQuery: rados.NamespaceParameter + "=unused"}
+	var opens int64
+	var data []byte
+	var ok bool
+	var i int
+	var err error
+
+	conn.CreatePool("other")
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "OpenIOContext" {
+			atomic.AddInt64(&opens, 1)
+		}
+		return nil
+	})
+
+	for i = 0; i < 3; i++ {
+		mustWrite(t, fs, "/object", []byte("default"))
+		if err = fs.WriteFull(ctx, tenant, []byte("tenant")); err != nil {
+			t.Fatalf("WriteFull(%s) -> %v", tenant, err)
+		}
+		if err = fs.WriteFull(ctx, other, []byte("other")); err != nil {
+			t.Fatalf("WriteFull(%s) -> %v", other, err)
+		}
+	}
+
+	if opens = atomic.LoadInt64(&opens); opens != 3 {
+		t.Errorf("Opened %d I/O contexts, want one per pool and namespace",
+			opens)
+	}
+	expectContents(t, fs, "/object", []byte("default"))
+	if data, err = fs.ReadFile(ctx, tenant); err != nil ||
+		string(data) != "tenant" {
+		t.Errorf("ReadFile(%s) -> %q, %v, want \"tenant\"", tenant, data, err)
+	}
+	if ok, err = fs.Exists(ctx, unused); err != nil || ok {
+		t.Errorf("Exists(%s) = %v, %v, want false", unused, ok, err)
+	}
+}
+
+func TestListPools(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var release = make(chan struct{})
+	var shortCtx context.Context
+	var cancel context.CancelFunc
+	var pools []string
+	var err error
+
+	conn.CreatePool("other")
+	if pools, err = fs.ListPools(ctx); err != nil {
+		t.Fatalf("ListPools() -> %v", err)
+	}
+	if len(pools) != 2 || pools[0] != "other" || pools[1] != testPool {
+		t.Errorf("ListPools() -> %v, want [other %s]", pools, testPool)
+	}
+
+	defer close(release)
+	conn.SetHook(blockOperation("ListPools", release))
+	shortCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err = fs.ListPools(shortCtx); !errors.Is(
+		err, context.DeadlineExceeded) {
+		t.Errorf("ListPools() while listing hangs -> %v, want "+
+			"DeadlineExceeded", err)
+	}
+}
+
+func TestOpenWriterOnNewAndExistingObjects(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var w filesystem.WriteCloser
+	var err error
+
+	mustWrite(t, fs, "/existing", []byte("previous contents"))
+
+	for _, oid := range []string{"/new", "/existing"} {
+		if w, err = fs.OpenWriter(ctx, testURL(oid)); err != nil {
+			t.Fatalf("OpenWriter(%s) -> %v", oid, err)
+		}
+		if _, err = w.Write(ctx, []byte("data")); err != nil {
+			t.Errorf("Write(%s) -> %v", oid, err)
+		}
+		if err = w.Close(ctx); err != nil {
+			t.Errorf("Close(%s) -> %v", oid, err)
+		}
+		expectContents(t, fs, oid, []byte("data"))
+	}
+
+	/* Some Rados versions refuse to truncate objects which do not exist. */
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Truncate" {
+			return radostest.Error(syscall.ENOENT)
+		}
+		return nil
+	})
+	if w, err = fs.OpenWriter(ctx, testURL("/refused")); err != nil {
+		t.Fatalf("OpenWriter() when truncating fails with ENOENT -> %v", err)
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Errorf("Close() -> %v", err)
+	}
+}
+
+func TestOpenContextsGauge(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var fs *rados.RadosFileSystem
+	var labels = prometheus.Labels{"cluster": "open-contexts"}
+	var pool string
+	var err error
+
+	fs = rados.NewRadosFileSystemWithConn(conn,
+		rados.WithCluster("open-contexts"))
+	for _, pool = range []string{"a", "b", "c"} {
+		conn.CreatePool(pool)
+		if err = fs.WriteFull(ctx, &url.URL{Scheme: "rados", Host: pool,
+			Path: "/object"}, nil); err != nil {
+			t.Fatalf("WriteFull() in pool %s -> %v", pool, err)
+		}
+		if err = fs.WriteFull(ctx, &url.URL{Scheme: "rados", Host: pool,
+			Path: "/other"}, nil); err != nil {
+			t.Fatalf("WriteFull() in pool %s -> %v", pool, err)
+		}
+	}
+	if got := metricValue(t, "rados_open_contexts", labels); got != 3 {
+		t.Errorf("rados_open_contexts%v = %v, want 3", labels, got)
+	}
+
+	fs.Shutdown()
+	if got := metricValue(t, "rados_open_contexts", labels); got != 0 {
+		t.Errorf("rados_open_contexts%v after Shutdown() = %v, want 0",
+			labels, got)
+	}
+}
+
+func TestRemoveIfExists(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var ok bool
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("data"))
+	if err = fs.RemoveIfExists(ctx, testURL("/object")); err != nil {
+		t.Errorf("RemoveIfExists() of an existing object -> %v", err)
+	}
+	if ok, err = fs.Exists(ctx, testURL("/object")); err != nil || ok {
+		t.Errorf("Exists() after RemoveIfExists() = %v, %v, want false", ok,
+			err)
+	}
+	if err = fs.RemoveIfExists(ctx, testURL("/object")); err != nil {
+		t.Errorf("RemoveIfExists() of a missing object -> %v", err)
+	}
+
+	mustWrite(t, fs, "/object", []byte("data"))
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Delete" {
+			return radostest.Error(syscall.EACCES)
+		}
+		return nil
+	})
+	if err = fs.RemoveIfExists(ctx, testURL("/object")); !errors.Is(err,
+		radostest.Error(syscall.EACCES)) {
+		t.Errorf("RemoveIfExists() without permission -> %v, want EACCES",
+			err)
+	}
+}
+
+func TestShutdownWhileOpeningContexts(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var errs = make(chan error, 16)
+	var started sync.WaitGroup
+	var i int
+	var err error
+
+	for i = 0; i < 4; i++ {
+		conn.CreatePool(fmt.Sprintf("pool%d", i))
+	}
+
+	/*
+	   Every iteration uses a new namespace, so that contexts keep being
+	   opened while the filesystem is shut down.
+	*/
+	for i = 0; i < cap(errs); i++ {
+		started.Add(1)
+		go func(i int) {
+			var u = &url.URL{Scheme: "rados", Host: fmt.Sprintf("pool%d", i%4),
+				Path: "/object"}
+			var err error
+
+			for j := 0; ; j++ {
+				u.RawQuery = fmt.Sprintf("%s=%d-%d", rados.NamespaceParameter,
+					i, j)
+				if err = fs.WriteFull(ctx, u, []byte("data")); err == nil {
+					_, err = fs.ReadFile(ctx, u)
+				}
+				if j == 0 {
+					started.Done()
+				}
+				if err != nil || j > 100000 {
+					errs <- err
+					return
+				}
+				runtime.Gosched()
+			}
+		}(i)
+	}
+
+	started.Wait()
+	fs.Shutdown()
+
+	for i = 0; i < cap(errs); i++ {
+		if err = <-errs; !errors.Is(err, rados.ErrClosed) {
+			t.Errorf("Operation during Shutdown() -> %v, want ErrClosed", err)
+		}
+	}
+}
+
+func TestReadOnlyModeRefusesModifications(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var modifications int64
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("data"))
+	fs.SetReadOnly(true)
+	conn.SetHook(func(op, pool, oid string) error {
+		switch op {
+		case "Write", "WriteFull", "OperateWrite", "Append", "Truncate",
+			"Delete", "Exec":
+			atomic.AddInt64(&modifications, 1)
+		}
+		return nil
+	})
+
+	if _, err = fs.OpenWriter(ctx, testURL("/object")); err !=
+		rados.ErrReadOnly {
+		t.Errorf("OpenWriter() -> %v, want %v", err, rados.ErrReadOnly)
+	}
+	if _, err = fs.OpenAppender(ctx, testURL("/object")); err !=
+		rados.ErrReadOnly {
+		t.Errorf("OpenAppender() -> %v, want %v", err, rados.ErrReadOnly)
+	}
+	if err = fs.WriteFull(ctx, testURL("/object"), nil); err !=
+		rados.ErrReadOnly {
+		t.Errorf("WriteFull() -> %v, want %v", err, rados.ErrReadOnly)
+	}
+	if err = fs.Remove(ctx, testURL("/object")); err !=
+		rados.ErrReadOnly {
+		t.Errorf("Remove() -> %v, want %v", err, rados.ErrReadOnly)
+	}
+	if _, err = fs.Exec(ctx, testURL("/object"), "class", "method",
+		nil); err != rados.ErrReadOnly {
+		t.Errorf("Exec() -> %v, want %v", err, rados.ErrReadOnly)
+	}
+	if modifications != 0 {
+		t.Errorf("Read-only filesystem made %d modifications", modifications)
+	}
+	expectContents(t, fs, "/object", []byte("data"))
+}
+
+func TestContextCacheCounters(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var fs *rados.RadosFileSystem
+	var hits = prometheus.Labels{"cluster": "context-cache", "result": "hit"}
+	var misses = prometheus.Labels{"cluster": "context-cache",
+		"result": "miss"}
+	var err error
+
+	conn.CreatePool(testPool)
+	fs = rados.NewRadosFileSystemWithConn(conn,
+		rados.WithCluster("context-cache"))
+
+	if err = fs.WriteFull(ctx, testURL("/object"), nil); err != nil {
+		t.Fatalf("WriteFull() -> %v", err)
+	}
+	if got := metricValue(t, "rados_context_cache", misses); got != 1 {
+		t.Errorf("rados_context_cache%v after the first write = %v, want 1",
+			misses, got)
+	}
+	if got := metricValue(t, "rados_context_cache", hits); got != 0 {
+		t.Errorf("rados_context_cache%v after the first write = %v, want 0",
+			hits, got)
+	}
+
+	if err = fs.WriteFull(ctx, testURL("/object"), nil); err != nil {
+		t.Fatalf("WriteFull() -> %v", err)
+	}
+	if got := metricValue(t, "rados_context_cache", misses); got != 1 {
+		t.Errorf("rados_context_cache%v after the second write = %v, want 1",
+			misses, got)
+	}
+	if got := metricValue(t, "rados_context_cache", hits); got != 1 {
+		t.Errorf("rados_context_cache%v after the second write = %v, want 1",
+			hits, got)
+	}
+}
+
+func TestRegistrationIsReplacedAndDeregistered(t *testing.T) {
+	var ctx = context.Background()
+	var first, _ = newTestFS(t)
+	var second, _ = newTestFS(t)
+	var err error
+
+	rados.RegisterAs("rados-registration", first)
+	rados.RegisterAs("rados-registration", first)
+	if err = first.WriteFull(ctx, testURL("/object"), nil); err != nil {
+		t.Errorf("WriteFull() after registering twice -> %v", err)
+	}
+
+	rados.RegisterAs("rados-registration", second)
+	if err = first.WriteFull(ctx, testURL("/object"),
+		nil); !errors.Is(err, rados.ErrClosed) {
+		t.Errorf("WriteFull() on the replaced instance -> %v, want %v", err,
+			rados.ErrClosed)
+	}
+	if err = second.WriteFull(ctx, testURL("/object"), nil); err != nil {
+		t.Errorf("WriteFull() on the new instance -> %v", err)
+	}
+
+	if err = rados.Deregister("rados-registration"); err != nil {
+		t.Fatalf("Deregister() -> %v", err)
+	}
+	if err = second.WriteFull(ctx, testURL("/object"),
+		nil); !errors.Is(err, rados.ErrClosed) {
+		t.Errorf("WriteFull() after Deregister() -> %v, want %v", err,
+			rados.ErrClosed)
+	}
+	if err = rados.Deregister("rados-registration"); err == nil {
+		t.Error("Deregister() of an unregistered scheme succeeded")
+	}
+}
+
+func TestSettersRaceWithOperations(t *testing.T) {
+	var fs, _ = newTestFS(t)
+	var done = make(chan struct{})
+	var i int
+
+	go func() {
+		var j int
+
+		defer close(done)
+		for j = 0; j < 100; j++ {
+			fs.SetReadOnly(false)
+			fs.SetLogger(nil)
+			fs.SetSpaceHighWater(0)
+			fs.SetNamespace("")
+			fs.SetPathSeparator("/")
+			fs.SetListConcurrency(j%2 + 1)
+			fs.SetDirectoryPlaceholders(j%2 == 0)
+			fs.SetImmutableObjects(false)
+			fs.SetChecksumStorage(&rados.ChecksumConfig{})
+			fs.SetChecksumVerification(&rados.ChecksumConfig{})
+		}
+	}()
+
+	for i = 0; i < 100; i++ {
+		mustWrite(t, fs, "/dir/object", []byte("data"))
+		expectContents(t, fs, "/dir/object", []byte("data"))
+		listNames(t, fs, "/dir/")
+	}
+	<-done
+}
+
+func TestNilLoggerDiscardsMessages(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var fs = rados.NewRadosFileSystemWithConn(conn, rados.WithLogger(nil))
+	var calls int64
+	var err error
+
+	conn.SetHook(failOperation("OpenIOContext", 1, syscall.EACCES, &calls))
+	if _, err = fs.Exists(ctx, testURL("/object")); err == nil {
+		t.Error("Exists() succeeded despite failing to open the pool")
+	}
+}
+
+func TestDefaultNamespace(t *testing.T) {
+	var ctx = context.Background()
+	var plain, conn = newTestFS(t)
+	var fs = rados.NewRadosFileSystemWithConn(conn,
+		rados.WithNamespace("tenant"))
+	var tenant = &url.URL{Scheme: "rados", Host: testPool, Path: "/object",
+		RawQuery: rados.NamespaceParameter + "=tenant"}
+	var other = &url.URL{Scheme: "rados", Host: testPool, Path: "/object",
+		RawQuery: rados.NamespaceParameter + "=other"}
+	var data []byte
+	var ok bool
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("tenant data"))
+	if err = fs.WriteFull(ctx, other, []byte("other data")); err != nil {
+		t.Fatalf("WriteFull(%s) -> %v", other, err)
+	}
+
+	if ok, err = plain.Exists(ctx, testURL("/object")); err != nil || ok {
+		t.Errorf("Exists() in the default namespace = %v, %v, want false",
+			ok, err)
+	}
+	if data, err = plain.ReadFile(ctx, tenant); err != nil ||
+		string(data) != "tenant data" {
+		t.Errorf("ReadFile(%s) -> %q, %v, want \"tenant data\"", tenant, data,
+			err)
+	}
+	if data, err = plain.ReadFile(ctx, other); err != nil ||
+		string(data) != "other data" {
+		t.Errorf("ReadFile(%s) -> %q, %v, want \"other data\"", other, data,
+			err)
+	}
+}
+
+func TestOpenContextLatencyIsObservedOnMisses(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var fs *rados.RadosFileSystem
+	var labels = prometheus.Labels{"cluster": "open-latency",
+		"pool": testPool}
+	var err error
+
+	conn.CreatePool(testPool)
+	fs = rados.NewRadosFileSystemWithConn(conn,
+		rados.WithCluster("open-latency"))
+
+	if err = fs.WriteFull(ctx, testURL("/object"), nil); err != nil {
+		t.Fatalf("WriteFull() -> %v", err)
+	}
+	if got := metricValue(t, "rados_open_context_latency",
+		labels); got != 1 {
+		t.Errorf("Observations of rados_open_context_latency%v after a "+
+			"miss = %v, want 1", labels, got)
+	}
+
+	if err = fs.WriteFull(ctx, testURL("/object"), nil); err != nil {
+		t.Fatalf("WriteFull() -> %v", err)
+	}
+	if got := metricValue(t, "rados_open_context_latency",
+		labels); got != 1 {
+		t.Errorf("Observations of rados_open_context_latency%v after a "+
+			"hit = %v, want 1", labels, got)
+	}
+}
+
+func TestMalformedURLsAreRejected(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("data"))
+
+	for _, raw := range []string{
+		"rados:///object",
+		"rados://" + testPool,
+		"rados://" + testPool + "/",
+		"rados://" + testPool + "/a/../object",
+	} {
+		var u *url.URL
+
+		if u, err = url.Parse(raw); err != nil {
+			t.Fatalf("Parsing %s -> %v", raw, err)
+		}
+		if _, err = fs.ReadFile(ctx, u); !errors.Is(err, rados.ErrInvalidURL) {
+			t.Errorf("ReadFile(%s) -> %v, want %v", raw, err,
+				rados.ErrInvalidURL)
+		}
+		if err = fs.WriteFull(ctx, u, nil); !errors.Is(err,
+			rados.ErrInvalidURL) {
+			t.Errorf("WriteFull(%s) -> %v, want %v", raw, err,
+				rados.ErrInvalidURL)
+		}
+		if err = fs.Remove(ctx, u); !errors.Is(err, rados.ErrInvalidURL) {
+			t.Errorf("Remove(%s) -> %v, want %v", raw, err,
+				rados.ErrInvalidURL)
+		}
+	}
+
+	if _, err = fs.ListEntries(ctx, &url.URL{Scheme: "rados",
+		Host: testPool}); err != nil {
+		t.Errorf("ListEntries() of a whole pool -> %v", err)
+	}
+}
+
+func TestRemoveWithIfExistsParameter(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var ifExists = &url.URL{Scheme: "rados", Host: testPool,
+		Path: "/object", RawQuery: rados.IfExistsParameter + "=true"}
+	var invalid = &url.URL{Scheme: "rados", Host: testPool,
+		Path: "/object", RawQuery: rados.IfExistsParameter + "=maybe"}
+	var err error
+
+	if err = fs.Remove(ctx, testURL("/object")); !errors.Is(err,
+		rados.ErrObjectNotFound) {
+		t.Errorf("Remove() of a missing object -> %v, want %v", err,
+			rados.ErrObjectNotFound)
+	}
+	if err = fs.Remove(ctx, ifExists); err != nil {
+		t.Errorf("Remove(%s) of a missing object -> %v", ifExists, err)
+	}
+	if err = fs.Remove(ctx, invalid); !errors.Is(err, rados.ErrInvalidURL) {
+		t.Errorf("Remove(%s) -> %v, want %v", invalid, err,
+			rados.ErrInvalidURL)
+	}
+}
+
+func TestErrorsNameObject(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var objErr *rados.ObjectError
+	var err error
+
+	if _, err = fs.ReadFile(ctx, testURL("/dir/missing")); err == nil {
+		t.Fatal("ReadFile() of a missing object succeeded")
+	}
+	if !strings.Contains(err.Error(), testPool) ||
+		!strings.Contains(err.Error(), "/dir/missing") {
+		t.Errorf("ReadFile() -> %q, want the pool and object named", err)
+	}
+	if !errors.Is(err, rados.ErrObjectNotFound) {
+		t.Errorf("ReadFile() -> %v, want %v", err, rados.ErrObjectNotFound)
+	}
+	if !errors.As(err, &objErr) || objErr.Pool != testPool ||
+		objErr.OID != "/dir/missing" {
+		t.Errorf("ReadFile() -> %#v, want an ObjectError for %s/dir/missing",
+			err, testPool)
+	}
+
+	mustWrite(t, fs, "/object", []byte("data"))
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Delete" {
+			return radostest.Error(syscall.EACCES)
+		}
+		return nil
+	})
+	if err = fs.Remove(ctx, testURL("/object")); !errors.Is(err,
+		radostest.Error(syscall.EACCES)) || !strings.Contains(err.Error(),
+		"/object") {
+		t.Errorf("Remove() without permission -> %v, want EACCES naming "+
+			"/object", err)
+	}
+}
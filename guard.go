@@ -0,0 +1,466 @@
+package rados
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+/*
+contextGuard keeps a shared context from being destroyed while operations on
+it are in progress. Once closed, operations which are started afterwards fail
+with ErrClosed rather than using the destroyed context, so that readers and
+writers which are still open when the filesystem is shut down report an error
+instead of crashing.
+*/
+type contextGuard struct {
+	mtx    sync.RWMutex
+	closed bool
+}
+
+/*
+enter marks the start of an operation on the context. It fails with ErrClosed
+if the context has been closed; otherwise exit() must be called once the
+operation is done.
+*/
+func (g *contextGuard) enter() error {
+	g.mtx.RLock()
+	if g.closed {
+		g.mtx.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+/*
+exit marks the end of an operation started with enter().
+*/
+func (g *contextGuard) exit() {
+	g.mtx.RUnlock()
+}
+
+/*
+close waits for all operations in progress to finish and makes all further
+calls to enter() fail.
+*/
+func (g *contextGuard) close() {
+	g.mtx.Lock()
+	g.closed = true
+	g.mtx.Unlock()
+}
+
+/*
+GetPoolName returns the name of the pool of the context.
+*/
+func (s sharedIOContext) GetPoolName() (string, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return "", err
+	}
+	defer s.guard.exit()
+	return s.IOContext.GetPoolName()
+}
+
+/*
+Read reads from the object into data, starting at offset.
+*/
+func (s sharedIOContext) Read(oid string, data []byte, offset uint64) (
+	int, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return 0, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.Read(oid, data, offset)
+}
+
+/*
+Write places data into the object at offset.
+*/
+func (s sharedIOContext) Write(oid string, data []byte, offset uint64) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.Write(oid, data, offset)
+}
+
+/*
+WriteFull replaces the contents of the object with data.
+*/
+func (s sharedIOContext) WriteFull(oid string, data []byte) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.WriteFull(oid, data)
+}
+
+/*
+Append adds data to the end of the object.
+*/
+func (s sharedIOContext) Append(oid string, data []byte) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.Append(oid, data)
+}
+
+/*
+Truncate resizes the object to size bytes.
+*/
+func (s sharedIOContext) Truncate(oid string, size uint64) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.Truncate(oid, size)
+}
+
+/*
+Stat determines the size and modification time of the object.
+*/
+func (s sharedIOContext) Stat(oid string) (rados.ObjectStat, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return rados.ObjectStat{}, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.Stat(oid)
+}
+
+/*
+Delete removes the object.
+*/
+func (s sharedIOContext) Delete(oid string) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.Delete(oid)
+}
+
+/*
+Iter creates an iterator over all objects in the pool. The iterator fails
+with ErrClosed once the context has been destroyed.
+*/
+func (s sharedIOContext) Iter() (Iter, error) {
+	var iter Iter
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return nil, err
+	}
+	defer s.guard.exit()
+	if iter, err = s.IOContext.Iter(); err != nil {
+		return nil, err
+	}
+	return &guardedIter{Iter: iter, guard: s.guard}, nil
+}
+
+/*
+GetXattr reads the extended attribute name of the object into data.
+*/
+func (s sharedIOContext) GetXattr(oid, name string, data []byte) (int, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return 0, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.GetXattr(oid, name, data)
+}
+
+/*
+SetXattr sets the extended attribute name of the object to data.
+*/
+func (s sharedIOContext) SetXattr(oid, name string, data []byte) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.SetXattr(oid, name, data)
+}
+
+/*
+RmXattr removes the extended attribute name from the object.
+*/
+func (s sharedIOContext) RmXattr(oid, name string) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.RmXattr(oid, name)
+}
+
+/*
+ListXattrs returns all extended attributes of the object.
+*/
+func (s sharedIOContext) ListXattrs(oid string) (map[string][]byte, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return nil, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.ListXattrs(oid)
+}
+
+/*
+RequiresAlignment reports whether the pool requires aligned appends.
+*/
+func (s sharedIOContext) RequiresAlignment() (bool, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return false, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.RequiresAlignment()
+}
+
+/*
+LockExclusive takes an exclusive lock on the object.
+*/
+func (s sharedIOContext) LockExclusive(oid, name, cookie, desc string,
+	duration time.Duration, flags *byte) (int, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return 0, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.LockExclusive(oid, name, cookie, desc, duration, flags)
+}
+
+/*
+Unlock releases a lock on the object.
+*/
+func (s sharedIOContext) Unlock(oid, name, cookie string) (int, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return 0, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.Unlock(oid, name, cookie)
+}
+
+/*
+Alignment returns the alignment appends to the pool must observe.
+*/
+func (s sharedIOContext) Alignment() (uint64, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return 0, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.Alignment()
+}
+
+/*
+Exec calls method of the object class on the object.
+*/
+func (s sharedIOContext) Exec(oid, class, method string, in []byte) (
+	[]byte, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return nil, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.Exec(oid, class, method, in)
+}
+
+/*
+CreateSnap creates a snapshot of the pool.
+*/
+func (s sharedIOContext) CreateSnap(name string) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.CreateSnap(name)
+}
+
+/*
+RemoveSnap removes a snapshot of the pool.
+*/
+func (s sharedIOContext) RemoveSnap(name string) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.RemoveSnap(name)
+}
+
+/*
+ListSnaps returns the IDs of all snapshots of the pool.
+*/
+func (s sharedIOContext) ListSnaps() ([]rados.SnapID, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return nil, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.ListSnaps()
+}
+
+/*
+LookupSnap returns the ID of the snapshot of the pool called name.
+*/
+func (s sharedIOContext) LookupSnap(name string) (rados.SnapID, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return 0, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.LookupSnap(name)
+}
+
+/*
+GetSnapName returns the name of the snapshot with the given ID.
+*/
+func (s sharedIOContext) GetSnapName(id rados.SnapID) (string, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return "", err
+	}
+	defer s.guard.exit()
+	return s.IOContext.GetSnapName(id)
+}
+
+/*
+GetSnapStamp returns the time the snapshot with the given ID was taken.
+*/
+func (s sharedIOContext) GetSnapStamp(id rados.SnapID) (time.Time, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return time.Time{}, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.GetSnapStamp(id)
+}
+
+/*
+GetAllOmapValues returns the omap entries of the object.
+*/
+func (s sharedIOContext) GetAllOmapValues(oid, startAfter, filterPrefix string,
+	iteratorSize int64) (map[string][]byte, error) {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return nil, err
+	}
+	defer s.guard.exit()
+	return s.IOContext.GetAllOmapValues(oid, startAfter, filterPrefix,
+		iteratorSize)
+}
+
+/*
+OperateWrite applies all steps of op to the object atomically.
+*/
+func (s sharedIOContext) OperateWrite(oid string, op *WriteOp) error {
+	var err error
+
+	if err = s.guard.enter(); err != nil {
+		return err
+	}
+	defer s.guard.exit()
+	return s.IOContext.OperateWrite(oid, op)
+}
+
+/*
+guardedIter wraps the iterators of a shared context so that they fail with
+ErrClosed rather than using the context after it has been destroyed.
+*/
+type guardedIter struct {
+	Iter
+	guard *contextGuard
+	err   error
+}
+
+/*
+Next advances to the next object. It returns false and makes Err() report
+ErrClosed if the context has been destroyed.
+*/
+func (g *guardedIter) Next() bool {
+	if g.err = g.guard.enter(); g.err != nil {
+		return false
+	}
+	defer g.guard.exit()
+	return g.Iter.Next()
+}
+
+/*
+Err returns the error which ended the iteration, if any.
+*/
+func (g *guardedIter) Err() error {
+	if g.err != nil {
+		return g.err
+	}
+	return g.Iter.Err()
+}
+
+/*
+Seek moves to the first object of the placement group identified by token.
+*/
+func (g *guardedIter) Seek(token rados.IterToken) {
+	if g.err = g.guard.enter(); g.err != nil {
+		return
+	}
+	defer g.guard.exit()
+	g.Iter.Seek(token)
+}
+
+/*
+Token returns the placement group of the current position, or 0 if the
+context has been destroyed.
+*/
+func (g *guardedIter) Token() rados.IterToken {
+	if g.err = g.guard.enter(); g.err != nil {
+		return 0
+	}
+	defer g.guard.exit()
+	return g.Iter.Token()
+}
+
+/*
+Close releases the iterator. Once the context has been destroyed, the
+iterator is left alone, as the cluster connection may be gone as well.
+*/
+func (g *guardedIter) Close() {
+	if g.guard.enter() != nil {
+		return
+	}
+	defer g.guard.exit()
+	g.Iter.Close()
+}
@@ -0,0 +1,373 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+/*
+FileInfo describes a Rados object, or a directory-like prefix of objects.
+*/
+type FileInfo struct {
+	/*
+		Name is the basename of the object, or the entry name when returned
+		by ListEntriesWithInfo.
+	*/
+	Name string
+
+	/*
+		Size is the length of the object in bytes. It is 0 for directories.
+	*/
+	Size int64
+
+	/*
+		ModTime is the time the object was last modified, as tracked by Rados.
+		Directories are not objects in their own right, so Rados does not
+		track a modification time for them and the zero time is reported.
+	*/
+	ModTime time.Time
+
+	/*
+		IsDir is true for directory-like prefixes of further objects.
+	*/
+	IsDir bool
+}
+
+/*
+Stat retrieves the size and modification time of the Rados object named
+u.Path in the pool pointed at by u.Host.
+*/
+func (r *RadosFileSystem) Stat(ctx context.Context, u *url.URL) (
+	*FileInfo, error) {
+	var entry *contextEntry
+	var stat rados.ObjectStat
+	var size int64
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		stat, err = entry.ioctx.Stat(u.Path)
+		return err
+	}); err != nil {
+		return nil, objectError("stat", entry.poolName, u.Path, err)
+	}
+
+	if size, err = objectSize(stat.Size); err != nil {
+		return nil, objectError("stat", entry.poolName, u.Path, err)
+	}
+
+	return &FileInfo{
+		Name:    u.Path[strings.LastIndex(u.Path, "/")+1:],
+		Size:    size,
+		ModTime: stat.ModTime,
+	}, nil
+}
+
+/*
+statConcurrency is the number of objects ListEntriesWithInfo stats at the same
+time. Each Stat also holds a slot of the operation limiter.
+*/
+const statConcurrency = 16
+
+/*
+ListEntriesWithInfo works like ListEntriesTyped, but also reports the size and
+modification time of each leaf object, e.g. for finding objects which have
+changed since a given time. This requires an additional Stat of every leaf
+object listed; these are run concurrently. Objects which are removed between
+being listed and being stat'ed are left out.
+*/
+func (r *RadosFileSystem) ListEntriesWithInfo(
+	ctx context.Context, u *url.URL) ([]FileInfo, error) {
+	var entry *contextEntry
+	var iter Iter
+	var set = make(map[string]bool)
+	var leaves = make(map[string]string)
+	var ret []FileInfo
+	var separator = r.pathSeparator()
+	var name string
+	var isDir bool
+	var ok bool
+	var err error
+
+	if entry, err = r.getURLContext(ctx, u); err != nil {
+		return nil, err
+	}
+
+	if iter, err = entry.ioctx.Iter(); err != nil {
+		return nil, objectError("list", entry.poolName, u.Path, err)
+	}
+	for iter.Next() {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		addListEntry(set, iter.Value(), u.Path, separator)
+		if name, isDir, ok = listEntryName(
+			iter.Value(), u.Path, separator); ok && !isDir {
+			leaves[name] = iter.Value()
+		}
+	}
+	if err == nil {
+		err = iter.Err()
+	}
+	iter.Close()
+	if err != nil {
+		return nil, objectError("list", entry.poolName, u.Path, err)
+	}
+
+	if r.dirPlaceholders.Load() {
+		delete(set, DirPlaceholder)
+	}
+	if r.dirIndex.Load() {
+		delete(set, DirIndexObject)
+	}
+
+	ret = make([]FileInfo, 0, len(set))
+	for name, isDir = range set {
+		if isDir {
+			ret = append(ret, FileInfo{Name: name, IsDir: true})
+		} else {
+			ret = append(ret, FileInfo{Name: name})
+		}
+	}
+
+	return r.statEntries(ctx, entry, ret, leaves)
+}
+
+/*
+statEntries fills in the size and modification time of the leaf entries in
+infos, whose object IDs are given by leaves, using up to statConcurrency
+concurrent Stat calls. Entries whose object no longer exists are removed from
+the returned list.
+*/
+func (r *RadosFileSystem) statEntries(ctx context.Context,
+	entry *contextEntry, infos []FileInfo, leaves map[string]string) (
+	[]FileInfo, error) {
+	var indices = make(chan int)
+	var gone = make([]bool, len(infos))
+	var ret = infos[:0]
+	var wg sync.WaitGroup
+	var errMtx sync.Mutex
+	var firstErr error
+	var cancel context.CancelFunc
+	var worker, idx int
+
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	for worker = 0; worker < statConcurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			var idx int
+			var err error
+
+			defer wg.Done()
+
+			for idx = range indices {
+				if gone[idx], err = r.statEntry(ctx, entry, &infos[idx],
+					leaves[infos[idx].Name]); err != nil {
+					errMtx.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMtx.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for idx = range infos {
+		if infos[idx].IsDir {
+			continue
+		}
+		select {
+		case indices <- idx:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if firstErr = ctx.Err(); firstErr != nil {
+		return nil, firstErr
+	}
+
+	for idx = range infos {
+		if !gone[idx] {
+			ret = append(ret, infos[idx])
+		}
+	}
+	return ret, nil
+}
+
+/*
+statEntry fills in the size and modification time of info from the object
+oid. It reports whether the object no longer exists, which is not an error.
+*/
+func (r *RadosFileSystem) statEntry(ctx context.Context,
+	entry *contextEntry, info *FileInfo, oid string) (bool, error) {
+	var stat rados.ObjectStat
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if err = r.slots.acquire(ctx); err != nil {
+		return false, err
+	}
+	defer r.slots.release()
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		stat, err = entry.ioctx.Stat(oid)
+		return err
+	}); err != nil {
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+			return true, nil
+		}
+		return false, objectError("stat", entry.poolName, oid, err)
+	}
+	if info.Size, err = objectSize(stat.Size); err != nil {
+		return false, objectError("stat", entry.poolName, oid, err)
+	}
+	info.ModTime = stat.ModTime
+	return false, nil
+}
+
+/*
+Exists reports whether the Rados object named u.Path exists in the pool
+pointed at by u.Host. A missing object is not an error; other errors, such as
+the pool being inaccessible, are returned as is.
+*/
+func (r *RadosFileSystem) Exists(ctx context.Context, u *url.URL) (
+	bool, error) {
+	var entry *contextEntry
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return false, err
+	}
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		_, err = entry.ioctx.Stat(u.Path)
+		return err
+	}); err != nil {
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+			return false, nil
+		}
+		return false, objectError("stat", entry.poolName, u.Path, err)
+	}
+
+	return true, nil
+}
+
+/*
+Version returns the current version of the Rados object named u.Path in the
+pool pointed at by u.Host, without reading its contents. Rados increases the
+version with every modification, so it can be used to check whether a cached
+copy is still current, or passed to WriteIfVersion() to replace the object
+only if nobody else has changed it since. A missing object is reported as an
+error wrapping ErrObjectNotFound.
+*/
+func (r *RadosFileSystem) Version(ctx context.Context, u *url.URL) (
+	uint64, error) {
+	var entry *contextEntry
+	var version uint64
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return 0, err
+	}
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		version, err = withVersion(entry.ioctx, func(rctx IOContext) error {
+			var err error
+			_, err = rctx.Stat(u.Path)
+			return err
+		})
+		return err
+	}); err != nil {
+		return 0, objectError("stat", entry.poolName, u.Path, err)
+	}
+
+	return version, nil
+}
+
+/*
+ReadIfChanged reads the Rados object named u.Path in the pool pointed at by
+u.Host, unless it is still at knownVersion. In that case, changed is false and
+no data is transferred. Otherwise the contents are returned along with the
+version they were read at, which can be passed in again on the next call.
+
+go-ceph read operations cannot report the object version, so the version and
+size are looked up first and the data is read using a ReadOp asserting that
+version. An unchanged object thus costs a single round trip. If the object is
+modified in between, the lookup is repeated.
+*/
+func (r *RadosFileSystem) ReadIfChanged(
+	ctx context.Context, u *url.URL, knownVersion uint64) (
+	data []byte, version uint64, changed bool, err error) {
+	var entry *contextEntry
+	var stat rados.ObjectStat
+	var op ReadOp
+	var step *ReadStep
+	var attempt int
+	var errno syscall.Errno
+	var ok bool
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, 0, false, err
+	}
+
+	for attempt = 0; attempt < maxReadOpAttempts; attempt++ {
+		if err = runWithContext(ctx, func() error {
+			var err error
+			version, err = withVersion(entry.ioctx,
+				func(rctx IOContext) error {
+					var err error
+					stat, err = rctx.Stat(u.Path)
+					return err
+				})
+			return err
+		}); err != nil {
+			return nil, 0, false, objectError("stat", entry.poolName, u.Path,
+				err)
+		}
+		if version == knownVersion {
+			return nil, version, false, nil
+		}
+
+		op = ReadOp{}
+		op.AssertVersion(version)
+		step = op.Read(0, stat.Size)
+		if err = r.OperateRead(ctx, u, &op); err == nil {
+			return step.Data, version, true, nil
+		}
+		if errno, ok = radosErrno(err); !ok || (errno != syscall.ECANCELED &&
+			errno != syscall.ERANGE && errno != syscall.EOVERFLOW) {
+			return nil, 0, false, err
+		}
+	}
+
+	return nil, 0, false, objectError("read", entry.poolName, u.Path,
+		ErrVersionMismatch)
+}
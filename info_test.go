@@ -0,0 +1,262 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"syscall"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+)
+
+func TestConcurrentVersionsDoNotMix(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var want = make(map[string]uint64)
+	var wg sync.WaitGroup
+	var oid string
+	var i int
+	var err error
+
+	for i = 0; i < 10; i++ {
+		mustWrite(t, fs, "/busy", []byte("data"))
+	}
+	mustWrite(t, fs, "/quiet", []byte("data"))
+
+	for _, oid = range []string{"/busy", "/quiet"} {
+		if want[oid], err = fs.Version(ctx, testURL(oid)); err != nil {
+			t.Fatalf("Version(%s) -> %v", oid, err)
+		}
+	}
+	if want["/busy"] == want["/quiet"] {
+		t.Fatalf("Objects have the same version %d", want["/busy"])
+	}
+
+	for i = 0; i < 8; i++ {
+		wg.Add(1)
+		go func(oid string) {
+			var version uint64
+			var data []byte
+			var err error
+			var j int
+
+			defer wg.Done()
+			for j = 0; j < 50; j++ {
+				if version, err = fs.Version(ctx, testURL(oid)); err != nil {
+					t.Errorf("Version(%s) -> %v", oid, err)
+					return
+				}
+				if version != want[oid] {
+					t.Errorf("Version(%s) = %d, want %d", oid, version,
+						want[oid])
+					return
+				}
+				if data, version, _, err = fs.ReadIfChanged(
+					ctx, testURL(oid), 0); err != nil {
+					t.Errorf("ReadIfChanged(%s) -> %v", oid, err)
+					return
+				}
+				if version != want[oid] || string(data) != "data" {
+					t.Errorf("ReadIfChanged(%s) = %q at %d, want %q at %d",
+						oid, data, version, "data", want[oid])
+					return
+				}
+			}
+		}([]string{"/busy", "/quiet"}[i%2])
+	}
+	wg.Wait()
+}
+
+func TestListEntriesWithInfoSkipsRemovedObjects(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var once sync.Once
+	var infos []rados.FileInfo
+	var names []string
+	var objErr *rados.ObjectError
+	var cancel context.CancelFunc
+	var cancelled context.Context
+	var i int
+	var err error
+
+	for i = 0; i < 40; i++ {
+		mustWrite(t, fs, fmt.Sprintf("/dir/%02d", i), []byte("data"))
+	}
+	mustWrite(t, fs, "/dir/gone", []byte("data"))
+	mustWrite(t, fs, "/dir/sub/object", []byte("data"))
+
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Stat" && oid == "/dir/gone" {
+			once.Do(func() {
+				if err := fs.Remove(ctx, testURL(oid)); err != nil {
+					t.Errorf("Remove(%s) -> %v", oid, err)
+				}
+			})
+		}
+		return nil
+	})
+	if infos, err = fs.ListEntriesWithInfo(ctx, testURL("/dir")); err != nil {
+		t.Fatalf("ListEntriesWithInfo() -> %v", err)
+	}
+	for _, info := range infos {
+		if info.IsDir {
+			names = append(names, info.Name+"/")
+			continue
+		}
+		names = append(names, info.Name)
+		if info.Size != 4 || info.ModTime.IsZero() {
+			t.Errorf("Entry %s has size %d and time %v", info.Name,
+				info.Size, info.ModTime)
+		}
+	}
+	if len(names) != 41 {
+		t.Errorf("ListEntriesWithInfo() lists %d entries, want 41: %v",
+			len(names), names)
+	}
+	for _, name := range names {
+		if name == "gone" {
+			t.Error("ListEntriesWithInfo() lists the removed object")
+		}
+	}
+
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Stat" && oid == "/dir/07" {
+			return errors.New("injected failure")
+		}
+		return nil
+	})
+	if _, err = fs.ListEntriesWithInfo(ctx, testURL("/dir")); !errors.As(
+		err, &objErr) || objErr.OID != "/dir/07" {
+		t.Errorf("ListEntriesWithInfo() with a failing Stat -> %v, "+
+			"want an ObjectError for /dir/07", err)
+	}
+
+	conn.SetHook(nil)
+	cancelled, cancel = context.WithCancel(ctx)
+	cancel()
+	if _, err = fs.ListEntriesWithInfo(cancelled, testURL("/dir")); !errors.Is(
+		err, context.Canceled) {
+		t.Errorf("ListEntriesWithInfo() with a cancelled context -> %v", err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var missingPool = &url.URL{Scheme: "rados", Host: "missing",
+		Path: "/object"}
+	var ok bool
+	var err error
+
+	mustWrite(t, fs, "/present", nil)
+	if ok, err = fs.Exists(ctx, testURL("/present")); err != nil || !ok {
+		t.Errorf("Exists(/present) = %v, %v, want true", ok, err)
+	}
+	if ok, err = fs.Exists(ctx, testURL("/absent")); err != nil || ok {
+		t.Errorf("Exists(/absent) = %v, %v, want false", ok, err)
+	}
+	if ok, err = fs.Exists(ctx, missingPool); err == nil || ok {
+		t.Errorf("Exists() in a missing pool = %v, %v, want an error", ok, err)
+	}
+
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Stat" {
+			return radostest.Error(syscall.EACCES)
+		}
+		return nil
+	})
+	if ok, err = fs.Exists(ctx, testURL("/present")); !errors.Is(err,
+		radostest.Error(syscall.EACCES)) || ok {
+		t.Errorf("Exists() without permission = %v, %v, want EACCES", ok, err)
+	}
+}
+
+func TestReadIfChanged(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var data []byte
+	var version, newVersion uint64
+	var changed bool
+	var reads int64
+	var err error
+
+	mustWrite(t, fs, "/config", []byte("v1"))
+	if data, version, changed, err = fs.ReadIfChanged(ctx,
+		testURL("/config"), 0); err != nil || !changed ||
+		string(data) != "v1" {
+		t.Fatalf("ReadIfChanged() of a new object -> %q, %v, %v, want "+
+			"\"v1\", true", data, changed, err)
+	}
+
+	conn.SetHook(failOperation("OperateRead", 0, 0, &reads))
+	if data, newVersion, changed, err = fs.ReadIfChanged(ctx,
+		testURL("/config"), version); err != nil || changed ||
+		len(data) > 0 || newVersion != version {
+		t.Errorf("ReadIfChanged() of an unchanged object -> %q, %d, %v, %v, "+
+			"want no data at %d", data, newVersion, changed, err, version)
+	}
+	if reads != 0 {
+		t.Errorf("ReadIfChanged() of an unchanged object read it %d times",
+			reads)
+	}
+	conn.SetHook(nil)
+
+	mustWrite(t, fs, "/config", []byte("v2"))
+	if data, newVersion, changed, err = fs.ReadIfChanged(ctx,
+		testURL("/config"), version); err != nil || !changed ||
+		string(data) != "v2" || newVersion == version {
+		t.Errorf("ReadIfChanged() of a modified object -> %q, %d, %v, %v, "+
+			"want \"v2\" at a version other than %d", data, newVersion,
+			changed, err, version)
+	}
+
+	if _, _, _, err = fs.ReadIfChanged(ctx, testURL("/missing"),
+		0); !errors.Is(err, rados.ErrObjectNotFound) {
+		t.Errorf("ReadIfChanged() of a missing object -> %v, want %v", err,
+			rados.ErrObjectNotFound)
+	}
+}
+
+func TestWriteIfVersion(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var objErr *rados.ObjectError
+	var op rados.ReadOp
+	var step *rados.ReadStep
+	var version uint64
+	var err error
+
+	fs.SetChecksumStorage(&rados.ChecksumConfig{})
+	mustWrite(t, fs, "/object", []byte("old"))
+	if version, err = fs.Version(ctx, testURL("/object")); err != nil {
+		t.Fatalf("Version() -> %v", err)
+	}
+
+	err = fs.WriteIfVersion(ctx, testURL("/object"), []byte("new"), version+1)
+	if !errors.Is(err, rados.ErrVersionMismatch) ||
+		!errors.As(err, &objErr) || objErr.OID != "/object" {
+		t.Errorf("WriteIfVersion() at the wrong version -> %v, want "+
+			"ErrVersionMismatch naming /object", err)
+	}
+	expectContents(t, fs, "/object", []byte("old"))
+
+	if err = fs.WriteIfVersion(
+		ctx, testURL("/object"), []byte("new"), version); err != nil {
+		t.Fatalf("WriteIfVersion() -> %v", err)
+	}
+	expectContents(t, fs, "/object", []byte("new"))
+
+	step = op.GetXattr(rados.DefaultChecksumXattr)
+	if err = fs.OperateRead(ctx, testURL("/object"), &op); err != nil {
+		t.Fatalf("Reading the checksum -> %v", err)
+	}
+	if len(step.Data) == 0 {
+		t.Error("WriteIfVersion() stored no checksum")
+	}
+	fs.SetChecksumVerification(&rados.ChecksumConfig{})
+	expectContents(t, fs, "/object", []byte("new"))
+}
@@ -0,0 +1,195 @@
+package rados
+
+import (
+	"context"
+	"encoding/hex"
+	"hash"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/childoftheuniverse/filesystem"
+)
+
+/*
+DefaultSizeXattr is the extended attribute the length of an object is stored
+in by OpenIntegrityWriter() if no other attribute name has been configured.
+*/
+const DefaultSizeXattr = "user.size"
+
+/*
+IntegrityConfig describes which integrity information OpenIntegrityWriter()
+stores with an object once it has been written.
+*/
+type IntegrityConfig struct {
+	/*
+		SizeXattr is the name of the extended attribute receiving the number
+		of bytes written, in decimal. Defaults to DefaultSizeXattr.
+	*/
+	SizeXattr string
+
+	/*
+		HashXattr is the name of the extended attribute receiving the hex
+		encoded digest of the written data. Defaults to "user." followed by
+		the name of the algorithm, e.g. "user.md5".
+	*/
+	HashXattr string
+
+	/*
+		Algorithm is the hash function computed over the written data.
+		Defaults to ChecksumMD5.
+	*/
+	Algorithm ChecksumAlgorithm
+
+	/*
+		SkipHash disables computing and storing the digest; only the size is
+		stored.
+	*/
+	SkipHash bool
+}
+
+/*
+sizeXattr returns the name of the size attribute to use, applying defaults.
+*/
+func (c *IntegrityConfig) sizeXattr() string {
+	if c.SizeXattr == "" {
+		return DefaultSizeXattr
+	}
+	return c.SizeXattr
+}
+
+/*
+algorithm returns the hash algorithm to use, applying defaults.
+*/
+func (c *IntegrityConfig) algorithm() ChecksumAlgorithm {
+	if c.Algorithm == "" {
+		return ChecksumMD5
+	}
+	return c.Algorithm
+}
+
+/*
+hashXattr returns the name of the digest attribute to use, applying defaults.
+*/
+func (c *IntegrityConfig) hashXattr() string {
+	if c.HashXattr == "" {
+		return "user." + string(c.algorithm())
+	}
+	return c.HashXattr
+}
+
+/*
+OpenIntegrityWriter opens the specified Rados object (u.Path) in the specified
+pool (u.Host), truncates it and returns a writer which keeps track of the
+number of bytes written and a running hash over them. When the writer is
+closed, both are stored in extended attributes of the object as described by
+cfg, in a single atomic operation. If cfg is nil, the defaults are used.
+*/
+func (r *RadosFileSystem) OpenIntegrityWriter(
+	ctx context.Context, u *url.URL, cfg *IntegrityConfig) (
+	filesystem.WriteCloser, error) {
+	var entry *contextEntry
+	var h hash.Hash
+	var err error
+
+	if cfg == nil {
+		cfg = &IntegrityConfig{}
+	}
+
+	if !cfg.SkipHash {
+		if h, err = cfg.algorithm().newHash(); err != nil {
+			return nil, err
+		}
+	}
+
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if err = entry.ioctx.WriteFull(u.Path, []byte{}); err != nil {
+		return nil, err
+	}
+	if err = r.indexAdd(ctx, entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	return &integrityWriter{
+		w:    r.openReadWriteCloser(entry, u.Path),
+		rctx: entry.ioctx,
+		oid:  u.Path,
+		cfg:  cfg,
+		hash: h,
+	}, nil
+}
+
+/*
+integrityWriter passes data through to the underlying Rados writer while
+accounting for its size and hash.
+*/
+type integrityWriter struct {
+	w    *ReadWriteCloser
+	rctx IOContext
+	oid  string
+	cfg  *IntegrityConfig
+	hash hash.Hash
+	size int64
+
+	/*
+		closed is set once Close() has been called, and closeErr holds its
+		result.
+	*/
+	closed   bool
+	closeErr error
+}
+
+/*
+Write writes p to the object and adds the bytes actually written to the size
+and hash.
+*/
+func (i *integrityWriter) Write(ctx context.Context, p []byte) (int, error) {
+	var n int
+	var err error
+
+	if i.closed {
+		return 0, os.ErrClosed
+	}
+
+	n, err = i.w.Write(ctx, p)
+	if n > 0 {
+		i.size += int64(n)
+		if i.hash != nil {
+			i.hash.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+/*
+Close stores the size and, unless disabled, the digest of everything written
+in the extended attributes of the object. Closing more than once does not
+store them again, but reports the result of the first Close.
+*/
+func (i *integrityWriter) Close(ctx context.Context) error {
+	var op WriteOp
+	var err error
+
+	if i.closed {
+		return i.closeErr
+	}
+	i.closed = true
+
+	op.SetXattr(i.cfg.sizeXattr(),
+		[]byte(strconv.FormatInt(i.size, 10)))
+	if i.hash != nil {
+		op.SetXattr(i.cfg.hashXattr(),
+			[]byte(hex.EncodeToString(i.hash.Sum(nil))))
+	}
+
+	if err = runWithContext(ctx, func() error {
+		return i.rctx.OperateWrite(i.oid, &op)
+	}); err != nil {
+		i.closeErr = err
+		return err
+	}
+	i.closeErr = i.w.Close(ctx)
+	return i.closeErr
+}
@@ -0,0 +1,84 @@
+package rados_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+/*
+readXattr returns the value of the extended attribute name of oid.
+*/
+func readXattr(fs *rados.RadosFileSystem, oid, name string) ([]byte, error) {
+	var op rados.ReadOp
+	var step = op.GetXattr(name)
+	var err error
+
+	if err = fs.OperateRead(context.Background(), testURL(oid),
+		&op); err != nil {
+		return nil, err
+	}
+	return step.Data, nil
+}
+
+func TestIntegrityWriterStoresSizeAndHash(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var data = []byte("some data to protect")
+	var md5Sum = md5.Sum(data)
+	var sha256Sum = sha256.Sum256(data)
+	var w filesystem.WriteCloser
+	var value []byte
+	var err error
+
+	for _, c := range []struct {
+		name      string
+		cfg       *rados.IntegrityConfig
+		sizeXattr string
+		hashXattr string
+		hash      string
+	}{
+		{"defaults", nil, "user.size", "user.md5",
+			hex.EncodeToString(md5Sum[:])},
+		{"configured", &rados.IntegrityConfig{
+			SizeXattr: "user.length",
+			HashXattr: "user.digest",
+			Algorithm: rados.ChecksumSHA256,
+		}, "user.length", "user.digest", hex.EncodeToString(sha256Sum[:])},
+		{"skipped", &rados.IntegrityConfig{SkipHash: true}, "user.size",
+			"user.md5", ""},
+	} {
+		if w, err = fs.OpenIntegrityWriter(ctx, testURL("/"+c.name),
+			c.cfg); err != nil {
+			t.Fatalf("%s: OpenIntegrityWriter() -> %v", c.name, err)
+		}
+		if _, err = w.Write(ctx, data[:5]); err != nil {
+			t.Errorf("%s: Write() -> %v", c.name, err)
+		}
+		if _, err = w.Write(ctx, data[5:]); err != nil {
+			t.Errorf("%s: Write() -> %v", c.name, err)
+		}
+		if err = w.Close(ctx); err != nil {
+			t.Fatalf("%s: Close() -> %v", c.name, err)
+		}
+		expectContents(t, fs, "/"+c.name, data)
+
+		if value, err = readXattr(fs, "/"+c.name, c.sizeXattr); err != nil ||
+			string(value) != "20" {
+			t.Errorf("%s: %s = %q, %v, want \"20\"", c.name, c.sizeXattr,
+				value, err)
+		}
+		value, err = readXattr(fs, "/"+c.name, c.hashXattr)
+		if c.hash == "" && err == nil {
+			t.Errorf("%s: %s = %q, want no hash", c.name, c.hashXattr, value)
+		} else if c.hash != "" && (err != nil || string(value) != c.hash) {
+			t.Errorf("%s: %s = %q, %v, want %q", c.name, c.hashXattr, value,
+				err, c.hash)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package rados
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var radosInflightOps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Subsystem: "rados",
+	Name:      "inflight_ops",
+	Help:      "Number of Rados data operations currently holding a slot",
+}, []string{"cluster"})
+
+func init() {
+	prometheus.MustRegister(radosInflightOps)
+}
+
+/*
+opLimiter bounds the number of Rados data operations in flight at the same
+time. A nil opLimiter imposes no limit.
+*/
+type opLimiter struct {
+	slots   chan struct{}
+	cluster string
+}
+
+/*
+newOpLimiter creates a limiter allowing up to n concurrent operations. If n is
+0 or less, no limit is imposed and nil is returned.
+*/
+func newOpLimiter(n int, cluster string) *opLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &opLimiter{
+		slots:   make(chan struct{}, n),
+		cluster: cluster,
+	}
+}
+
+/*
+acquire waits for a free slot. If ctx expires first, the context error is
+returned and no slot is held.
+*/
+func (l *opLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		radosInflightOps.With(prometheus.Labels{"cluster": l.cluster}).Inc()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+release frees a slot obtained with acquire.
+*/
+func (l *opLimiter) release() {
+	if l == nil {
+		return
+	}
+
+	<-l.slots
+	radosInflightOps.With(prometheus.Labels{"cluster": l.cluster}).Dec()
+}
@@ -0,0 +1,83 @@
+package rados
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+/*
+lineReadAhead is the number of bytes a LineReader reads from Rados at once.
+*/
+const lineReadAhead = 64 << 10
+
+/*
+LineReader reads newline-delimited text such as logs or JSONL from a Rados
+object one line at a time.
+*/
+type LineReader struct {
+	r   *ReadWriteCloser
+	buf *bufio.Reader
+}
+
+/*
+NewLineReader opens the specified Rados object (u.Path) in the specified pool
+(u.Host) for reading it line by line. All reads are made with ctx, so
+cancelling it aborts pending and future calls to ReadLine().
+*/
+func (r *RadosFileSystem) NewLineReader(ctx context.Context, u *url.URL) (
+	*LineReader, error) {
+	var entry *contextEntry
+	var rwc *ReadWriteCloser
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+
+	rwc = r.openReadWriteCloser(entry, u.Path)
+	return &LineReader{
+		r:   rwc,
+		buf: getLineReader(&contextReader{ctx: ctx, r: rwc}),
+	}, nil
+}
+
+/*
+ReadLine returns the next line of the object without its line terminator
+("\n" or "\r\n"). A final line which is not terminated is returned as well.
+Once all lines have been read, io.EOF is returned.
+*/
+func (l *LineReader) ReadLine() (string, error) {
+	var line string
+	var err error
+
+	if l.buf == nil {
+		return "", os.ErrClosed
+	}
+
+	line, err = l.buf.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+/*
+Close closes the underlying reader. Further calls to ReadLine() fail with
+os.ErrClosed.
+*/
+func (l *LineReader) Close(ctx context.Context) error {
+	if l.buf != nil {
+		putLineReader(l.buf)
+		l.buf = nil
+	}
+	return l.r.Close(ctx)
+}
@@ -0,0 +1,126 @@
+package rados
+
+import (
+	"context"
+	"flag"
+	"net/url"
+	"sync"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+var listConcurrency = flag.Int("rados-list-concurrency", 1,
+	"Number of placement group ranges listed in parallel by ListEntries")
+
+/*
+SetListConcurrency sets the number of placement group ranges listed in
+parallel when listing a pool, as with WithListConcurrency().
+*/
+func (r *RadosFileSystem) SetListConcurrency(shards int) {
+	r.listConcurrency.Store(int64(shards))
+}
+
+/*
+collectEntriesParallel works like collectEntries, but splits the pool into
+shards of consecutive placement groups and iterates over up to shards of them
+concurrently. Each shard records the entries it finds in its own set, which
+are merged afterwards. If any shard fails or ctx expires, all shards are
+stopped.
+*/
+func (r *RadosFileSystem) collectEntriesParallel(
+	ctx context.Context, u *url.URL, entry *contextEntry, shards int) (
+	map[string]bool, error) {
+	var pgs uint32
+	var sets []map[string]bool
+	var set = make(map[string]bool)
+	var separator = r.pathSeparator()
+	var wg sync.WaitGroup
+	var errMtx sync.Mutex
+	var firstErr error
+	var cancel context.CancelFunc
+	var shard int
+	var name string
+	var isDir bool
+	var err error
+
+	if pgs, err = r.rfs.PoolPGCount(entry.poolName); err != nil {
+		return nil, err
+	}
+	if uint32(shards) > pgs {
+		shards = int(pgs)
+	}
+	if shards < 1 {
+		shards = 1
+	}
+
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	sets = make([]map[string]bool, shards)
+	for shard = 0; shard < shards; shard++ {
+		sets[shard] = make(map[string]bool)
+		wg.Add(1)
+		go func(shard int) {
+			var start = rados.IterToken(uint64(pgs) * uint64(shard) /
+				uint64(shards))
+			var end = rados.IterToken(uint64(pgs) * uint64(shard+1) /
+				uint64(shards))
+			var err error
+
+			defer wg.Done()
+
+			if err = listShard(ctx, entry.ioctx, u.Path, separator,
+				start, end, sets[shard]); err != nil {
+				errMtx.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMtx.Unlock()
+				cancel()
+			}
+		}(shard)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	for shard = range sets {
+		for name, isDir = range sets[shard] {
+			set[name] = set[name] || isDir
+		}
+	}
+
+	return set, nil
+}
+
+/*
+listShard adds the entries below path, split at sep, of all objects in the placement groups
+from start up to, but excluding, end to set. The placement group of an object
+is only known once it has been returned, so the first object of end may be
+recorded as well; this is harmless as the sets of all shards are merged.
+*/
+func listShard(ctx context.Context, ioctx IOContext, path, sep string,
+	start, end rados.IterToken, set map[string]bool) error {
+	var iter Iter
+	var err error
+
+	if iter, err = ioctx.Iter(); err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	iter.Seek(start)
+	for iter.Next() {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		addListEntry(set, iter.Value(), path, sep)
+		if iter.Token() >= end {
+			break
+		}
+	}
+
+	return iter.Err()
+}
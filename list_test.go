@@ -0,0 +1,226 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+)
+
+/*
+newListingFS creates a filesystem holding objects spread over a few
+directories, for comparing sequential and parallel listings.
+*/
+func newListingFS(t testing.TB) (*rados.RadosFileSystem, *radostest.Conn) {
+	var fs, conn = newTestFS(t)
+	var i int
+
+	t.Helper()
+	for i = 0; i < 200; i++ {
+		mustWrite(t, fs, fmt.Sprintf("/dir/%d/object%d", i%7, i), nil)
+		mustWrite(t, fs, fmt.Sprintf("/dir/leaf%d", i), nil)
+	}
+	return fs, conn
+}
+
+func TestParallelListingMatchesSequential(t *testing.T) {
+	var fs, conn = newListingFS(t)
+	var parallel = rados.NewRadosFileSystemWithConn(conn)
+	var shards int
+	var oid string
+
+	for _, shards = range []int{2, 4, radostest.PGCount, 1000} {
+		parallel.SetListConcurrency(shards)
+		for _, oid = range []string{"/", "/dir", "/dir/3", "/missing"} {
+			if got, want := listNames(t, parallel, oid),
+				listNames(t, fs, oid); !reflect.DeepEqual(got, want) {
+				t.Errorf("Listing %s in %d shards -> %v, want %v", oid,
+					shards, got, want)
+			}
+		}
+	}
+}
+
+func TestCancelledParallelListingStopsShards(t *testing.T) {
+	var fs, conn = newListingFS(t)
+	var ctx, cancel = context.WithCancel(context.Background())
+	var goroutines = runtime.NumGoroutine()
+	var err error
+
+	defer cancel()
+	fs.SetListConcurrency(4)
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Iter" {
+			cancel()
+		}
+		return nil
+	})
+
+	if _, err = fs.ListEntries(ctx, testURL("/dir")); !errors.Is(err,
+		context.Canceled) {
+		t.Errorf("ListEntries() cancelled while listing -> %v, want %v", err,
+			context.Canceled)
+	}
+	if got := runtime.NumGoroutine(); got > goroutines {
+		t.Errorf("%d goroutines left after the listing, had %d before", got,
+			goroutines)
+	}
+}
+
+func BenchmarkListEntries(b *testing.B) {
+	var ctx = context.Background()
+	var fs, _ = newListingFS(b)
+	var shards, i int
+	var err error
+
+	for _, shards = range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			fs.SetListConcurrency(shards)
+			for i = 0; i < b.N; i++ {
+				if _, err = fs.ListEntries(ctx, testURL("/dir")); err != nil {
+					b.Fatalf("ListEntries() -> %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestListEntriesSplitsAtSeparator(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var entries []string
+	var err error
+
+	for _, oid := range []string{"/a/b", "/a/c/d", "/a/c/e", "/f"} {
+		mustWrite(t, fs, oid, nil)
+	}
+
+	for _, c := range []struct {
+		path string
+		want []string
+	}{
+		{"/", []string{"a", "f"}},
+		{"/a", []string{"b", "c"}},
+		{"/a/c", []string{"d", "e"}},
+		{"/missing", nil},
+	} {
+		if entries, err = fs.ListEntries(ctx, testURL(c.path)); err != nil {
+			t.Fatalf("ListEntries(%s) -> %v", c.path, err)
+		}
+		sort.Strings(entries)
+		if len(entries) != len(c.want) ||
+			(len(entries) > 0 && !reflect.DeepEqual(entries, c.want)) {
+			t.Errorf("ListEntries(%s) -> %v, want %v", c.path, entries, c.want)
+		}
+	}
+}
+
+func TestListEntriesWithColonSeparator(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var fs *rados.RadosFileSystem
+	var entries []rados.Entry
+	var err error
+
+	conn.CreatePool(testPool)
+	fs = rados.NewRadosFileSystemWithConn(conn, rados.WithPathSeparator(":"))
+	for _, oid := range []string{"/logs:2024:01", "/logs:2024:02",
+		"/logs:2025:01", "/logs:current", "/logs/slashed"} {
+		mustWrite(t, fs, oid, nil)
+	}
+
+	for _, c := range []struct {
+		path string
+		want []rados.Entry
+	}{
+		{"/logs", []rados.Entry{{Name: "2024", IsDir: true},
+			{Name: "2025", IsDir: true}, {Name: "current"}}},
+		{"/logs:2024", []rados.Entry{{Name: "01"}, {Name: "02"}}},
+		{"/logs:current", []rados.Entry{{Name: "current"}}},
+		{"/logs:missing", nil},
+	} {
+		if entries, err = fs.ListEntriesTyped(ctx,
+			testURL(c.path)); err != nil {
+			t.Fatalf("ListEntriesTyped(%s) -> %v", c.path, err)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name < entries[j].Name
+		})
+		if len(entries) != len(c.want) ||
+			(len(entries) > 0 && !reflect.DeepEqual(entries, c.want)) {
+			t.Errorf("ListEntriesTyped(%s) -> %v, want %v", c.path, entries,
+				c.want)
+		}
+	}
+}
+
+func TestListEntriesTypedMixedTree(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var entries []rados.Entry
+	var err error
+
+	for _, oid := range []string{"/leaf", "/dir/a", "/dir/sub/b", "/both",
+		"/both/c"} {
+		mustWrite(t, fs, oid, nil)
+	}
+
+	for _, c := range []struct {
+		path string
+		want []rados.Entry
+	}{
+		{"/", []rados.Entry{{"both", true}, {"dir", true}, {"leaf", false}}},
+		{"/dir", []rados.Entry{{"a", false}, {"sub", true}}},
+		/* The object named like the listed path is listed as a leaf. */
+		{"/both", []rados.Entry{{"both", false}, {"c", false}}},
+	} {
+		if entries, err = fs.ListEntriesTyped(ctx,
+			testURL(c.path)); err != nil {
+			t.Fatalf("ListEntriesTyped(%s) -> %v", c.path, err)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name < entries[j].Name
+		})
+		if !reflect.DeepEqual(entries, c.want) {
+			t.Errorf("ListEntriesTyped(%s) -> %v, want %v", c.path, entries,
+				c.want)
+		}
+	}
+}
+
+func TestListEntriesObjectMatchingPath(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var entries []string
+	var err error
+
+	for _, oid := range []string{"/foo", "/foo/bar", "/foo/baz"} {
+		mustWrite(t, fs, oid, nil)
+	}
+
+	for _, c := range []struct {
+		path string
+		want []string
+	}{
+		{"/", []string{"foo"}},
+		{"/foo", []string{"bar", "baz", "foo"}},
+		{"/foo/", []string{"bar", "baz"}},
+		{"/foo/bar", []string{"bar"}},
+		{"/fo", nil},
+	} {
+		if entries, err = fs.ListEntries(ctx, testURL(c.path)); err != nil {
+			t.Fatalf("ListEntries(%s) -> %v", c.path, err)
+		}
+		sort.Strings(entries)
+		if len(entries) != len(c.want) ||
+			(len(entries) > 0 && !reflect.DeepEqual(entries, c.want)) {
+			t.Errorf("ListEntries(%s) -> %v, want %v", c.path, entries, c.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package rados
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+/*
+ObjectLockName is the name of the Rados advisory lock taken on objects which
+are modified using read-modify-write cycles, such as by Increment(). Other
+clients can take the same lock to coordinate with this package.
+*/
+const ObjectLockName = "filesystem-rados"
+
+/*
+objectLockDuration is the time after which a lock expires if its holder has
+not released it, e.g. because it crashed.
+*/
+const objectLockDuration = 30 * time.Second
+
+/*
+maxLockAttempts is the number of times taking a contended lock is attempted
+before giving up with ErrLocked.
+*/
+const maxLockAttempts = 20
+
+/*
+lockBackoff is the time waited after the first failed attempt to take a lock.
+It doubles with every further attempt, up to maxLockBackoff.
+*/
+const lockBackoff = 5 * time.Millisecond
+const maxLockBackoff = 500 * time.Millisecond
+
+/*
+withObjectLock takes the exclusive lock ObjectLockName on oid, runs fn and
+releases the lock again. Rados creates oid if it does not exist yet. If the
+lock is held by someone else, taking it is retried with exponential backoff
+up to maxLockAttempts times before an error wrapping ErrLocked is returned.
+*/
+func withObjectLock(ctx context.Context, entry *contextEntry, oid string,
+	fn func() error) error {
+	var cookie string
+	var backoff = lockBackoff
+	var timer *time.Timer
+	var ret int
+	var attempt int
+	var err error
+
+	if cookie, err = lockCookie(); err != nil {
+		return err
+	}
+
+	for attempt = 0; ; attempt++ {
+		if err = runWithContext(ctx, func() error {
+			var err error
+			ret, err = entry.ioctx.LockExclusive(oid, ObjectLockName, cookie,
+				"", objectLockDuration, nil)
+			return err
+		}); err != nil {
+			return objectError("lock", entry.poolName, oid, err)
+		}
+		if ret != -int(syscall.EBUSY) {
+			break
+		}
+		if attempt+1 >= maxLockAttempts {
+			return fmt.Errorf("%w: %s/%s: gave up after %d attempts",
+				ErrLocked, entry.poolName, oid, maxLockAttempts)
+		}
+
+		timer = time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > maxLockBackoff {
+			backoff = maxLockBackoff
+		}
+	}
+
+	defer func() {
+		/*
+		   Errors are ignored here; at worst, the lock expires after
+		   objectLockDuration.
+		*/
+		entry.ioctx.Unlock(oid, ObjectLockName, cookie)
+	}()
+
+	return fn()
+}
+
+/*
+lockCookie creates a random cookie identifying a single lock holder.
+*/
+func lockCookie() (string, error) {
+	var cookie = make([]byte, 8)
+	var err error
+
+	if _, err = rand.Read(cookie); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(cookie), nil
+}
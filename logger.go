@@ -0,0 +1,55 @@
+package rados
+
+/*
+Logger receives the log messages of this package. Messages come with
+alternating keys and values describing the context, such as the cluster, pool
+and operation, in the style of log/slog; a *slog.Logger can be used directly.
+*/
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+/*
+nopLogger discards all messages. It is used unless a Logger has been
+configured using WithLogger().
+*/
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+/*
+WithLogger routes the log messages of this package to l. By default, nothing
+is logged; passing nil keeps it that way.
+*/
+func WithLogger(l Logger) Option {
+	return func(c *config) {
+		if l == nil {
+			l = nopLogger{}
+		}
+		c.logger = l
+	}
+}
+
+/*
+SetLogger routes the log messages of this RadosFileSystem to l, as with
+WithLogger(). Passing nil disables logging.
+*/
+func (r *RadosFileSystem) SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	r.logger.Store(&l)
+}
+
+/*
+log returns the Logger receiving the log messages of this RadosFileSystem.
+*/
+func (r *RadosFileSystem) log() Logger {
+	return *r.logger.Load()
+}
@@ -0,0 +1,164 @@
+package rados
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"syscall"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+/*
+StripeManifestSuffix is appended to the name of a striped file to obtain the
+name of its manifest object.
+*/
+const StripeManifestSuffix = ".manifest"
+
+/*
+StripeManifestVersion is the version of the manifest format written by this
+package. Readers reject manifests with a newer version.
+*/
+const StripeManifestVersion = 1
+
+/*
+StripeManifest describes a striped file as written by a StripedWriter. It is
+stored as JSON in a companion object next to the chunks.
+*/
+type StripeManifest struct {
+	/*
+		Version identifies the format of the manifest, see
+		StripeManifestVersion.
+	*/
+	Version    int   `json:"version"`
+	ChunkSize  int64 `json:"chunk_size"`
+	ChunkCount int64 `json:"chunk_count"`
+	TotalSize  int64 `json:"total_size"`
+
+	/*
+		Checksums holds the CRC32C of every chunk, in chunk order.
+	*/
+	Checksums []uint32 `json:"chunk_crc32c"`
+}
+
+/*
+chunkLength returns the expected size of the chunk number idx.
+*/
+func (m *StripeManifest) chunkLength(idx int64) int64 {
+	if idx == m.ChunkCount-1 {
+		return m.TotalSize - idx*m.ChunkSize
+	}
+	return m.ChunkSize
+}
+
+/*
+readStripeManifest fetches and decodes the manifest of the striped file oid.
+Returns nil without an error if the file has no manifest.
+*/
+func readStripeManifest(ctx context.Context, rctx IOContext, oid string) (
+	*StripeManifest, error) {
+	var name = oid + StripeManifestSuffix
+	var manifest StripeManifest
+	var stat rados.ObjectStat
+	var data []byte
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if err = runWithContext(ctx, func() error {
+		var n int
+		var err error
+
+		if stat, err = rctx.Stat(name); err != nil {
+			return err
+		}
+		data = make([]byte, stat.Size)
+		n, err = rctx.Read(name, data, 0)
+		data = data[:n]
+		return err
+	}); err != nil {
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("Cannot parse stripe manifest %s: %w", name, err)
+	}
+	if manifest.Version < 1 || manifest.Version > StripeManifestVersion {
+		return nil, fmt.Errorf("Unsupported stripe manifest version %d in %s",
+			manifest.Version, name)
+	}
+	if manifest.ChunkSize <= 0 || manifest.ChunkCount < 0 ||
+		int64(len(manifest.Checksums)) != manifest.ChunkCount {
+		return nil, fmt.Errorf("Inconsistent stripe manifest %s", name)
+	}
+	return &manifest, nil
+}
+
+/*
+checkStripeComplete verifies that all chunks listed in manifest exist with
+the expected sizes.
+*/
+func checkStripeComplete(ctx context.Context, rctx IOContext,
+	layout StripeLayout, oid string, manifest *StripeManifest) error {
+	var stat rados.ObjectStat
+	var name string
+	var idx int64
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	for idx = 0; idx < manifest.ChunkCount; idx++ {
+		name = layout.chunkName(oid, idx)
+		if err = runWithContext(ctx, func() error {
+			var err error
+			stat, err = rctx.Stat(name)
+			return err
+		}); err != nil {
+			if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+				return fmt.Errorf("%w: chunk %s is missing",
+					ErrIncompleteStripe, name)
+			}
+			return err
+		}
+		if int64(stat.Size) != manifest.chunkLength(idx) {
+			return fmt.Errorf("%w: chunk %s has %d bytes, expected %d",
+				ErrIncompleteStripe, name, stat.Size, manifest.chunkLength(idx))
+		}
+	}
+	return nil
+}
+
+/*
+chunkChecksum computes the CRC32C of the chunk name.
+*/
+func chunkChecksum(ctx context.Context, rctx IOContext, name string,
+	length int64) (uint32, error) {
+	var bufp = getChunkBuffer()
+	var buf = *bufp
+	var sum uint32
+	var off int64
+	var n int
+	var err error
+
+	defer putChunkBuffer(bufp)
+
+	for off < length {
+		if err = runWithContext(ctx, func() error {
+			var err error
+			n, err = rctx.Read(name, buf, uint64(off))
+			return err
+		}); err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		sum = crc32.Update(sum, crc32cTable, buf[:n])
+		off += int64(n)
+	}
+	return sum, nil
+}
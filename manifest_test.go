@@ -0,0 +1,89 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestStripeManifestRoundTrip(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var data = bytes.Repeat([]byte("0123456789"), 25)
+	var r *rados.StripedReader
+	var m *rados.StripeManifest
+	var size int64
+	var err error
+
+	writeStriped(t, fs, "/file", rados.StripeLayout{ChunkSize: 64}, data,
+		true)
+
+	/* The chunk size is taken from the manifest. */
+	if r, err = fs.OpenStripedReader(ctx, testURL("/file"),
+		rados.StripeLayout{ChunkSize: 1}); err != nil {
+		t.Fatalf("OpenStripedReader() -> %v", err)
+	}
+	defer r.Close(ctx)
+
+	if m = r.Manifest(); m == nil {
+		t.Fatal("Manifest() -> nil")
+	}
+	if m.Version != rados.StripeManifestVersion || m.ChunkSize != 64 ||
+		m.ChunkCount != 4 || m.TotalSize != 250 || len(m.Checksums) != 4 {
+		t.Errorf("Manifest() -> %+v, want 4 chunks of 64 bytes totalling 250",
+			m)
+	}
+	if size, err = r.Size(ctx); err != nil || size != 250 {
+		t.Errorf("Size() -> %d, %v, want 250", size, err)
+	}
+	if err = r.VerifyChecksums(ctx); err != nil {
+		t.Errorf("VerifyChecksums() -> %v", err)
+	}
+	if got := readStriped(t, fs, "/file", rados.StripeLayout{}); !bytes.Equal(
+		got, data) {
+		t.Errorf("Read back %d bytes, which differ from the %d written",
+			len(got), len(data))
+	}
+
+	mustWrite(t, fs, "/file.0001", bytes.Repeat([]byte("x"), 64))
+	if err = r.VerifyChecksums(ctx); !errors.Is(err,
+		rados.ErrChecksumMismatch) {
+		t.Errorf("VerifyChecksums() of a modified chunk -> %v, want "+
+			"ErrChecksumMismatch", err)
+	}
+}
+
+func TestStripeManifestDetectsMissingChunks(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var layout = rados.StripeLayout{ChunkSize: 64}
+	var err error
+
+	writeStriped(t, fs, "/missing", layout, make([]byte, 200), true)
+	if err = fs.Remove(ctx, testURL("/missing.0002")); err != nil {
+		t.Fatalf("Remove() -> %v", err)
+	}
+	if _, err = fs.OpenStripedReader(ctx, testURL("/missing"),
+		layout); !errors.Is(err, rados.ErrIncompleteStripe) {
+		t.Errorf("OpenStripedReader() with a missing chunk -> %v, want "+
+			"ErrIncompleteStripe", err)
+	}
+
+	writeStriped(t, fs, "/short", layout, make([]byte, 200), true)
+	mustWrite(t, fs, "/short.0003", make([]byte, 4))
+	if _, err = fs.OpenStripedReader(ctx, testURL("/short"),
+		layout); !errors.Is(err, rados.ErrIncompleteStripe) {
+		t.Errorf("OpenStripedReader() with a truncated chunk -> %v, want "+
+			"ErrIncompleteStripe", err)
+	}
+
+	mustWrite(t, fs, "/future"+rados.StripeManifestSuffix,
+		[]byte(`{"version": 99, "chunk_size": 64}`))
+	if _, err = fs.OpenStripedReader(ctx, testURL("/future"),
+		layout); err == nil {
+		t.Error("OpenStripedReader() with a newer manifest version succeeded")
+	}
+}
@@ -0,0 +1,162 @@
+package rados
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/*
+metricCollectors returns all prometheus collectors exported by this package.
+*/
+func metricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		radosReadLatencies,
+		radosWriteLatencies,
+		radosReadErrors,
+		radosWriteErrors,
+		radosReadBytes,
+		radosWriteBytes,
+		radosAppenderLatencies,
+		radosAppenderErrors,
+		radosAppenderBytes,
+		radosRetries,
+		radosConnectionUp,
+		radosOpenContexts,
+		radosContextCache,
+		radosOpenContextLatencies,
+		radosInflightOps,
+		radosWriteNoSpace,
+	}
+}
+
+/*
+clusterCollector exports the series of the metrics of this package which
+belong to a single cluster, identified by the value of their "cluster" label,
+along with a rados_cluster_info series naming the cluster. Registering it
+through a registerer which adds the FSID as a constant label thus labels only
+the series of that cluster with its FSID, even though the metrics themselves
+are shared by all clusters. The info series also keeps the collectors of
+different cluster names apart when they are registered with the same
+registry.
+*/
+type clusterCollector struct {
+	cluster string
+	info    *prometheus.Desc
+}
+
+/*
+newClusterCollector creates a collector for the series of cluster.
+*/
+func newClusterCollector(cluster string) *clusterCollector {
+	return &clusterCollector{
+		cluster: cluster,
+		info: prometheus.NewDesc("rados_cluster_info",
+			"Rados cluster the metrics of a cluster name refer to", nil,
+			prometheus.Labels{"cluster": cluster}),
+	}
+}
+
+/*
+Describe sends the descriptors of all metrics of this package.
+*/
+func (c *clusterCollector) Describe(ch chan<- *prometheus.Desc) {
+	var collector prometheus.Collector
+
+	ch <- c.info
+	for _, collector = range metricCollectors() {
+		collector.Describe(ch)
+	}
+}
+
+/*
+Collect sends the series of all metrics of this package which belong to the
+cluster.
+*/
+func (c *clusterCollector) Collect(ch chan<- prometheus.Metric) {
+	var metrics = make(chan prometheus.Metric)
+	var metric prometheus.Metric
+
+	go func() {
+		var collector prometheus.Collector
+
+		for _, collector = range metricCollectors() {
+			collector.Collect(metrics)
+		}
+		close(metrics)
+	}()
+
+	ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1)
+	for metric = range metrics {
+		if metricCluster(metric) == c.cluster {
+			ch <- metric
+		}
+	}
+}
+
+/*
+metricCluster returns the value of the "cluster" label of metric, or an empty
+string if it has none.
+*/
+func metricCluster(metric prometheus.Metric) string {
+	var pb dto.Metric
+	var pair *dto.LabelPair
+
+	if metric.Write(&pb) != nil {
+		return ""
+	}
+	for _, pair = range pb.GetLabel() {
+		if pair.GetName() == "cluster" {
+			return pair.GetValue()
+		}
+	}
+	return ""
+}
+
+/*
+fsidConn is the part of a Rados connection handle used to determine the FSID
+metrics are labelled with.
+*/
+type fsidConn interface {
+	GetFSID() (string, error)
+}
+
+/*
+registerClusterMetrics registers the metrics of this package with reg. The
+series of the cluster rfs is connected to carry its FSID as the constant
+label "fsid", which keeps the metrics of clusters sharing a name apart when
+scraped together. If the FSID cannot be determined, the metrics of all
+clusters are registered without it.
+*/
+func registerClusterMetrics(rfs fsidConn, cfg *config,
+	reg prometheus.Registerer) error {
+	var fsid string
+	var err error
+
+	if fsid, err = rfs.GetFSID(); err != nil {
+		cfg.logger.Warn("Cannot determine rados cluster FSID", "cluster",
+			cfg.clusterName(), "error", err)
+		return registerMetrics(reg, metricCollectors())
+	}
+	return registerMetrics(
+		prometheus.WrapRegistererWith(prometheus.Labels{"fsid": fsid}, reg),
+		[]prometheus.Collector{newClusterCollector(cfg.clusterName())})
+}
+
+/*
+registerMetrics registers collectors with reg. Collectors which have been
+registered with reg before are skipped.
+*/
+func registerMetrics(reg prometheus.Registerer,
+	collectors []prometheus.Collector) error {
+	var collector prometheus.Collector
+	var err error
+
+	for _, collector = range collectors {
+		if err = reg.Register(collector); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
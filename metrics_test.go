@@ -0,0 +1,118 @@
+package rados_test
+
+import (
+	"context"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/*
+metricValue returns the value of the series of the metric name in the
+default registry which carries all of labels. Histograms report their sample
+count. Series which have not been created yet count as 0.
+*/
+func metricValue(t testing.TB, name string, labels prometheus.Labels) float64 {
+	t.Helper()
+	return gatheredValue(t, prometheus.DefaultGatherer, name, labels)
+}
+
+/*
+gatheredValue works like metricValue, but for the series gathered from g.
+*/
+func gatheredValue(t testing.TB, g prometheus.Gatherer, name string,
+	labels prometheus.Labels) float64 {
+	var families []*dto.MetricFamily
+	var family *dto.MetricFamily
+	var metric *dto.Metric
+	var err error
+
+	t.Helper()
+	if families, err = g.Gather(); err != nil {
+		t.Fatalf("Gather() -> %v", err)
+	}
+	for _, family = range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric = range family.GetMetric() {
+			if !hasLabels(metric, labels) {
+				continue
+			}
+			switch {
+			case metric.GetCounter() != nil:
+				return metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				return metric.GetGauge().GetValue()
+			case metric.GetHistogram() != nil:
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+/*
+hasLabels determines whether metric carries all of labels.
+*/
+func hasLabels(metric *dto.Metric, labels prometheus.Labels) bool {
+	var pair *dto.LabelPair
+	var matched int
+
+	for _, pair = range metric.GetLabel() {
+		if value, ok := labels[pair.GetName()]; ok && value == pair.GetValue() {
+			matched++
+		}
+	}
+	return matched == len(labels)
+}
+
+func TestClusterMetricsCarryFSID(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var reg = prometheus.NewRegistry()
+	var fs, other *rados.RadosFileSystem
+	var fsid string
+	var err error
+
+	conn.CreatePool(testPool)
+	conn.SetFSID("5f0c3a2e-fsid")
+	fs = rados.NewRadosFileSystemWithConn(conn, rados.WithCluster("fsid"))
+	other = rados.NewRadosFileSystemWithConn(conn,
+		rados.WithCluster("fsid-other"))
+
+	if fsid, err = fs.FSID(ctx); err != nil || fsid != "5f0c3a2e-fsid" {
+		t.Errorf("FSID() -> %q, %v, want \"5f0c3a2e-fsid\"", fsid, err)
+	}
+
+	if err = rados.RegisterClusterMetrics(conn, reg,
+		rados.WithCluster("fsid")); err != nil {
+		t.Fatalf("RegisterClusterMetrics() -> %v", err)
+	}
+	if err = fs.WriteFull(ctx, testURL("/object"), nil); err != nil {
+		t.Fatalf("WriteFull() -> %v", err)
+	}
+	if err = other.WriteFull(ctx, testURL("/object"), nil); err != nil {
+		t.Fatalf("WriteFull() -> %v", err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		labels prometheus.Labels
+		want   float64
+	}{
+		{"rados_cluster_info", prometheus.Labels{"cluster": "fsid",
+			"fsid": "5f0c3a2e-fsid"}, 1},
+		{"rados_open_contexts", prometheus.Labels{"cluster": "fsid",
+			"fsid": "5f0c3a2e-fsid"}, 1},
+		{"rados_open_contexts", prometheus.Labels{"cluster": "fsid-other"},
+			0},
+	} {
+		if got := gatheredValue(t, reg, c.name, c.labels); got != c.want {
+			t.Errorf("%s%v = %v, want %v", c.name, c.labels, got, c.want)
+		}
+	}
+}
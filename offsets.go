@@ -0,0 +1,29 @@
+package rados
+
+import (
+	"math"
+)
+
+/*
+objectSize converts the size of an object as reported by Rados into an
+offset. Sizes which do not fit into an int64 yield ErrOffsetOverflow rather
+than wrapping around to a negative number.
+*/
+func objectSize(size uint64) (int64, error) {
+	if size > math.MaxInt64 {
+		return 0, ErrOffsetOverflow
+	}
+	return int64(size), nil
+}
+
+/*
+addOffset adds delta to the offset pos, returning ErrOffsetOverflow if the
+result does not fit into an int64.
+*/
+func addOffset(pos, delta int64) (int64, error) {
+	if (delta > 0 && pos > math.MaxInt64-delta) ||
+		(delta < 0 && pos < math.MinInt64-delta) {
+		return 0, ErrOffsetOverflow
+	}
+	return pos + delta, nil
+}
@@ -0,0 +1,80 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestHugeObjectSizesAreRejected(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var r filesystem.ReadCloser
+	var err error
+
+	mustWrite(t, fs, "/huge", []byte("data"))
+	if err = conn.SetReportedSize(testPool, "/huge",
+		math.MaxInt64+1); err != nil {
+		t.Fatalf("SetReportedSize() -> %v", err)
+	}
+
+	if _, err = fs.Stat(ctx, testURL("/huge")); !errors.Is(err,
+		rados.ErrOffsetOverflow) {
+		t.Errorf("Stat() -> %v, want %v", err, rados.ErrOffsetOverflow)
+	}
+	if _, err = fs.OpenAppender(ctx, testURL("/huge")); !errors.Is(err,
+		rados.ErrOffsetOverflow) {
+		t.Errorf("OpenAppender() -> %v, want %v", err,
+			rados.ErrOffsetOverflow)
+	}
+
+	if r, err = fs.OpenReader(ctx, testURL("/huge")); err != nil {
+		t.Fatalf("OpenReader() -> %v", err)
+	}
+	defer r.Close(ctx)
+	if _, err = r.(*rados.ReadWriteCloser).Seek(ctx, -1,
+		os.SEEK_END); !errors.Is(err, rados.ErrOffsetOverflow) {
+		t.Errorf("Seek() relative to the end -> %v, want %v", err,
+			rados.ErrOffsetOverflow)
+	}
+}
+
+func TestPositionsDoNotWrapAround(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var w filesystem.WriteCloser
+	var s *rados.ReadWriteCloser
+	var pos int64
+	var err error
+
+	if w, err = fs.OpenWriter(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+	defer w.Close(ctx)
+	s = w.(*rados.ReadWriteCloser)
+	s.SetAllowSparse(true)
+
+	if pos, err = s.Seek(ctx, math.MaxInt64-2, os.SEEK_SET); err != nil {
+		t.Fatalf("Seek() -> %d, %v", pos, err)
+	}
+	if _, err = s.Seek(ctx, 10, os.SEEK_CUR); !errors.Is(err,
+		rados.ErrOffsetOverflow) {
+		t.Errorf("Seek() past the largest offset -> %v, want %v", err,
+			rados.ErrOffsetOverflow)
+	}
+	if _, err = w.Write(ctx, []byte("overflow")); !errors.Is(err,
+		rados.ErrOffsetOverflow) {
+		t.Errorf("Write() past the largest offset -> %v, want %v", err,
+			rados.ErrOffsetOverflow)
+	}
+	if pos, err = s.Seek(ctx, 0, os.SEEK_CUR); err != nil ||
+		pos != math.MaxInt64-2 {
+		t.Errorf("Position after the failures = %d, %v, want %d", pos, err,
+			int64(math.MaxInt64-2))
+	}
+}
@@ -0,0 +1,287 @@
+package rados
+
+import (
+	"time"
+)
+
+/*
+WriteStepKind identifies the kind of a step in a WriteOp.
+*/
+type WriteStepKind int
+
+const (
+	// WriteStepAssertVersion fails the operation unless the object is at Version.
+	WriteStepAssertVersion WriteStepKind = iota
+	// WriteStepWriteFull replaces the object contents with Data.
+	WriteStepWriteFull
+	// WriteStepSetXattr sets the extended attribute Name to Data.
+	WriteStepSetXattr
+	// WriteStepAssertExists fails the operation unless the object exists.
+	WriteStepAssertExists
+	// WriteStepCreate creates the object; if Exclusive, it must not exist yet.
+	WriteStepCreate
+	// WriteStepWrite places Data at Offset.
+	WriteStepWrite
+	// WriteStepAppend adds Data to the end of the object.
+	WriteStepAppend
+	// WriteStepTruncate resizes the object to Offset bytes.
+	WriteStepTruncate
+	// WriteStepZero zeroes Length bytes starting at Offset.
+	WriteStepZero
+	// WriteStepRemove deletes the object.
+	WriteStepRemove
+	// WriteStepRmXattr removes the extended attribute Name.
+	WriteStepRmXattr
+	// WriteStepSetOmap sets the omap entries in Pairs.
+	WriteStepSetOmap
+	// WriteStepRmOmapKeys removes the omap entries named in Keys.
+	WriteStepRmOmapKeys
+)
+
+/*
+WriteStep is a single step of a WriteOp. Which fields are used depends on the
+Kind of the step.
+*/
+type WriteStep struct {
+	Kind      WriteStepKind
+	Name      string
+	Data      []byte
+	Offset    uint64
+	Length    uint64
+	Version   uint64
+	Exclusive bool
+	Pairs     map[string][]byte
+	Keys      []string
+}
+
+/*
+WriteOp collects a number of modifications to a single Rados object which are
+applied atomically: either all steps succeed, or none of them take effect.
+Steps are executed in the order they have been added. The zero value is an
+empty operation ready to use; it is executed using
+RadosFileSystem.OperateWrite().
+*/
+type WriteOp struct {
+	steps []WriteStep
+}
+
+/*
+Steps returns the steps of the operation in the order they were added.
+*/
+func (w *WriteOp) Steps() []WriteStep {
+	return w.steps
+}
+
+/*
+AssertVersion makes the operation fail unless the object is at version v.
+*/
+func (w *WriteOp) AssertVersion(v uint64) {
+	w.steps = append(w.steps, WriteStep{Kind: WriteStepAssertVersion, Version: v})
+}
+
+/*
+WriteFull replaces the contents of the object with data.
+*/
+func (w *WriteOp) WriteFull(data []byte) {
+	w.steps = append(w.steps, WriteStep{Kind: WriteStepWriteFull, Data: data})
+}
+
+/*
+SetXattr sets the extended attribute name of the object to value.
+*/
+func (w *WriteOp) SetXattr(name string, value []byte) {
+	w.steps = append(w.steps, WriteStep{
+		Kind: WriteStepSetXattr,
+		Name: name,
+		Data: value,
+	})
+}
+
+/*
+AssertExists makes the operation fail unless the object exists.
+*/
+func (w *WriteOp) AssertExists() {
+	w.steps = append(w.steps, WriteStep{Kind: WriteStepAssertExists})
+}
+
+/*
+Create creates the object. If exclusive is set, the operation fails if the
+object exists already.
+*/
+func (w *WriteOp) Create(exclusive bool) {
+	w.steps = append(w.steps, WriteStep{
+		Kind:      WriteStepCreate,
+		Exclusive: exclusive,
+	})
+}
+
+/*
+Write places data into the object at offset.
+*/
+func (w *WriteOp) Write(data []byte, offset uint64) {
+	w.steps = append(w.steps, WriteStep{
+		Kind:   WriteStepWrite,
+		Data:   data,
+		Offset: offset,
+	})
+}
+
+/*
+Append adds data to the end of the object.
+*/
+func (w *WriteOp) Append(data []byte) {
+	w.steps = append(w.steps, WriteStep{Kind: WriteStepAppend, Data: data})
+}
+
+/*
+Truncate resizes the object to size bytes.
+*/
+func (w *WriteOp) Truncate(size uint64) {
+	w.steps = append(w.steps, WriteStep{Kind: WriteStepTruncate, Offset: size})
+}
+
+/*
+Zero overwrites length bytes of the object starting at offset with zeroes.
+*/
+func (w *WriteOp) Zero(offset, length uint64) {
+	w.steps = append(w.steps, WriteStep{
+		Kind:   WriteStepZero,
+		Offset: offset,
+		Length: length,
+	})
+}
+
+/*
+Remove deletes the object.
+*/
+func (w *WriteOp) Remove() {
+	w.steps = append(w.steps, WriteStep{Kind: WriteStepRemove})
+}
+
+/*
+RmXattr removes the extended attribute name from the object.
+*/
+func (w *WriteOp) RmXattr(name string) {
+	w.steps = append(w.steps, WriteStep{Kind: WriteStepRmXattr, Name: name})
+}
+
+/*
+SetOmap sets the specified key/value pairs in the omap of the object.
+*/
+func (w *WriteOp) SetOmap(pairs map[string][]byte) {
+	w.steps = append(w.steps, WriteStep{Kind: WriteStepSetOmap, Pairs: pairs})
+}
+
+/*
+RmOmapKeys removes the specified keys from the omap of the object.
+*/
+func (w *WriteOp) RmOmapKeys(keys []string) {
+	w.steps = append(w.steps, WriteStep{Kind: WriteStepRmOmapKeys, Keys: keys})
+}
+
+/*
+ReadStepKind identifies the kind of a step in a ReadOp.
+*/
+type ReadStepKind int
+
+const (
+	// ReadStepRead reads Length bytes starting at Offset into Data.
+	ReadStepRead ReadStepKind = iota
+	// ReadStepGetXattr reads the extended attribute Name into Data.
+	ReadStepGetXattr
+	// ReadStepStat reads the size and modification time of the object.
+	ReadStepStat
+	// ReadStepAssertVersion fails the operation unless the object is at
+	// Version.
+	ReadStepAssertVersion
+)
+
+/*
+ReadStep is a single step of a ReadOp. Which fields are used depends on the
+Kind of the step; result fields are filled in once the operation has been
+executed.
+*/
+type ReadStep struct {
+	Kind   ReadStepKind
+	Name   string
+	Offset uint64
+	Length uint64
+
+	/*
+		Data receives the bytes read by a ReadStepRead step, or the value of
+		the extended attribute for a ReadStepGetXattr step. For reads it may
+		be shorter than Length if the end of the object was reached.
+	*/
+	Data []byte
+
+	/*
+		Size and ModTime receive the size and modification time of the object
+		for a ReadStepStat step.
+	*/
+	Size    uint64
+	ModTime time.Time
+
+	/*
+		Version is the object version required by a ReadStepAssertVersion
+		step.
+	*/
+	Version uint64
+}
+
+/*
+ReadOp collects a number of reads from a single Rados object which are executed
+together, so that all of them observe the same version of the object. The
+zero value is an empty operation ready to use; it is executed using
+RadosFileSystem.OperateRead().
+*/
+type ReadOp struct {
+	steps []*ReadStep
+}
+
+/*
+Steps returns the steps of the operation in the order they were added.
+*/
+func (r *ReadOp) Steps() []*ReadStep {
+	return r.steps
+}
+
+/*
+Read reads length bytes starting at offset. The data is available from the
+returned step once the operation has been executed.
+*/
+func (r *ReadOp) Read(offset, length uint64) *ReadStep {
+	var step = &ReadStep{Kind: ReadStepRead, Offset: offset, Length: length}
+	r.steps = append(r.steps, step)
+	return step
+}
+
+/*
+GetXattr reads the extended attribute name. The value is available from the
+returned step once the operation has been executed. The operation fails if the
+attribute does not exist.
+*/
+func (r *ReadOp) GetXattr(name string) *ReadStep {
+	var step = &ReadStep{Kind: ReadStepGetXattr, Name: name}
+	r.steps = append(r.steps, step)
+	return step
+}
+
+/*
+AssertVersion makes the operation fail unless the object is at version v, as
+with WriteOp.AssertVersion(). This allows reading an object only if it is
+still at the version observed before.
+*/
+func (r *ReadOp) AssertVersion(v uint64) {
+	r.steps = append(r.steps, &ReadStep{Kind: ReadStepAssertVersion,
+		Version: v})
+}
+
+/*
+Stat reads the size and modification time of the object. They are available
+from the returned step once the operation has been executed.
+*/
+func (r *ReadOp) Stat() *ReadStep {
+	var step = &ReadStep{Kind: ReadStepStat}
+	r.steps = append(r.steps, step)
+	return step
+}
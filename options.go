@@ -0,0 +1,484 @@
+package rados
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+Option configures how the Rados implementation connects to the cluster. Options
+are passed to RegisterRados().
+*/
+type Option func(*config)
+
+/*
+config holds the settings assembled from the options passed to RegisterRados().
+*/
+type config struct {
+	configPath  string
+	user        string
+	cluster     string
+	monHosts    []string
+	keyringPath string
+	key         string
+	options     []ConfigOption
+	registerer  prometheus.Registerer
+	retryPolicy RetryPolicy
+	opRetry     RetryPolicy
+
+	connectTimeout time.Duration
+	poolFlags      map[string]rados.OperationFlags
+	maxOps         int
+
+	dirPlaceholders bool
+	sealOnClose     bool
+	readOnly        bool
+	logger          Logger
+	appendClasses   map[string]bool
+	dirIndex        bool
+	namespace       string
+	spaceHighWater  float64
+	separator       string
+	listConcurrency int
+}
+
+/*
+defaultConnectTimeout is the time a connection attempt may take unless
+overridden using WithConnectTimeout().
+*/
+const defaultConnectTimeout = 30 * time.Second
+
+/*
+newConfig assembles a configuration from the specified options.
+*/
+func newConfig(opts []Option) *config {
+	var cfg = &config{
+		retryPolicy:    RetryPolicy{MaxAttempts: 1},
+		connectTimeout: defaultConnectTimeout,
+		logger:         nopLogger{},
+	}
+	var opt Option
+
+	for _, opt = range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+/*
+clusterName returns the name of the cluster connected to, applying the Ceph
+default.
+*/
+func (c *config) clusterName() string {
+	if c.cluster == "" {
+		return "ceph"
+	}
+	return c.cluster
+}
+
+/*
+WithConfigPath reads the Rados configuration from the file at path. If no path
+is specified, the default configuration file will be used.
+*/
+func WithConfigPath(path string) Option {
+	return func(c *config) {
+		c.configPath = path
+	}
+}
+
+/*
+WithUser sets the cephx user to use for talking to the cluster.
+*/
+func WithUser(user string) Option {
+	return func(c *config) {
+		c.user = user
+	}
+}
+
+/*
+WithCluster sets the name of the Ceph cluster to connect to. The cluster name
+is only used if a user has been specified as well.
+*/
+func WithCluster(cluster string) Option {
+	return func(c *config) {
+		c.cluster = cluster
+	}
+}
+
+/*
+WithMonHosts overrides the addresses of the monitors to contact, which are
+otherwise taken from the configuration file. Hosts may be given as host names,
+IPv4 or IPv6 addresses, optionally with a port and a messenger protocol
+prefix, e.g. "10.0.0.1:6789", "::1", "[::1]:3300" or "v2:[::1]:3300".
+*/
+func WithMonHosts(hosts ...string) Option {
+	return func(c *config) {
+		c.monHosts = hosts
+	}
+}
+
+/*
+WithKeyringPath authenticates using the cephx keyring stored at path instead of
+the keyring named in the configuration file. Cannot be combined with WithKey().
+*/
+func WithKeyringPath(path string) Option {
+	return func(c *config) {
+		c.keyringPath = path
+	}
+}
+
+/*
+WithKey authenticates using the specified base64 encoded cephx key, which is
+useful when the key is injected e.g. through the environment. Cannot be
+combined with WithKeyringPath().
+*/
+func WithKey(key string) Option {
+	return func(c *config) {
+		c.key = key
+	}
+}
+
+/*
+ConfigOption is a single Ceph client configuration option, such as
+rados_osd_op_timeout, along with the value to set it to.
+*/
+type ConfigOption struct {
+	Key   string
+	Value string
+}
+
+/*
+WithConfigOption sets the Ceph client configuration option key to value,
+overriding the configuration file. Options are applied in the order they are
+specified, after the configuration file and all other options have been
+processed.
+*/
+func WithConfigOption(key, value string) Option {
+	return func(c *config) {
+		c.options = append(c.options, ConfigOption{Key: key, Value: value})
+	}
+}
+
+/*
+WithConfigOptions sets all Ceph client configuration options contained in
+options as with WithConfigOption(). They are applied in the lexical order of
+their keys.
+*/
+func WithConfigOptions(options map[string]string) Option {
+	var keys = make([]string, 0, len(options))
+	var key string
+
+	for key = range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return func(c *config) {
+		var k string
+
+		for _, k = range keys {
+			c.options = append(c.options,
+				ConfigOption{Key: k, Value: options[k]})
+		}
+	}
+}
+
+/*
+withConfigOptionList sets all Ceph client configuration options in options, in
+order.
+*/
+func withConfigOptionList(options []ConfigOption) Option {
+	return func(c *config) {
+		c.options = append(c.options, options...)
+	}
+}
+
+/*
+configOptionFlag collects the values of the repeatable -rados-option flag.
+*/
+type configOptionFlag []ConfigOption
+
+/*
+String returns the options specified so far in flag syntax.
+*/
+func (f *configOptionFlag) String() string {
+	var parts = make([]string, 0, len(*f))
+	var opt ConfigOption
+
+	for _, opt = range *f {
+		parts = append(parts, opt.Key+"="+opt.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+/*
+Set parses an option given as key=value and adds it to the list.
+*/
+func (f *configOptionFlag) Set(value string) error {
+	var idx = strings.IndexByte(value, '=')
+
+	if idx <= 0 {
+		return fmt.Errorf("Rados option %q must be given as key=value", value)
+	}
+
+	*f = append(*f, ConfigOption{Key: value[:idx], Value: value[idx+1:]})
+	return nil
+}
+
+/*
+WithRegisterer registers the Rados metrics with reg in addition to the default
+prometheus registry. Only the series of the cluster connected to are
+registered with reg; they carry its FSID as the constant label "fsid", and a
+rados_cluster_info series relates the FSID to the cluster name. Filesystems
+connected to different clusters can therefore share reg.
+*/
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(c *config) {
+		c.registerer = reg
+	}
+}
+
+/*
+WithRetryPolicy determines how often, and with which delays, connecting to the
+cluster is attempted before giving up. By default, only one attempt is made.
+*/
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *config) {
+		c.retryPolicy = policy
+	}
+}
+
+/*
+WithConnectRetries retries connecting to the cluster up to retries times if
+the initial attempt fails, e.g. because the service started before Ceph was
+ready. The delay before the first retry is backoff and doubles with every
+further retry. This is a shorthand for WithRetryPolicy().
+*/
+func WithConnectRetries(retries int, backoff time.Duration) Option {
+	return WithRetryPolicy(RetryPolicy{
+		MaxAttempts: retries + 1,
+		BaseBackoff: backoff,
+	})
+}
+
+/*
+WithConnectTimeout limits the time a single attempt to connect to the cluster
+may take, so that unreachable monitors do not block startup forever. Defaults
+to 30 seconds; a timeout of 0 or less waits indefinitely.
+*/
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.connectTimeout = timeout
+	}
+}
+
+/*
+WithOperationRetryPolicy retries reads, writes and appends which fail with a
+transient error, such as EAGAIN or a timeout while an OSD is in transition,
+according to policy. Permanent errors are returned immediately. By default,
+operations are not retried.
+
+Note that an append which timed out may still have been applied, so retrying
+it can duplicate the appended data.
+*/
+func WithOperationRetryPolicy(policy RetryPolicy) Option {
+	return func(c *config) {
+		c.opRetry = policy
+	}
+}
+
+/*
+WithMaxConcurrentOps limits the number of reads, writes, appends and batched
+operations which may be in flight at the same time to n, to avoid overloading
+the OSDs. Further operations wait for a slot to become available, or for their
+context to expire. By default, there is no limit.
+*/
+func WithMaxConcurrentOps(n int) Option {
+	return func(c *config) {
+		c.maxOps = n
+	}
+}
+
+/*
+WithPoolOperationFlags sets go-ceph operation flags to be used for the
+compound operations (batched reads and writes, see WriteOp and ReadOp) on the
+named pool. The flags useful for tuning are:
+
+  - rados.OperationBalanceReads, to spread reads across all replicas,
+  - rados.OperationLocalizeReads, to read from the closest replica,
+  - rados.OperationOrderReadsWrites, to order reads after pending writes,
+  - rados.OperationIgnoreCache and rados.OperationIgnoreOverlay, to bypass
+    cache tiering,
+  - rados.OperationFullTry, to allow writes to a full pool to be attempted.
+
+Flags can be combined using |. They replace any flags set for the same pool
+before.
+*/
+func WithPoolOperationFlags(pool string, flags rados.OperationFlags) Option {
+	return func(c *config) {
+		if c.poolFlags == nil {
+			c.poolFlags = make(map[string]rados.OperationFlags)
+		}
+		c.poolFlags[pool] = flags
+	}
+}
+
+/*
+WithDirectoryPlaceholders enables MkDir(), which represents empty directories
+by placeholder objects, and hides these placeholders from listings. This is
+disabled by default so that pools are not cluttered with placeholder objects
+unless they are wanted.
+*/
+func WithDirectoryPlaceholders() Option {
+	return func(c *config) {
+		c.dirPlaceholders = true
+	}
+}
+
+/*
+WithImmutableObjects marks every object written through OpenWriter() as
+immutable once the writer is closed, see MarkImmutable(). This suits
+compliance setups where objects must not change after they have been
+written. Note that the protection is only enforced by this package.
+*/
+func WithImmutableObjects() Option {
+	return func(c *config) {
+		c.sealOnClose = true
+	}
+}
+
+/*
+WithReadOnly makes all operations which would write to or delete from the
+cluster fail with ErrReadOnly without contacting the cluster. This is a
+safety rail for read replicas and audit tooling; it does not replace proper
+access control through Ceph capabilities.
+*/
+func WithReadOnly(readOnly bool) Option {
+	return func(c *config) {
+		c.readOnly = readOnly
+	}
+}
+
+/*
+WithSpaceHighWater refuses writes with ErrNoSpace once more than the fraction
+highWater (e.g. 0.9) of the capacity of the cluster is in use, before the
+cluster itself starts rejecting writes. This lets ingest pipelines back off
+early. The usage is looked up at most every few seconds, but as it costs an
+extra request, the check is disabled by default.
+*/
+func WithSpaceHighWater(highWater float64) Option {
+	return func(c *config) {
+		c.spaceHighWater = highWater
+	}
+}
+
+/*
+WithPathSeparator splits object IDs into directory-like entries at sep rather
+than at slashes when listing, e.g. ":" for object IDs like tenant:2024:log.
+The directory index, see WithDirectoryIndex(), is only used for listings with
+the default separator.
+*/
+func WithPathSeparator(sep string) Option {
+	return func(c *config) {
+		c.separator = sep
+	}
+}
+
+/*
+WithListConcurrency lists pools in up to shards ranges of placement groups in
+parallel when a listing has to scan the whole pool, which speeds up listing
+large pools at the cost of more concurrent requests. Listings with a shards
+value of 1 or less, the default, scan the pool sequentially.
+*/
+func WithListConcurrency(shards int) Option {
+	return func(c *config) {
+		c.listConcurrency = shards
+	}
+}
+
+/*
+WithNamespace sets the object namespace used for all objects whose URL does
+not select one using NamespaceParameter. This isolates tenants of a shared
+pool without having to spell out the namespace in every URL.
+*/
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+/*
+WithDirectoryIndex maintains an index object per directory, see
+DirIndexObject, whose OMAP records the entries of the directory. Listings
+read the index instead of iterating over the whole pool, falling back to the
+scan for directories which have not been indexed. Objects written before
+enabling the index, or by other clients, are only picked up by Reindex().
+*/
+func WithDirectoryIndex() Option {
+	return func(c *config) {
+		c.dirIndex = true
+	}
+}
+
+/*
+WithAppendClasses declares the classes appenders may be opened with using
+OpenAppenderWithClass(). The class becomes a label of the append metrics, so
+throughput can be broken down by the type of data. Classes must be declared
+up front to keep the number of metric series bounded.
+*/
+func WithAppendClasses(classes ...string) Option {
+	return func(c *config) {
+		var class string
+
+		if c.appendClasses == nil {
+			c.appendClasses = make(map[string]bool)
+		}
+		for _, class = range classes {
+			c.appendClasses[class] = true
+		}
+	}
+}
+
+/*
+formatMonHost brings a monitor address into the form expected in the mon_host
+configuration option. Bare IPv6 addresses need to be enclosed in brackets so
+their colons are not mistaken for a port separator.
+*/
+func formatMonHost(host string) string {
+	var proto string
+
+	host = strings.TrimSpace(host)
+	if strings.HasPrefix(host, "v1:") || strings.HasPrefix(host, "v2:") {
+		proto, host = host[:3], host[3:]
+	}
+
+	if !strings.HasPrefix(host, "[") && strings.Count(host, ":") > 1 &&
+		net.ParseIP(host) != nil {
+		host = "[" + host + "]"
+	}
+
+	return proto + host
+}
+
+/*
+monHostValue joins the monitor addresses into the value of the mon_host
+configuration option.
+*/
+func monHostValue(hosts []string) string {
+	var formatted = make([]string, 0, len(hosts))
+	var host string
+
+	for _, host = range hosts {
+		if host = formatMonHost(host); host != "" {
+			formatted = append(formatted, host)
+		}
+	}
+
+	return strings.Join(formatted, ",")
+}
@@ -0,0 +1,131 @@
+package rados_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	ceph "github.com/ceph/go-ceph/rados"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+/*
+configRecorder is a connection handle recording the configuration options set
+on it, in order. Configuration files, the environment and the command line are
+ignored. Setting the option named invalid fails.
+*/
+type configRecorder struct {
+	options []string
+	invalid string
+}
+
+func (c *configRecorder) ReadConfigFile(path string) error     { return nil }
+func (c *configRecorder) ReadDefaultConfigFile() error         { return nil }
+func (c *configRecorder) ParseDefaultConfigEnv() error         { return nil }
+func (c *configRecorder) ParseCmdLineArgs(args []string) error { return nil }
+
+func (c *configRecorder) SetConfigOption(option, value string) error {
+	if option == c.invalid {
+		return syscall.ENOENT
+	}
+	c.options = append(c.options, option+"="+value)
+	return nil
+}
+
+func TestKeyringIsConfigured(t *testing.T) {
+	var keyring = filepath.Join(t.TempDir(), "keyring")
+	var conn configRecorder
+	var err error
+
+	if err = os.WriteFile(keyring, nil, 0600); err != nil {
+		t.Fatalf("Creating %s -> %v", keyring, err)
+	}
+	if err = rados.ConfigureConn(&conn,
+		rados.WithKeyringPath(keyring)); err != nil {
+		t.Fatalf("ConfigureConn() -> %v", err)
+	}
+	if want := []string{"keyring=" + keyring}; !reflect.DeepEqual(
+		conn.options, want) {
+		t.Errorf("Options set -> %v, want %v", conn.options, want)
+	}
+}
+
+func TestKeyringIsValidated(t *testing.T) {
+	var missing = filepath.Join(t.TempDir(), "keyring")
+	var conn configRecorder
+	var err error
+
+	if err = rados.ConfigureConn(&conn,
+		rados.WithKeyringPath(missing)); !errors.Is(err, os.ErrNotExist) ||
+		!strings.Contains(err.Error(), missing) {
+		t.Errorf("ConfigureConn() with a missing keyring -> %v, want an "+
+			"error naming %s", err, missing)
+	}
+
+	if err = rados.ConfigureConn(&conn, rados.WithKeyringPath(missing),
+		rados.WithKey("AQBzZWNyZXQ=")); err == nil {
+		t.Error("ConfigureConn() with both a keyring and a key succeeded")
+	}
+
+	if len(conn.options) > 0 {
+		t.Errorf("Invalid configurations set options %v", conn.options)
+	}
+}
+
+func TestConfigOptionsAreAppliedInOrder(t *testing.T) {
+	var conn = configRecorder{invalid: "no_such_option"}
+	var err error
+
+	if err = rados.ConfigureConn(&conn,
+		rados.WithConfigOption("rados_osd_op_timeout", "30"),
+		rados.WithConfigOptions(map[string]string{
+			"osd_op_timeout":        "20",
+			"objecter_inflight_ops": "1024",
+			"client_mount_timeout":  "10",
+		}),
+		rados.WithConfigOption("rados_osd_op_timeout", "60")); err != nil {
+		t.Fatalf("ConfigureConn() -> %v", err)
+	}
+	if want := []string{
+		"rados_osd_op_timeout=30",
+		"client_mount_timeout=10",
+		"objecter_inflight_ops=1024",
+		"osd_op_timeout=20",
+		"rados_osd_op_timeout=60",
+	}; !reflect.DeepEqual(conn.options, want) {
+		t.Errorf("Options set -> %v, want %v", conn.options, want)
+	}
+
+	if err = rados.ConfigureConn(&conn, rados.WithConfigOption(
+		"no_such_option", "1")); err == nil ||
+		!strings.Contains(err.Error(), "no_such_option=1") {
+		t.Errorf("ConfigureConn() with an invalid option -> %v, want an "+
+			"error naming it", err)
+	}
+}
+
+func TestPoolOperationFlags(t *testing.T) {
+	var opts = []rados.Option{
+		rados.WithPoolOperationFlags("scan", ceph.OperationLocalizeReads),
+		rados.WithPoolOperationFlags("hot", ceph.OperationIgnoreCache),
+		rados.WithPoolOperationFlags("scan",
+			ceph.OperationBalanceReads|ceph.OperationIgnoreOverlay),
+	}
+
+	for _, c := range []struct {
+		pool string
+		want ceph.OperationFlags
+	}{
+		{"scan", ceph.OperationBalanceReads | ceph.OperationIgnoreOverlay},
+		{"hot", ceph.OperationIgnoreCache},
+		{"other", ceph.OperationNoFlag},
+	} {
+		if got := rados.PoolOperationFlags(c.pool, opts...); got != c.want {
+			t.Errorf("Flags of pool %s = %v, want %v", c.pool, got, c.want)
+		}
+	}
+}
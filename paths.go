@@ -0,0 +1,62 @@
+package rados
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/*
+normalizeURL returns a copy of u whose path has been brought into canonical
+form, so that e.g. rados://pool/a//b/./c and rados://pool/a/b/c address the
+same object: repeated slashes and "." segments are removed, as is a trailing
+slash. Segments of ".." are rejected with an error wrapping ErrInvalidURL
+rather than resolved, so that joined path fragments cannot address objects
+outside of the prefix they were meant for.
+*/
+func normalizeURL(u *url.URL) (*url.URL, error) {
+	var ret url.URL
+	var segment string
+
+	for _, segment = range strings.Split(u.Path, "/") {
+		if segment == ".." {
+			return nil, fmt.Errorf("%w: Rados object path %q must not "+
+				"contain \"..\"", ErrInvalidURL, u.Path)
+		}
+	}
+
+	ret = *u
+	if ret.Path != "" {
+		ret.Path = path.Clean(ret.Path)
+		if ret.Path == "." {
+			ret.Path = ""
+		}
+	}
+	ret.RawPath = ""
+	return &ret, nil
+}
+
+/*
+queryBool returns the value of the boolean query parameter name of u, which
+is false if it is absent. A parameter given without a value counts as true.
+*/
+func queryBool(u *url.URL, name string) (bool, error) {
+	var values []string
+	var value bool
+	var ok bool
+	var err error
+
+	if values, ok = u.Query()[name]; !ok || len(values) == 0 {
+		return false, nil
+	}
+	if values[0] == "" {
+		return true, nil
+	}
+	if value, err = strconv.ParseBool(values[0]); err != nil {
+		return false, fmt.Errorf("%w: invalid value %q for %s", ErrInvalidURL,
+			values[0], name)
+	}
+	return value, nil
+}
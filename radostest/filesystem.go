@@ -0,0 +1,37 @@
+package radostest
+
+import (
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+/*
+Scheme is the URL scheme the fake Rados filesystem is registered for by
+Register().
+*/
+const Scheme = "rados-fake"
+
+/*
+NewFileSystem creates a RadosFileSystem on top of a new fake connection
+without any pools, configured by opts as with
+rados.NewRadosFileSystemWithConn(). Pools must be created on the returned
+connection before they can be used. The filesystem is not registered with the
+filesystem API.
+*/
+func NewFileSystem(opts ...rados.Option) (*rados.RadosFileSystem, *Conn) {
+	var conn = NewConn()
+
+	return rados.NewRadosFileSystemWithConn(conn, opts...), conn
+}
+
+/*
+Register creates a RadosFileSystem on top of a new fake connection as with
+NewFileSystem(), and registers it for handling rados-fake:// URLs, so that
+code using the filesystem API can be tested without a Ceph cluster.
+*/
+func Register(opts ...rados.Option) (*rados.RadosFileSystem, *Conn) {
+	var fs, conn = NewFileSystem(opts...)
+
+	filesystem.AddImplementation(Scheme, fs)
+	return fs, conn
+}
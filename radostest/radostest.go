@@ -0,0 +1,1430 @@
+/*
+Package radostest provides an in-memory implementation of the Rados connection
+interfaces used by the filesystem-rados package, so that code built on top of
+it can be tested without a Ceph cluster:
+
+	var conn = radostest.NewConn()
+	conn.CreatePool("test")
+	var fs = rados.NewRadosFileSystemWithConn(conn)
+
+NewFileSystem() does the same in one step, and Register() additionally makes
+the filesystem handle rados-fake:// URLs. Failures and slow operations can be
+simulated by installing a Hook.
+*/
+package radostest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	ceph "github.com/ceph/go-ceph/rados"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+/*
+Hook is invoked before every operation on the fake connection with the name of
+the operation (e.g. "OpenIOContext", "Read", "Write") as well as the pool and
+object ID concerned, where applicable. If the hook returns an error, the
+operation fails with that error without taking effect. Hooks may block to
+simulate slow operations.
+*/
+type Hook func(op, pool, oid string) error
+
+/*
+ClassMethod implements a method of a fake Ceph object class. It receives the
+current contents of the object (nil if it doesn't exist) and the input passed
+to Exec, and returns the output of the method. If newData is non-nil, it
+replaces the contents of the object.
+*/
+type ClassMethod func(data, in []byte) (out, newData []byte, err error)
+
+/*
+Error returns an error carrying the specified errno in the same way errors from
+go-ceph do. ENOENT is mapped to go-ceph's ErrNotFound.
+*/
+func Error(errno syscall.Errno) error {
+	if errno == syscall.ENOENT {
+		return ceph.ErrNotFound
+	}
+	return radosError(-int(errno))
+}
+
+/*
+radosError mimics the errors returned by go-ceph: a negative errno.
+*/
+type radosError int
+
+/*
+Error formats the error like go-ceph does.
+*/
+func (e radosError) Error() string {
+	return fmt.Sprintf("rados: ret=%d, %s", int(e), syscall.Errno(-e).Error())
+}
+
+/*
+ErrorCode returns the negative errno, like go-ceph errors do.
+*/
+func (e radosError) ErrorCode() int {
+	return int(e)
+}
+
+var _ rados.Conn = (*Conn)(nil)
+var _ rados.IOContext = (*IOContext)(nil)
+
+/*
+object is a single fake Rados object.
+*/
+type object struct {
+	data    []byte
+	xattrs  map[string][]byte
+	omap    map[string][]byte
+	version uint64
+	modTime time.Time
+
+	/*
+		reportedSize overrides the size reported for the object if it is not
+		zero, see SetReportedSize().
+	*/
+	reportedSize uint64
+}
+
+/*
+size returns the size reported for the object.
+*/
+func (o *object) size() uint64 {
+	if o.reportedSize != 0 {
+		return o.reportedSize
+	}
+	return uint64(len(o.data))
+}
+
+/*
+clone creates a deep copy of the object.
+*/
+func (o *object) clone() *object {
+	var ret = &object{
+		data:         append([]byte(nil), o.data...),
+		xattrs:       make(map[string][]byte),
+		omap:         make(map[string][]byte),
+		version:      o.version,
+		modTime:      o.modTime,
+		reportedSize: o.reportedSize,
+	}
+	var k string
+	var v []byte
+
+	for k, v = range o.xattrs {
+		ret.xattrs[k] = append([]byte(nil), v...)
+	}
+	for k, v = range o.omap {
+		ret.omap[k] = append([]byte(nil), v...)
+	}
+	return ret
+}
+
+/*
+touch marks the object as modified.
+*/
+func (o *object) touch() {
+	o.version++
+	o.modTime = time.Now()
+}
+
+/*
+PGCount is the number of placement groups every fake pool has. Objects are
+distributed across them by a hash of their ID.
+*/
+const PGCount = 8
+
+/*
+pgOf returns the placement group the object oid belongs to.
+*/
+func pgOf(oid string) ceph.IterToken {
+	var h = fnv.New32a()
+
+	h.Write([]byte(oid))
+	return ceph.IterToken(h.Sum32() % PGCount)
+}
+
+/*
+snapshot is a fake pool snapshot. Only its metadata is tracked; the objects
+of the pool are not preserved.
+*/
+type snapshot struct {
+	id      ceph.SnapID
+	name    string
+	created time.Time
+}
+
+/*
+namespaceKey identifies the objects of a non-default namespace of a pool.
+*/
+type namespaceKey struct {
+	pool      string
+	namespace string
+}
+
+/*
+lockKey identifies an advisory lock on an object.
+*/
+type lockKey struct {
+	pool      string
+	namespace string
+	oid       string
+	name      string
+}
+
+/*
+heldLock is an exclusive advisory lock held by the client identified by
+cookie. A zero expiry time means the lock does not expire.
+*/
+type heldLock struct {
+	cookie  string
+	expires time.Time
+}
+
+/*
+Conn is a fake Rados connection keeping all pools and objects in memory. It
+implements the rados.Conn interface and is safe for concurrent use.
+*/
+type Conn struct {
+	pools    map[string]map[string]*object
+	spaces   map[namespaceKey]map[string]*object
+	snaps    map[string][]*snapshot
+	lastSnap ceph.SnapID
+	capacity uint64
+	fsid     string
+	aligns   map[string]uint64
+	locks    map[lockKey]*heldLock
+	methods  map[string]ClassMethod
+	hook     Hook
+	mtx      sync.Mutex
+}
+
+/*
+NewConn creates a new fake connection without any pools.
+*/
+func NewConn() *Conn {
+	return &Conn{
+		pools:   make(map[string]map[string]*object),
+		fsid:    DefaultFSID,
+		spaces:  make(map[namespaceKey]map[string]*object),
+		aligns:  make(map[string]uint64),
+		locks:   make(map[lockKey]*heldLock),
+		snaps:   make(map[string][]*snapshot),
+		methods: make(map[string]ClassMethod),
+	}
+}
+
+/*
+CreatePool creates an empty pool with the given name, unless it exists already.
+*/
+func (c *Conn) CreatePool(name string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.pools[name]; !ok {
+		c.pools[name] = make(map[string]*object)
+	}
+}
+
+/*
+CreateSnapshot records a snapshot called name for the named pool. It fails
+with EEXIST if the pool has a snapshot of that name already.
+*/
+func (c *Conn) CreateSnapshot(pool, name string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.createSnapshot(pool, name)
+}
+
+/*
+createSnapshot implements CreateSnapshot. The caller must hold the lock.
+*/
+func (c *Conn) createSnapshot(pool, name string) error {
+	var snap *snapshot
+
+	if _, ok := c.pools[pool]; !ok {
+		return ceph.ErrNotFound
+	}
+	for _, snap = range c.snaps[pool] {
+		if snap.name == name {
+			return Error(syscall.EEXIST)
+		}
+	}
+
+	c.lastSnap++
+	c.snaps[pool] = append(c.snaps[pool], &snapshot{
+		id:      c.lastSnap,
+		name:    name,
+		created: time.Now(),
+	})
+	return nil
+}
+
+/*
+findSnapshot returns the snapshot of pool with the given ID, or nil. The
+caller must hold the lock.
+*/
+func (c *Conn) findSnapshot(pool string, id ceph.SnapID) *snapshot {
+	var snap *snapshot
+
+	for _, snap = range c.snaps[pool] {
+		if snap.id == id {
+			return snap
+		}
+	}
+	return nil
+}
+
+/*
+RegisterClassMethod makes fn available to Exec as method of the object class
+named class.
+*/
+func (c *Conn) RegisterClassMethod(class, method string, fn ClassMethod) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.methods[class+"."+method] = fn
+}
+
+/*
+SetHook installs a hook invoked before every operation. A nil hook removes it.
+*/
+func (c *Conn) SetHook(hook Hook) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.hook = hook
+}
+
+/*
+callHook invokes the hook, if any, without holding the lock so the hook can
+block.
+*/
+func (c *Conn) callHook(op, pool, oid string) error {
+	var hook Hook
+
+	c.mtx.Lock()
+	hook = c.hook
+	c.mtx.Unlock()
+
+	if hook == nil {
+		return nil
+	}
+	return hook(op, pool, oid)
+}
+
+/*
+OpenIOContext opens a fake I/O context for the named pool, which must exist.
+*/
+func (c *Conn) OpenIOContext(pool string) (rados.IOContext, error) {
+	var err error
+
+	if err = c.callHook("OpenIOContext", pool, ""); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.pools[pool]; !ok {
+		return nil, ceph.ErrNotFound
+	}
+	return &IOContext{conn: c, pool: pool}, nil
+}
+
+/*
+PoolPGCount returns PGCount for all existing pools.
+*/
+func (c *Conn) PoolPGCount(pool string) (uint32, error) {
+	var err error
+
+	if err = c.callHook("PoolPGCount", pool, ""); err != nil {
+		return 0, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.pools[pool]; !ok {
+		return 0, ceph.ErrNotFound
+	}
+	return PGCount, nil
+}
+
+/*
+SetPoolAlignment makes the named pool report that it requires writes aligned
+to alignment bytes, like an erasure-coded pool would. An alignment of 0
+removes the requirement. The alignment is only reported; writes are not
+checked against it.
+*/
+func (c *Conn) SetPoolAlignment(pool string, alignment uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if alignment == 0 {
+		delete(c.aligns, pool)
+	} else {
+		c.aligns[pool] = alignment
+	}
+}
+
+/*
+SetReportedSize makes stat operations on the object oid in the default
+namespace of pool report size rather than the actual length of its data, e.g.
+to simulate objects too large to be held in memory. The contents of the
+object are not changed. A size of 0 reverts to reporting the actual length.
+Fails with go-ceph's ErrNotFound if there is no such object.
+*/
+func (c *Conn) SetReportedSize(pool, oid string, size uint64) error {
+	var obj *object
+	var err error
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if obj, err = lookup(c.pools[pool], oid); err != nil {
+		return err
+	}
+	obj.reportedSize = size
+	return nil
+}
+
+/*
+DefaultFSID is the cluster ID reported by fake connections unless changed
+using SetFSID.
+*/
+const DefaultFSID = "00000000-0000-0000-0000-000000000000"
+
+/*
+SetFSID sets the cluster ID reported by GetFSID.
+*/
+func (c *Conn) SetFSID(fsid string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.fsid = fsid
+}
+
+/*
+GetFSID returns the cluster ID set using SetFSID, or DefaultFSID.
+*/
+func (c *Conn) GetFSID() (string, error) {
+	var err error
+
+	if err = c.callHook("GetFSID", "", ""); err != nil {
+		return "", err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.fsid, nil
+}
+
+/*
+SetCapacity sets the total capacity of the fake cluster in bytes, as reported
+by GetClusterStats. A capacity of 0, the default, reports no capacity at all.
+*/
+func (c *Conn) SetCapacity(capacity uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.capacity = capacity
+}
+
+/*
+GetClusterStats reports the capacity set with SetCapacity, and the combined
+size of all objects in all pools as used.
+*/
+func (c *Conn) GetClusterStats() (ceph.ClusterStat, error) {
+	var objects map[string]*object
+	var obj *object
+	var stats ceph.ClusterStat
+	var used uint64
+	var count uint64
+	var err error
+
+	if err = c.callHook("GetClusterStats", "", ""); err != nil {
+		return ceph.ClusterStat{}, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, objects = range c.pools {
+		for _, obj = range objects {
+			used += uint64(len(obj.data))
+			count++
+		}
+	}
+	for _, objects = range c.spaces {
+		for _, obj = range objects {
+			used += uint64(len(obj.data))
+			count++
+		}
+	}
+
+	stats = ceph.ClusterStat{
+		Kb:          c.capacity / 1024,
+		Kb_used:     used / 1024,
+		Num_objects: count,
+	}
+	if stats.Kb > stats.Kb_used {
+		stats.Kb_avail = stats.Kb - stats.Kb_used
+	}
+	return stats, nil
+}
+
+/*
+Shutdown is a no-op; the fake connection keeps working so that several
+filesystems may share it.
+*/
+func (c *Conn) Shutdown() {
+}
+
+/*
+ListPools returns the names of all pools, in lexical order.
+*/
+func (c *Conn) ListPools() ([]string, error) {
+	var pools []string
+	var pool string
+	var err error
+
+	if err = c.callHook("ListPools", "", ""); err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for pool = range c.pools {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+	return pools, nil
+}
+
+/*
+IOContext is a fake I/O context for a single pool of a fake connection.
+*/
+type IOContext struct {
+	conn        *Conn
+	pool        string
+	namespace   string
+	lastVersion uint64
+}
+
+/*
+begin invokes the hook for the operation and locks the connection. It returns
+the objects of the namespace of the pool the I/O context is set to, or an
+error if the pool has been removed.
+*/
+func (i *IOContext) begin(op, oid string) (map[string]*object, error) {
+	var objects map[string]*object
+	var key namespaceKey
+	var ok bool
+	var err error
+
+	if err = i.conn.callHook(op, i.pool, oid); err != nil {
+		return nil, err
+	}
+
+	i.conn.mtx.Lock()
+	if objects, ok = i.conn.pools[i.pool]; !ok {
+		i.conn.mtx.Unlock()
+		return nil, ceph.ErrNotFound
+	}
+	if i.namespace != "" {
+		key = namespaceKey{pool: i.pool, namespace: i.namespace}
+		if objects, ok = i.conn.spaces[key]; !ok {
+			objects = make(map[string]*object)
+			i.conn.spaces[key] = objects
+		}
+	}
+	return objects, nil
+}
+
+/*
+RequiresAlignment reports whether an alignment has been set for the pool
+using SetPoolAlignment.
+*/
+func (i *IOContext) RequiresAlignment() (bool, error) {
+	var err error
+
+	if _, err = i.begin("RequiresAlignment", ""); err != nil {
+		return false, err
+	}
+	defer i.end()
+
+	return i.conn.aligns[i.pool] > 0, nil
+}
+
+/*
+Alignment returns the alignment set for the pool using SetPoolAlignment, or 0.
+*/
+func (i *IOContext) Alignment() (uint64, error) {
+	var err error
+
+	if _, err = i.begin("Alignment", ""); err != nil {
+		return 0, err
+	}
+	defer i.end()
+
+	return i.conn.aligns[i.pool], nil
+}
+
+/*
+LockExclusive takes the exclusive advisory lock name on the object, creating
+the object if necessary. Like go-ceph, it returns -EBUSY if another cookie
+holds the lock and -EEXIST if cookie holds it already. Locks expire after
+duration unless it is 0.
+*/
+func (i *IOContext) LockExclusive(oid, name, cookie, desc string,
+	duration time.Duration, flags *byte) (int, error) {
+	var objects map[string]*object
+	var key = lockKey{pool: i.pool, namespace: i.namespace, oid: oid,
+		name: name}
+	var held *heldLock
+	var ok bool
+	var err error
+
+	if objects, err = i.begin("LockExclusive", oid); err != nil {
+		return 0, err
+	}
+	defer i.end()
+
+	create(objects, oid)
+
+	if held, ok = i.conn.locks[key]; ok &&
+		(held.expires.IsZero() || time.Now().Before(held.expires)) {
+		if held.cookie == cookie {
+			return -int(syscall.EEXIST), nil
+		}
+		return -int(syscall.EBUSY), nil
+	}
+
+	held = &heldLock{cookie: cookie}
+	if duration > 0 {
+		held.expires = time.Now().Add(duration)
+	}
+	i.conn.locks[key] = held
+	return 0, nil
+}
+
+/*
+Unlock releases the advisory lock name on the object if cookie holds it, and
+returns -ENOENT otherwise, like go-ceph.
+*/
+func (i *IOContext) Unlock(oid, name, cookie string) (int, error) {
+	var key = lockKey{pool: i.pool, namespace: i.namespace, oid: oid,
+		name: name}
+	var held *heldLock
+	var ok bool
+	var err error
+
+	if _, err = i.begin("Unlock", oid); err != nil {
+		return 0, err
+	}
+	defer i.end()
+
+	if held, ok = i.conn.locks[key]; !ok || held.cookie != cookie {
+		return -int(syscall.ENOENT), nil
+	}
+	delete(i.conn.locks, key)
+	return 0, nil
+}
+
+/*
+SetNamespace makes all further operations on the I/O context refer to the
+objects of the named namespace. The empty string selects the default
+namespace.
+*/
+func (i *IOContext) SetNamespace(namespace string) {
+	i.namespace = namespace
+}
+
+/*
+end unlocks the connection again.
+*/
+func (i *IOContext) end() {
+	i.conn.mtx.Unlock()
+}
+
+/*
+lookup finds an existing object, or returns ErrNotFound.
+*/
+func lookup(objects map[string]*object, oid string) (*object, error) {
+	var obj *object
+	var ok bool
+
+	if obj, ok = objects[oid]; !ok {
+		return nil, ceph.ErrNotFound
+	}
+	return obj, nil
+}
+
+/*
+create finds an object, creating it if it doesn't exist yet.
+*/
+func create(objects map[string]*object, oid string) *object {
+	var obj *object
+	var ok bool
+
+	if obj, ok = objects[oid]; !ok {
+		obj = &object{xattrs: make(map[string][]byte)}
+		objects[oid] = obj
+	}
+	return obj
+}
+
+/*
+writeAt places data at offset in obj, growing it with zeroes as necessary.
+*/
+func writeAt(obj *object, data []byte, offset uint64) {
+	var end = offset + uint64(len(data))
+
+	if end > uint64(len(obj.data)) {
+		obj.data = append(obj.data, make([]byte, end-uint64(len(obj.data)))...)
+	}
+	copy(obj.data[offset:], data)
+}
+
+/*
+GetPoolName returns the name of the pool the context refers to.
+*/
+func (i *IOContext) GetPoolName() (string, error) {
+	var err error
+
+	if err = i.conn.callHook("GetPoolName", i.pool, ""); err != nil {
+		return "", err
+	}
+	return i.pool, nil
+}
+
+/*
+Read copies data from the object starting at offset.
+*/
+func (i *IOContext) Read(oid string, data []byte, offset uint64) (int, error) {
+	var objects map[string]*object
+	var obj *object
+	var err error
+
+	if objects, err = i.begin("Read", oid); err != nil {
+		return 0, err
+	}
+	defer i.end()
+
+	if obj, err = lookup(objects, oid); err != nil {
+		return 0, err
+	}
+
+	i.lastVersion = obj.version
+	if offset >= uint64(len(obj.data)) {
+		return 0, nil
+	}
+	return copy(data, obj.data[offset:]), nil
+}
+
+/*
+Write places data into the object at offset, creating the object if needed.
+*/
+func (i *IOContext) Write(oid string, data []byte, offset uint64) error {
+	var objects map[string]*object
+	var obj *object
+	var err error
+
+	if objects, err = i.begin("Write", oid); err != nil {
+		return err
+	}
+	defer i.end()
+
+	obj = create(objects, oid)
+	writeAt(obj, data, offset)
+	obj.touch()
+	i.lastVersion = obj.version
+	return nil
+}
+
+/*
+WriteFull replaces the contents of the object, creating it if needed.
+*/
+func (i *IOContext) WriteFull(oid string, data []byte) error {
+	var objects map[string]*object
+	var obj *object
+	var err error
+
+	if objects, err = i.begin("WriteFull", oid); err != nil {
+		return err
+	}
+	defer i.end()
+
+	obj = create(objects, oid)
+	obj.data = append([]byte(nil), data...)
+	obj.touch()
+	i.lastVersion = obj.version
+	return nil
+}
+
+/*
+Append adds data to the end of the object, creating it if needed.
+*/
+func (i *IOContext) Append(oid string, data []byte) error {
+	var objects map[string]*object
+	var obj *object
+	var err error
+
+	if objects, err = i.begin("Append", oid); err != nil {
+		return err
+	}
+	defer i.end()
+
+	obj = create(objects, oid)
+	obj.data = append(obj.data, data...)
+	obj.touch()
+	i.lastVersion = obj.version
+	return nil
+}
+
+/*
+Truncate resizes an existing object to size bytes.
+*/
+func (i *IOContext) Truncate(oid string, size uint64) error {
+	var objects map[string]*object
+	var obj *object
+	var err error
+
+	if objects, err = i.begin("Truncate", oid); err != nil {
+		return err
+	}
+	defer i.end()
+
+	if obj, err = lookup(objects, oid); err != nil {
+		return err
+	}
+
+	if size < uint64(len(obj.data)) {
+		obj.data = obj.data[:size]
+	} else {
+		writeAt(obj, nil, size)
+	}
+	obj.touch()
+	i.lastVersion = obj.version
+	return nil
+}
+
+/*
+Stat returns the size and modification time of the object.
+*/
+func (i *IOContext) Stat(oid string) (ceph.ObjectStat, error) {
+	var objects map[string]*object
+	var obj *object
+	var err error
+
+	if objects, err = i.begin("Stat", oid); err != nil {
+		return ceph.ObjectStat{}, err
+	}
+	defer i.end()
+
+	if obj, err = lookup(objects, oid); err != nil {
+		return ceph.ObjectStat{}, err
+	}
+
+	i.lastVersion = obj.version
+	return ceph.ObjectStat{
+		Size:    obj.size(),
+		ModTime: obj.modTime,
+	}, nil
+}
+
+/*
+Delete removes the object.
+*/
+func (i *IOContext) Delete(oid string) error {
+	var objects map[string]*object
+	var err error
+
+	if objects, err = i.begin("Delete", oid); err != nil {
+		return err
+	}
+	defer i.end()
+
+	if _, err = lookup(objects, oid); err != nil {
+		return err
+	}
+
+	delete(objects, oid)
+	return nil
+}
+
+/*
+Iter returns an iterator over the object IDs of the pool at the time of the
+call, ordered by placement group and lexically within each placement group.
+*/
+func (i *IOContext) Iter() (rados.Iter, error) {
+	var objects map[string]*object
+	var oids []string
+	var oid string
+	var err error
+
+	if objects, err = i.begin("Iter", ""); err != nil {
+		return nil, err
+	}
+	defer i.end()
+
+	for oid = range objects {
+		oids = append(oids, oid)
+	}
+	sort.Slice(oids, func(a, b int) bool {
+		if pgOf(oids[a]) != pgOf(oids[b]) {
+			return pgOf(oids[a]) < pgOf(oids[b])
+		}
+		return oids[a] < oids[b]
+	})
+
+	return &iter{oids: oids, pos: -1}, nil
+}
+
+/*
+GetXattr copies the value of the extended attribute name into data.
+*/
+func (i *IOContext) GetXattr(oid, name string, data []byte) (int, error) {
+	var objects map[string]*object
+	var obj *object
+	var value []byte
+	var ok bool
+	var err error
+
+	if objects, err = i.begin("GetXattr", oid); err != nil {
+		return 0, err
+	}
+	defer i.end()
+
+	if obj, err = lookup(objects, oid); err != nil {
+		return 0, err
+	}
+	if value, ok = obj.xattrs[name]; !ok {
+		return 0, Error(syscall.ENODATA)
+	}
+	if len(value) > len(data) {
+		return 0, Error(syscall.ERANGE)
+	}
+	return copy(data, value), nil
+}
+
+/*
+SetXattr sets the extended attribute name to data, creating the object if
+needed.
+*/
+func (i *IOContext) SetXattr(oid, name string, data []byte) error {
+	var objects map[string]*object
+	var obj *object
+	var err error
+
+	if objects, err = i.begin("SetXattr", oid); err != nil {
+		return err
+	}
+	defer i.end()
+
+	obj = create(objects, oid)
+	obj.xattrs[name] = append([]byte(nil), data...)
+	obj.touch()
+	i.lastVersion = obj.version
+	return nil
+}
+
+/*
+RmXattr removes the extended attribute name from the object.
+*/
+func (i *IOContext) RmXattr(oid, name string) error {
+	var objects map[string]*object
+	var obj *object
+	var ok bool
+	var err error
+
+	if objects, err = i.begin("RmXattr", oid); err != nil {
+		return err
+	}
+	defer i.end()
+
+	if obj, err = lookup(objects, oid); err != nil {
+		return err
+	}
+	if _, ok = obj.xattrs[name]; !ok {
+		return Error(syscall.ENODATA)
+	}
+	delete(obj.xattrs, name)
+	obj.touch()
+	i.lastVersion = obj.version
+	return nil
+}
+
+/*
+Destroy is a no-op since fake I/O contexts hold no resources.
+*/
+func (i *IOContext) Destroy() {
+}
+
+/*
+ListXattrs returns copies of all extended attributes of the object.
+*/
+func (i *IOContext) ListXattrs(oid string) (map[string][]byte, error) {
+	var objects map[string]*object
+	var obj *object
+	var ret = make(map[string][]byte)
+	var name string
+	var value []byte
+	var err error
+
+	if objects, err = i.begin("ListXattrs", oid); err != nil {
+		return nil, err
+	}
+	defer i.end()
+
+	if obj, err = lookup(objects, oid); err != nil {
+		return nil, err
+	}
+
+	for name, value = range obj.xattrs {
+		ret[name] = append([]byte(nil), value...)
+	}
+	i.lastVersion = obj.version
+	return ret, nil
+}
+
+/*
+GetAllOmapValues returns copies of the omap entries of the object whose keys
+sort after startAfter and start with filterPrefix. iteratorSize is ignored
+since all entries are returned at once.
+*/
+func (i *IOContext) GetAllOmapValues(oid, startAfter, filterPrefix string,
+	iteratorSize int64) (map[string][]byte, error) {
+	var objects map[string]*object
+	var obj *object
+	var ret = make(map[string][]byte)
+	var key string
+	var value []byte
+	var err error
+
+	if objects, err = i.begin("GetAllOmapValues", oid); err != nil {
+		return nil, err
+	}
+	defer i.end()
+
+	if obj, err = lookup(objects, oid); err != nil {
+		return nil, err
+	}
+
+	for key, value = range obj.omap {
+		if key <= startAfter || !strings.HasPrefix(key, filterPrefix) {
+			continue
+		}
+		ret[key] = append([]byte(nil), value...)
+	}
+	return ret, nil
+}
+
+/*
+CreateSnap records a snapshot of the pool called name, see CreateSnapshot.
+*/
+func (i *IOContext) CreateSnap(name string) error {
+	var err error
+
+	if _, err = i.begin("CreateSnap", ""); err != nil {
+		return err
+	}
+	defer i.end()
+
+	return i.conn.createSnapshot(i.pool, name)
+}
+
+/*
+RemoveSnap removes the snapshot of the pool called name.
+*/
+func (i *IOContext) RemoveSnap(name string) error {
+	var snaps []*snapshot
+	var idx int
+	var err error
+
+	if _, err = i.begin("RemoveSnap", ""); err != nil {
+		return err
+	}
+	defer i.end()
+
+	snaps = i.conn.snaps[i.pool]
+	for idx = range snaps {
+		if snaps[idx].name == name {
+			i.conn.snaps[i.pool] = append(snaps[:idx:idx], snaps[idx+1:]...)
+			return nil
+		}
+	}
+	return ceph.ErrNotFound
+}
+
+/*
+ListSnaps returns the IDs of all snapshots of the pool, in creation order.
+*/
+func (i *IOContext) ListSnaps() ([]ceph.SnapID, error) {
+	var ret []ceph.SnapID
+	var snap *snapshot
+	var err error
+
+	if _, err = i.begin("ListSnaps", ""); err != nil {
+		return nil, err
+	}
+	defer i.end()
+
+	for _, snap = range i.conn.snaps[i.pool] {
+		ret = append(ret, snap.id)
+	}
+	return ret, nil
+}
+
+/*
+LookupSnap returns the ID of the snapshot called name.
+*/
+func (i *IOContext) LookupSnap(name string) (ceph.SnapID, error) {
+	var snap *snapshot
+	var err error
+
+	if _, err = i.begin("LookupSnap", ""); err != nil {
+		return 0, err
+	}
+	defer i.end()
+
+	for _, snap = range i.conn.snaps[i.pool] {
+		if snap.name == name {
+			return snap.id, nil
+		}
+	}
+	return 0, ceph.ErrNotFound
+}
+
+/*
+GetSnapName returns the name of the snapshot with the given ID.
+*/
+func (i *IOContext) GetSnapName(id ceph.SnapID) (string, error) {
+	var snap *snapshot
+	var err error
+
+	if _, err = i.begin("GetSnapName", ""); err != nil {
+		return "", err
+	}
+	defer i.end()
+
+	if snap = i.conn.findSnapshot(i.pool, id); snap == nil {
+		return "", ceph.ErrNotFound
+	}
+	return snap.name, nil
+}
+
+/*
+GetSnapStamp returns the creation time of the snapshot with the given ID.
+*/
+func (i *IOContext) GetSnapStamp(id ceph.SnapID) (time.Time, error) {
+	var snap *snapshot
+	var err error
+
+	if _, err = i.begin("GetSnapStamp", ""); err != nil {
+		return time.Time{}, err
+	}
+	defer i.end()
+
+	if snap = i.conn.findSnapshot(i.pool, id); snap == nil {
+		return time.Time{}, ceph.ErrNotFound
+	}
+	return snap.created, nil
+}
+
+/*
+GetLastVersion returns the version of the object most recently accessed
+through this context.
+*/
+func (i *IOContext) GetLastVersion() (uint64, error) {
+	i.conn.mtx.Lock()
+	defer i.conn.mtx.Unlock()
+
+	return i.lastVersion, nil
+}
+
+/*
+Exec invokes a method registered using RegisterClassMethod on the object. The
+object is created if the method returns new contents for it.
+*/
+func (i *IOContext) Exec(oid, class, method string, in []byte) (
+	[]byte, error) {
+	var objects map[string]*object
+	var fn ClassMethod
+	var obj *object
+	var ok bool
+	var data, out, newData []byte
+	var err error
+
+	if objects, err = i.begin("Exec", oid); err != nil {
+		return nil, err
+	}
+	defer i.end()
+
+	if fn, ok = i.conn.methods[class+"."+method]; !ok {
+		return nil, Error(syscall.EOPNOTSUPP)
+	}
+	if obj, ok = objects[oid]; ok {
+		data = append([]byte(nil), obj.data...)
+	}
+
+	if out, newData, err = fn(data, in); err != nil {
+		return nil, err
+	}
+	if newData != nil {
+		obj = create(objects, oid)
+		obj.data = append([]byte(nil), newData...)
+		obj.touch()
+		i.lastVersion = obj.version
+	}
+	return out, nil
+}
+
+/*
+OperateWrite applies all steps of op to a copy of the object and only stores
+the result if all of them succeed.
+*/
+func (i *IOContext) OperateWrite(oid string, op *rados.WriteOp) error {
+	var objects map[string]*object
+	var obj *object
+	var existing *object
+	var exists bool
+	var existed bool
+	var step rados.WriteStep
+	var k string
+	var v []byte
+	var end uint64
+	var ok bool
+	var err error
+
+	if objects, err = i.begin("OperateWrite", oid); err != nil {
+		return err
+	}
+	defer i.end()
+
+	if existing, exists = objects[oid]; exists {
+		obj = existing.clone()
+	} else {
+		obj = &object{xattrs: make(map[string][]byte)}
+	}
+
+	for _, step = range op.Steps() {
+		/*
+		   All steps except for assertions and removal implicitly create the
+		   object if it does not exist yet.
+		*/
+		existed = exists
+		if step.Kind != rados.WriteStepAssertVersion &&
+			step.Kind != rados.WriteStepAssertExists &&
+			step.Kind != rados.WriteStepRemove {
+			if !exists {
+				obj = &object{
+					version: obj.version,
+					xattrs:  make(map[string][]byte),
+				}
+			}
+			exists = true
+		}
+
+		switch step.Kind {
+		case rados.WriteStepAssertExists:
+			if !exists {
+				return ceph.ErrNotFound
+			}
+		case rados.WriteStepAssertVersion:
+			if !exists {
+				return ceph.ErrNotFound
+			}
+			if obj.version < step.Version {
+				return Error(syscall.ERANGE)
+			} else if obj.version > step.Version {
+				return Error(syscall.EOVERFLOW)
+			}
+		case rados.WriteStepWriteFull:
+			obj.data = append([]byte(nil), step.Data...)
+		case rados.WriteStepSetXattr:
+			obj.xattrs[step.Name] = append([]byte(nil), step.Data...)
+		case rados.WriteStepCreate:
+			if step.Exclusive && existed {
+				return ceph.ErrObjectExists
+			}
+		case rados.WriteStepWrite:
+			writeAt(obj, step.Data, step.Offset)
+		case rados.WriteStepAppend:
+			writeAt(obj, step.Data, uint64(len(obj.data)))
+		case rados.WriteStepTruncate:
+			if step.Offset < uint64(len(obj.data)) {
+				obj.data = obj.data[:step.Offset]
+			} else {
+				writeAt(obj, nil, step.Offset)
+			}
+		case rados.WriteStepZero:
+			if step.Offset < uint64(len(obj.data)) {
+				end = step.Offset + step.Length
+				if end > uint64(len(obj.data)) {
+					end = uint64(len(obj.data))
+				}
+				copy(obj.data[step.Offset:end], make([]byte, end-step.Offset))
+			}
+		case rados.WriteStepRemove:
+			if !exists {
+				return ceph.ErrNotFound
+			}
+			exists = false
+		case rados.WriteStepRmXattr:
+			if _, ok = obj.xattrs[step.Name]; !ok {
+				return Error(syscall.ENODATA)
+			}
+			delete(obj.xattrs, step.Name)
+		case rados.WriteStepSetOmap:
+			if obj.omap == nil {
+				obj.omap = make(map[string][]byte)
+			}
+			for k, v = range step.Pairs {
+				obj.omap[k] = append([]byte(nil), v...)
+			}
+		case rados.WriteStepRmOmapKeys:
+			for _, k = range step.Keys {
+				delete(obj.omap, k)
+			}
+		default:
+			return Error(syscall.EOPNOTSUPP)
+		}
+	}
+
+	if !exists {
+		delete(objects, oid)
+		return nil
+	}
+
+	obj.touch()
+	objects[oid] = obj
+	i.lastVersion = obj.version
+	return nil
+}
+
+/*
+OperateRead executes all steps of op against the object.
+*/
+func (i *IOContext) OperateRead(oid string, op *rados.ReadOp) error {
+	var objects map[string]*object
+	var obj *object
+	var step *rados.ReadStep
+	var value []byte
+	var end uint64
+	var ok bool
+	var err error
+
+	if objects, err = i.begin("OperateRead", oid); err != nil {
+		return err
+	}
+	defer i.end()
+
+	if obj, err = lookup(objects, oid); err != nil {
+		return err
+	}
+
+	for _, step = range op.Steps() {
+		switch step.Kind {
+		case rados.ReadStepRead:
+			step.Data = []byte{}
+			if step.Offset < uint64(len(obj.data)) {
+				end = step.Offset + step.Length
+				if end > uint64(len(obj.data)) {
+					end = uint64(len(obj.data))
+				}
+				step.Data = append(step.Data, obj.data[step.Offset:end]...)
+			}
+		case rados.ReadStepGetXattr:
+			if value, ok = obj.xattrs[step.Name]; !ok {
+				return Error(syscall.ENODATA)
+			}
+			step.Data = append([]byte(nil), value...)
+		case rados.ReadStepStat:
+			step.Size = obj.size()
+			step.ModTime = obj.modTime
+		case rados.ReadStepAssertVersion:
+			if obj.version < step.Version {
+				return Error(syscall.ERANGE)
+			} else if obj.version > step.Version {
+				return Error(syscall.EOVERFLOW)
+			}
+		default:
+			return Error(syscall.EOPNOTSUPP)
+		}
+	}
+
+	i.lastVersion = obj.version
+	return nil
+}
+
+/*
+iter iterates over a snapshot of object IDs.
+*/
+type iter struct {
+	oids []string
+	pos  int
+}
+
+/*
+Next advances to the next object ID, returning false at the end.
+*/
+func (it *iter) Next() bool {
+	it.pos++
+	return it.pos < len(it.oids)
+}
+
+/*
+Value returns the current object ID.
+*/
+func (it *iter) Value() string {
+	return it.oids[it.pos]
+}
+
+/*
+Err always returns nil since iterating over memory cannot fail.
+*/
+func (it *iter) Err() error {
+	return nil
+}
+
+/*
+Close is a no-op.
+*/
+func (it *iter) Close() {
+}
+
+/*
+Seek positions the iterator so that the next call to Next() advances to the
+first object in placement group token or later.
+*/
+func (it *iter) Seek(token ceph.IterToken) {
+	it.pos = sort.Search(len(it.oids), func(i int) bool {
+		return pgOf(it.oids[i]) >= token
+	}) - 1
+}
+
+/*
+Token returns the placement group of the current object, or PGCount once the
+iterator is exhausted.
+*/
+func (it *iter) Token() ceph.IterToken {
+	if it.pos < 0 {
+		return 0
+	}
+	if it.pos >= len(it.oids) {
+		return PGCount
+	}
+	return pgOf(it.oids[it.pos])
+}
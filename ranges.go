@@ -0,0 +1,212 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+Range describes a contiguous section of a Rados object, starting at Offset and
+spanning Length bytes.
+*/
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+/*
+ReadRanges reads several, possibly non-contiguous, sections of the Rados object
+named u.Path in the pool pointed at by u.Host in a single batched operation.
+The contents of each range are returned in the order the ranges were given.
+Ranges which extend past the end of the object are cut short; ranges starting
+past the end of the object yield empty slices. Compressed objects are refused
+with an error wrapping ErrUnsupported.
+*/
+func (r *RadosFileSystem) ReadRanges(
+	ctx context.Context, u *url.URL, ranges []Range) ([][]byte, error) {
+	var entry *contextEntry
+	var op ReadOp
+	var steps = make([]*ReadStep, len(ranges))
+	var ret = make([][]byte, len(ranges))
+	var start = time.Now()
+	var total int64
+	var i int
+	var rng Range
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if err = checkUncompressed("read", entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	for i, rng = range ranges {
+		if rng.Offset < 0 || rng.Length < 0 {
+			return nil, os.ErrInvalid
+		}
+		steps[i] = op.Read(uint64(rng.Offset), uint64(rng.Length))
+	}
+
+	if err = r.slots.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer r.slots.release()
+
+	if err = runWithContext(ctx, func() error {
+		return entry.ioctx.OperateRead(u.Path, &op)
+	}); err != nil {
+		radosReadErrors.With(entry.labels()).Inc()
+		return nil, objectError("read", entry.poolName, u.Path, err)
+	}
+
+	for i = range steps {
+		ret[i] = steps[i].Data
+		total += int64(len(steps[i].Data))
+	}
+
+	radosReadLatencies.With(entry.labels()).Observe(
+		time.Now().Sub(start).Seconds())
+	radosReadBytes.With(entry.labels()).Add(float64(total))
+
+	return ret, nil
+}
+
+/*
+readvConcurrency is the number of reads Readv() keeps in flight at a time.
+*/
+const readvConcurrency = 16
+
+/*
+Readv reads several, possibly scattered, sections of the Rados object named
+u.Path in the pool pointed at by u.Host, issuing up to readvConcurrency reads
+concurrently. Unlike ReadRanges, the reads are independent, so they are not
+guaranteed to observe the same version of the object, but large numbers of
+ranges are served with less latency. The contents of each range are returned
+in the order the ranges were given; ranges are cut short at the end of the
+object. If any read fails, the remaining ones are abandoned and the first
+error is returned. Compressed objects are refused as with ReadRanges.
+*/
+func (r *RadosFileSystem) Readv(
+	ctx context.Context, u *url.URL, ranges []Range) ([][]byte, error) {
+	var entry *contextEntry
+	var ret = make([][]byte, len(ranges))
+	var sem = make(chan struct{}, readvConcurrency)
+	var start = time.Now()
+	var cancel context.CancelFunc
+	var wg sync.WaitGroup
+	var errMtx sync.Mutex
+	var firstErr error
+	var total int64
+	var i int
+	var rng Range
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if err = checkUncompressed("read", entry, u.Path); err != nil {
+		return nil, err
+	}
+	for _, rng = range ranges {
+		if rng.Offset < 0 || rng.Length < 0 {
+			return nil, os.ErrInvalid
+		}
+	}
+
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	for i, rng = range ranges {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, rng Range) {
+			var err error
+
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if ret[i], err = r.readRange(ctx, entry, u.Path, rng); err != nil {
+				errMtx.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMtx.Unlock()
+				cancel()
+			}
+		}(i, rng)
+	}
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		radosReadErrors.With(entry.labels()).Inc()
+		return nil, firstErr
+	}
+
+	for i = range ret {
+		total += int64(len(ret[i]))
+	}
+	radosReadLatencies.With(entry.labels()).Observe(
+		time.Now().Sub(start).Seconds())
+	radosReadBytes.With(entry.labels()).Add(float64(total))
+
+	return ret, nil
+}
+
+/*
+readRange reads a single range of oid for Readv(), stopping short at the end
+of the object. The range is read in steps of at most copyChunkSize bytes, so
+that the buffer only grows as far as the object actually extends rather than
+to the requested length.
+*/
+func (r *RadosFileSystem) readRange(ctx context.Context, entry *contextEntry,
+	oid string, rng Range) ([]byte, error) {
+	var buf []byte
+	var step int64
+	var start int
+	var n int
+	var err error
+
+	if err = r.slots.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer r.slots.release()
+
+	for int64(len(buf)) < rng.Length {
+		step = rng.Length - int64(len(buf))
+		if step > copyChunkSize {
+			step = copyChunkSize
+		}
+		start = len(buf)
+		buf = append(buf, make([]byte, step)...)
+
+		if err = r.opRetry.do(ctx, entry.cluster, entry.poolName, "read",
+			func() error {
+				var err error
+				n, err = entry.ioctx.Read(
+					oid, buf[start:], uint64(rng.Offset+int64(start)))
+				return err
+			}); err != nil {
+			return nil, objectError("read", entry.poolName, oid, err)
+		}
+		buf = buf[:start+n]
+		if n == 0 {
+			break
+		}
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,108 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestReadvHugeRangeStopsAtEnd(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var data [][]byte
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("0123456789"))
+	if data, err = fs.Readv(ctx, testURL("/object"), []rados.Range{
+		{Offset: 2, Length: 1 << 40},
+		{Offset: 20, Length: 1 << 40},
+	}); err != nil {
+		t.Fatalf("Readv() -> %v", err)
+	}
+	if string(data[0]) != "23456789" || len(data[1]) != 0 {
+		t.Errorf("Readv() = %q, want [\"23456789\" \"\"]", data)
+	}
+}
+
+func TestSparseReadHugeLength(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var extents []rados.Extent
+	var data []byte
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("data"))
+	if extents, data, err = fs.SparseRead(ctx, testURL("/object"), 0,
+		1<<40); err != nil {
+		t.Fatalf("SparseRead() -> %v", err)
+	}
+	if len(extents) != 1 || extents[0].Length != 4 || string(data) != "data" {
+		t.Errorf("SparseRead() = %v, %q, want one extent of \"data\"",
+			extents, data)
+	}
+}
+
+func TestReadRangesHugeRangeAndErrors(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var data [][]byte
+	var objErr *rados.ObjectError
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("0123456789"))
+	if data, err = fs.ReadRanges(ctx, testURL("/object"), []rados.Range{
+		{Offset: 8, Length: 1 << 40},
+	}); err != nil {
+		t.Fatalf("ReadRanges() -> %v", err)
+	}
+	if string(data[0]) != "89" {
+		t.Errorf("ReadRanges() = %q, want [\"89\"]", data)
+	}
+
+	if _, err = fs.ReadRanges(ctx, testURL("/missing"), []rados.Range{
+		{Length: 1},
+	}); !errors.As(err, &objErr) || !errors.Is(err, rados.ErrObjectNotFound) {
+		t.Errorf("ReadRanges(/missing) -> %v, want an ObjectError wrapping "+
+			"ErrObjectNotFound", err)
+	}
+}
+
+func TestReadRangesMatchesIndividualReads(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var contents = []byte("The quick brown fox jumps over the lazy dog")
+	var ranges = []rados.Range{
+		{Offset: 4, Length: 5},
+		{Offset: 40, Length: 3},
+		{Offset: 0, Length: 3},
+		{Offset: 10, Length: 0},
+		{Offset: 16, Length: 10},
+	}
+	var data [][]byte
+	var single [][]byte
+	var i int
+	var err error
+
+	mustWrite(t, fs, "/object", contents)
+	if data, err = fs.ReadRanges(ctx, testURL("/object"), ranges); err != nil {
+		t.Fatalf("ReadRanges() -> %v", err)
+	}
+	if len(data) != len(ranges) {
+		t.Fatalf("ReadRanges() returned %d ranges, want %d", len(data),
+			len(ranges))
+	}
+	for i = range ranges {
+		if single, err = fs.ReadRanges(ctx, testURL("/object"),
+			ranges[i:i+1]); err != nil {
+			t.Fatalf("ReadRanges(%v) -> %v", ranges[i], err)
+		}
+		if !bytes.Equal(data[i], single[0]) || !bytes.Equal(data[i],
+			contents[ranges[i].Offset:ranges[i].Offset+ranges[i].Length]) {
+			t.Errorf("Range %v = %q, read alone %q", ranges[i], data[i],
+				single[0])
+		}
+	}
+}
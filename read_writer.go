@@ -2,6 +2,7 @@ package rados
 
 import (
 	"context"
+	"errors"
 	"github.com/ceph/go-ceph/rados"
 	"github.com/prometheus/client_golang/prometheus"
 	"io"
@@ -14,33 +15,33 @@ var radosReadLatencies = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Name:      "read_latency",
 	Help:      "Latency of Rados Read requests",
 	Buckets:   prometheus.ExponentialBuckets(0.001, 5, 20),
-}, []string{"pool"})
+}, []string{"cluster", "pool"})
 var radosWriteLatencies = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Subsystem: "rados",
 	Name:      "write_latency",
 	Help:      "Latency of Rados Write requests",
 	Buckets:   prometheus.ExponentialBuckets(0.001, 5, 20),
-}, []string{"pool"})
+}, []string{"cluster", "pool"})
 var radosReadErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Subsystem: "rados",
 	Name:      "read_errors",
 	Help:      "Number of errors received when reading from Rados files",
-}, []string{"pool"})
+}, []string{"cluster", "pool"})
 var radosWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Subsystem: "rados",
 	Name:      "write_errors",
 	Help:      "Number of errors received when writing to Rados files",
-}, []string{"pool"})
+}, []string{"cluster", "pool"})
 var radosReadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Subsystem: "rados",
 	Name:      "read_bytes",
 	Help:      "Number of bytes received when reading from Rados files",
-}, []string{"pool"})
+}, []string{"cluster", "pool"})
 var radosWriteBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Subsystem: "rados",
 	Name:      "write_bytes",
 	Help:      "Number of bytes sent when writing to Rados files",
-}, []string{"pool"})
+}, []string{"cluster", "pool"})
 
 func init() {
 	prometheus.MustRegister(radosReadLatencies)
@@ -57,10 +58,34 @@ A virtual position within the object is maintained by this class to provide
 a regular filesystem API.
 */
 type ReadWriteCloser struct {
-	rctx *rados.IOContext
-	pool string
-	oid  string
-	pos  int64
+	rctx    IOContext
+	cluster string
+	pool    string
+	oid     string
+	pos     int64
+	limit   int64
+	version uint64
+	sparse  bool
+	retry   RetryPolicy
+	slots   *opLimiter
+	space   *spaceGuard
+	closed  bool
+
+	/*
+		seal marks the object immutable when the ReadWriteCloser is closed.
+	*/
+	seal bool
+
+	/*
+		readOnly rejects all writes with ErrReadOnly.
+	*/
+	readOnly bool
+
+	/*
+		trackVersion makes Read() record the object version, see
+		SetTrackVersion().
+	*/
+	trackVersion bool
 }
 
 /*
@@ -80,74 +105,242 @@ func NewReadWriteCloser(rctx *rados.IOContext, oid string) *ReadWriteCloser {
 	*/
 	pool, _ = rctx.GetPoolName()
 
+	return newReadWriteCloser(&contextEntry{
+		ioctx:    radosIOContext{IOContext: rctx},
+		poolName: pool,
+	}, oid)
+}
+
+/*
+newReadWriteCloser is like NewReadWriteCloser, but takes the pool name from
+the cached I/O context entry rather than looking it up.
+*/
+func newReadWriteCloser(entry *contextEntry, oid string) *ReadWriteCloser {
 	return &ReadWriteCloser{
-		rctx: rctx,
-		pool: pool,
-		oid:  oid,
-		pos:  0,
+		rctx:    entry.ioctx,
+		cluster: entry.cluster,
+		pool:    entry.poolName,
+		oid:     oid,
+		pos:     0,
 	}
 }
 
+/*
+labels returns the metric labels for operations on this object.
+*/
+func (r *ReadWriteCloser) labels() prometheus.Labels {
+	return prometheus.Labels{"cluster": r.cluster, "pool": r.pool}
+}
+
 /*
 Read fetches up to len(p) bytes from the Rados object pointed to into the
-specified buffer. Returns the number of bytes actually read.
-TODO: does not respect contexts yet.
+specified buffer. Returns the number of bytes actually read. ctx is honored
+while waiting for an operation slot and between retries; a read in progress
+is not interrupted, since Rados writes its result into p.
 */
 func (r *ReadWriteCloser) Read(ctx context.Context, p []byte) (n int, err error) {
 	var start = time.Now()
-	n, err = r.rctx.Read(r.oid, p, uint64(r.pos))
+	var version uint64
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	if err = r.slots.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer r.slots.release()
+
+	err = r.retry.do(ctx, r.cluster, r.pool, "read", func() error {
+		var err error
+		if !r.trackVersion {
+			n, err = r.rctx.Read(r.oid, p, uint64(r.pos))
+			return err
+		}
+		version, err = withVersion(r.rctx, func(rctx IOContext) error {
+			var err error
+			n, err = rctx.Read(r.oid, p, uint64(r.pos))
+			return err
+		})
+		return err
+	})
+	err = objectError("read", r.pool, r.oid, err)
 	if n > 0 {
 		r.pos += int64(n)
+		if r.trackVersion {
+			r.version = version
+		}
 	} else if n == 0 && err == nil {
 		/* TODO: find some way to check this is actually the end of the file. */
 		err = io.EOF
 	}
 	if err == nil {
-		radosReadLatencies.With(prometheus.Labels{"pool": r.pool}).Observe(
+		radosReadLatencies.With(r.labels()).Observe(
 			time.Now().Sub(start).Seconds())
-		radosReadBytes.With(prometheus.Labels{"pool": r.pool}).Add(
+		radosReadBytes.With(r.labels()).Add(
 			float64(n))
 	} else {
-		radosReadErrors.With(prometheus.Labels{"pool": r.pool}).Inc()
+		radosReadErrors.With(r.labels()).Inc()
 	}
 	return
 }
 
+/*
+Version returns the version of the Rados object as observed by the most
+recent successful Read(). It can be passed to WriteIfVersion() to modify the
+object only if nobody else has changed it since it was read.
+Returns 0 if nothing has been read yet, or if version tracking has not been
+enabled using SetTrackVersion().
+*/
+func (r *ReadWriteCloser) Version() uint64 {
+	return r.version
+}
+
+/*
+SetTrackVersion enables or disables recording the object version on every
+Read(), for use by Version(). Tracking is off by default: on shared I/O
+contexts, each tracked Read() has to use a private I/O context of its own.
+*/
+func (r *ReadWriteCloser) SetTrackVersion(track bool) {
+	r.trackVersion = track
+}
+
+/*
+SetSizeLimit sets the maximum size, in bytes, the Rados object may reach
+through this writer. Writes which would extend the object past the limit are
+cut off at the limit and return ErrSizeLimitExceeded. A limit of 0 or less
+disables the check, which is the default.
+*/
+func (r *ReadWriteCloser) SetSizeLimit(limit int64) {
+	r.limit = limit
+}
+
+/*
+maxWriteChunk is the largest number of bytes sent to Rados in a single write.
+Larger writes are split up, since the OSDs reject overly large requests.
+*/
+const maxWriteChunk = 16 << 20
+
 /*
 Write emplaces the bytes contained in p into the current position of the Rados
 object specified by oid.
-TODO: does not respect contexts yet.
+If a size limit is set and the write would exceed it, only the bytes up to the
+limit are written and ErrSizeLimitExceeded is returned.
+Large writes are sent to Rados in several chunks. If one of them fails, the
+number of bytes committed by the preceding chunks is returned along with the
+error, and the position is advanced by just that much, so the caller can
+resume from there. ctx is honored while waiting for an operation slot and
+between retries, but a chunk which is being written is not interrupted.
 */
 func (r *ReadWriteCloser) Write(ctx context.Context, p []byte) (int, error) {
 	var start = time.Now()
-	var err = r.rctx.Write(r.oid, p, uint64(r.pos))
-	if err != nil {
-		radosWriteErrors.With(prometheus.Labels{"pool": r.pool}).Inc()
+	var chunk []byte
+	var total int
+	var limitErr error
+	var err error
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	if _, err = addOffset(r.pos, int64(len(p))); err != nil {
+		return 0, objectError("write", r.pool, r.oid, err)
+	}
+
+	p, limitErr = capToSizeLimit(p, r.pos, r.limit)
+	if len(p) == 0 {
+		return 0, limitErr
+	}
+	if err = r.space.check(ctx); err != nil {
+		countNoSpace(r.cluster, r.pool, err)
+		return 0, err
+	}
+
+	if err = r.slots.acquire(ctx); err != nil {
 		return 0, err
 	}
+	defer r.slots.release()
 
-	radosWriteLatencies.With(prometheus.Labels{"pool": r.pool}).Observe(
+	for total < len(p) {
+		chunk = p[total:]
+		if len(chunk) > maxWriteChunk {
+			chunk = chunk[:maxWriteChunk]
+		}
+
+		if err = r.retry.do(ctx, r.cluster, r.pool, "write", func() error {
+			return r.rctx.Write(r.oid, chunk, uint64(r.pos))
+		}); err != nil {
+			radosWriteErrors.With(r.labels()).Inc()
+			radosWriteBytes.With(r.labels()).Add(float64(total))
+			err = objectError("write", r.pool, r.oid, err)
+			countNoSpace(r.cluster, r.pool, err)
+			return total, err
+		}
+
+		r.pos += int64(len(chunk))
+		total += len(chunk)
+	}
+
+	radosWriteLatencies.With(r.labels()).Observe(
 		time.Now().Sub(start).Seconds())
-	radosWriteBytes.With(prometheus.Labels{"pool": r.pool}).Add(
-		float64(len(p)))
-	r.pos += int64(len(p))
-	return len(p), nil
+	radosWriteBytes.With(r.labels()).Add(
+		float64(total))
+	return total, limitErr
+}
+
+/*
+capToSizeLimit shortens p so that writing it at offset pos does not grow the
+object past limit. If p had to be shortened, ErrSizeLimitExceeded is returned
+alongside the remaining bytes. A limit of 0 or less means no limit.
+*/
+func capToSizeLimit(p []byte, pos, limit int64) ([]byte, error) {
+	if limit <= 0 || pos+int64(len(p)) <= limit {
+		return p, nil
+	}
+	if pos >= limit {
+		return p[:0], ErrSizeLimitExceeded
+	}
+	return p[:limit-pos], ErrSizeLimitExceeded
+}
+
+/*
+SetAllowSparse controls whether Seek may position the ReadWriteCloser past the
+current end of the Rados object. If enabled, a subsequent Write will leave a
+zero-filled gap between the old end of the object and the written data, as
+with lseek(2). By default, seeking past the end of the object is rejected.
+*/
+func (r *ReadWriteCloser) SetAllowSparse(allow bool) {
+	r.sparse = allow
 }
 
 /*
 Seek modifies the position of the ReadWriteCloser in the Rados object as
 outlined in the io.Seeker API.
-TODO: does not respect contexts yet.
+Positions past the end of the object are only accepted if sparse seeking has
+been enabled using SetAllowSparse(). The size of the object is only looked up
+if it is needed, i.e. when seeking relative to the end or when sparse seeking
+is disabled. Objects which do not exist yet count as empty, so writers can
+seek before the first write.
 */
 func (r *ReadWriteCloser) Seek(
 	ctx context.Context, offset int64, whence int) (int64, error) {
-	var stat rados.ObjectStat
+	var size int64
 	var newpos int64
 	var err error
 
-	stat, err = r.rctx.Stat(r.oid)
-	if err != nil {
-		return r.pos, err
+	if r.closed {
+		return r.pos, os.ErrClosed
+	}
+
+	if whence == os.SEEK_END || !r.sparse {
+		if size, err = r.Size(ctx); errors.Is(err, ErrObjectNotFound) {
+			size = 0
+		} else if err != nil {
+			return r.pos, err
+		}
 	}
 
 	if whence == os.SEEK_SET {
@@ -155,13 +348,16 @@ func (r *ReadWriteCloser) Seek(
 		newpos = offset
 	} else if whence == os.SEEK_CUR {
 		// Seeking relative to the current offset.
-		newpos = r.pos + offset
+		newpos, err = addOffset(r.pos, offset)
 	} else if whence == os.SEEK_END {
 		// Seeking relative to the end of the file.
-		newpos = int64(stat.Size) + offset
+		newpos, err = addOffset(size, offset)
+	}
+	if err != nil {
+		return r.pos, objectError("seek", r.pool, r.oid, err)
 	}
 
-	if newpos < 0 || newpos > int64(stat.Size) {
+	if newpos < 0 || (!r.sparse && newpos > size) {
 		return r.pos, os.ErrInvalid
 	}
 
@@ -169,6 +365,29 @@ func (r *ReadWriteCloser) Seek(
 	return newpos, nil
 }
 
+/*
+Size returns the current size of the Rados object in bytes, determined using
+a single Stat. Unlike Seek(0, os.SEEK_END), this does not move the position.
+*/
+func (r *ReadWriteCloser) Size(ctx context.Context) (int64, error) {
+	var stat rados.ObjectStat
+	var err error
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		stat, err = r.rctx.Stat(r.oid)
+		return err
+	}); err != nil {
+		return 0, objectError("stat", r.pool, r.oid, err)
+	}
+
+	return objectSize(stat.Size)
+}
+
 /*
 Tell determines the current position of the ReadWriteCloser in the Rados
 object as outlined in the io.Seeker API.
@@ -178,8 +397,28 @@ func (r *ReadWriteCloser) Tell(ctx context.Context) (int64, error) {
 }
 
 /*
-Close is a no-op since Rados operations are quasi-synchronous and stateless.
+Close marks the ReadWriteCloser as closed; Rados operations are
+quasi-synchronous and stateless, so there is nothing else to release, unless
+the object is to be marked immutable after writing. Further reads, writes and
+seeks fail with os.ErrClosed. Closing more than once is harmless. If marking
+the object immutable fails, the ReadWriteCloser stays open, so that Close()
+can be retried.
 */
 func (r *ReadWriteCloser) Close(ctx context.Context) error {
+	var err error
+
+	if r.closed {
+		return nil
+	}
+
+	if r.seal {
+		if err = runWithContext(ctx, func() error {
+			return markImmutable(r.rctx, r.oid)
+		}); err != nil {
+			return err
+		}
+	}
+
+	r.closed = true
 	return nil
 }
@@ -0,0 +1,423 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+sizeLimitCases are writes of a total of 6 bytes against various size limits,
+along with the number of bytes expected to be written.
+*/
+var sizeLimitCases = []struct {
+	name    string
+	limit   int64
+	written int
+}{
+	{"below", 10, 6},
+	{"at", 6, 6},
+	{"beyond", 4, 4},
+	{"disabled", 0, 6},
+}
+
+func TestWriterSizeLimit(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var w filesystem.WriteCloser
+	var n int
+	var err error
+
+	for _, c := range sizeLimitCases {
+		if w, err = fs.OpenWriter(ctx, testURL("/"+c.name)); err != nil {
+			t.Fatalf("OpenWriter() -> %v", err)
+		}
+		w.(*rados.ReadWriteCloser).SetSizeLimit(c.limit)
+		if _, err = w.Write(ctx, []byte("abc")); err != nil {
+			t.Errorf("%s: first Write() -> %v", c.name, err)
+		}
+		n, err = w.Write(ctx, []byte("def"))
+		if c.written < 6 && (!errors.Is(err, rados.ErrSizeLimitExceeded) ||
+			n != c.written-3) {
+			t.Errorf("%s: Write() past the limit -> %d, %v, want %d, "+
+				"ErrSizeLimitExceeded", c.name, n, err, c.written-3)
+		} else if c.written == 6 && (err != nil || n != 3) {
+			t.Errorf("%s: Write() -> %d, %v, want 3, nil", c.name, n, err)
+		}
+		if err = w.Close(ctx); err != nil {
+			t.Fatalf("Close() -> %v", err)
+		}
+		expectContents(t, fs, "/"+c.name, []byte("abcdef")[:c.written])
+	}
+}
+
+/*
+countPoolNameLookups installs a hook on conn counting the calls to
+GetPoolName() in calls.
+*/
+func countPoolNameLookups(conn *radostest.Conn, calls *int64) {
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "GetPoolName" {
+			atomic.AddInt64(calls, 1)
+		}
+		return nil
+	})
+}
+
+func TestReadersUseCachedPoolName(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var fs *rados.RadosFileSystem
+	var labels = prometheus.Labels{"cluster": "poolname", "pool": testPool}
+	var r filesystem.ReadCloser
+	var buf = make([]byte, 4)
+	var calls int64
+	var i int
+	var err error
+
+	conn.CreatePool(testPool)
+	fs = rados.NewRadosFileSystemWithConn(conn, rados.WithCluster("poolname"))
+	mustWrite(t, fs, "/object", []byte("data"))
+	countPoolNameLookups(conn, &calls)
+
+	for i = 0; i < 10; i++ {
+		if r, err = fs.OpenReader(ctx, testURL("/object")); err != nil {
+			t.Fatalf("OpenReader() -> %v", err)
+		}
+		if _, err = r.Read(ctx, buf); err != nil {
+			t.Errorf("Read() -> %v", err)
+		}
+		if err = r.Close(ctx); err != nil {
+			t.Errorf("Close() -> %v", err)
+		}
+	}
+
+	if calls = atomic.LoadInt64(&calls); calls != 0 {
+		t.Errorf("GetPoolName() called %d times, want 0", calls)
+	}
+	if got := metricValue(t, "rados_read_bytes", labels); got != 40 {
+		t.Errorf("rados_read_bytes%v = %v, want 40", labels, got)
+	}
+}
+
+func BenchmarkOpenReader(b *testing.B) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(b)
+	var r filesystem.ReadCloser
+	var calls int64
+	var i int
+	var err error
+
+	mustWrite(b, fs, "/object", []byte("data"))
+	countPoolNameLookups(conn, &calls)
+
+	b.ResetTimer()
+	for i = 0; i < b.N; i++ {
+		if r, err = fs.OpenReader(ctx, testURL("/object")); err != nil {
+			b.Fatalf("OpenReader() -> %v", err)
+		}
+		r.Close(ctx)
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&calls))/float64(b.N),
+		"lookups/op")
+}
+
+func TestReadSeekAndEOF(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var r filesystem.ReadCloser
+	var rs *rados.ReadWriteCloser
+	var buf = make([]byte, 10)
+	var pos int64
+	var n int
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("0123456789"))
+	if r, err = fs.OpenReader(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenReader() -> %v", err)
+	}
+	defer r.Close(ctx)
+	rs = r.(*rados.ReadWriteCloser)
+
+	if n, err = rs.Read(ctx, buf[:4]); err != nil || string(buf[:n]) != "0123" {
+		t.Errorf("Read() -> %q, %v, want \"0123\"", buf[:n], err)
+	}
+	if pos, err = rs.Seek(ctx, 2, os.SEEK_CUR); err != nil || pos != 6 {
+		t.Errorf("Seek(2, SEEK_CUR) -> %d, %v, want 6", pos, err)
+	}
+	if n, err = rs.Read(ctx, buf); err != nil || string(buf[:n]) != "6789" {
+		t.Errorf("Read() -> %q, %v, want \"6789\"", buf[:n], err)
+	}
+	if n, err = rs.Read(ctx, buf); n != 0 || err != io.EOF {
+		t.Errorf("Read() at the end -> %d, %v, want 0, EOF", n, err)
+	}
+
+	if pos, err = rs.Seek(ctx, -3, os.SEEK_END); err != nil || pos != 7 {
+		t.Errorf("Seek(-3, SEEK_END) -> %d, %v, want 7", pos, err)
+	}
+	if n, err = rs.Read(ctx, buf); err != nil || string(buf[:n]) != "789" {
+		t.Errorf("Read() -> %q, %v, want \"789\"", buf[:n], err)
+	}
+	for _, off := range []int64{-1, 11} {
+		if pos, err = rs.Seek(ctx, off, os.SEEK_SET); err != os.ErrInvalid ||
+			pos != 10 {
+			t.Errorf("Seek(%d, SEEK_SET) -> %d, %v, want 10, ErrInvalid",
+				off, pos, err)
+		}
+	}
+}
+
+/*
+closer is implemented by all readers and writers of the package.
+*/
+type closer interface {
+	Close(ctx context.Context) error
+}
+
+func TestDoubleCloseReleasesResources(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var layout = rados.StripeLayout{ChunkSize: 4}
+	var goroutines = runtime.NumGoroutine()
+	var closers = []struct {
+		name string
+		open func(u string) (closer, error)
+	}{
+		{"OpenWriter", func(u string) (closer, error) {
+			return fs.OpenWriter(ctx, testURL(u))
+		}},
+		{"OpenAppender", func(u string) (closer, error) {
+			return fs.OpenAppender(ctx, testURL(u))
+		}},
+		{"OpenBufferedWriter", func(u string) (closer, error) {
+			return fs.OpenBufferedWriter(ctx, testURL(u), 4)
+		}},
+		{"OpenBatchingAppender", func(u string) (closer, error) {
+			return fs.OpenBatchingAppender(ctx, testURL(u), 16, time.Millisecond)
+		}},
+		{"OpenRecordAppender", func(u string) (closer, error) {
+			return fs.OpenRecordAppender(ctx, testURL(u), true)
+		}},
+		{"OpenRollingAppender", func(u string) (closer, error) {
+			return fs.OpenRollingAppender(ctx, testURL(u), 16)
+		}},
+		{"OpenStripedWriter", func(u string) (closer, error) {
+			return fs.OpenStripedWriter(ctx, testURL(u), layout)
+		}},
+		{"OpenEncryptedWriter", func(u string) (closer, error) {
+			return fs.OpenEncryptedWriter(ctx, testURL(u), staticKeys{})
+		}},
+		{"OpenIntegrityWriter", func(u string) (closer, error) {
+			return fs.OpenIntegrityWriter(ctx, testURL(u), nil)
+		}},
+		{"OpenReader", func(u string) (closer, error) {
+			return fs.OpenReader(ctx, testURL("/OpenWriter"))
+		}},
+		{"OpenReaderAt", func(u string) (closer, error) {
+			return fs.OpenReaderAt(ctx, testURL("/OpenWriter"))
+		}},
+		{"OpenSectionReader", func(u string) (closer, error) {
+			return fs.OpenSectionReader(ctx, testURL("/OpenWriter"), 1, 2)
+		}},
+		{"NewLineReader", func(u string) (closer, error) {
+			return fs.NewLineReader(ctx, testURL("/OpenWriter"))
+		}},
+		{"OpenRecordReader", func(u string) (closer, error) {
+			return fs.OpenRecordReader(ctx, testURL("/OpenRecordAppender"),
+				true)
+		}},
+		{"OpenStripedReader", func(u string) (closer, error) {
+			return fs.OpenStripedReader(ctx, testURL("/OpenStripedWriter"),
+				layout)
+		}},
+		{"OpenEncryptedReader", func(u string) (closer, error) {
+			return fs.OpenEncryptedReader(ctx, testURL("/OpenEncryptedWriter"),
+				staticKeys{})
+		}},
+	}
+	var c closer
+	var err error
+
+	for _, cl := range closers {
+		if c, err = cl.open("/" + cl.name); err != nil {
+			t.Fatalf("%s() -> %v", cl.name, err)
+		}
+		if w, ok := c.(filesystem.WriteCloser); ok {
+			if _, err = w.Write(ctx, []byte("some data\n")); err != nil {
+				t.Errorf("%s: Write() -> %v", cl.name, err)
+			}
+		} else if r, ok := c.(filesystem.ReadCloser); ok {
+			if _, err = r.Read(ctx, make([]byte, 4)); err != nil {
+				t.Errorf("%s: Read() -> %v", cl.name, err)
+			}
+		}
+		if err = c.Close(ctx); err != nil {
+			t.Errorf("%s: Close() -> %v", cl.name, err)
+		}
+		if err = c.Close(ctx); err != nil {
+			t.Errorf("%s: second Close() -> %v", cl.name, err)
+		}
+	}
+
+	for i := 0; runtime.NumGoroutine() > goroutines && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines left running after closing, had %d before",
+			n, goroutines)
+	}
+}
+
+func TestSizeKeepsReadPosition(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var r filesystem.ReadCloser
+	var rs *rados.ReadWriteCloser
+	var buf = make([]byte, 4)
+	var size, pos int64
+	var n int
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("0123456789"))
+	if r, err = fs.OpenReader(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenReader() -> %v", err)
+	}
+	defer r.Close(ctx)
+	rs = r.(*rados.ReadWriteCloser)
+
+	if _, err = rs.Read(ctx, buf); err != nil {
+		t.Fatalf("Read() -> %v", err)
+	}
+	if size, err = rs.Size(ctx); err != nil || size != 10 {
+		t.Errorf("Size() -> %d, %v, want 10", size, err)
+	}
+	if pos, err = rs.Tell(ctx); err != nil || pos != 4 {
+		t.Errorf("Tell() after Size() -> %d, %v, want 4", pos, err)
+	}
+	if n, err = rs.Read(ctx, buf); err != nil || string(buf[:n]) != "4567" {
+		t.Errorf("Read() after Size() -> %q, %v, want \"4567\"", buf[:n], err)
+	}
+}
+
+func TestWriteReportsCommittedBytes(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var data = make([]byte, rados.MaxWriteChunk+10)
+	var w filesystem.WriteCloser
+	var writes int64
+	var n int
+	var err error
+
+	if w, err = fs.OpenWriter(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+	conn.SetHook(func(op, pool, oid string) error {
+		if op == "Write" && atomic.AddInt64(&writes, 1) == 2 {
+			return radostest.Error(syscall.EIO)
+		}
+		return nil
+	})
+
+	if n, err = w.Write(ctx, data); err == nil || n != rados.MaxWriteChunk {
+		t.Errorf("Write() failing in the second chunk -> %d, %v, want %d "+
+			"and an error", n, err, rados.MaxWriteChunk)
+	}
+	if _, err = w.Write(ctx, []byte("tail")); err != nil {
+		t.Errorf("Write() after the failure -> %v", err)
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Fatalf("Close() -> %v", err)
+	}
+
+	if got := len(mustRead(t, fs, "/object")); got != rados.MaxWriteChunk+4 {
+		t.Errorf("Object holds %d bytes, want the first chunk and the tail "+
+			"(%d bytes)", got, rados.MaxWriteChunk+4)
+	}
+}
+
+func TestReadsOnlyOpenContextsWhenTrackingVersions(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var r filesystem.ReadCloser
+	var rs *rados.ReadWriteCloser
+	var p = make([]byte, 2)
+	var version uint64
+	var opens int64
+	var err error
+
+	mustWrite(t, fs, "/versioned", []byte("data"))
+	if version, err = fs.Version(ctx, testURL("/versioned")); err != nil {
+		t.Fatalf("Version() -> %v", err)
+	}
+	if r, err = fs.OpenReader(ctx, testURL("/versioned")); err != nil {
+		t.Fatalf("OpenReader() -> %v", err)
+	}
+	defer r.Close(ctx)
+	rs = r.(*rados.ReadWriteCloser)
+
+	conn.SetHook(failOperation("OpenIOContext", 0, 0, &opens))
+	if _, err = rs.Read(ctx, p); err != nil {
+		t.Fatalf("Read() -> %v", err)
+	}
+	if atomic.LoadInt64(&opens) != 0 || rs.Version() != 0 {
+		t.Errorf("untracked Read() opened %d contexts, version %d, want 0, 0",
+			opens, rs.Version())
+	}
+
+	rs.SetTrackVersion(true)
+	if _, err = rs.Read(ctx, p); err != nil {
+		t.Fatalf("tracked Read() -> %v", err)
+	}
+	if rs.Version() != version {
+		t.Errorf("Version() after tracked Read() -> %d, want %d",
+			rs.Version(), version)
+	}
+}
+
+func TestWriterSeeksBeforeFirstWrite(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var w filesystem.WriteCloser
+	var rw *rados.ReadWriteCloser
+	var stats int64
+	var pos int64
+	var err error
+
+	if w, err = fs.OpenWriter(ctx, testURL("/new")); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+	rw = w.(*rados.ReadWriteCloser)
+	if pos, err = rw.Seek(ctx, 0, os.SEEK_END); err != nil || pos != 0 {
+		t.Errorf("Seek(0, SEEK_END) on a new object -> %d, %v, want 0", pos,
+			err)
+	}
+
+	rw.SetAllowSparse(true)
+	conn.SetHook(failOperation("Stat", 0, 0, &stats))
+	if pos, err = rw.Seek(ctx, 4, os.SEEK_SET); err != nil || pos != 4 {
+		t.Errorf("Sparse Seek(4, SEEK_SET) on a new object -> %d, %v, "+
+			"want 4", pos, err)
+	}
+	if atomic.LoadInt64(&stats) != 0 {
+		t.Errorf("Sparse Seek(4, SEEK_SET) looked up the size %d times",
+			stats)
+	}
+	if _, err = w.Write(ctx, []byte("data")); err != nil {
+		t.Fatalf("Write() -> %v", err)
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Fatalf("Close() -> %v", err)
+	}
+	expectContents(t, fs, "/new", []byte("\x00\x00\x00\x00data"))
+}
@@ -0,0 +1,135 @@
+package rados
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ReaderAt provides positional reads from a Rados object, as needed by parsers
+for formats such as zip or parquet. It does not maintain a position, so it is
+safe for concurrent use.
+*/
+type ReaderAt struct {
+	rctx    IOContext
+	cluster string
+	pool    string
+	oid     string
+	retry   RetryPolicy
+	slots   *opLimiter
+}
+
+/*
+OpenReaderAt opens the specified Rados object (u.Path) in the specified pool
+(u.Host) for positional reads. Like OpenReader(), this does not check whether
+the object exists; this is determined by the first read. Compressed objects
+are refused with an error wrapping ErrUnsupported.
+*/
+func (r *RadosFileSystem) OpenReaderAt(ctx context.Context, u *url.URL) (
+	*ReaderAt, error) {
+	var entry *contextEntry
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if err = checkUncompressed("read", entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	return &ReaderAt{
+		rctx:    entry.ioctx,
+		cluster: entry.cluster,
+		pool:    entry.poolName,
+		oid:     u.Path,
+		retry:   r.opRetry,
+		slots:   r.slots,
+	}, nil
+}
+
+/*
+labels returns the metric labels for operations on this object.
+*/
+func (r *ReaderAt) labels() prometheus.Labels {
+	return prometheus.Labels{"cluster": r.cluster, "pool": r.pool}
+}
+
+/*
+ReadAt reads len(p) bytes from the Rados object starting at offset off, as
+outlined in the io.ReaderAt API: if fewer bytes are read because the end of the
+object was reached, io.EOF is returned along with the number of bytes read.
+*/
+func (r *ReaderAt) ReadAt(ctx context.Context, p []byte, off int64) (
+	int, error) {
+	var start = time.Now()
+	var total int
+	var n int
+	var err error
+
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+	if _, err = addOffset(off, int64(len(p))); err != nil {
+		return 0, objectError("read", r.pool, r.oid, err)
+	}
+
+	if err = r.slots.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer r.slots.release()
+
+	for total < len(p) {
+		if err = r.retry.do(ctx, r.cluster, r.pool, "read", func() error {
+			var err error
+			n, err = r.rctx.Read(r.oid, p[total:], uint64(off+int64(total)))
+			return err
+		}); err != nil {
+			radosReadErrors.With(r.labels()).Inc()
+			return total, objectError("read", r.pool, r.oid, err)
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+	}
+
+	radosReadLatencies.With(r.labels()).Observe(
+		time.Now().Sub(start).Seconds())
+	radosReadBytes.With(r.labels()).Add(float64(total))
+
+	if total < len(p) {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+/*
+Size returns the current size of the Rados object in bytes.
+*/
+func (r *ReaderAt) Size(ctx context.Context) (int64, error) {
+	var stat rados.ObjectStat
+	var err error
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		stat, err = r.rctx.Stat(r.oid)
+		return err
+	}); err != nil {
+		return 0, objectError("stat", r.pool, r.oid, err)
+	}
+
+	return objectSize(stat.Size)
+}
+
+/*
+Close is a no-op since the ReaderAt holds no resources of its own.
+*/
+func (r *ReaderAt) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestConcurrentReadAt(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var data = make([]byte, 64<<10)
+	var r *rados.ReaderAt
+	var errs = make(chan error, 64)
+	var wg sync.WaitGroup
+	var size int64
+	var i int
+	var err error
+
+	for i = range data {
+		data[i] = byte(i * 7)
+	}
+	mustWrite(t, fs, "/object", data)
+	if r, err = fs.OpenReaderAt(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenReaderAt() -> %v", err)
+	}
+	defer r.Close(ctx)
+
+	if size, err = r.Size(ctx); err != nil || size != int64(len(data)) {
+		t.Errorf("Size() -> %d, %v, want %d", size, err, len(data))
+	}
+
+	for i = 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			var off = int64(i) * 1021
+			var buf = make([]byte, 4096)
+			var want = data[off:]
+			var n int
+			var err error
+
+			defer wg.Done()
+			if len(want) > len(buf) {
+				want = want[:len(buf)]
+			}
+			n, err = r.ReadAt(ctx, buf, off)
+			if len(want) < len(buf) && err != io.EOF {
+				errs <- fmt.Errorf("ReadAt(%d) near the end -> %v, want EOF",
+					off, err)
+			} else if len(want) == len(buf) && err != nil {
+				errs <- fmt.Errorf("ReadAt(%d) -> %v", off, err)
+			} else if !bytes.Equal(buf[:n], want) {
+				errs <- fmt.Errorf("ReadAt(%d) returned the wrong %d bytes",
+					off, n)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err = range errs {
+		t.Error(err)
+	}
+}
+
+func TestReadAtPastTheEnd(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var r *rados.ReaderAt
+	var buf = make([]byte, 4)
+	var n int
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("data"))
+	if r, err = fs.OpenReaderAt(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenReaderAt() -> %v", err)
+	}
+	defer r.Close(ctx)
+
+	if n, err = r.ReadAt(ctx, buf, 10); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt() past the end -> %d, %v, want 0, EOF", n, err)
+	}
+	if _, err = r.ReadAt(ctx, buf, -1); err == nil {
+		t.Error("ReadAt() at a negative offset succeeded")
+	}
+}
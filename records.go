@@ -0,0 +1,252 @@
+package rados
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"net/url"
+)
+
+/*
+recordLengthSize and recordChecksumSize are the sizes of the fields of the
+header preceding every record: the length of the record, followed by the
+CRC32C of the record data if checksums are enabled. Both are stored in big
+endian byte order.
+*/
+const (
+	recordLengthSize   = 4
+	recordChecksumSize = 4
+)
+
+/*
+DefaultMaxRecordSize is the size of the largest record a RecordReader accepts
+unless configured otherwise using SetMaxRecordSize().
+*/
+const DefaultMaxRecordSize = 64 << 20
+
+/*
+RecordAppender appends length-prefixed records to a Rados object, so that they
+can be split up again by a RecordReader. Every record is appended using a
+single Rados append, so records are never interleaved with data from other
+writers.
+*/
+type RecordAppender struct {
+	a        *Appender
+	checksum bool
+}
+
+/*
+NewRecordAppender creates a RecordAppender writing records through a. If
+checksum is set, every record is stored along with its CRC32C; the reader must
+be configured the same way.
+*/
+func NewRecordAppender(a *Appender, checksum bool) *RecordAppender {
+	return &RecordAppender{a: a, checksum: checksum}
+}
+
+/*
+OpenRecordAppender opens the specified Rados object (u.Path) in the specified
+pool (u.Host) for appending records, creating it if necessary.
+*/
+func (r *RadosFileSystem) OpenRecordAppender(
+	ctx context.Context, u *url.URL, checksum bool) (*RecordAppender, error) {
+	var entry *contextEntry
+	var a *Appender
+	var err error
+
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if a, err = r.openAppender(ctx, entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	return NewRecordAppender(a, checksum), nil
+}
+
+/*
+recordHeaderSize returns the size of the header preceding every record.
+*/
+func recordHeaderSize(checksum bool) int {
+	if checksum {
+		return recordLengthSize + recordChecksumSize
+	}
+	return recordLengthSize
+}
+
+/*
+Write appends p to the object as one record. Records which would exceed the
+size limit of the underlying Appender are rejected with ErrSizeLimitExceeded
+without writing anything, so that no partial records are stored.
+*/
+func (w *RecordAppender) Write(ctx context.Context, p []byte) (int, error) {
+	var header = recordHeaderSize(w.checksum)
+	var frame []byte
+	var n int
+	var err error
+
+	if uint64(len(p)) > math.MaxUint32 {
+		return 0, ErrSizeLimitExceeded
+	}
+
+	frame = make([]byte, header+len(p))
+	binary.BigEndian.PutUint32(frame, uint32(len(p)))
+	if w.checksum {
+		binary.BigEndian.PutUint32(frame[recordLengthSize:],
+			crc32.Checksum(p, crc32cTable))
+	}
+	copy(frame[header:], p)
+
+	if w.a.limit > 0 && w.a.pos+int64(len(frame)) > w.a.limit {
+		return 0, ErrSizeLimitExceeded
+	}
+
+	if n, err = w.a.Write(ctx, frame); err != nil {
+		return 0, err
+	}
+	if n < len(frame) {
+		return 0, io.ErrShortWrite
+	}
+	return len(p), nil
+}
+
+/*
+Close closes the underlying Appender.
+*/
+func (w *RecordAppender) Close(ctx context.Context) error {
+	return w.a.Close(ctx)
+}
+
+/*
+RecordReader reads back records written by a RecordAppender one at a time.
+*/
+type RecordReader struct {
+	r        *ReadWriteCloser
+	checksum bool
+	maxSize  int64
+}
+
+/*
+NewRecordReader creates a RecordReader reading records through r, starting at
+its current position. checksum must match the setting of the RecordAppender
+the records were written with.
+*/
+func NewRecordReader(r *ReadWriteCloser, checksum bool) *RecordReader {
+	return &RecordReader{r: r, checksum: checksum,
+		maxSize: DefaultMaxRecordSize}
+}
+
+/*
+SetMaxRecordSize sets the size of the largest record ReadRecord() accepts.
+Larger records, which are most likely caused by reading from the wrong offset
+or data which are not records at all, are refused rather than allocated.
+*/
+func (rr *RecordReader) SetMaxRecordSize(size int64) {
+	rr.maxSize = size
+}
+
+/*
+OpenRecordReader opens the specified Rados object (u.Path) in the specified
+pool (u.Host) for reading records starting from the first one. Compressed
+objects are refused with an error wrapping ErrUnsupported.
+*/
+func (r *RadosFileSystem) OpenRecordReader(
+	ctx context.Context, u *url.URL, checksum bool) (*RecordReader, error) {
+	var entry *contextEntry
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if err = checkUncompressed("read", entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	return NewRecordReader(r.openReadWriteCloser(entry, u.Path), checksum), nil
+}
+
+/*
+ReadRecord returns the next record. io.EOF is returned once all records have
+been read, and io.ErrUnexpectedEOF if the object ends in the middle of a
+record. If checksums are enabled and a record does not match its checksum,
+ErrChecksumMismatch is returned. Records larger than the maximum record size,
+see SetMaxRecordSize(), are refused with an error wrapping
+ErrSizeLimitExceeded.
+
+The record buffer grows as data arrives, so a corrupted length does not
+allocate more memory than the object holds.
+*/
+func (rr *RecordReader) ReadRecord(ctx context.Context) ([]byte, error) {
+	var header = make([]byte, recordHeaderSize(rr.checksum))
+	var record = []byte{}
+	var length int64
+	var step int64
+	var start int
+	var n int
+	var err error
+
+	if n, err = rr.readFull(ctx, header); err != nil {
+		if err == io.EOF && n > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	length = int64(binary.BigEndian.Uint32(header))
+	if length > rr.maxSize {
+		return nil, fmt.Errorf("%w: record of %d bytes, at most %d allowed",
+			ErrSizeLimitExceeded, length, rr.maxSize)
+	}
+
+	for int64(len(record)) < length {
+		step = length - int64(len(record))
+		if step > copyChunkSize {
+			step = copyChunkSize
+		}
+		start = len(record)
+		record = append(record, make([]byte, step)...)
+
+		if _, err = rr.readFull(ctx, record[start:]); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+
+	if rr.checksum && crc32.Checksum(record, crc32cTable) !=
+		binary.BigEndian.Uint32(header[recordLengthSize:]) {
+		return nil, ErrChecksumMismatch
+	}
+
+	return record, nil
+}
+
+/*
+readFull reads exactly len(p) bytes, unless the end of the object is reached
+first, in which case io.EOF is returned along with the number of bytes read.
+*/
+func (rr *RecordReader) readFull(ctx context.Context, p []byte) (int, error) {
+	var total int
+	var n int
+	var err error
+
+	for total < len(p) {
+		n, err = rr.r.Read(ctx, p[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+/*
+Close closes the underlying reader.
+*/
+func (rr *RecordReader) Close(ctx context.Context) error {
+	return rr.r.Close(ctx)
+}
@@ -0,0 +1,76 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestRecordReaderBoundsRecordSize(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var large = bytes.Repeat([]byte("record"), 300000)
+	var appender *rados.RecordAppender
+	var reader *rados.RecordReader
+	var record []byte
+	var err error
+
+	if appender, err = fs.OpenRecordAppender(ctx, testURL("/records"),
+		true); err != nil {
+		t.Fatalf("OpenRecordAppender() -> %v", err)
+	}
+	for _, record = range [][]byte{[]byte("small"), {}, large} {
+		if _, err = appender.Write(ctx, record); err != nil {
+			t.Fatalf("Write() -> %v", err)
+		}
+	}
+	if err = appender.Close(ctx); err != nil {
+		t.Fatalf("Close() -> %v", err)
+	}
+
+	if reader, err = fs.OpenRecordReader(ctx, testURL("/records"),
+		true); err != nil {
+		t.Fatalf("OpenRecordReader() -> %v", err)
+	}
+	for _, want := range [][]byte{[]byte("small"), {}, large} {
+		if record, err = reader.ReadRecord(ctx); err != nil ||
+			!bytes.Equal(record, want) {
+			t.Errorf("ReadRecord() -> %d bytes, %v, want %d bytes",
+				len(record), err, len(want))
+		}
+	}
+	if _, err = reader.ReadRecord(ctx); err != io.EOF {
+		t.Errorf("ReadRecord() at the end -> %v, want io.EOF", err)
+	}
+
+	if reader, err = fs.OpenRecordReader(ctx, testURL("/records"),
+		true); err != nil {
+		t.Fatalf("OpenRecordReader() -> %v", err)
+	}
+	reader.SetMaxRecordSize(5)
+	if _, err = reader.ReadRecord(ctx); err != nil {
+		t.Errorf("ReadRecord() of a record at the limit -> %v", err)
+	}
+	reader.ReadRecord(ctx)
+	if _, err = reader.ReadRecord(ctx); !errors.Is(err,
+		rados.ErrSizeLimitExceeded) {
+		t.Errorf("ReadRecord() of a record above the limit -> %v, want "+
+			"ErrSizeLimitExceeded", err)
+	}
+
+	mustWrite(t, fs, "/garbage", []byte("\xff\xff\xff\xf0\x00\x00\x00\x00data"))
+	if reader, err = fs.OpenRecordReader(ctx, testURL("/garbage"),
+		true); err != nil {
+		t.Fatalf("OpenRecordReader() -> %v", err)
+	}
+	reader.SetMaxRecordSize(math.MaxInt64)
+	if _, err = reader.ReadRecord(ctx); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadRecord() of a truncated huge record -> %v, want "+
+			"io.ErrUnexpectedEOF", err)
+	}
+}
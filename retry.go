@@ -0,0 +1,100 @@
+package rados
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var radosRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Subsystem: "rados",
+	Name:      "retries",
+	Help:      "Number of Rados operations retried after a transient error",
+}, []string{"cluster", "pool", "operation"})
+
+func init() {
+	prometheus.MustRegister(radosRetries)
+}
+
+/*
+RetryPolicy describes how failed operations are retried: up to MaxAttempts
+attempts are made in total, waiting BaseBackoff after the first failure and
+doubling the delay after every subsequent one.
+*/
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseBackoff time.Duration
+}
+
+/*
+attempts returns the total number of attempts to make, which is at least one.
+*/
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+/*
+backoff returns the delay to wait after the specified (zero based) failed
+attempt.
+*/
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	return p.BaseBackoff << uint(attempt)
+}
+
+/*
+do runs fn, retrying it according to the policy as long as it fails with a
+transient error. Every retry is counted in the retry metric for the cluster,
+pool and operation. Retries stop early once ctx expires; the last error is returned.
+Errors due to a closed connection are never retried and are reported as
+ErrConnectionClosed.
+*/
+func (p RetryPolicy) do(
+	ctx context.Context, cluster, pool, op string, fn func() error) error {
+	var attempt int
+	var err error
+
+	for attempt = 0; ; attempt++ {
+		if err = fn(); err == nil || attempt+1 >= p.attempts() ||
+			!isTransientError(err) {
+			return translateShutdown(err)
+		}
+
+		radosRetries.With(prometheus.Labels{
+			"cluster":   cluster,
+			"pool":      pool,
+			"operation": op,
+		}).Inc()
+
+		select {
+		case <-time.After(p.backoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+/*
+isTransientError determines whether err is likely to go away if the operation
+is retried, e.g. because an OSD was busy or in transition. Permanent errors
+such as missing objects or permissions are never considered transient.
+*/
+func isTransientError(err error) bool {
+	var errno syscall.Errno
+	var ok bool
+
+	if errno, ok = radosErrno(err); !ok {
+		return false
+	}
+
+	switch errno {
+	case syscall.EAGAIN, syscall.EBUSY, syscall.EINTR, syscall.ETIMEDOUT:
+		return true
+	default:
+		return false
+	}
+}
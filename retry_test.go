@@ -0,0 +1,125 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+failOperation returns a hook making the first failures calls of op fail with
+errno, counting all calls of op in calls.
+*/
+func failOperation(op string, failures int64, errno syscall.Errno,
+	calls *int64) radostest.Hook {
+	return func(name, pool, oid string) error {
+		if name == op && atomic.AddInt64(calls, 1) <= failures {
+			return radostest.Error(errno)
+		}
+		return nil
+	}
+}
+
+/*
+newRetryFS creates a filesystem for the cluster name cluster which retries
+operations up to 4 times.
+*/
+func newRetryFS(t *testing.T, cluster string) (
+	*rados.RadosFileSystem, *radostest.Conn) {
+	var conn = radostest.NewConn()
+
+	t.Helper()
+	conn.CreatePool(testPool)
+	return rados.NewRadosFileSystemWithConn(conn, rados.WithCluster(cluster),
+		rados.WithOperationRetryPolicy(rados.RetryPolicy{
+			MaxAttempts: 4,
+			BaseBackoff: time.Millisecond,
+		})), conn
+}
+
+func TestTransientErrorsAreRetried(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newRetryFS(t, "retry")
+	var w filesystem.WriteCloser
+	var r filesystem.ReadCloser
+	var buf = make([]byte, 4)
+	var calls int64
+	var n int
+	var err error
+
+	if w, err = fs.OpenWriter(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+	conn.SetHook(failOperation("Write", 2, syscall.EAGAIN, &calls))
+	if _, err = w.Write(ctx, []byte("data")); err != nil {
+		t.Errorf("Write() failing twice -> %v", err)
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Errorf("Close() -> %v", err)
+	}
+
+	if r, err = fs.OpenReader(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenReader() -> %v", err)
+	}
+	defer r.Close(ctx)
+	calls = 0
+	conn.SetHook(failOperation("Read", 3, syscall.ETIMEDOUT, &calls))
+	if n, err = r.Read(ctx, buf); err != nil || string(buf[:n]) != "data" {
+		t.Errorf("Read() failing 3 times -> %q, %v, want \"data\"", buf[:n],
+			err)
+	}
+
+	for _, c := range []struct {
+		op   string
+		want float64
+	}{
+		{"write", 2},
+		{"read", 3},
+	} {
+		if got := metricValue(t, "rados_retries", prometheus.Labels{
+			"cluster": "retry", "pool": testPool, "operation": c.op,
+		}); got != c.want {
+			t.Errorf("%s retries = %v, want %v", c.op, got, c.want)
+		}
+	}
+}
+
+func TestRetriesGiveUp(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newRetryFS(t, "retry-give-up")
+	var a filesystem.WriteCloser
+	var calls int64
+	var err error
+
+	if a, err = fs.OpenAppender(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenAppender() -> %v", err)
+	}
+	defer a.Close(ctx)
+
+	conn.SetHook(failOperation("Append", 10, syscall.EBUSY, &calls))
+	if _, err = a.Write(ctx, []byte("data")); !errors.Is(err,
+		radostest.Error(syscall.EBUSY)) {
+		t.Errorf("Write() failing persistently -> %v, want EBUSY", err)
+	}
+	if calls = atomic.LoadInt64(&calls); calls != 4 {
+		t.Errorf("Append() attempted %d times, want 4", calls)
+	}
+
+	calls = 0
+	conn.SetHook(failOperation("Append", 10, syscall.EACCES, &calls))
+	if _, err = a.Write(ctx, []byte("data")); err == nil {
+		t.Error("Write() without permission succeeded")
+	}
+	if calls = atomic.LoadInt64(&calls); calls != 1 {
+		t.Errorf("Append() without permission attempted %d times, want 1",
+			calls)
+	}
+}
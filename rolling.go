@@ -0,0 +1,197 @@
+package rados
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"syscall"
+)
+
+/*
+RollingAppender appends to a single logical stream which is backed by a
+sequence of Rados objects named after the base name and a sequence number,
+e.g. log.0, log.1, etc. Once a write would grow the current object past the
+configured cap, the appender rolls over to the next object in the sequence.
+*/
+type RollingAppender struct {
+	fs      *RadosFileSystem
+	entry   *contextEntry
+	base    string
+	maxSize int64
+	seq     int64
+	current *Appender
+	closed  bool
+}
+
+/*
+OpenRollingAppender opens a rolling append stream for the base name u.Path in
+the specified pool (u.Host). Every backing object is kept at or below maxSize
+bytes, unless a single write is larger than that; such writes are stored in an
+object of their own. Appending resumes at the last existing object of the
+sequence.
+*/
+func (r *RadosFileSystem) OpenRollingAppender(
+	ctx context.Context, u *url.URL, maxSize int64) (*RollingAppender, error) {
+	var ret *RollingAppender
+	var segments []string
+	var err error
+
+	if maxSize <= 0 {
+		return nil, os.ErrInvalid
+	}
+
+	ret = &RollingAppender{
+		maxSize: maxSize,
+		fs:      r,
+	}
+	if ret.entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return nil, err
+	}
+	ret.base = u.Path
+
+	/* Resume at the last object of the sequence which exists already. */
+	if segments, err = rollingSegments(ctx, ret.entry, u.Path); err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		ret.seq = int64(len(segments) - 1)
+	}
+	if err = checkMutable(ctx, ret.entry, ret.objectName(ret.seq)); err != nil {
+		return nil, err
+	}
+
+	if ret.current, err = r.openAppender(ctx,
+		ret.entry, ret.objectName(ret.seq)); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+/*
+objectName returns the name of the backing object with the given sequence
+number.
+*/
+func (w *RollingAppender) objectName(seq int64) string {
+	return rollingObjectName(w.base, seq)
+}
+
+/*
+rollingObjectName returns the name of the backing object of the rolling
+stream base with the given sequence number.
+*/
+func rollingObjectName(base string, seq int64) string {
+	return fmt.Sprintf("%s.%d", base, seq)
+}
+
+/*
+rollingSegments returns the names of the backing objects of the rolling
+stream base in sequence order, up to the first one which does not exist.
+*/
+func rollingSegments(ctx context.Context, entry *contextEntry, base string) (
+	[]string, error) {
+	var segments []string
+	var name string
+	var seq int64
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	for seq = 0; ; seq++ {
+		name = rollingObjectName(base, seq)
+		if err = runWithContext(ctx, func() error {
+			var err error
+			_, err = entry.ioctx.Stat(name)
+			return err
+		}); err != nil {
+			if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+				return segments, nil
+			}
+			return nil, objectError("stat", entry.poolName, name, err)
+		}
+		segments = append(segments, name)
+	}
+}
+
+/*
+RollingSegments returns the names of the objects backing the rolling append
+stream with the base name u.Path in the specified pool (u.Host), oldest first.
+The stream can be read back by reading the objects in this order.
+*/
+func (r *RadosFileSystem) RollingSegments(ctx context.Context, u *url.URL) (
+	[]string, error) {
+	var entry *contextEntry
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+	return rollingSegments(ctx, entry, u.Path)
+}
+
+/*
+Segments returns the names of all objects of the sequence written so far,
+oldest first. The last one is the current object.
+*/
+func (w *RollingAppender) Segments(ctx context.Context) ([]string, error) {
+	return rollingSegments(ctx, w.entry, w.base)
+}
+
+/*
+CurrentObject returns the name of the object appends currently go to. Readers
+can use it to follow the sequence.
+*/
+func (w *RollingAppender) CurrentObject() string {
+	return w.current.oid
+}
+
+/*
+Write appends p to the stream. If this would grow the current object past the
+size cap, the current object is closed first and p is appended to the next
+object of the sequence instead. Data passed to a single Write() is never split
+across objects.
+*/
+func (w *RollingAppender) Write(ctx context.Context, p []byte) (int, error) {
+	var err error
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	if w.current.pos > 0 && w.current.pos+int64(len(p)) > w.maxSize {
+		if err = w.current.Close(ctx); err != nil {
+			return 0, err
+		}
+		w.seq++
+		if w.current, err = w.fs.openAppender(ctx,
+			w.entry, w.objectName(w.seq)); err != nil {
+			return 0, err
+		}
+	}
+
+	return w.current.Write(ctx, p)
+}
+
+/*
+Tell returns the position within the current object.
+*/
+func (w *RollingAppender) Tell(ctx context.Context) (int64, error) {
+	return w.current.pos, nil
+}
+
+/*
+Flush waits for all outstanding appends to the current object to complete.
+*/
+func (w *RollingAppender) Flush(ctx context.Context) error {
+	return w.current.Flush(ctx)
+}
+
+/*
+Close finalizes the current object by waiting for all outstanding appends to
+it. Further appends fail with os.ErrClosed; reopening the stream resumes at
+the same object.
+*/
+func (w *RollingAppender) Close(ctx context.Context) error {
+	w.closed = true
+	return w.current.Close(ctx)
+}
@@ -0,0 +1,57 @@
+package rados_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestRollingAppenderRollsOver(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var w *rados.RollingAppender
+	var segments []string
+	var record string
+	var err error
+
+	if w, err = fs.OpenRollingAppender(ctx, testURL("/log"), 10); err != nil {
+		t.Fatalf("OpenRollingAppender() -> %v", err)
+	}
+	for _, record = range []string{"aaaa", "bbbb", "cccc",
+		"a record of 19 byte", "dd"} {
+		if _, err = w.Write(ctx, []byte(record)); err != nil {
+			t.Fatalf("Write(%q) -> %v", record, err)
+		}
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Fatalf("Close() -> %v", err)
+	}
+
+	if segments, err = fs.RollingSegments(ctx, testURL("/log")); err != nil {
+		t.Fatalf("RollingSegments() -> %v", err)
+	}
+	if want := []string{"/log.0", "/log.1", "/log.2",
+		"/log.3"}; !reflect.DeepEqual(segments, want) {
+		t.Fatalf("RollingSegments() -> %v, want %v", segments, want)
+	}
+	expectContents(t, fs, "/log.0", []byte("aaaabbbb"))
+	expectContents(t, fs, "/log.1", []byte("cccc"))
+	expectContents(t, fs, "/log.2", []byte("a record of 19 byte"))
+	expectContents(t, fs, "/log.3", []byte("dd"))
+
+	if w, err = fs.OpenRollingAppender(ctx, testURL("/log"), 10); err != nil {
+		t.Fatalf("Reopening with OpenRollingAppender() -> %v", err)
+	}
+	if got := w.CurrentObject(); got != "/log.3" {
+		t.Errorf("Reopened appender writes to %s, want /log.3", got)
+	}
+	if _, err = w.Write(ctx, []byte("ee")); err != nil {
+		t.Fatalf("Write() after reopening -> %v", err)
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Fatalf("Close() -> %v", err)
+	}
+	expectContents(t, fs, "/log.3", []byte("ddee"))
+}
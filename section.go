@@ -0,0 +1,83 @@
+package rados
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/childoftheuniverse/filesystem"
+)
+
+/*
+OpenSectionReader opens the specified Rados object (u.Path) in the specified
+pool (u.Host) for reading the section of length bytes starting at offset.
+Reads return io.EOF once length bytes have been read or the end of the object
+has been reached, whichever comes first, which makes the reader suitable for
+serving HTTP range requests. Compressed objects are refused with an error
+wrapping ErrUnsupported.
+*/
+func (r *RadosFileSystem) OpenSectionReader(
+	ctx context.Context, u *url.URL, offset, length int64) (
+	filesystem.ReadCloser, error) {
+	var entry *contextEntry
+	var rd *ReadWriteCloser
+	var err error
+
+	if offset < 0 || length < 0 {
+		return nil, os.ErrInvalid
+	}
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+	if err = checkUncompressed("read", entry, u.Path); err != nil {
+		return nil, err
+	}
+
+	/*
+	   Position the reader directly rather than seeking, which would require
+	   a Stat() to validate the offset. Reads past the end of the object return
+	   io.EOF anyway.
+	*/
+	rd = r.openReadWriteCloser(entry, u.Path)
+	rd.pos = offset
+
+	return &sectionReader{r: rd, remaining: length}, nil
+}
+
+/*
+sectionReader caps reads from the underlying Rados reader to a fixed number of
+bytes.
+*/
+type sectionReader struct {
+	r         *ReadWriteCloser
+	remaining int64
+}
+
+/*
+Read fetches up to len(p) bytes from the section, but no more than are left
+in it.
+*/
+func (s *sectionReader) Read(ctx context.Context, p []byte) (int, error) {
+	var n int
+	var err error
+
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+
+	n, err = s.r.Read(ctx, p)
+	s.remaining -= int64(n)
+	return n, err
+}
+
+/*
+Close releases the underlying Rados reader.
+*/
+func (s *sectionReader) Close(ctx context.Context) error {
+	return s.r.Close(ctx)
+}
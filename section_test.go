@@ -0,0 +1,65 @@
+package rados_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+)
+
+/*
+readAll reads from r in small steps until it reports io.EOF.
+*/
+func readAll(ctx context.Context, r filesystem.ReadCloser) ([]byte, error) {
+	var data []byte
+	var buf = make([]byte, 3)
+	var n int
+	var err error
+
+	for {
+		n, err = r.Read(ctx, buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			return data, nil
+		} else if err != nil {
+			return data, err
+		}
+	}
+}
+
+func TestSectionReader(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var r filesystem.ReadCloser
+	var data []byte
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("0123456789"))
+
+	for _, c := range []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{"within", 2, 5, "23456"},
+		{"past the end", 7, 10, "789"},
+		{"beyond the end", 12, 4, ""},
+		{"empty", 3, 0, ""},
+	} {
+		if r, err = fs.OpenSectionReader(ctx, testURL("/object"), c.offset,
+			c.length); err != nil {
+			t.Fatalf("%s: OpenSectionReader() -> %v", c.name, err)
+		}
+		if data, err = readAll(ctx, r); err != nil || string(data) != c.want {
+			t.Errorf("%s: reading [%d, %d) -> %q, %v, want %q", c.name,
+				c.offset, c.offset+c.length, data, err, c.want)
+		}
+		r.Close(ctx)
+	}
+
+	if _, err = fs.OpenSectionReader(ctx, testURL("/object"), -1,
+		4); err == nil {
+		t.Error("OpenSectionReader() at a negative offset succeeded")
+	}
+}
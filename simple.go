@@ -0,0 +1,62 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+)
+
+/*
+SimpleFS offers the most common operations of a RadosFileSystem without the
+context and URL arguments, for scripts and command line tools which do not
+need cancellation. Objects are addressed by pool and object ID directly. All
+operations run with context.Background(), so they are only bounded by the
+default timeout where RadosFileSystem applies it.
+*/
+type SimpleFS struct {
+	fs *RadosFileSystem
+}
+
+/*
+NewSimpleFS creates a SimpleFS performing its operations through fs.
+*/
+func NewSimpleFS(fs *RadosFileSystem) *SimpleFS {
+	return &SimpleFS{fs: fs}
+}
+
+/*
+objectURL returns the URL of the object oid in pool.
+*/
+func objectURL(pool, oid string) *url.URL {
+	return &url.URL{Scheme: "rados", Host: pool, Path: oid}
+}
+
+/*
+ReadFile returns the contents of the object oid in pool, see
+RadosFileSystem.ReadFile().
+*/
+func (s *SimpleFS) ReadFile(pool, oid string) ([]byte, error) {
+	return s.fs.ReadFile(context.Background(), objectURL(pool, oid))
+}
+
+/*
+WriteFile replaces the contents of the object oid in pool with data, creating
+it if necessary, see RadosFileSystem.WriteFull().
+*/
+func (s *SimpleFS) WriteFile(pool, oid string, data []byte) error {
+	return s.fs.WriteFull(context.Background(), objectURL(pool, oid), data)
+}
+
+/*
+Remove deletes the object oid in pool, see RadosFileSystem.Remove().
+*/
+func (s *SimpleFS) Remove(pool, oid string) error {
+	return s.fs.Remove(context.Background(), objectURL(pool, oid))
+}
+
+/*
+List returns the entries below prefix in pool, see
+RadosFileSystem.ListEntries().
+*/
+func (s *SimpleFS) List(pool, prefix string) ([]string, error) {
+	return s.fs.ListEntries(context.Background(), objectURL(pool, prefix))
+}
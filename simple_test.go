@@ -0,0 +1,46 @@
+package rados_test
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestSimpleFS(t *testing.T) {
+	var fs, _ = newTestFS(t)
+	var simple = rados.NewSimpleFS(fs)
+	var names []string
+	var data []byte
+	var err error
+
+	if err = simple.WriteFile(testPool, "/dir/a", []byte("alpha")); err != nil {
+		t.Fatalf("WriteFile(/dir/a) -> %v", err)
+	}
+	if err = simple.WriteFile(testPool, "/dir/b", []byte("beta")); err != nil {
+		t.Fatalf("WriteFile(/dir/b) -> %v", err)
+	}
+
+	if data, err = simple.ReadFile(testPool, "/dir/a"); err != nil ||
+		string(data) != "alpha" {
+		t.Errorf("ReadFile(/dir/a) -> %q, %v, want \"alpha\"", data, err)
+	}
+	if names, err = simple.List(testPool, "/dir/"); err != nil {
+		t.Errorf("List(/dir/) -> %v", err)
+	}
+	sort.Strings(names)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List(/dir/) -> %v, want %v", names, want)
+	}
+
+	if err = simple.Remove(testPool, "/dir/a"); err != nil {
+		t.Errorf("Remove(/dir/a) -> %v", err)
+	}
+	if _, err = simple.ReadFile(testPool, "/dir/a"); !errors.Is(err,
+		rados.ErrObjectNotFound) {
+		t.Errorf("ReadFile() of a removed object -> %v, want %v", err,
+			rados.ErrObjectNotFound)
+	}
+}
@@ -0,0 +1,143 @@
+package rados
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+/*
+Snapshot describes a pool snapshot.
+*/
+type Snapshot struct {
+	/*
+		ID identifies the snapshot when selecting it for reads.
+	*/
+	ID      rados.SnapID
+	Name    string
+	Created time.Time
+}
+
+/*
+ListSnapshots returns all snapshots of the named pool, in the order reported
+by Rados.
+*/
+func (r *RadosFileSystem) ListSnapshots(ctx context.Context, pool string) (
+	[]Snapshot, error) {
+	var entry *contextEntry
+	var ids []rados.SnapID
+	var ret []Snapshot
+	var err error
+
+	if entry, err = r.getContext(ctx, pool); err != nil {
+		return nil, err
+	}
+
+	if err = runWithContext(ctx, func() error {
+		var snap Snapshot
+		var id rados.SnapID
+		var err error
+
+		if ids, err = entry.ioctx.ListSnaps(); err != nil {
+			return err
+		}
+		ret = make([]Snapshot, 0, len(ids))
+		for _, id = range ids {
+			snap = Snapshot{ID: id}
+			if snap.Name, err = entry.ioctx.GetSnapName(id); err != nil {
+				return err
+			}
+			if snap.Created, err = entry.ioctx.GetSnapStamp(id); err != nil {
+				return err
+			}
+			ret = append(ret, snap)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("Cannot list snapshots of pool %s: %w", pool,
+			err)
+	}
+
+	return ret, nil
+}
+
+/*
+GetSnapshotByName looks up the snapshot of the named pool called name. If
+there is no such snapshot, an error wrapping ErrSnapshotNotFound is returned.
+*/
+func (r *RadosFileSystem) GetSnapshotByName(
+	ctx context.Context, pool, name string) (*Snapshot, error) {
+	var entry *contextEntry
+	var snap = &Snapshot{Name: name}
+	var err error
+
+	if entry, err = r.getContext(ctx, pool); err != nil {
+		return nil, err
+	}
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+
+		if snap.ID, err = entry.ioctx.LookupSnap(name); err != nil {
+			return err
+		}
+		snap.Created, err = entry.ioctx.GetSnapStamp(snap.ID)
+		return err
+	}); err != nil {
+		return nil, snapshotError("lookup", pool, name, err)
+	}
+
+	return snap, nil
+}
+
+/*
+CreatePoolSnapshot takes a snapshot called name of the named pool, e.g. to
+obtain a consistent point-in-time view before a batch operation. If a
+snapshot of that name exists already, an error wrapping ErrSnapshotExists is
+returned.
+*/
+func (r *RadosFileSystem) CreatePoolSnapshot(
+	ctx context.Context, pool, name string) error {
+	var entry *contextEntry
+	var err error
+
+	if err = r.checkWritable(); err != nil {
+		return err
+	}
+	if entry, err = r.getContext(ctx, pool); err != nil {
+		return err
+	}
+
+	if err = runWithContext(ctx, func() error {
+		return entry.ioctx.CreateSnap(name)
+	}); err != nil {
+		return snapshotError("create", pool, name, err)
+	}
+	return nil
+}
+
+/*
+RemovePoolSnapshot removes the snapshot called name of the named pool. If
+there is no such snapshot, an error wrapping ErrSnapshotNotFound is returned.
+*/
+func (r *RadosFileSystem) RemovePoolSnapshot(
+	ctx context.Context, pool, name string) error {
+	var entry *contextEntry
+	var err error
+
+	if err = r.checkWritable(); err != nil {
+		return err
+	}
+	if entry, err = r.getContext(ctx, pool); err != nil {
+		return err
+	}
+
+	if err = runWithContext(ctx, func() error {
+		return entry.ioctx.RemoveSnap(name)
+	}); err != nil {
+		return snapshotError("remove", pool, name, err)
+	}
+	return nil
+}
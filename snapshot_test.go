@@ -0,0 +1,109 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+/*
+snapshotNames returns the names of the snapshots of pool, failing the test on
+errors.
+*/
+func snapshotNames(t *testing.T, fs *rados.RadosFileSystem,
+	pool string) []string {
+	var snaps []rados.Snapshot
+	var snap rados.Snapshot
+	var names []string
+	var err error
+
+	t.Helper()
+	if snaps, err = fs.ListSnapshots(context.Background(), pool); err != nil {
+		t.Fatalf("ListSnapshots(%s) -> %v", pool, err)
+	}
+	for _, snap = range snaps {
+		names = append(names, snap.Name)
+	}
+	return names
+}
+
+func TestListAndLookupSnapshots(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var snaps []rados.Snapshot
+	var snap *rados.Snapshot
+	var err error
+
+	if err = conn.CreateSnapshot(testPool, "nightly"); err != nil {
+		t.Fatalf("CreateSnapshot(nightly) -> %v", err)
+	}
+	if err = conn.CreateSnapshot(testPool, "weekly"); err != nil {
+		t.Fatalf("CreateSnapshot(weekly) -> %v", err)
+	}
+
+	if snaps, err = fs.ListSnapshots(ctx, testPool); err != nil {
+		t.Fatalf("ListSnapshots() -> %v", err)
+	}
+	if len(snaps) != 2 || snaps[0].Name != "nightly" ||
+		snaps[1].Name != "weekly" {
+		t.Fatalf("ListSnapshots() -> %v, want nightly and weekly", snaps)
+	}
+	if snaps[0].ID == snaps[1].ID {
+		t.Errorf("Both snapshots have the ID %v", snaps[0].ID)
+	}
+
+	if snap, err = fs.GetSnapshotByName(ctx, testPool, "weekly"); err != nil {
+		t.Fatalf("GetSnapshotByName(weekly) -> %v", err)
+	}
+	if snap.ID != snaps[1].ID || snap.Name != "weekly" ||
+		!snap.Created.Equal(snaps[1].Created) {
+		t.Errorf("GetSnapshotByName(weekly) -> %+v, want %+v", snap, snaps[1])
+	}
+
+	if _, err = fs.GetSnapshotByName(ctx, testPool,
+		"monthly"); !errors.Is(err, rados.ErrSnapshotNotFound) {
+		t.Errorf("GetSnapshotByName(monthly) -> %v, want %v", err,
+			rados.ErrSnapshotNotFound)
+	}
+}
+
+func TestCreateAndRemovePoolSnapshots(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var err error
+
+	if err = fs.CreatePoolSnapshot(ctx, testPool, "backup"); err != nil {
+		t.Fatalf("CreatePoolSnapshot() -> %v", err)
+	}
+	if got := snapshotNames(t, fs, testPool); !reflect.DeepEqual(
+		got, []string{"backup"}) {
+		t.Errorf("Snapshots after creation = %v, want [backup]", got)
+	}
+	if err = fs.CreatePoolSnapshot(ctx, testPool,
+		"backup"); !errors.Is(err, rados.ErrSnapshotExists) {
+		t.Errorf("CreatePoolSnapshot() of an existing snapshot -> %v, "+
+			"want %v", err, rados.ErrSnapshotExists)
+	}
+
+	if err = fs.RemovePoolSnapshot(ctx, testPool, "backup"); err != nil {
+		t.Fatalf("RemovePoolSnapshot() -> %v", err)
+	}
+	if got := snapshotNames(t, fs, testPool); len(got) > 0 {
+		t.Errorf("Snapshots after removal = %v, want none", got)
+	}
+	if err = fs.RemovePoolSnapshot(ctx, testPool,
+		"backup"); !errors.Is(err, rados.ErrSnapshotNotFound) {
+		t.Errorf("RemovePoolSnapshot() of a missing snapshot -> %v, want %v",
+			err, rados.ErrSnapshotNotFound)
+	}
+
+	fs.SetReadOnly(true)
+	if err = fs.CreatePoolSnapshot(ctx, testPool,
+		"backup"); err != rados.ErrReadOnly {
+		t.Errorf("CreatePoolSnapshot() in read-only mode -> %v, want %v",
+			err, rados.ErrReadOnly)
+	}
+}
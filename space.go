@@ -0,0 +1,122 @@
+package rados
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var radosWriteNoSpace = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Subsystem: "rados",
+	Name:      "write_nospace_total",
+	Help:      "Number of writes refused because the cluster or pool was full",
+}, []string{"cluster", "pool"})
+
+func init() {
+	prometheus.MustRegister(radosWriteNoSpace)
+}
+
+/*
+countNoSpace counts err in the write_nospace_total metric if it reports a
+full cluster or pool.
+*/
+func countNoSpace(cluster, pool string, err error) {
+	if errors.Is(err, ErrNoSpace) {
+		radosWriteNoSpace.With(prometheus.Labels{
+			"cluster": cluster, "pool": pool}).Inc()
+	}
+}
+
+/*
+spaceCheckInterval is how long the result of a capacity check is reused
+before the cluster is asked again.
+*/
+const spaceCheckInterval = 10 * time.Second
+
+/*
+spaceGuard refuses writes while the cluster is filled beyond a high-water
+mark, so that writers back off before the cluster itself starts rejecting
+writes. A nil spaceGuard never refuses anything.
+*/
+type spaceGuard struct {
+	conn      Conn
+	cluster   string
+	highWater float64
+
+	/*
+		checked is the time of the last successful capacity check, and used
+		the fraction of the cluster capacity in use at that time.
+	*/
+	mtx     sync.Mutex
+	checked time.Time
+	used    float64
+}
+
+/*
+newSpaceGuard creates a guard refusing writes once more than the fraction
+highWater of the capacity of the cluster is in use. If highWater is 0 or less,
+no check is performed and nil is returned.
+*/
+func newSpaceGuard(conn Conn, cluster string, highWater float64) *spaceGuard {
+	if highWater <= 0 {
+		return nil
+	}
+	return &spaceGuard{
+		conn:      conn,
+		cluster:   cluster,
+		highWater: highWater,
+	}
+}
+
+/*
+SetSpaceHighWater refuses writes with ErrNoSpace once more than the fraction
+highWater of the cluster capacity is in use, as with WithSpaceHighWater(). A
+value of 0 disables the check.
+*/
+func (r *RadosFileSystem) SetSpaceHighWater(highWater float64) {
+	r.space.Store(newSpaceGuard(r.rfs, r.cluster, highWater))
+}
+
+/*
+check returns an error wrapping ErrNoSpace if the cluster was filled beyond
+the high-water mark when last checked. The usage is refreshed at most once
+every spaceCheckInterval. If the usage cannot be determined, writes are not
+held up; the last known usage is used until the next check.
+*/
+func (g *spaceGuard) check(ctx context.Context) error {
+	var stats rados.ClusterStat
+	var err error
+
+	if g == nil {
+		return nil
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if time.Since(g.checked) >= spaceCheckInterval {
+		if err = runWithContext(ctx, func() error {
+			var err error
+			stats, err = g.conn.GetClusterStats()
+			return err
+		}); err == nil {
+			g.checked = time.Now()
+			g.used = 0
+			if stats.Kb > 0 {
+				g.used = float64(stats.Kb_used) / float64(stats.Kb)
+			}
+		}
+	}
+
+	if g.used >= g.highWater {
+		return fmt.Errorf("%w: cluster %s is %.1f%% full, writes are "+
+			"refused above %.1f%%", ErrNoSpace, g.cluster, g.used*100,
+			g.highWater*100)
+	}
+	return nil
+}
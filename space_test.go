@@ -0,0 +1,85 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWritesAreRefusedAboveHighWater(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var err error
+
+	mustWrite(t, fs, "/existing", bytes.Repeat([]byte("x"), 8<<10))
+	conn.SetCapacity(10 << 10)
+
+	fs.SetSpaceHighWater(0.5)
+	if err = fs.WriteFull(ctx, testURL("/object"),
+		[]byte("data")); !errors.Is(err, rados.ErrNoSpace) {
+		t.Errorf("WriteFull() above the high-water mark -> %v, want %v", err,
+			rados.ErrNoSpace)
+	}
+
+	fs.SetSpaceHighWater(0.9)
+	if err = fs.WriteFull(ctx, testURL("/object"), []byte("data")); err != nil {
+		t.Errorf("WriteFull() below the high-water mark -> %v", err)
+	}
+
+	fs.SetSpaceHighWater(0)
+	conn.SetCapacity(1 << 10)
+	if err = fs.WriteFull(ctx, testURL("/object"), []byte("data")); err != nil {
+		t.Errorf("WriteFull() without a high-water mark -> %v", err)
+	}
+}
+
+func TestFullClusterReportsNoSpace(t *testing.T) {
+	var ctx = context.Background()
+	var conn = radostest.NewConn()
+	var fs *rados.RadosFileSystem
+	var labels = prometheus.Labels{"cluster": "nospace", "pool": testPool}
+	var w filesystem.WriteCloser
+	var calls int64
+	var err error
+
+	conn.CreatePool(testPool)
+	fs = rados.NewRadosFileSystemWithConn(conn, rados.WithCluster("nospace"))
+	conn.SetHook(func(op, pool, oid string) error {
+		switch op {
+		case "OperateWrite", "Write":
+			atomic.AddInt64(&calls, 1)
+			return radostest.Error(syscall.ENOSPC)
+		}
+		return nil
+	})
+
+	if err = fs.WriteFull(ctx, testURL("/object"),
+		[]byte("data")); !errors.Is(err, rados.ErrNoSpace) {
+		t.Errorf("WriteFull() on a full cluster -> %v, want %v", err,
+			rados.ErrNoSpace)
+	}
+	if w, err = fs.OpenWriter(ctx, testURL("/object")); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+	if _, err = w.Write(ctx, []byte("data")); !errors.Is(err,
+		rados.ErrNoSpace) {
+		t.Errorf("Write() on a full cluster -> %v, want %v", err,
+			rados.ErrNoSpace)
+	}
+	w.Close(ctx)
+
+	if calls == 0 {
+		t.Fatal("No write reached the cluster")
+	}
+	if got := metricValue(t, "rados_write_nospace_total", labels); got != 2 {
+		t.Errorf("rados_write_nospace_total%v = %v, want 2", labels, got)
+	}
+}
@@ -0,0 +1,75 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestSparseReadReportsExtents(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var w filesystem.WriteCloser
+	var rw *rados.ReadWriteCloser
+	var first = append([]byte("head"), make([]byte, 4092)...)
+	var last = append(make([]byte, 10), []byte("tail")...)
+	var extents []rados.Extent
+	var data []byte
+	var err error
+
+	if w, err = fs.OpenWriter(ctx, testURL("/sparse")); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+	rw = w.(*rados.ReadWriteCloser)
+	rw.SetAllowSparse(true)
+	if _, err = w.Write(ctx, []byte("head")); err != nil {
+		t.Fatalf("Write() -> %v", err)
+	}
+	if _, err = rw.Seek(ctx, 3*4096+10, os.SEEK_SET); err != nil {
+		t.Fatalf("Seek() -> %v", err)
+	}
+	if _, err = w.Write(ctx, []byte("tail")); err != nil {
+		t.Fatalf("Write() -> %v", err)
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Fatalf("Close() -> %v", err)
+	}
+
+	for _, c := range []struct {
+		name           string
+		offset, length int64
+		extents        []rados.Extent
+		data           []byte
+	}{
+		{"whole object", 0, 1 << 20,
+			[]rados.Extent{{0, 4096}, {3 * 4096, 14}},
+			append(append([]byte(nil), first...), last...)},
+		{"hole only", 100, 8192, nil, nil},
+		{"partial blocks", 2, 3*4096 + 10, []rados.Extent{{2, 4094},
+			{3 * 4096, 12}}, append(append([]byte(nil), first[2:]...),
+			last[:12]...)},
+	} {
+		if extents, data, err = fs.SparseRead(ctx, testURL("/sparse"),
+			c.offset, c.length); err != nil {
+			t.Errorf("%s: SparseRead() -> %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(extents, c.extents) {
+			t.Errorf("%s: extents = %v, want %v", c.name, extents, c.extents)
+		}
+		if !bytes.Equal(data, c.data) {
+			t.Errorf("%s: got %d bytes of data, want %d", c.name, len(data),
+				len(c.data))
+		}
+	}
+
+	if _, _, err = fs.SparseRead(ctx, testURL("/sparse"), -1,
+		10); err == nil {
+		t.Error("SparseRead() at a negative offset succeeded")
+	}
+}
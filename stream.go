@@ -0,0 +1,120 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+)
+
+/*
+ListEntriesStream works like ListEntries, but sends the entries on the
+returned channel as they are found while iterating over the pool, so that
+callers can start processing them right away. The entry channel is closed once
+the listing is complete. If it fails, or ctx expires, the error is sent on the
+error channel, which is closed afterwards as well. Callers must either drain
+the entry channel or cancel ctx.
+
+Only the names of the entries of the directory listed are kept in memory, to
+avoid sending the same directory more than once, rather than all object IDs
+of the pool. Entries are sent in iteration order, not sorted.
+*/
+func (r *RadosFileSystem) ListEntriesStream(ctx context.Context, u *url.URL) (
+	<-chan string, <-chan error) {
+	var names = make(chan string)
+	var errs = make(chan error, 1)
+
+	go func() {
+		var err error
+
+		defer close(errs)
+		defer close(names)
+
+		if err = r.streamEntries(ctx, u, names); err != nil {
+			errs <- err
+		}
+	}()
+
+	return names, errs
+}
+
+/*
+streamEntries sends the entries of u on names, reading them from the directory
+index if there is one or by iterating over the pool otherwise.
+*/
+func (r *RadosFileSystem) streamEntries(
+	ctx context.Context, u *url.URL, names chan<- string) error {
+	var entry *contextEntry
+	var iter Iter
+	var seen = make(map[string]bool)
+	var separator = r.pathSeparator()
+	var name string
+	var ok bool
+	var err error
+
+	if entry, err = r.getURLContext(ctx, u); err != nil {
+		return err
+	}
+
+	if r.dirIndex.Load() && separator == DefaultPathSeparator {
+		if seen, err = readIndex(ctx, entry, u.Path); err != nil {
+			return err
+		}
+		if seen != nil {
+			for name = range seen {
+				if r.hiddenEntry(name) {
+					continue
+				}
+				if err = r.sendEntry(ctx, names, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		seen = make(map[string]bool)
+	}
+
+	if iter, err = entry.ioctx.Iter(); err != nil {
+		return objectError("list", entry.poolName, u.Path, err)
+	}
+	defer iter.Close()
+
+	for iter.Next() {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if name, _, ok = listEntryName(iter.Value(), u.Path, separator); !ok ||
+			seen[name] || r.hiddenEntry(name) {
+			continue
+		}
+		seen[name] = true
+		if err = r.sendEntry(ctx, names, name); err != nil {
+			return err
+		}
+	}
+
+	if err = iter.Err(); err != nil {
+		return objectError("list", entry.poolName, u.Path, err)
+	}
+	return nil
+}
+
+/*
+hiddenEntry returns whether name is an object used internally by this
+package, which is not to be listed.
+*/
+func (r *RadosFileSystem) hiddenEntry(name string) bool {
+	return (r.dirPlaceholders.Load() && name == DirPlaceholder) ||
+		(r.dirIndex.Load() && name == DirIndexObject)
+}
+
+/*
+sendEntry sends name on names, unless ctx expires first.
+*/
+func (r *RadosFileSystem) sendEntry(
+	ctx context.Context, names chan<- string, name string) error {
+	select {
+	case names <- name:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
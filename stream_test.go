@@ -0,0 +1,60 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestListEntriesStreamMatchesListEntries(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newListingFS(t)
+	var names <-chan string
+	var errs <-chan error
+	var want, got []string
+	var name string
+	var err error
+
+	for _, oid := range []string{"/dir", "/dir/3", "/missing"} {
+		if want, err = fs.ListEntries(ctx, testURL(oid)); err != nil {
+			t.Fatalf("ListEntries(%s) -> %v", oid, err)
+		}
+		got = nil
+		names, errs = fs.ListEntriesStream(ctx, testURL(oid))
+		for name = range names {
+			got = append(got, name)
+		}
+		if err = <-errs; err != nil {
+			t.Errorf("ListEntriesStream(%s) -> %v", oid, err)
+		}
+		sort.Strings(want)
+		sort.Strings(got)
+		if len(got) != len(want) || (len(got) > 0 &&
+			!reflect.DeepEqual(got, want)) {
+			t.Errorf("ListEntriesStream(%s) -> %v, want %v", oid, got, want)
+		}
+	}
+}
+
+func TestListEntriesStreamCancelled(t *testing.T) {
+	var fs, _ = newListingFS(t)
+	var ctx, cancel = context.WithCancel(context.Background())
+	var names <-chan string
+	var errs <-chan error
+	var err error
+
+	names, errs = fs.ListEntriesStream(ctx, testURL("/dir"))
+	if _, ok := <-names; !ok {
+		t.Fatal("ListEntriesStream() sent no entries")
+	}
+	cancel()
+
+	if err = <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("ListEntriesStream() after cancellation -> %v, want %v", err,
+			context.Canceled)
+	}
+	for range names {
+	}
+}
@@ -0,0 +1,312 @@
+package rados
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+DefaultStripeNamePattern names the chunks of a striped file by appending a
+four digit sequence number to the name of the logical file, e.g. oid.0000,
+oid.0001, etc.
+*/
+const DefaultStripeNamePattern = "%s.%04d"
+
+/*
+StripeLayout describes how a large logical file is split into a series of
+sequentially numbered Rados objects.
+*/
+type StripeLayout struct {
+	/*
+		ChunkSize is the number of bytes stored in every chunk. All chunks
+		except the last one must be exactly this large.
+	*/
+	ChunkSize int64
+
+	/*
+		NamePattern is the fmt pattern used to derive chunk object names from
+		the name of the logical file and the chunk number. Defaults to
+		DefaultStripeNamePattern if empty.
+	*/
+	NamePattern string
+}
+
+/*
+chunkName returns the object name of the chunk number idx of oid.
+*/
+func (l StripeLayout) chunkName(oid string, idx int64) string {
+	if l.NamePattern == "" {
+		return fmt.Sprintf(DefaultStripeNamePattern, oid, idx)
+	}
+	return fmt.Sprintf(l.NamePattern, oid, idx)
+}
+
+/*
+locate returns the chunk number a position of the logical file falls into,
+and the offset of the position within that chunk.
+*/
+func (l StripeLayout) locate(pos int64) (int64, int64) {
+	return pos / l.ChunkSize, pos % l.ChunkSize
+}
+
+/*
+StripedReader presents a set of sequentially numbered Rados objects, as
+described by a StripeLayout, as one continuous stream. If the file has a
+manifest, its size is taken from there; otherwise, the end of the stream is
+reached at the first chunk which is either missing or shorter than the chunk
+size.
+*/
+type StripedReader struct {
+	rctx     IOContext
+	cluster  string
+	pool     string
+	oid      string
+	layout   StripeLayout
+	manifest *StripeManifest
+	pos      int64
+	retry    RetryPolicy
+	slots    *opLimiter
+	closed   bool
+}
+
+/*
+OpenStripedReader opens the striped file u.Path in the specified pool (u.Host)
+for reading. If the file has a manifest, the chunk size is taken from it, and
+all chunks are checked to be present with the expected sizes; if any are not,
+an error wrapping ErrIncompleteStripe is returned. Without a manifest, this
+does not check whether any chunks exist; this is determined by the first read.
+*/
+func (r *RadosFileSystem) OpenStripedReader(
+	ctx context.Context, u *url.URL, layout StripeLayout) (
+	*StripedReader, error) {
+	var entry *contextEntry
+	var manifest *StripeManifest
+	var err error
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return nil, err
+	}
+
+	if manifest, err = readStripeManifest(
+		ctx, entry.ioctx, u.Path); err != nil {
+		return nil, err
+	}
+	if manifest != nil {
+		layout.ChunkSize = manifest.ChunkSize
+		if err = checkStripeComplete(
+			ctx, entry.ioctx, layout, u.Path, manifest); err != nil {
+			return nil, err
+		}
+	}
+	if layout.ChunkSize <= 0 {
+		return nil, os.ErrInvalid
+	}
+
+	return &StripedReader{
+		rctx:     entry.ioctx,
+		cluster:  entry.cluster,
+		pool:     entry.poolName,
+		oid:      u.Path,
+		layout:   layout,
+		manifest: manifest,
+		retry:    r.opRetry,
+		slots:    r.slots,
+	}, nil
+}
+
+/*
+Manifest returns the manifest of the striped file, or nil if it has none.
+*/
+func (s *StripedReader) Manifest() *StripeManifest {
+	return s.manifest
+}
+
+/*
+VerifyChecksums reads all chunks listed in the manifest and compares them to
+the checksums recorded there. ErrChecksumMismatch is returned for the first
+chunk which does not match. Files without a manifest cannot be verified and
+are reported as correct.
+*/
+func (s *StripedReader) VerifyChecksums(ctx context.Context) error {
+	var name string
+	var sum uint32
+	var idx int64
+	var err error
+
+	if s.closed {
+		return os.ErrClosed
+	}
+	if s.manifest == nil {
+		return nil
+	}
+
+	for idx = 0; idx < s.manifest.ChunkCount; idx++ {
+		name = s.layout.chunkName(s.oid, idx)
+		if sum, err = chunkChecksum(
+			ctx, s.rctx, name, s.manifest.chunkLength(idx)); err != nil {
+			return err
+		}
+		if sum != s.manifest.Checksums[idx] {
+			return fmt.Errorf("%w: chunk %s", ErrChecksumMismatch, name)
+		}
+	}
+	return nil
+}
+
+/*
+labels returns the metric labels for operations on this file.
+*/
+func (s *StripedReader) labels() prometheus.Labels {
+	return prometheus.Labels{"cluster": s.cluster, "pool": s.pool}
+}
+
+/*
+Read fetches up to len(p) bytes from the current position. A single call never
+reads across a chunk boundary, so fewer bytes than requested may be returned
+even if the end of the file has not been reached yet. io.EOF is returned once
+the end of the file has been reached.
+*/
+func (s *StripedReader) Read(ctx context.Context, p []byte) (int, error) {
+	var start = time.Now()
+	var chunk, off int64
+	var errno syscall.Errno
+	var ok bool
+	var n int
+	var err error
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if s.manifest != nil && s.pos >= s.manifest.TotalSize {
+		return 0, io.EOF
+	}
+
+	chunk, off = s.layout.locate(s.pos)
+	if int64(len(p)) > s.layout.ChunkSize-off {
+		p = p[:s.layout.ChunkSize-off]
+	}
+
+	if err = s.slots.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer s.slots.release()
+
+	if err = s.retry.do(ctx, s.cluster, s.pool, "read", func() error {
+		var err error
+		n, err = s.rctx.Read(s.layout.chunkName(s.oid, chunk), p, uint64(off))
+		return err
+	}); err != nil {
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+			return 0, io.EOF
+		}
+		radosReadErrors.With(s.labels()).Inc()
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+
+	s.pos += int64(n)
+	radosReadLatencies.With(s.labels()).Observe(
+		time.Now().Sub(start).Seconds())
+	radosReadBytes.With(s.labels()).Add(float64(n))
+	return n, nil
+}
+
+/*
+Size determines the total size of the striped file by looking up chunks until
+the first missing or partial one is found.
+*/
+func (s *StripedReader) Size(ctx context.Context) (int64, error) {
+	var stat rados.ObjectStat
+	var chunk int64
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	if s.manifest != nil {
+		return s.manifest.TotalSize, nil
+	}
+
+	for chunk = 0; ; chunk++ {
+		if err = runWithContext(ctx, func() error {
+			var err error
+			stat, err = s.rctx.Stat(s.layout.chunkName(s.oid, chunk))
+			return err
+		}); err != nil {
+			if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+				return chunk * s.layout.ChunkSize, nil
+			}
+			return 0, err
+		}
+		if stat.Size < uint64(s.layout.ChunkSize) {
+			return chunk*s.layout.ChunkSize + int64(stat.Size), nil
+		}
+	}
+}
+
+/*
+Seek modifies the position in the striped file as outlined in the io.Seeker
+API. Positions past the end of the file are rejected.
+*/
+func (s *StripedReader) Seek(
+	ctx context.Context, offset int64, whence int) (int64, error) {
+	var size int64
+	var newpos int64
+	var err error
+
+	if s.closed {
+		return s.pos, os.ErrClosed
+	}
+
+	if size, err = s.Size(ctx); err != nil {
+		return s.pos, err
+	}
+
+	if whence == os.SEEK_SET {
+		newpos = offset
+	} else if whence == os.SEEK_CUR {
+		newpos = s.pos + offset
+	} else if whence == os.SEEK_END {
+		newpos = size + offset
+	} else {
+		return s.pos, os.ErrInvalid
+	}
+
+	if newpos < 0 || newpos > size {
+		return s.pos, os.ErrInvalid
+	}
+
+	s.pos = newpos
+	return newpos, nil
+}
+
+/*
+Tell returns the current position in the striped file.
+*/
+func (s *StripedReader) Tell(ctx context.Context) (int64, error) {
+	return s.pos, nil
+}
+
+/*
+Close marks the StripedReader as closed. Further reads and seeks fail with
+os.ErrClosed.
+*/
+func (s *StripedReader) Close(ctx context.Context) error {
+	s.closed = true
+	return nil
+}
@@ -0,0 +1,84 @@
+package rados_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+/*
+readN reads exactly n bytes from r, which may take several reads.
+*/
+func readN(ctx context.Context, r filesystem.ReadCloser, n int) ([]byte,
+	error) {
+	var data = make([]byte, n)
+	var total, read int
+	var err error
+
+	for total < n {
+		if read, err = r.Read(ctx, data[total:]); err != nil {
+			return data[:total+read], err
+		}
+		total += read
+	}
+	return data, nil
+}
+
+func TestStripedReaderAcrossChunks(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var layout = rados.StripeLayout{ChunkSize: 4}
+	var r *rados.StripedReader
+	var data []byte
+	var size, pos int64
+	var err error
+
+	mustWrite(t, fs, "/file.0000", []byte("0123"))
+	mustWrite(t, fs, "/file.0001", []byte("4567"))
+	mustWrite(t, fs, "/file.0002", []byte("89"))
+
+	if r, err = fs.OpenStripedReader(ctx, testURL("/file"),
+		layout); err != nil {
+		t.Fatalf("OpenStripedReader() -> %v", err)
+	}
+	defer r.Close(ctx)
+
+	if data, err = readAll(ctx, r); err != nil ||
+		string(data) != "0123456789" {
+		t.Errorf("Reading the striped file -> %q, %v, want \"0123456789\"",
+			data, err)
+	}
+	if size, err = r.Size(ctx); err != nil || size != 10 {
+		t.Errorf("Size() -> %d, %v, want 10", size, err)
+	}
+
+	for _, c := range []struct {
+		offset int64
+		whence int
+		pos    int64
+		want   string
+	}{
+		{3, os.SEEK_SET, 3, "3456"},
+		{-3, os.SEEK_CUR, 4, "4567"},
+		{-3, os.SEEK_END, 7, "789"},
+		{8, os.SEEK_SET, 8, "89"},
+	} {
+		if pos, err = r.Seek(ctx, c.offset, c.whence); err != nil ||
+			pos != c.pos {
+			t.Errorf("Seek(%d, %d) -> %d, %v, want %d", c.offset, c.whence,
+				pos, err, c.pos)
+		}
+		if data, err = readN(ctx, r, len(c.want)); err != nil ||
+			string(data) != c.want {
+			t.Errorf("Reading at %d -> %q, %v, want %q", c.pos, data, err,
+				c.want)
+		}
+	}
+
+	if _, err = r.Seek(ctx, 11, os.SEEK_SET); err == nil {
+		t.Error("Seek() past the end succeeded")
+	}
+}
@@ -0,0 +1,212 @@
+package rados
+
+import (
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+StripedWriter writes a stream across sequentially numbered Rados objects as
+described by a StripeLayout, rolling over to the next object whenever a chunk
+has been filled. The result can be read back using a StripedReader.
+*/
+type StripedWriter struct {
+	fs       *RadosFileSystem
+	entry    *contextEntry
+	rctx     IOContext
+	cluster  string
+	pool     string
+	oid      string
+	layout   StripeLayout
+	pos      int64
+	manifest bool
+
+	/*
+		checksums holds the running CRC32C of every chunk written so far.
+	*/
+	checksums []uint32
+	retry     RetryPolicy
+	slots     *opLimiter
+	closed    bool
+}
+
+/*
+OpenStripedWriter opens the striped file u.Path in the specified pool (u.Host)
+for writing from the beginning. Existing chunks are overwritten as the stream
+reaches them.
+*/
+func (r *RadosFileSystem) OpenStripedWriter(
+	ctx context.Context, u *url.URL, layout StripeLayout) (
+	*StripedWriter, error) {
+	var entry *contextEntry
+	var err error
+
+	if layout.ChunkSize <= 0 {
+		return nil, os.ErrInvalid
+	}
+
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return nil, err
+	}
+
+	return &StripedWriter{
+		fs:      r,
+		entry:   entry,
+		rctx:    entry.ioctx,
+		cluster: entry.cluster,
+		pool:    entry.poolName,
+		oid:     u.Path,
+		layout:  layout,
+		retry:   r.opRetry,
+		slots:   r.slots,
+	}, nil
+}
+
+/*
+SetWriteManifest determines whether Close() stores a StripeManifest alongside
+the chunks. Disabled by default.
+*/
+func (s *StripedWriter) SetWriteManifest(enable bool) {
+	s.manifest = enable
+}
+
+/*
+labels returns the metric labels for operations on this file.
+*/
+func (s *StripedWriter) labels() prometheus.Labels {
+	return prometheus.Labels{"cluster": s.cluster, "pool": s.pool}
+}
+
+/*
+Write appends p to the striped file, splitting it across as many chunks as
+necessary. The first write to every chunk replaces its previous contents.
+Returns the number of bytes written before an error occurred, if any.
+*/
+func (s *StripedWriter) Write(ctx context.Context, p []byte) (int, error) {
+	var start = time.Now()
+	var chunk, off int64
+	var data []byte
+	var total int
+	var err error
+
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+
+	if err = s.slots.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer s.slots.release()
+
+	for total < len(p) {
+		chunk, off = s.layout.locate(s.pos)
+		data = p[total:]
+		if int64(len(data)) > s.layout.ChunkSize-off {
+			data = data[:s.layout.ChunkSize-off]
+		}
+		if off == 0 {
+			if err = s.fs.indexAdd(
+				ctx, s.entry, s.layout.chunkName(s.oid, chunk)); err != nil {
+				return total, err
+			}
+		}
+
+		if err = s.retry.do(ctx, s.cluster, s.pool, "write", func() error {
+			if off == 0 {
+				return s.rctx.WriteFull(s.layout.chunkName(s.oid, chunk), data)
+			}
+			return s.rctx.Write(
+				s.layout.chunkName(s.oid, chunk), data, uint64(off))
+		}); err != nil {
+			radosWriteErrors.With(s.labels()).Inc()
+			err = objectError("write", s.pool,
+				s.layout.chunkName(s.oid, chunk), err)
+			countNoSpace(s.cluster, s.pool, err)
+			return total, err
+		}
+
+		if off == 0 {
+			s.checksums = append(s.checksums, 0)
+		}
+		s.checksums[chunk] = crc32.Update(
+			s.checksums[chunk], crc32cTable, data)
+
+		s.pos += int64(len(data))
+		total += len(data)
+	}
+
+	radosWriteLatencies.With(s.labels()).Observe(
+		time.Now().Sub(start).Seconds())
+	radosWriteBytes.With(s.labels()).Add(float64(total))
+	return total, nil
+}
+
+/*
+Tell returns the number of bytes written so far.
+*/
+func (s *StripedWriter) Tell(ctx context.Context) (int64, error) {
+	return s.pos, nil
+}
+
+/*
+chunkCount returns the number of chunks written so far.
+*/
+func (s *StripedWriter) chunkCount() int64 {
+	return (s.pos + s.layout.ChunkSize - 1) / s.layout.ChunkSize
+}
+
+/*
+Close finalizes the striped file. The chunk following the last one written is
+removed if it is left over from an earlier, longer version of the file, so
+that readers stop at the right place. If enabled, the manifest is written
+afterwards. Closing more than once is harmless.
+*/
+func (s *StripedWriter) Close(ctx context.Context) error {
+	var manifest []byte
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if err = runWithContext(ctx, func() error {
+		return s.rctx.Delete(s.layout.chunkName(s.oid, s.chunkCount()))
+	}); err != nil {
+		if errno, ok = radosErrno(err); !ok || errno != syscall.ENOENT {
+			return err
+		}
+	} else if err = s.fs.indexRemove(
+		ctx, s.entry, s.layout.chunkName(s.oid, s.chunkCount())); err != nil {
+		return err
+	}
+
+	if !s.manifest {
+		return nil
+	}
+
+	if manifest, err = json.Marshal(&StripeManifest{
+		Version:    StripeManifestVersion,
+		ChunkSize:  s.layout.ChunkSize,
+		ChunkCount: s.chunkCount(),
+		TotalSize:  s.pos,
+		Checksums:  s.checksums,
+	}); err != nil {
+		return err
+	}
+	if err = runWithContext(ctx, func() error {
+		return s.rctx.WriteFull(s.oid+StripeManifestSuffix, manifest)
+	}); err != nil {
+		return err
+	}
+	return s.fs.indexAdd(ctx, s.entry, s.oid+StripeManifestSuffix)
+}
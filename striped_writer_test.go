@@ -0,0 +1,85 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+/*
+writeStriped writes data to the striped file oid in pieces of varying sizes.
+*/
+func writeStriped(t *testing.T, fs *rados.RadosFileSystem, oid string,
+	layout rados.StripeLayout, data []byte, manifest bool) {
+	var ctx = context.Background()
+	var w *rados.StripedWriter
+	var piece int
+	var err error
+
+	t.Helper()
+	if w, err = fs.OpenStripedWriter(ctx, testURL(oid), layout); err != nil {
+		t.Fatalf("OpenStripedWriter() -> %v", err)
+	}
+	w.SetWriteManifest(manifest)
+	for piece = 1; len(data) > 0; piece = piece%13 + 1 {
+		if piece > len(data) {
+			piece = len(data)
+		}
+		if _, err = w.Write(ctx, data[:piece]); err != nil {
+			t.Fatalf("Write() -> %v", err)
+		}
+		data = data[piece:]
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Fatalf("Close() -> %v", err)
+	}
+}
+
+/*
+readStriped reads the striped file oid back in full.
+*/
+func readStriped(t *testing.T, fs *rados.RadosFileSystem, oid string,
+	layout rados.StripeLayout) []byte {
+	var ctx = context.Background()
+	var r *rados.StripedReader
+	var data []byte
+	var err error
+
+	t.Helper()
+	if r, err = fs.OpenStripedReader(ctx, testURL(oid), layout); err != nil {
+		t.Fatalf("OpenStripedReader() -> %v", err)
+	}
+	defer r.Close(ctx)
+	if data, err = readAll(ctx, r); err != nil {
+		t.Fatalf("Reading %s -> %v", oid, err)
+	}
+	return data
+}
+
+func TestStripedWriterRoundTrip(t *testing.T) {
+	var fs, _ = newTestFS(t)
+	var layout = rados.StripeLayout{ChunkSize: 64}
+	var data = make([]byte, 1000)
+	var got []byte
+	var i int
+
+	for i = range data {
+		data[i] = byte(i % 251)
+	}
+
+	writeStriped(t, fs, "/file", layout, data, false)
+	if got = readStriped(t, fs, "/file", layout); !bytes.Equal(got, data) {
+		t.Errorf("Read back %d bytes, which differ from the %d written",
+			len(got), len(data))
+	}
+	expectContents(t, fs, "/file.0015", data[960:])
+
+	/* A shorter rewrite must not be extended by stale chunks. */
+	writeStriped(t, fs, "/file", layout, data[:130], false)
+	if got = readStriped(t, fs, "/file", layout); !bytes.Equal(got,
+		data[:130]) {
+		t.Errorf("Read back %d bytes after rewriting 130", len(got))
+	}
+}
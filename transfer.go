@@ -0,0 +1,574 @@
+package rados
+
+import (
+	"bytes"
+	"context"
+	"hash"
+	"io"
+	"net/url"
+	"syscall"
+
+	"github.com/childoftheuniverse/filesystem"
+)
+
+/*
+copyChunkSize is the number of bytes transferred from or to Rados in a single
+request when streaming whole objects.
+*/
+const copyChunkSize = 1 << 20
+
+/*
+MaxAtomicWriteSize is the largest number of bytes WriteAtomic() accepts. The
+data is held in memory and sent to Rados in a single write, so larger inputs
+are refused with ErrSizeLimitExceeded.
+*/
+const MaxAtomicWriteSize = maxWriteChunk
+
+/*
+maxChecksumSize is the largest checksum attribute value which will be read.
+*/
+const maxChecksumSize = 256
+
+/*
+SetChecksumVerification enables verification of stored checksums in ReadFile
+and CopyTo using the given configuration. If cfg is nil, verification is
+disabled, which is the default.
+*/
+func (r *RadosFileSystem) SetChecksumVerification(cfg *ChecksumConfig) {
+	r.verifyChecksum.Store(cfg)
+}
+
+/*
+SetChecksumStorage makes WriteFull and CopyFrom compute a checksum over the
+written data and store it with the object as described by cfg. The algorithm
+is stored alongside the digest. If cfg is nil, no checksums are stored, which
+is the default.
+*/
+func (r *RadosFileSystem) SetChecksumStorage(cfg *ChecksumConfig) {
+	r.storeChecksum.Store(cfg)
+}
+
+/*
+ReadFile reads the entire Rados object named u.Path in the pool pointed at by
+u.Host into memory and returns its contents.
+*/
+func (r *RadosFileSystem) ReadFile(ctx context.Context, u *url.URL) (
+	[]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	if _, err = r.CopyTo(ctx, u, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+/*
+CopyTo streams the entire Rados object named u.Path in the pool pointed at by
+u.Host into w and returns the number of bytes copied. Objects which have been
+compressed by OpenWriter() are decompressed transparently.
+
+If checksum verification is enabled and the object carries a checksum,
+ErrChecksumMismatch is returned if the streamed data does not match it. Since
+the data is streamed, w will already have received it at that point.
+*/
+func (r *RadosFileSystem) CopyTo(
+	ctx context.Context, u *url.URL, w io.Writer) (int64, error) {
+	var entry *contextEntry
+	var rwc *ReadWriteCloser
+	var reader filesystem.ReadCloser
+	var codec string
+	var cfg *ChecksumConfig
+	var expected []byte
+	var h hash.Hash
+	var bufp = getChunkBuffer()
+	var buf = *bufp
+	var total int64
+	var n, written int
+	var werr error
+	var err error
+
+	defer putChunkBuffer(bufp)
+
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return 0, err
+	}
+
+	if cfg = r.verifyChecksum.Load(); cfg != nil {
+		if h, expected, err = loadChecksum(
+			entry.ioctx, u.Path, cfg); err != nil {
+			return 0, err
+		}
+	}
+
+	if codec, err = objectCompression(entry.ioctx, u.Path); err != nil {
+		return 0, objectError("read", entry.poolName, u.Path, err)
+	}
+
+	rwc = r.openReadWriteCloser(entry, u.Path)
+	reader = rwc
+	if codec == CompressionGzip {
+		reader = newGzipReader(rwc)
+	}
+	defer reader.Close(ctx)
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return total, err
+		}
+
+		n, err = reader.Read(ctx, buf)
+		if n > 0 {
+			if h != nil {
+				h.Write(buf[:n])
+			}
+			written, werr = w.Write(buf[:n])
+			total += int64(written)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return total, err
+		}
+	}
+
+	if h != nil && !bytes.Equal(h.Sum(nil), expected) {
+		return total, ErrChecksumMismatch
+	}
+
+	return total, nil
+}
+
+/*
+loadChecksum reads the checksum stored with the Rados object oid as described
+by cfg and returns a fresh hash function to compute it with, along with the
+expected digest. If the object has no checksum, a nil hash is returned.
+*/
+func loadChecksum(rctx IOContext, oid string, cfg *ChecksumConfig) (
+	hash.Hash, []byte, error) {
+	var value = make([]byte, maxChecksumSize)
+	var alg ChecksumAlgorithm
+	var digest []byte
+	var h hash.Hash
+	var errno syscall.Errno
+	var ok bool
+	var n int
+	var err error
+
+	if n, err = rctx.GetXattr(oid, cfg.xattr(), value); err != nil {
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENODATA {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	if alg, digest, err = parseChecksum(
+		string(value[:n]), cfg.algorithm()); err != nil {
+		return nil, nil, err
+	}
+	if h, err = alg.newHash(); err != nil {
+		return nil, nil, err
+	}
+
+	return h, digest, nil
+}
+
+/*
+contentXattrs returns the extended attributes which describe the contents of
+an object, and which therefore become stale once the object is rewritten: the
+compression record and the checksums stored or verified by this filesystem.
+*/
+func (r *RadosFileSystem) contentXattrs() []string {
+	var names = []string{CompressionXattr, DefaultChecksumXattr}
+	var cfg *ChecksumConfig
+
+	for _, cfg = range []*ChecksumConfig{
+		r.storeChecksum.Load(), r.verifyChecksum.Load()} {
+		if cfg != nil && cfg.xattr() != DefaultChecksumXattr {
+			names = append(names, cfg.xattr())
+		}
+	}
+	return names
+}
+
+/*
+clearXattrs adds steps to op which remove those of the extended attributes
+names which oid currently carries. Removing an attribute which does not exist
+would fail the whole operation, so the attributes are listed first. Objects
+which do not exist carry no attributes.
+*/
+func clearXattrs(rctx IOContext, oid string, op *WriteOp, names []string) error {
+	var attrs map[string][]byte
+	var name string
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if attrs, err = rctx.ListXattrs(oid); err != nil {
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+			return nil
+		}
+		return err
+	}
+	for _, name = range names {
+		if _, ok = attrs[name]; ok {
+			op.RmXattr(name)
+		}
+	}
+	return nil
+}
+
+/*
+WriteFull replaces the contents of the Rados object named u.Path in the pool
+pointed at by u.Host with data, creating the object if necessary. If checksum
+storage is enabled, the checksum is stored in the same atomic operation.
+Compression records and checksums left over from earlier versions of the
+object are removed.
+*/
+func (r *RadosFileSystem) WriteFull(
+	ctx context.Context, u *url.URL, data []byte) error {
+	var entry *contextEntry
+	var op WriteOp
+	var err error
+
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return err
+	}
+
+	if err = r.writeFull(ctx, entry, u.Path, &op, data); err != nil {
+		return err
+	}
+
+	return r.indexAdd(ctx, entry, u.Path)
+}
+
+/*
+writeFull adds the steps replacing the contents of oid with data to op, along
+with the checksum if checksum storage is enabled and the removal of stale
+content attributes, and applies op using applyWrite(). Any steps op already
+holds are applied in the same atomic operation.
+*/
+func (r *RadosFileSystem) writeFull(ctx context.Context, entry *contextEntry,
+	oid string, op *WriteOp, data []byte) error {
+	var cfg = r.storeChecksum.Load()
+	var h hash.Hash
+	var err error
+
+	if err = clearXattrs(entry.ioctx, oid, op, r.contentXattrs()); err != nil {
+		return objectError("write", entry.poolName, oid, err)
+	}
+	op.WriteFull(data)
+
+	if cfg != nil {
+		if h, err = cfg.algorithm().newHash(); err != nil {
+			return err
+		}
+		h.Write(data)
+		op.SetXattr(cfg.xattr(), []byte(formatChecksum(
+			cfg.algorithm(), h.Sum(nil))))
+	}
+
+	return r.applyWrite(ctx, entry, oid, op)
+}
+
+/*
+applyWrite applies op to oid once the pool has space and an operation slot is
+available, giving up when ctx expires.
+*/
+func (r *RadosFileSystem) applyWrite(ctx context.Context, entry *contextEntry,
+	oid string, op *WriteOp) error {
+	var err error
+
+	if err = r.space.Load().check(ctx); err != nil {
+		countNoSpace(entry.cluster, entry.poolName, err)
+		return err
+	}
+
+	if err = r.slots.acquire(ctx); err != nil {
+		return err
+	}
+	defer r.slots.release()
+
+	if err = runWithContext(ctx, func() error {
+		return entry.ioctx.OperateWrite(oid, op)
+	}); err != nil {
+		err = objectError("write", entry.poolName, oid, err)
+		countNoSpace(entry.cluster, entry.poolName, err)
+		return err
+	}
+
+	return nil
+}
+
+/*
+CopyFrom replaces the contents of the Rados object named u.Path in the pool
+pointed at by u.Host with everything read from src, creating the object if
+necessary, and returns the number of bytes copied. If checksum storage is
+enabled, the checksum is stored once all data has been written. Compression
+records and checksums left over from earlier versions of the object are
+removed before writing.
+*/
+func (r *RadosFileSystem) CopyFrom(
+	ctx context.Context, u *url.URL, src io.Reader) (int64, error) {
+	var entry *contextEntry
+	var writer *ReadWriteCloser
+	var op, sum WriteOp
+	var cfg = r.storeChecksum.Load()
+	var h hash.Hash
+	var bufp = getChunkBuffer()
+	var buf = *bufp
+	var total int64
+	var n, written int
+	var rerr error
+	var err error
+
+	defer putChunkBuffer(bufp)
+
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return 0, err
+	}
+
+	if cfg != nil {
+		if h, err = cfg.algorithm().newHash(); err != nil {
+			return 0, err
+		}
+	}
+
+	/* Create or empty the object so that no old data remains at the end. */
+	if err = clearXattrs(
+		entry.ioctx, u.Path, &op, r.contentXattrs()); err != nil {
+		return 0, objectError("write", entry.poolName, u.Path, err)
+	}
+	op.WriteFull([]byte{})
+	if err = r.applyWrite(ctx, entry, u.Path, &op); err != nil {
+		return 0, err
+	}
+	if err = r.indexAdd(ctx, entry, u.Path); err != nil {
+		return 0, err
+	}
+
+	writer = r.openReadWriteCloser(entry, u.Path)
+	defer writer.Close(ctx)
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return total, err
+		}
+
+		n, rerr = io.ReadFull(src, buf)
+		if n > 0 {
+			if h != nil {
+				h.Write(buf[:n])
+			}
+			written, err = writer.Write(ctx, buf[:n])
+			total += int64(written)
+			if err != nil {
+				return total, err
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		} else if rerr != nil {
+			return total, rerr
+		}
+	}
+
+	if h != nil {
+		sum.SetXattr(cfg.xattr(), []byte(
+			formatChecksum(cfg.algorithm(), h.Sum(nil))))
+		if err = r.applyWrite(ctx, entry, u.Path, &sum); err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+/*
+CopyAcross copies the object src to dst, where both URLs may be handled by
+different filesystem implementations, e.g. Rados filesystems registered for
+two different clusters. The data is streamed in chunks and the number of
+bytes copied is returned.
+
+If both sides are Rados filesystems registered through this package, the
+extended attributes of the source object are copied as well. Otherwise, only
+the data is copied using the generic filesystem API.
+*/
+func CopyAcross(ctx context.Context, src, dst *url.URL) (int64, error) {
+	var srcFS, dstFS *RadosFileSystem
+	var srcOK, dstOK bool
+
+	srcFS, srcOK = lookupRegistered(src.Scheme)
+	dstFS, dstOK = lookupRegistered(dst.Scheme)
+	if !srcOK || !dstOK {
+		return copyStream(ctx, src, dst)
+	}
+
+	return srcFS.copyAcross(ctx, src, dstFS, dst)
+}
+
+/*
+copyAcross copies the object src from r to the object dst of dstFS including
+its extended attributes.
+*/
+func (r *RadosFileSystem) copyAcross(ctx context.Context, src *url.URL,
+	dstFS *RadosFileSystem, dst *url.URL) (int64, error) {
+	var srcEntry, dstEntry *contextEntry
+	var reader *ReadWriteCloser
+	var xattrs map[string][]byte
+	var op WriteOp
+	var name string
+	var value []byte
+	var total int64
+	var err error
+
+	if srcEntry, src, err = r.getObjectContext(ctx, src); err != nil {
+		return 0, err
+	}
+	if dstEntry, dst, err = dstFS.getObjectContext(ctx, dst); err != nil {
+		return 0, err
+	}
+	if xattrs, err = srcEntry.ioctx.ListXattrs(src.Path); err != nil {
+		return 0, err
+	}
+
+	reader = r.openReadWriteCloser(srcEntry, src.Path)
+	defer reader.Close(ctx)
+
+	if total, err = dstFS.CopyFrom(
+		ctx, dst, &contextReader{ctx: ctx, r: reader}); err != nil {
+		return total, err
+	}
+
+	if len(xattrs) == 0 {
+		return total, nil
+	}
+	for name, value = range xattrs {
+		op.SetXattr(name, value)
+	}
+	return total, runWithContext(ctx, func() error {
+		return dstEntry.ioctx.OperateWrite(dst.Path, &op)
+	})
+}
+
+/*
+copyStream copies the object src to dst through the generic filesystem API.
+*/
+func copyStream(ctx context.Context, src, dst *url.URL) (int64, error) {
+	var reader filesystem.ReadCloser
+	var writer filesystem.WriteCloser
+	var bufp = getChunkBuffer()
+	var buf = *bufp
+	var total int64
+	var n, written int
+	var rerr error
+	var err error
+
+	defer putChunkBuffer(bufp)
+
+	if reader, err = filesystem.OpenReader(ctx, src); err != nil {
+		return 0, err
+	}
+	defer reader.Close(ctx)
+
+	if writer, err = filesystem.OpenWriter(ctx, dst); err != nil {
+		return 0, err
+	}
+
+	for {
+		if err = ctx.Err(); err != nil {
+			writer.Close(ctx)
+			return total, err
+		}
+
+		n, rerr = reader.Read(ctx, buf)
+		if n > 0 {
+			written, err = writer.Write(ctx, buf[:n])
+			total += int64(written)
+			if err != nil {
+				writer.Close(ctx)
+				return total, err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		} else if rerr != nil {
+			writer.Close(ctx)
+			return total, rerr
+		}
+	}
+
+	return total, writer.Close(ctx)
+}
+
+/*
+contextReader adapts a context-aware reader to io.Reader by using a fixed
+context for all reads.
+*/
+type contextReader struct {
+	ctx context.Context
+	r   filesystem.ReadCloser
+}
+
+/*
+Read reads from the underlying reader using the fixed context.
+*/
+func (c *contextReader) Read(p []byte) (int, error) {
+	return c.r.Read(c.ctx, p)
+}
+
+/*
+WriteAtomic replaces the contents of the Rados object named u.Path in the pool
+pointed at by u.Host with everything read from src, such that readers never
+observe a partially written object. src is consumed completely before the
+object is touched, so the object is left unchanged if reading fails or ctx
+expires. The data then replaces the contents as with WriteFull(), along with
+its checksum if checksum storage is enabled, in a single atomic operation.
+
+Rados has no rename operation, so objects written this way must fit into
+memory and into a single Rados write. Reading stops as soon as src yields more
+than MaxAtomicWriteSize bytes, and ErrSizeLimitExceeded is returned without
+touching the object.
+*/
+func (r *RadosFileSystem) WriteAtomic(
+	ctx context.Context, u *url.URL, src io.Reader) error {
+	var buf bytes.Buffer
+	var n int64
+	var err error
+
+	if err = r.checkWritable(); err != nil {
+		return err
+	}
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if n, err = buf.ReadFrom(io.LimitReader(src, copyChunkSize)); err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if buf.Len() > MaxAtomicWriteSize {
+			return objectError("write", u.Host, u.Path, ErrSizeLimitExceeded)
+		}
+	}
+
+	return r.WriteFull(ctx, u, buf.Bytes())
+}
+
+/*
+AtomicWriteObject is an alias of WriteFull(), which already replaces the
+contents and checksum of an object in a single atomic operation.
+
+Deprecated: use WriteFull().
+*/
+func (r *RadosFileSystem) AtomicWriteObject(
+	ctx context.Context, u *url.URL, data []byte) error {
+	return r.WriteFull(ctx, u, data)
+}
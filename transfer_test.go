@@ -0,0 +1,218 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+	"testing/iotest"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+func TestWriteAtomicLeavesObjectOnReadError(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var readErr = errors.New("read failed")
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("old"))
+	if err = fs.WriteAtomic(ctx, testURL("/object"),
+		iotest.ErrReader(readErr)); !errors.Is(err, readErr) {
+		t.Errorf("WriteAtomic() -> %v, want %v", err, readErr)
+	}
+	expectContents(t, fs, "/object", []byte("old"))
+
+	if got := listNames(t, fs, "/"); !reflect.DeepEqual(
+		got, []string{"object"}) {
+		t.Errorf("Pool holds %v after WriteAtomic(), want [object]", got)
+	}
+}
+
+/*
+zeroReader yields an endless stream of zero bytes.
+*/
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestWriteAtomicRefusesOversizedInput(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("old"))
+	if err = fs.WriteAtomic(ctx, testURL("/object"),
+		zeroReader{}); !errors.Is(err, rados.ErrSizeLimitExceeded) {
+		t.Errorf("WriteAtomic() of endless input -> %v, want %v", err,
+			rados.ErrSizeLimitExceeded)
+	}
+	expectContents(t, fs, "/object", []byte("old"))
+
+	if err = fs.WriteAtomic(ctx, testURL("/object"), io.LimitReader(
+		zeroReader{}, rados.MaxAtomicWriteSize)); err != nil {
+		t.Errorf("WriteAtomic() of MaxAtomicWriteSize bytes -> %v", err)
+	}
+}
+
+func TestWriteAtomicStoresChecksum(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var op rados.ReadOp
+	var step *rados.ReadStep
+	var err error
+
+	fs.SetChecksumStorage(&rados.ChecksumConfig{})
+	if err = fs.WriteAtomic(ctx, testURL("/object"),
+		bytes.NewReader([]byte("new"))); err != nil {
+		t.Fatalf("WriteAtomic() -> %v", err)
+	}
+
+	step = op.GetXattr(rados.DefaultChecksumXattr)
+	if err = fs.OperateRead(ctx, testURL("/object"), &op); err != nil {
+		t.Fatalf("Reading the checksum -> %v", err)
+	}
+	if len(step.Data) == 0 {
+		t.Error("WriteAtomic() stored no checksum")
+	}
+	expectContents(t, fs, "/object", []byte("new"))
+}
+
+func TestAtomicWriteObjectReplacesContents(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("old contents"))
+	if err = fs.AtomicWriteObject(ctx, testURL("/object"),
+		[]byte("new")); err != nil {
+		t.Fatalf("AtomicWriteObject() -> %v", err)
+	}
+	expectContents(t, fs, "/object", []byte("new"))
+
+	if got := listNames(t, fs, "/"); !reflect.DeepEqual(
+		got, []string{"object"}) {
+		t.Errorf("Pool holds %v after AtomicWriteObject(), want [object]",
+			got)
+	}
+}
+
+func TestAtomicWriteObjectIsNeverSeenPartially(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var contents = [][]byte{
+		bytes.Repeat([]byte("a"), 1024),
+		bytes.Repeat([]byte("b"), 1024),
+	}
+	var done = make(chan struct{})
+	var errs = make(chan error, 1)
+	var data []byte
+	var err error
+
+	mustWrite(t, fs, "/object", contents[0])
+	go func() {
+		var i int
+		var err error
+
+		defer close(errs)
+		for i = 0; i < 200; i++ {
+			if err = fs.AtomicWriteObject(ctx, testURL("/object"),
+				contents[i%2]); err != nil {
+				errs <- err
+				return
+			}
+			runtime.Gosched()
+		}
+	}()
+	go func() {
+		for range errs {
+		}
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if data, err = fs.ReadFile(ctx, testURL("/object")); err != nil {
+			t.Fatalf("ReadFile() -> %v", err)
+		}
+		if !bytes.Equal(data, contents[0]) && !bytes.Equal(data, contents[1]) {
+			t.Fatalf("ReadFile() during AtomicWriteObject() -> %d bytes "+
+				"mixing old and new contents", len(data))
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestAtomicWriteObjectFailureLeavesNoLitter(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var calls int64
+	var err error
+
+	mustWrite(t, fs, "/object", []byte("old"))
+	conn.SetHook(failOperation("OperateWrite", 1, syscall.EIO, &calls))
+	if err = fs.AtomicWriteObject(ctx, testURL("/object"),
+		[]byte("new")); err == nil {
+		t.Error("AtomicWriteObject() succeeded despite a failing write")
+	}
+	conn.SetHook(nil)
+	expectContents(t, fs, "/object", []byte("old"))
+
+	if got := listNames(t, fs, "/"); !reflect.DeepEqual(
+		got, []string{"object"}) {
+		t.Errorf("Pool holds %v after a failed AtomicWriteObject(), "+
+			"want [object]", got)
+	}
+}
+
+func TestCopyAcrossClusters(t *testing.T) {
+	var ctx = context.Background()
+	var src, _ = newTestFS(t)
+	var dst, _ = newTestFS(t)
+	var srcURL = &url.URL{Scheme: "rados-src", Host: testPool, Path: "/object"}
+	var dstURL = &url.URL{Scheme: "rados-dst", Host: testPool, Path: "/copy"}
+	var data = bytes.Repeat([]byte("0123456789"), 100000)
+	var op rados.WriteOp
+	var value []byte
+	var n int64
+	var err error
+
+	rados.RegisterAs(srcURL.Scheme, src)
+	defer rados.Deregister(srcURL.Scheme)
+	rados.RegisterAs(dstURL.Scheme, dst)
+	defer rados.Deregister(dstURL.Scheme)
+
+	mustWrite(t, src, "/object", data)
+	op.SetXattr("user.owner", []byte("someone"))
+	if err = src.OperateWrite(ctx, srcURL, &op); err != nil {
+		t.Fatalf("OperateWrite() -> %v", err)
+	}
+
+	if n, err = rados.CopyAcross(ctx, srcURL, dstURL); err != nil ||
+		n != int64(len(data)) {
+		t.Errorf("CopyAcross() -> %d, %v, want %d", n, err, len(data))
+	}
+	expectContents(t, dst, "/copy", data)
+	if value, err = readXattr(dst, "/copy", "user.owner"); err != nil ||
+		string(value) != "someone" {
+		t.Errorf("user.owner of the copy = %q, %v, want \"someone\"", value,
+			err)
+	}
+	if _, err = src.Stat(ctx, testURL("/copy")); err == nil {
+		t.Error("CopyAcross() created the copy in the source cluster")
+	}
+}
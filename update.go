@@ -0,0 +1,42 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+)
+
+/*
+Update atomically transforms the contents of the Rados object named u.Path in
+the pool pointed at by u.Host: it takes the exclusive lock ObjectLockName,
+reads the current contents, passes them to fn and replaces the contents with
+the result using WriteFull(). Objects which do not exist yet are passed to fn
+as empty. If fn returns an error, the object is left unchanged and the error
+is returned as is.
+
+The lock is only advisory, so the update is atomic only with respect to other
+callers of Update() and Increment(), or clients taking the same lock. If the
+lock is contended, taking it is retried a bounded number of times before an
+error wrapping ErrLocked is returned.
+*/
+func (r *RadosFileSystem) Update(ctx context.Context, u *url.URL,
+	fn func(old []byte) ([]byte, error)) error {
+	var entry *contextEntry
+	var err error
+
+	if entry, u, err = r.getWritableContext(ctx, u); err != nil {
+		return err
+	}
+
+	return withObjectLock(ctx, entry, u.Path, func() error {
+		var data []byte
+		var err error
+
+		if data, err = r.ReadFile(ctx, u); err != nil {
+			return err
+		}
+		if data, err = fn(data); err != nil {
+			return err
+		}
+		return r.WriteFull(ctx, u, data)
+	})
+}
@@ -0,0 +1,60 @@
+package rados_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentUpdatesAreNotLost(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var errs = make(chan error, 8)
+	var wg sync.WaitGroup
+	var data []byte
+	var i int
+	var err error
+
+	for i = 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- fs.Update(ctx, testURL("/counter"),
+				func(old []byte) ([]byte, error) {
+					return append(old, 'x'), nil
+				})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err = range errs {
+		if err != nil {
+			t.Errorf("Update() -> %v", err)
+		}
+	}
+	data = mustRead(t, fs, "/counter")
+	if want := strings.Repeat("x", cap(errs)); string(data) != want {
+		t.Errorf("Contents after %d updates = %q, want %q", cap(errs), data,
+			want)
+	}
+}
+
+func TestFailedUpdateLeavesObjectUnchanged(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var updateErr = errors.New("invalid contents")
+	var err error
+
+	mustWrite(t, fs, "/config", []byte("old"))
+	if err = fs.Update(ctx, testURL("/config"),
+		func(old []byte) ([]byte, error) {
+			return []byte("new"), updateErr
+		}); err != updateErr {
+		t.Errorf("Update() with a failing transform -> %v, want %v", err,
+			updateErr)
+	}
+	expectContents(t, fs, "/config", []byte("old"))
+}
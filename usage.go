@@ -0,0 +1,161 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/ceph/go-ceph/rados"
+)
+
+/*
+DiskUsage determines the number of objects below u.Path in the pool pointed
+at by u.Host, including an object named u.Path itself, and the total number of
+bytes they occupy. This is the Rados equivalent of du -s. Every object is
+looked up individually, so this can take a while for large prefixes; the
+operation is abandoned with the context error if ctx expires.
+
+With directory indexing enabled, the objects are found through the indexes
+rather than by iterating over the whole pool. Directories which have not been
+indexed are scanned instead. Objects removed while the usage is determined are
+not counted.
+*/
+func (r *RadosFileSystem) DiskUsage(ctx context.Context, u *url.URL) (
+	objectCount int64, totalBytes int64, err error) {
+	var entry *contextEntry
+	var oids []string
+	var oid string
+	var size int64
+	var found bool
+
+	if entry, err = r.getURLContext(ctx, u); err != nil {
+		return 0, 0, err
+	}
+
+	if r.dirIndex.Load() {
+		if oids, err = r.indexedObjects(ctx, entry, u.Path); err != nil {
+			return 0, 0, err
+		}
+	} else if oids, err = scanObjects(ctx, entry, u.Path); err != nil {
+		return 0, 0, err
+	}
+
+	for _, oid = range oids {
+		if err = ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+		if size, found, err = objectUsage(ctx, entry, oid); err != nil {
+			return 0, 0, err
+		}
+		if found {
+			objectCount++
+			totalBytes += size
+		}
+	}
+
+	return objectCount, totalBytes, nil
+}
+
+/*
+objectUsage returns the size of oid, and whether it exists at all.
+*/
+func objectUsage(ctx context.Context, entry *contextEntry, oid string) (
+	int64, bool, error) {
+	var stat rados.ObjectStat
+	var size int64
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		stat, err = entry.ioctx.Stat(oid)
+		return err
+	}); err != nil {
+		if errno, ok = radosErrno(err); ok && errno == syscall.ENOENT {
+			return 0, false, nil
+		}
+		return 0, false, objectError("stat", entry.poolName, oid, err)
+	}
+	if size, err = objectSize(stat.Size); err != nil {
+		return 0, false, objectError("stat", entry.poolName, oid, err)
+	}
+	return size, true, nil
+}
+
+/*
+scanObjects iterates over the whole pool of entry and returns the IDs of all
+objects which are either named path or located below it.
+*/
+func scanObjects(ctx context.Context, entry *contextEntry, path string) (
+	[]string, error) {
+	var iter Iter
+	var prefix = path
+	var oids []string
+	var oid string
+	var err error
+
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	if iter, err = entry.ioctx.Iter(); err != nil {
+		return nil, objectError("list", entry.poolName, path, err)
+	}
+	defer iter.Close()
+
+	for iter.Next() {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		oid = iter.Value()
+		if oid == path || strings.HasPrefix(oid, prefix) {
+			oids = append(oids, oid)
+		}
+	}
+
+	return oids, iter.Err()
+}
+
+/*
+indexedObjects returns the IDs of all objects below path according to the
+directory indexes, plus path itself, which might be an object rather than a
+directory. Directories without an index are scanned using scanObjects.
+*/
+func (r *RadosFileSystem) indexedObjects(ctx context.Context,
+	entry *contextEntry, path string) ([]string, error) {
+	var set map[string]bool
+	var oids []string
+	var children []string
+	var prefix = path
+	var name string
+	var isDir bool
+	var err error
+
+	if set, err = readIndex(ctx, entry, path); err != nil {
+		return nil, err
+	}
+	if set == nil {
+		return scanObjects(ctx, entry, path)
+	}
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		oids = append(oids, path)
+		prefix += "/"
+	}
+
+	for name, isDir = range set {
+		if !isDir {
+			oids = append(oids, prefix+name)
+			continue
+		}
+		if children, err = r.indexedObjects(
+			ctx, entry, prefix+name); err != nil {
+			return nil, err
+		}
+		oids = append(oids, children...)
+	}
+
+	return oids, nil
+}
@@ -0,0 +1,50 @@
+package rados_test
+
+import (
+	"context"
+	"testing"
+
+	rados "github.com/childoftheuniverse/filesystem-rados"
+	"github.com/childoftheuniverse/filesystem-rados/radostest"
+)
+
+func TestDiskUsage(t *testing.T) {
+	var ctx = context.Background()
+
+	for _, c := range []struct {
+		name string
+		opts []rados.Option
+	}{
+		{"scanned", nil},
+		{"indexed", []rados.Option{rados.WithDirectoryIndex()}},
+	} {
+		var conn = radostest.NewConn()
+		var fs *rados.RadosFileSystem
+		var objects, bytes int64
+		var err error
+
+		conn.CreatePool(testPool)
+		fs = rados.NewRadosFileSystemWithConn(conn, c.opts...)
+		mustWrite(t, fs, "/data", []byte("0123456789"))
+		mustWrite(t, fs, "/data/a", []byte("abc"))
+		mustWrite(t, fs, "/data/sub/b", []byte("defgh"))
+		mustWrite(t, fs, "/database", []byte("not below /data"))
+		mustWrite(t, fs, "/other", []byte("elsewhere"))
+
+		for _, u := range []struct {
+			path           string
+			objects, bytes int64
+		}{
+			{"/data", 3, 18},
+			{"/data/sub", 1, 5},
+			{"/missing", 0, 0},
+		} {
+			if objects, bytes, err = fs.DiskUsage(ctx,
+				testURL(u.path)); err != nil || objects != u.objects ||
+				bytes != u.bytes {
+				t.Errorf("%s: DiskUsage(%s) -> %d, %d, %v, want %d, %d",
+					c.name, u.path, objects, bytes, err, u.objects, u.bytes)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package rados
+
+import (
+	"context"
+	"net/url"
+	"syscall"
+)
+
+/*
+ImmutableXattr is the extended attribute marking an object as immutable. Its
+value is irrelevant; the presence of the attribute is what counts.
+*/
+const ImmutableXattr = "user.immutable"
+
+/*
+SetImmutableObjects determines whether objects written through OpenWriter()
+are marked immutable when the writer is closed, as with
+WithImmutableObjects().
+*/
+func (r *RadosFileSystem) SetImmutableObjects(enabled bool) {
+	r.sealOnClose.Store(enabled)
+}
+
+/*
+MarkImmutable marks the Rados object named u.Path in the pool pointed at by
+u.Host as immutable. Afterwards, all operations of this package which would
+modify or remove the object, such as OpenWriter(), OpenAppender(),
+WriteFull(), OperateWrite() and Remove(), refuse to touch it and return
+ErrImmutable. For chunked files, the manifest object is checked.
+
+This protection is cooperative: it is enforced by this package only, so other
+Rados clients can still modify or delete the object, and the attribute itself
+can be removed by anyone with write access to the pool. Real write-once
+guarantees require a server side object class.
+*/
+func (r *RadosFileSystem) MarkImmutable(ctx context.Context, u *url.URL) error {
+	var entry *contextEntry
+	var err error
+
+	if err = r.checkWritable(); err != nil {
+		return err
+	}
+	if entry, u, err = r.getObjectContext(ctx, u); err != nil {
+		return err
+	}
+
+	return runWithContext(ctx, func() error {
+		return markImmutable(entry.ioctx, u.Path)
+	})
+}
+
+/*
+markImmutable sets the immutability attribute on oid.
+*/
+func markImmutable(rctx IOContext, oid string) error {
+	return rctx.SetXattr(oid, ImmutableXattr, []byte("1"))
+}
+
+/*
+checkMutable returns ErrImmutable if oid has been marked immutable. Objects
+which do not exist are mutable.
+*/
+func checkMutable(ctx context.Context, entry *contextEntry, oid string) error {
+	var buf = make([]byte, 1)
+	var errno syscall.Errno
+	var ok bool
+	var err error
+
+	if err = runWithContext(ctx, func() error {
+		var err error
+		_, err = entry.ioctx.GetXattr(oid, ImmutableXattr, buf)
+		return err
+	}); err != nil {
+		if errno, ok = radosErrno(err); ok &&
+			(errno == syscall.ENODATA || errno == syscall.ENOENT) {
+			return nil
+		}
+		/* The attribute exists, it just doesn't fit into buf. */
+		if !ok || errno != syscall.ERANGE {
+			return err
+		}
+	}
+	return ErrImmutable
+}
@@ -0,0 +1,190 @@
+package rados_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/childoftheuniverse/filesystem"
+	rados "github.com/childoftheuniverse/filesystem-rados"
+)
+
+/*
+staticKeys is a KeyProvider with a single fixed key.
+*/
+type staticKeys struct{}
+
+func (staticKeys) CurrentKey() (string, []byte, error) {
+	return "k1", bytes.Repeat([]byte{1}, 32), nil
+}
+
+func (staticKeys) Key(string) ([]byte, error) {
+	return bytes.Repeat([]byte{1}, 32), nil
+}
+
+func TestImmutableObjectsRefuseAllWriters(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var u = testURL("/sealed")
+	var writers = map[string]func() error{
+		"OpenWriter": func() error {
+			_, err := fs.OpenWriter(ctx, u)
+			return err
+		},
+		"OpenAppender": func() error {
+			_, err := fs.OpenAppender(ctx, u)
+			return err
+		},
+		"WriteFull": func() error {
+			return fs.WriteFull(ctx, u, []byte("new"))
+		},
+		"CopyFrom": func() error {
+			_, err := fs.CopyFrom(ctx, u, bytes.NewReader([]byte("new")))
+			return err
+		},
+		"WriteAtomic": func() error {
+			return fs.WriteAtomic(ctx, u, bytes.NewReader([]byte("new")))
+		},
+		"WriteIfVersion": func() error {
+			var version, err = fs.Version(ctx, u)
+			if err != nil {
+				return err
+			}
+			return fs.WriteIfVersion(ctx, u, []byte("new"), version)
+		},
+		"OperateWrite": func() error {
+			var op rados.WriteOp
+			op.Remove()
+			return fs.OperateWrite(ctx, u, &op)
+		},
+		"Update": func() error {
+			return fs.Update(ctx, u, func(old []byte) ([]byte, error) {
+				return []byte("new"), nil
+			})
+		},
+		"Remove": func() error {
+			return fs.Remove(ctx, u)
+		},
+		"Exec": func() error {
+			_, err := fs.Exec(ctx, u, "class", "method", nil)
+			return err
+		},
+		"OpenRecordAppender": func() error {
+			_, err := fs.OpenRecordAppender(ctx, u, false)
+			return err
+		},
+		"OpenBatchingAppender": func() error {
+			_, err := fs.OpenBatchingAppender(ctx, u, 10, 0)
+			return err
+		},
+		"OpenEncryptedWriter": func() error {
+			_, err := fs.OpenEncryptedWriter(ctx, u, staticKeys{})
+			return err
+		},
+		"OpenIntegrityWriter": func() error {
+			_, err := fs.OpenIntegrityWriter(ctx, u, nil)
+			return err
+		},
+		"OpenStripedWriter": func() error {
+			_, err := fs.OpenStripedWriter(ctx, u,
+				rados.StripeLayout{ChunkSize: 4})
+			return err
+		},
+	}
+	var name string
+	var fn func() error
+	var err error
+
+	mustWrite(t, fs, u.Path, []byte("old"))
+	if err = fs.MarkImmutable(ctx, u); err != nil {
+		t.Fatalf("MarkImmutable() -> %v", err)
+	}
+
+	for name, fn = range writers {
+		if err = fn(); !errors.Is(err, rados.ErrImmutable) {
+			t.Errorf("%s() -> %v, want ErrImmutable", name, err)
+		}
+	}
+	expectContents(t, fs, u.Path, []byte("old"))
+}
+
+func TestFailedSealIsReportedUntilRetried(t *testing.T) {
+	var ctx = context.Background()
+	var fs, conn = newTestFS(t)
+	var u = testURL("/sealed")
+	var w filesystem.WriteCloser
+	var calls int64
+	var err error
+
+	fs.SetImmutableObjects(true)
+	if w, err = fs.OpenWriter(ctx, u); err != nil {
+		t.Fatalf("OpenWriter() -> %v", err)
+	}
+	if _, err = w.Write(ctx, []byte("data")); err != nil {
+		t.Fatalf("Write() -> %v", err)
+	}
+
+	conn.SetHook(failOperation("SetXattr", 2, syscall.EIO, &calls))
+	if err = w.Close(ctx); err == nil {
+		t.Error("Close() succeeded despite failing to seal the object")
+	}
+	if err = w.Close(ctx); err == nil {
+		t.Error("Retried Close() succeeded despite failing to seal the object")
+	}
+	if err = w.Close(ctx); err != nil {
+		t.Fatalf("Close() once sealing works -> %v", err)
+	}
+	if err = fs.WriteFull(ctx, u, []byte("new")); !errors.Is(err,
+		rados.ErrImmutable) {
+		t.Errorf("WriteFull() after sealing -> %v, want ErrImmutable", err)
+	}
+}
+
+func TestImmutableRollingSegmentIsRefused(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var err error
+
+	mustWrite(t, fs, "/log.0", []byte("old"))
+	if err = fs.MarkImmutable(ctx, testURL("/log.0")); err != nil {
+		t.Fatalf("MarkImmutable() -> %v", err)
+	}
+
+	if _, err = fs.OpenRollingAppender(ctx, testURL("/log"),
+		100); !errors.Is(err, rados.ErrImmutable) {
+		t.Errorf("OpenRollingAppender() -> %v, want ErrImmutable", err)
+	}
+}
+
+func TestImmutableChunkedFileIsRefused(t *testing.T) {
+	var ctx = context.Background()
+	var fs, _ = newTestFS(t)
+	var file *rados.ChunkedFile
+	var data = []byte("0123456789")
+	var err error
+
+	if file, err = fs.OpenChunkedFile(ctx, testURL("/chunked"), 4); err != nil {
+		t.Fatalf("OpenChunkedFile() -> %v", err)
+	}
+	if err = file.Upload(ctx, bytes.NewReader(data), int64(len(data)), 2,
+		nil); err != nil {
+		t.Fatalf("Upload() -> %v", err)
+	}
+	if err = fs.MarkImmutable(ctx,
+		testURL("/chunked/"+rados.ChunkManifestName)); err != nil {
+		t.Fatalf("MarkImmutable() -> %v", err)
+	}
+
+	if _, err = file.Writer(ctx); !errors.Is(err, rados.ErrImmutable) {
+		t.Errorf("Writer() -> %v, want ErrImmutable", err)
+	}
+	if err = file.Upload(ctx, bytes.NewReader(data), int64(len(data)), 2,
+		nil); !errors.Is(err, rados.ErrImmutable) {
+		t.Errorf("Upload() -> %v, want ErrImmutable", err)
+	}
+	if err = file.Remove(ctx); !errors.Is(err, rados.ErrImmutable) {
+		t.Errorf("Remove() -> %v, want ErrImmutable", err)
+	}
+}